@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"simple-auth/pkg/config"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/email"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var cmdAccount = &cli.Command{
+	Name:     "account",
+	Usage:    "Deactivate, reactivate, approve, unlock, exempt from password expiry, or delete an account",
+	Category: "user",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "deactivate",
+			Usage:     "Deactivate an account, revoking all of its tokens",
+			ArgsUsage: "<email>",
+			Action:    accountAction(db.SADB.DeactivateAccount, "deactivated"),
+		},
+		{
+			Name:      "reactivate",
+			Usage:     "Reactivate a deactivated account",
+			ArgsUsage: "<email>",
+			Action:    accountAction(db.SADB.ReactivateAccount, "reactivated"),
+		},
+		{
+			Name:      "unlock",
+			Usage:     "Unlock a local login, locked after repeated failed logins",
+			ArgsUsage: "<email>",
+			Action:    accountAction(unlockAccount, "unlocked"),
+		},
+		{
+			Name:      "exempt-expiry",
+			Usage:     "Exempt a local login's password from expiring, eg. a service account",
+			ArgsUsage: "<email>",
+			Action:    accountAction(setPasswordNeverExpires(true), "exempted from password expiry"),
+		},
+		{
+			Name:      "unexempt-expiry",
+			Usage:     "Remove a local login's exemption from password expiry",
+			ArgsUsage: "<email>",
+			Action:    accountAction(setPasswordNeverExpires(false), "no longer exempt from password expiry"),
+		},
+		{
+			Name:      "delete",
+			Usage:     "Permanently delete an account. Audit records are retained",
+			ArgsUsage: "<email>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "yes",
+					Usage: "Confirm deletion",
+				},
+			},
+			Before: func(c *cli.Context) error {
+				if !c.Bool("yes") {
+					return errors.New("deleting an account is permanent, pass --yes to confirm")
+				}
+				return nil
+			},
+			Action: accountAction(db.SADB.DeleteAccount, "deleted"),
+		},
+		{
+			Name:  "pending",
+			Usage: "List accounts waiting to be approved",
+			Action: func(c *cli.Context) error {
+				accounts, err := getDB().FindAccountsPendingApproval()
+				if err != nil {
+					return err
+				}
+				for _, account := range accounts {
+					fmt.Printf("%s\t%s\t%s\t%s\n", account.UUID, account.CreatedAt.Format(time.RFC3339), account.Email, account.Name)
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "approve",
+			Usage:     "Approve an account waiting to be approved, and email the applicant",
+			ArgsUsage: "<email>",
+			Flags:     approvalFlags,
+			Action:    approvalAction(true),
+		},
+		{
+			Name:      "reject",
+			Usage:     "Reject an account waiting to be approved, deactivating it, and email the applicant",
+			ArgsUsage: "<email>",
+			Flags:     approvalFlags,
+			Action:    approvalAction(false),
+		},
+	},
+}
+
+var approvalFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "reason",
+		Usage: "Reason included in the email to the applicant",
+	},
+	&cli.BoolFlag{
+		Name:  "no-email",
+		Usage: "Don't email the applicant",
+	},
+}
+
+// accountAction runs the modification against the account in a transaction
+func accountAction(modify func(db.SADB, *db.Account) error, verb string) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		email := c.Args().First()
+		if email == "" {
+			return errors.New("missing email")
+		}
+
+		sadb := getDB()
+		account, err := sadb.FindAccountByEmail(email)
+		if err != nil {
+			return fmt.Errorf("unable to find account for %s: %w", email, err)
+		}
+
+		fmt.Printf("Account: %s\n", account.UUID)
+
+		tx := sadb.BeginTransaction()
+		if err := modify(tx, account); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		fmt.Printf("Account %s.\n", verb)
+		return nil
+	}
+}
+
+// approvalAction approves or rejects a pending account, then emails the applicant. The email is
+// sent synchronously, unlike the server, so that it isn't lost on exit
+func approvalAction(approve bool) cli.ActionFunc {
+	verb := "rejected"
+	if approve {
+		verb = "approved"
+	}
+
+	return func(c *cli.Context) error {
+		reason := c.String("reason")
+
+		var resolved *db.Account
+		err := accountAction(func(sadb db.SADB, account *db.Account) error {
+			resolved = account
+			if approve {
+				return sadb.ApproveAccount(account, reason)
+			}
+			return sadb.RejectAccount(account, reason)
+		}, verb)(c)
+		if err != nil || c.Bool("no-email") {
+			return err
+		}
+
+		cfg := config.Load()
+		data := &email.ApprovalData{
+			EmailData: email.EmailData{
+				Company: cfg.Metadata.Company,
+				BaseURL: cfg.Web.GetBaseURL(),
+			},
+			Name:   resolved.Name,
+			Reason: reason,
+		}
+		emailService := email.NewFromConfig(&cfg.Email)
+		if approve {
+			return emailService.SendApprovedEmail(resolved.Email, data)
+		}
+		return emailService.SendRejectedEmail(resolved.Email, data)
+	}
+}
+
+func unlockAccount(sadb db.SADB, account *db.Account) error {
+	authLocal, err := sadb.FindAuthLocal(account)
+	if err != nil {
+		return err
+	}
+	return sadb.UnlockAuthLocal(authLocal)
+}
+
+func setPasswordNeverExpires(neverExpires bool) func(db.SADB, *db.Account) error {
+	return func(sadb db.SADB, account *db.Account) error {
+		authLocal, err := sadb.FindAuthLocal(account)
+		if err != nil {
+			return err
+		}
+		return sadb.SetPasswordNeverExpires(authLocal, neverExpires)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"simple-auth/pkg/config"
-	"simple-auth/pkg/db"
 
 	"github.com/urfave/cli/v2"
 )
@@ -40,8 +39,12 @@ func funcAddUser(c *cli.Context) error {
 		password = readPass
 	}
 
+	db := openDB(config)
+	if err := newLocalLoginService(config, db).ValidatePassword(username, email, password); err != nil {
+		return err
+	}
+
 	fmt.Println("Creating account...")
-	db := db.New(config.Db.Driver, config.Db.URL)
 	account, err := db.CreateAccount(name, email)
 	if err != nil {
 		return err
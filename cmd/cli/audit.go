@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"simple-auth/pkg/config"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/lib/checkpoint"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var cmdAudit = &cli.Command{
+	Name:  "audit",
+	Usage: "Verify the audit trail's hash-chain, and export signed checkpoints",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "verify",
+			Usage: "Walk the audit hash-chain, reporting the first broken link",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "checkpoints",
+					Usage: "File of signed checkpoints (one per line) that the chain must still match",
+				},
+			},
+			Action: funcAuditVerify,
+		},
+		{
+			Name:  "checkpoint",
+			Usage: "Sign the newest link in the audit chain, to be stored outside the database",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "out",
+					Usage: "Append the checkpoint to this file, rather than printing it",
+				},
+			},
+			Action: funcAuditCheckpoint,
+		},
+	},
+}
+
+func funcAuditVerify(c *cli.Context) error {
+	config := config.Load()
+	sadb := openDB(config)
+
+	report, err := sadb.VerifyAuditChain()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Verified %d records (%d to %d)\n", report.Verified, report.First, report.Last)
+	if report.Unsealed > 0 {
+		fmt.Printf("%d records haven't been sealed into the chain yet\n", report.Unsealed)
+	}
+	if report.Broken != nil {
+		return fmt.Errorf("chain broken at index %d (record %d): %s", report.Broken.Index, report.Broken.RecordID, report.Broken.Reason)
+	}
+
+	if filename := c.String("checkpoints"); filename != "" {
+		signer, err := checkpoint.NewFromConfig(&config.Audit.Checkpoint)
+		if err != nil {
+			return err
+		}
+		if err := verifyCheckpoints(sadb, signer, report, auditRetention(config), filename); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Audit chain OK")
+	return nil
+}
+
+// auditRetention is how long the reaper keeps audit records, or 0 if it doesn't remove them
+func auditRetention(cfg *config.Config) time.Duration {
+	reaper := &cfg.Db.Reaper
+	if !reaper.Enabled || reaper.AuditRetention == "" || reaper.AuditRetention == "0" {
+		return 0
+	}
+	d, err := time.ParseDuration(reaper.AuditRetention)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// verifyCheckpoints checks that every checkpoint still matches the link in the chain. A checkpoint's record may
+// only be missing if it's older than the chain, and the audit retention would have removed it
+func verifyCheckpoints(sadb db.SADB, signer *checkpoint.Signer, report *db.AuditChainReport, retention time.Duration, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		cp, err := signer.Verify(line)
+		if err != nil {
+			return fmt.Errorf("checkpoint on line %d has an invalid signature: %w", lineNum, err)
+		}
+
+		link, err := sadb.FindAuditChainLink(cp.Index)
+		if err != nil {
+			return err
+		}
+		switch {
+		case link == nil && cp.Index < report.First && retention > 0 && time.Since(cp.Created) > retention:
+			fmt.Printf("Checkpoint %d: removed by retention\n", cp.Index)
+		case link == nil:
+			return fmt.Errorf("checkpoint %d (%s): record is missing", cp.Index, cp.Created.Format(time.RFC3339))
+		case link.Hash != cp.Hash:
+			return fmt.Errorf("checkpoint %d (%s): hash doesn't match, the chain has been rewritten", cp.Index, cp.Created.Format(time.RFC3339))
+		default:
+			fmt.Printf("Checkpoint %d: OK\n", cp.Index)
+		}
+	}
+	return scanner.Err()
+}
+
+func funcAuditCheckpoint(c *cli.Context) error {
+	config := config.Load()
+	signer, err := checkpoint.NewFromConfig(&config.Audit.Checkpoint)
+	if err != nil {
+		return err
+	}
+
+	sadb := openDB(config)
+	_, signed, err := signer.SignHead(sadb)
+	if err != nil {
+		return err
+	}
+
+	if filename := c.String("out"); filename != "" {
+		return checkpoint.AppendFile(filename, signed)
+	}
+
+	fmt.Println(signed)
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"simple-auth/pkg/db"
+	"sort"
+
+	"github.com/urfave/cli/v2"
+)
+
+var cmdBackup = &cli.Command{
+	Name:      "backup",
+	Usage:     "Write every table to a portable archive, which can be restored to any supported driver",
+	Category:  "database",
+	ArgsUsage: "<file>",
+	Action:    funcBackup,
+}
+
+var cmdRestore = &cli.Command{
+	Name:      "restore",
+	Usage:     "Restore an archive into an empty database",
+	Category:  "database",
+	ArgsUsage: "<file>",
+	Action:    funcRestore,
+}
+
+var cmdVerify = &cli.Command{
+	Name:      "verify",
+	Usage:     "Verify an archive's integrity, and compare it with the database",
+	Category:  "database",
+	ArgsUsage: "<file>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "archive-only",
+			Usage: "Only verify the archive, don't compare it with the database",
+		},
+	},
+	Action: funcVerify,
+}
+
+func printManifest(manifest *db.BackupManifest) {
+	fmt.Printf("Schema version: %d\n", manifest.Schema)
+	fmt.Printf("Created: %s\n", manifest.Created)
+
+	names := make([]string, 0, len(manifest.Tables))
+	for name := range manifest.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s\t%d\n", name, manifest.Tables[name].Count)
+	}
+}
+
+func funcBackup(c *cli.Context) error {
+	filename := c.Args().First()
+	if filename == "" {
+		return errors.New("missing file")
+	}
+
+	sadb := getDB()
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Read everything in a single transaction for a consistent snapshot
+	tx := sadb.BeginTransaction()
+	defer tx.Rollback()
+
+	manifest, err := tx.Backup(f)
+	if err != nil {
+		os.Remove(filename)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	printManifest(manifest)
+	fmt.Printf("Backup written to %s\n", filename)
+	return nil
+}
+
+func funcRestore(c *cli.Context) error {
+	filename := c.Args().First()
+	if filename == "" {
+		return errors.New("missing file")
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The target is usually a new database, so bring its schema up to date first
+	sadb := openSchemaDB()
+	if _, err := sadb.MigrateUp(0); err != nil {
+		return err
+	}
+
+	tx := sadb.BeginTransaction()
+	manifest, err := tx.Restore(f)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	printManifest(manifest)
+	fmt.Println("Restore complete")
+	return nil
+}
+
+func funcVerify(c *cli.Context) error {
+	filename := c.Args().First()
+	if filename == "" {
+		return errors.New("missing file")
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	manifest, err := db.ReadBackupManifest(f)
+	if err != nil {
+		return err
+	}
+	printManifest(manifest)
+	fmt.Println("Archive OK")
+
+	if c.Bool("archive-only") {
+		return nil
+	}
+
+	sadb := getDB()
+	tx := sadb.BeginTransaction()
+	defer tx.Rollback()
+
+	current, err := tx.CurrentManifest()
+	if err != nil {
+		return err
+	}
+	if current.Schema != manifest.Schema {
+		return fmt.Errorf("database is schema version %d, archive is %d", current.Schema, manifest.Schema)
+	}
+	if diffs := manifest.Compare(current); len(diffs) > 0 {
+		for _, diff := range diffs {
+			fmt.Println(diff)
+		}
+		return errors.New("database differs from archive")
+	}
+
+	fmt.Println("Database matches archive")
+	return nil
+}
@@ -1,11 +1,47 @@
 package main
 
 import (
+	"simple-auth/cmd/internal/dbsetup"
+	"simple-auth/pkg/appcontext"
 	"simple-auth/pkg/config"
 	"simple-auth/pkg/db"
+	"simple-auth/pkg/email"
+	"simple-auth/pkg/services"
+
+	"github.com/sirupsen/logrus"
 )
 
+// organizationFlag scopes account lookups and creation to an organization (UUID or slug)
+var organizationFlag string
+
 func getDB() db.SADB {
 	config := config.Load()
-	return db.New(config.Db.Driver, config.Db.URL)
+	return openDB(config)
+}
+
+// openDB connects to the db, see dbsetup.Open, scoped to the --org flag
+func openDB(cfg *config.Config) db.SADB {
+	return inOrganizationFlag(dbsetup.Open(cfg))
+}
+
+// inOrganizationFlag scopes the db to the organization given by --org, if any
+func inOrganizationFlag(sadb db.SADB) db.SADB {
+	if organizationFlag == "" {
+		return sadb
+	}
+	org, err := sadb.FindOrganization(organizationFlag)
+	if err != nil {
+		logrus.Fatalf("Unable to find organization %s: %v", organizationFlag, err)
+	}
+	return sadb.InOrganization(org)
+}
+
+// newLocalLoginService applies the same rules to local logins as the server does (eg. the password policy)
+func newLocalLoginService(cfg *config.Config, sadb db.SADB) services.LocalLoginService {
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+	ctx.Use(appcontext.WithLogger(logrus.StandardLogger()))
+
+	return services.NewLocalLoginService(email.NewFromConfig(&cfg.Email), &cfg.Metadata, &cfg.Providers.Local, &cfg.Providers.Settings.Terms, cfg.Web.GetBaseURL()).
+		WithContext(ctx)
 }
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"simple-auth/pkg/config"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/lib/passhash"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+var cmdImport = &cli.Command{
+	Name:      "import",
+	Category:  "user",
+	Usage:     "Bulk import users, with existing password hashes, from htpasswd, csv, or jsonl",
+	ArgsUsage: "<file>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "htpasswd, csv, or jsonl. Defaults to the file extension",
+		},
+		&cli.StringFlag{
+			Name:  "email-domain",
+			Usage: "Domain to create emails with (username@domain), for records without an email (eg. htpasswd)",
+		},
+		&cli.BoolFlag{
+			Name:  "require-verification",
+			Usage: "Email each user a verification link, which they must follow before login. Otherwise emails are considered verified",
+		},
+		&cli.BoolFlag{
+			Name:  "skip-existing",
+			Usage: "Skip records whose email or username already exists, rather than failing",
+		},
+		&cli.IntFlag{
+			Name:  "batch-size",
+			Usage: "Number of users imported per transaction",
+			Value: 100,
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Validate and run the import, but roll back every batch",
+		},
+	},
+	Action: funcImport,
+}
+
+// importRecord is a single user to import. Columns in csv, and keys in jsonl, match the json tags
+type importRecord struct {
+	Line         int    `json:"-"`
+	Name         string `json:"name"`
+	Email        string `json:"email"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+}
+
+type importReader func(r io.Reader) ([]*importRecord, error)
+
+var importReaders = map[string]importReader{
+	"htpasswd": readHtpasswd,
+	"csv":      readImportCSV,
+	"jsonl":    readImportJSONL,
+}
+
+func readHtpasswd(r io.Reader) (ret []*importRecord, err error) {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected username:hash", line)
+		}
+		ret = append(ret, &importRecord{
+			Line:         line,
+			Name:         parts[0],
+			Username:     parts[0],
+			PasswordHash: parts[1],
+		})
+	}
+	return ret, scanner.Err()
+}
+
+func readImportCSV(r io.Reader) (ret []*importRecord, err error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read csv header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, col := range header {
+		columns[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	for _, required := range []string{"username", "password_hash"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("csv missing required column '%s'", required)
+		}
+	}
+
+	get := func(row []string, col string) string {
+		if i, ok := columns[col]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, &importRecord{
+			Line:         line,
+			Name:         get(row, "name"),
+			Email:        get(row, "email"),
+			Username:     get(row, "username"),
+			PasswordHash: get(row, "password_hash"),
+		})
+	}
+	return ret, nil
+}
+
+func readImportJSONL(r io.Reader) (ret []*importRecord, err error) {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		record := &importRecord{Line: line}
+		if err := json.Unmarshal([]byte(text), record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		ret = append(ret, record)
+	}
+	return ret, scanner.Err()
+}
+
+// normalize fills in defaults, and validates the record can be imported
+func (s *importRecord) normalize(emailDomain string) error {
+	s.Username = strings.TrimSpace(s.Username)
+	s.Email = strings.TrimSpace(s.Email)
+	if s.Username == "" {
+		return errors.New("missing username")
+	}
+	if s.Email == "" {
+		if emailDomain == "" {
+			return errors.New("missing email, and no --email-domain")
+		}
+		s.Email = s.Username + "@" + emailDomain
+	}
+	if s.Name == "" {
+		s.Name = s.Username
+	}
+	if !passhash.Supported(s.PasswordHash) {
+		return errors.New("unsupported password hash (expected bcrypt, argon2id, or scrypt)")
+	}
+	return nil
+}
+
+func funcImport(c *cli.Context) error {
+	filename := c.Args().First()
+	if filename == "" {
+		return errors.New("missing file")
+	}
+
+	format := c.String("format")
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(filename), ".")
+	}
+	reader, ok := importReaders[strings.ToLower(format)]
+	if !ok {
+		return fmt.Errorf("unknown format '%s', specify --format", format)
+	}
+
+	batchSize := c.Int("batch-size")
+	if batchSize <= 0 {
+		return errors.New("batch-size must be greater than 0")
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records, err := reader(f)
+	if err != nil {
+		return err
+	}
+
+	cfg := config.Load()
+	sadb := openDB(cfg)
+	localLogin := newLocalLoginService(cfg, sadb)
+
+	// Validate everything before writing to the db
+	seen := make(map[string]int)
+	for _, record := range records {
+		if err := record.normalize(c.String("email-domain")); err != nil {
+			return fmt.Errorf("line %d: %w", record.Line, err)
+		}
+		if err := localLogin.ValidateUsername(record.Username); err != nil {
+			return fmt.Errorf("line %d: %w", record.Line, err)
+		}
+		for _, key := range []string{"email:" + strings.ToLower(record.Email), "username:" + strings.ToLower(record.Username)} {
+			if prev, ok := seen[key]; ok {
+				return fmt.Errorf("line %d: duplicate %s (line %d)", record.Line, key, prev)
+			}
+			seen[key] = record.Line
+		}
+	}
+
+	fmt.Printf("Importing %d users...\n", len(records))
+
+	imported, skipped := 0, 0
+	for start := 0; start < len(records); start += batchSize {
+		end := start + batchSize
+		if end > len(records) {
+			end = len(records)
+		}
+
+		tx := sadb.BeginTransaction()
+		batchImported, batchSkipped, verifications, err := importBatch(cfg, tx, records[start:end], c.Bool("require-verification"), c.Bool("skip-existing"))
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("batch rolled back, %d users imported before failure: %w", imported, err)
+		}
+
+		if c.Bool("dry-run") {
+			err = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+		if err != nil {
+			return err
+		}
+
+		if !c.Bool("dry-run") {
+			for _, v := range verifications {
+				if err := localLogin.SendVerification(v.account, v.stip); err != nil {
+					fmt.Printf("  Unable to send verification email to %s: %v\n", v.account.Email, err)
+				}
+			}
+		}
+
+		imported += batchImported
+		skipped += batchSkipped
+		fmt.Printf("  %d/%d\n", end, len(records))
+	}
+
+	if c.Bool("dry-run") {
+		fmt.Printf("Dry run: %d users would be imported, %d skipped.\n", imported, skipped)
+	} else {
+		fmt.Printf("Imported %d users, %d skipped.\n", imported, skipped)
+	}
+	return nil
+}
+
+// importVerification is a verification email to send once its batch is committed
+type importVerification struct {
+	account *db.Account
+	stip    *db.TokenStipulation
+}
+
+func importBatch(cfg *config.Config, sadb db.SADB, records []*importRecord, requireVerification, skipExisting bool) (imported, skipped int, verifications []importVerification, err error) {
+	localLogin := newLocalLoginService(cfg, sadb)
+	for _, record := range records {
+		if skipExisting && importRecordExists(sadb, record) {
+			fmt.Printf("  Skipping existing user %s (line %d)\n", record.Username, record.Line)
+			skipped++
+			continue
+		}
+
+		account, err := sadb.CreateAccount(record.Name, record.Email)
+		if err != nil {
+			return imported, skipped, nil, fmt.Errorf("line %d: unable to create account %s: %w", record.Line, record.Email, err)
+		}
+
+		if _, err := sadb.CreateAuthLocalWithHash(account, record.Username, record.PasswordHash); err != nil {
+			return imported, skipped, nil, fmt.Errorf("line %d: unable to create login %s: %w", record.Line, record.Username, err)
+		}
+
+		if requireVerification {
+			stip, err := localLogin.RequireVerification(account)
+			if err != nil {
+				return imported, skipped, nil, fmt.Errorf("line %d: %w", record.Line, err)
+			}
+			verifications = append(verifications, importVerification{account, stip})
+		}
+
+		imported++
+	}
+	return imported, skipped, verifications, nil
+}
+
+func importRecordExists(sadb db.SADB, record *importRecord) bool {
+	if _, err := sadb.FindAccountByEmail(record.Email); err == nil {
+		return true
+	}
+	if _, err := sadb.FindAuthLocalByUsername(record.Username); err == nil {
+		return true
+	}
+	return false
+}
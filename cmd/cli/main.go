@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"simple-auth/cmd/internal/dbsetup"
 
 	"github.com/urfave/cli/v2"
 )
@@ -14,13 +15,31 @@ func cliMain(args ...string) error {
 		Description:            `CLI Tool for inspecting, testing, and modifying data for simple-auth`,
 		Version:                fmt.Sprintf("%s, %s", version, buildSha),
 		UseShortOptionHandling: true,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "org",
+				Usage:       "Organization UUID or slug that accounts are in",
+				EnvVars:     []string{"SA_ORGANIZATION"},
+				Destination: &organizationFlag,
+			},
+		},
 		Commands: []*cli.Command{
 			cmdAddUser,
+			cmdImport,
 			cmdPasswd,
 			cmdOneTime,
+			cmdAccount,
+			cmdRole,
+			cmdGroup,
+			cmdOrganization,
 			cmdStipulation,
 			cmdConfig,
 			cmdQuery,
+			cmdMigrate,
+			cmdBackup,
+			cmdRestore,
+			cmdVerify,
+			cmdAudit,
 		},
 		Copyright: `simple-auth  Copyright (C) 2020 Chris LaPointe
 		This program comes with ABSOLUTELY NO WARRANTY.
@@ -33,6 +52,7 @@ func cliMain(args ...string) error {
 
 func main() {
 	err := cliMain(os.Args...)
+	dbsetup.Close()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"simple-auth/pkg/db"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+var cmdRole = membershipCommand(db.MembershipRole, "roles")
+var cmdGroup = membershipCommand(db.MembershipGroup, "groups")
+
+// membershipCommand manages the accounts with a role or in a group
+func membershipCommand(kind db.MembershipKind, plural string) *cli.Command {
+	return &cli.Command{
+		Name:     string(kind),
+		Usage:    fmt.Sprintf("Manage account %s", plural),
+		Category: "user",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "add",
+				Usage:     fmt.Sprintf("Add a %s to an account", kind),
+				ArgsUsage: "<email> <name>",
+				Action:    membershipAction(kind, true),
+			},
+			{
+				Name:      "remove",
+				Usage:     fmt.Sprintf("Remove a %s from an account", kind),
+				ArgsUsage: "<email> <name>",
+				Action:    membershipAction(kind, false),
+			},
+			{
+				Name:      "list",
+				Usage:     fmt.Sprintf("List the %s of an account", plural),
+				ArgsUsage: "<email>",
+				Action: func(c *cli.Context) error {
+					email := c.Args().First()
+					if email == "" {
+						return errors.New("missing email")
+					}
+
+					sadb := getDB()
+					account, err := sadb.FindAccountByEmail(email)
+					if err != nil {
+						return fmt.Errorf("unable to find account for %s: %w", email, err)
+					}
+					memberships, err := sadb.GetMemberships(account)
+					if err != nil {
+						return err
+					}
+
+					names := memberships.Roles
+					if kind == db.MembershipGroup {
+						names = memberships.Groups
+					}
+					fmt.Println(strings.Join(names, "\n"))
+					return nil
+				},
+			},
+			{
+				Name:      "members",
+				Usage:     fmt.Sprintf("List the accounts with a %s", kind),
+				ArgsUsage: "<name>",
+				Action: func(c *cli.Context) error {
+					name := c.Args().First()
+					if name == "" {
+						return fmt.Errorf("missing %s", kind)
+					}
+
+					accounts, err := getDB().FindMembers(kind, name)
+					if err != nil {
+						return err
+					}
+					for _, account := range accounts {
+						fmt.Printf("%s\t%s\t%s\n", account.UUID, account.Email, account.Name)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+func membershipAction(kind db.MembershipKind, add bool) cli.ActionFunc {
+	verb := "removed from"
+	if add {
+		verb = "added to"
+	}
+
+	return func(c *cli.Context) error {
+		name := c.Args().Get(1)
+		if name == "" {
+			return fmt.Errorf("missing %s", kind)
+		}
+
+		return accountAction(func(sadb db.SADB, account *db.Account) error {
+			if add {
+				return sadb.AddMembership(account, kind, name)
+			}
+			return sadb.RemoveMembership(account, kind, name)
+		}, fmt.Sprintf("%s %s %s", verb, kind, name))(c)
+	}
+}
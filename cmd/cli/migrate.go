@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"simple-auth/cmd/internal/dbsetup"
+	"simple-auth/pkg/config"
+	"simple-auth/pkg/db"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+)
+
+var cmdMigrate = &cli.Command{
+	Name:  "migrate",
+	Usage: "Inspect and apply database schema migrations",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "status",
+			Usage:  "List migrations, and whether they've been applied",
+			Action: funcMigrateStatus,
+		},
+		{
+			Name:      "up",
+			Usage:     "Apply pending migrations",
+			ArgsUsage: "[version]",
+			Action:    funcMigrateUp,
+		},
+		{
+			Name:      "down",
+			Usage:     "Revert applied migrations until the schema is at version",
+			ArgsUsage: "<version>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "yes",
+					Usage: "Confirm reverting, which may discard data",
+				},
+			},
+			Action: funcMigrateDown,
+		},
+	},
+}
+
+func openSchemaDB() db.SADB {
+	config := config.Load()
+	return db.Open(config.Db.Driver, config.Db.URL, dbsetup.Options(config)...)
+}
+
+func funcMigrateStatus(c *cli.Context) error {
+	sadb := openSchemaDB()
+
+	status, err := sadb.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range status {
+		applied := "pending"
+		if m.AppliedAt != nil {
+			applied = m.AppliedAt.String()
+		}
+		fmt.Printf("%d\t%s\t%s\n", m.Version, m.Name, applied)
+	}
+
+	return nil
+}
+
+func funcMigrateUp(c *cli.Context) error {
+	target := 0
+	if arg := c.Args().First(); arg != "" {
+		v, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid version: %w", err)
+		}
+		target = v
+	}
+
+	sadb := openSchemaDB()
+	applied, err := sadb.MigrateUp(target)
+	for _, m := range applied {
+		fmt.Printf("Applied %d\t%s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		fmt.Println("Schema is up to date.")
+	} else {
+		fmt.Println("Done.")
+	}
+	return nil
+}
+
+func funcMigrateDown(c *cli.Context) error {
+	arg := c.Args().First()
+	if arg == "" {
+		return errors.New("missing version")
+	}
+	target, err := strconv.Atoi(arg)
+	if err != nil {
+		return fmt.Errorf("invalid version: %w", err)
+	}
+	if !c.Bool("yes") {
+		return errors.New("reverting migrations may discard data, re-run with --yes to confirm")
+	}
+
+	sadb := openSchemaDB()
+	reverted, err := sadb.MigrateDown(target)
+	for _, m := range reverted {
+		fmt.Printf("Reverted %d\t%s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Done.")
+	return nil
+}
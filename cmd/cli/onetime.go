@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"simple-auth/pkg/config"
-	"simple-auth/pkg/db"
 	"time"
 
 	"github.com/urfave/cli/v2"
@@ -36,7 +35,7 @@ func funcOneTime(c *cli.Context) error {
 	}
 
 	config := config.Load()
-	db := db.New(config.Db.Driver, config.Db.URL)
+	db := openDB(config)
 	account, err := db.FindAccountByEmail(email)
 	if err != nil {
 		return fmt.Errorf("unable to find account for %s: %w", email, err)
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"simple-auth/pkg/db"
+
+	"github.com/urfave/cli/v2"
+)
+
+var cmdOrganization = &cli.Command{
+	Name:     "org",
+	Usage:    "Manage organizations, and the accounts in them",
+	Category: "user",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "create",
+			Usage:     "Create an organization",
+			ArgsUsage: "<slug> [name]",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "signup",
+					Usage: "Allow anyone to create an account in the organization",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				slug := c.Args().First()
+				if slug == "" {
+					return errors.New("missing slug")
+				}
+
+				sadb := getDB()
+				org, err := sadb.CreateOrganization(slug, c.Args().Get(1))
+				if err != nil {
+					return err
+				}
+				if c.Bool("signup") {
+					org.AllowSignup = true
+					if err := sadb.UpdateOrganization(org); err != nil {
+						return err
+					}
+				}
+
+				fmt.Printf("Organization: %s\n", org.UUID)
+				return nil
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "List organizations",
+			Action: func(c *cli.Context) error {
+				orgs, err := getDB().GetOrganizations()
+				if err != nil {
+					return err
+				}
+				for _, org := range orgs {
+					fmt.Printf("%s\t%s\t%s\n", org.UUID, org.Slug, org.Name)
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "assign",
+			Usage:     "Move an account into an organization. Use the global --org flag to find accounts already in one",
+			ArgsUsage: "<email> <org>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "admin",
+					Usage: "Account administers the organization",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				target := c.Args().Get(1)
+				if target == "" {
+					return errors.New("missing organization")
+				}
+
+				return accountAction(func(sadb db.SADB, account *db.Account) error {
+					org, err := sadb.FindOrganization(target)
+					if err != nil {
+						return err
+					}
+					return sadb.SetAccountOrganization(account, org, c.Bool("admin"))
+				}, "moved to organization "+target)(c)
+			},
+		},
+		{
+			Name:      "remove",
+			Usage:     "Remove an account from its organization. Use with the global --org flag",
+			ArgsUsage: "<email>",
+			Action: accountAction(func(sadb db.SADB, account *db.Account) error {
+				return sadb.SetAccountOrganization(account, nil, false)
+			}, "removed from its organization"),
+		},
+		{
+			Name:      "delete",
+			Usage:     "Delete an organization. It must not have any accounts",
+			ArgsUsage: "<org>",
+			Action: func(c *cli.Context) error {
+				slug := c.Args().First()
+				if slug == "" {
+					return errors.New("missing organization")
+				}
+
+				sadb := getDB()
+				org, err := sadb.FindOrganization(slug)
+				if err != nil {
+					return err
+				}
+				if err := sadb.DeleteOrganization(org); err != nil {
+					return err
+				}
+				fmt.Println("Organization deleted.")
+				return nil
+			},
+		},
+	},
+}
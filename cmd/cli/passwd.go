@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"simple-auth/pkg/config"
 
 	"github.com/urfave/cli/v2"
 )
@@ -12,7 +13,13 @@ var cmdPasswd = &cli.Command{
 	Category:  "user",
 	Usage:     "Change or set password for simple-auth user",
 	ArgsUsage: "<username>",
-	Action:    funcPasswd,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "require-change",
+			Usage: "Require the user to change the password on their next login, eg. when setting a temporary password",
+		},
+	},
+	Action: funcPasswd,
 }
 
 func funcPasswd(c *cli.Context) error {
@@ -30,17 +37,24 @@ func funcPasswd(c *cli.Context) error {
 
 	// Make the modifications
 	fmt.Println("Updating password...")
-	db := getDB()
+	cfg := config.Load()
+	db := openDB(cfg)
 	authLocal, err := db.FindAuthLocalByUsername(username)
 	if err != nil {
 		return fmt.Errorf("unable to find account: %w", err)
 	}
 
-	err = db.UpdateAuthLocalPassword(authLocal, password)
+	err = newLocalLoginService(cfg, db).UpdatePasswordUnsafe(authLocal, password)
 	if err != nil {
 		return fmt.Errorf("unable to update password: %w", err)
 	}
 
+	if c.Bool("require-change") {
+		if err := db.RequirePasswordChange(authLocal.Account()); err != nil {
+			return fmt.Errorf("unable to require password change: %w", err)
+		}
+	}
+
 	fmt.Println("Done.")
 
 	return nil
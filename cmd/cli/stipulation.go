@@ -3,6 +3,8 @@ package main
 import (
 	"errors"
 	"fmt"
+	"simple-auth/pkg/db"
+	"time"
 
 	"github.com/urfave/cli/v2"
 )
@@ -11,16 +13,167 @@ var cmdStipulation = &cli.Command{
 	Name:  "stipulation",
 	Usage: "Modify stipulations on an account",
 	Subcommands: []*cli.Command{
+		cmdStipulationList,
+		cmdStipulationAdd,
+		cmdStipulationRemove,
 		cmdStipulationRemoveAll,
+		cmdStipulationRequirePasswordChange,
 	},
 }
 
+var cmdStipulationList = &cli.Command{
+	Name:      "list",
+	Usage:     "List the stipulations on an account",
+	ArgsUsage: "<email>",
+	Action:    funcListStipulations,
+}
+
+var cmdStipulationAdd = &cli.Command{
+	Name:      "add",
+	Usage:     "Add a stipulation to an account",
+	ArgsUsage: "<email> <token|manual|password-reset|terms|enroll-2fa>",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "expires",
+			Usage: "token: How long the code is valid. 0 never expires",
+		},
+		&cli.StringFlag{
+			Name:  "version",
+			Usage: "terms: Version of the terms to accept",
+		},
+		&cli.IntFlag{
+			Name:  "grace",
+			Usage: "enroll-2fa: Logins allowed before enrollment is enforced",
+		},
+	},
+	Action: funcAddStipulation,
+}
+
+var cmdStipulationRemove = &cli.Command{
+	Name:      "remove",
+	Usage:     "Remove the stipulations of a type from an account",
+	ArgsUsage: "<email> <type>",
+	Action:    funcRemoveStipulations,
+}
+
 var cmdStipulationRemoveAll = &cli.Command{
 	Name:      "removeall",
 	ArgsUsage: "<email>",
 	Action:    funcRemoveAllStipulations,
 }
 
+var cmdStipulationRequirePasswordChange = &cli.Command{
+	Name:      "require-password-change",
+	Usage:     "Require accounts to change their password on next login",
+	ArgsUsage: "<email>...",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "Every active account with a local login, in the --org organization",
+		},
+	},
+	Action: funcRequirePasswordChange,
+}
+
+func funcListStipulations(c *cli.Context) error {
+	email := c.Args().First()
+
+	if email == "" {
+		return errors.New("missing email")
+	}
+
+	sadb := getDB()
+
+	account, err := sadb.FindAccountByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	stips, err := sadb.ListStipulations(account)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Account: %s\n", account.UUID)
+	for _, stip := range stips {
+		fmt.Printf("%-15s %s  %s\n", stip.Type, stip.Created.Format(time.RFC3339), stip.Specification)
+	}
+	fmt.Printf("%d stipulations\n", len(stips))
+	return nil
+}
+
+func funcAddStipulation(c *cli.Context) error {
+	email := c.Args().Get(0)
+	stipType := db.StipulationType(c.Args().Get(1))
+
+	if email == "" || stipType == "" {
+		return errors.New("missing email or type")
+	}
+
+	sadb := getDB()
+
+	account, err := sadb.FindAccountByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Account: %s\n", account.UUID)
+
+	switch stipType {
+	case (&db.TokenStipulation{}).Type():
+		stip := db.NewExpiringTokenStipulation(c.Duration("expires"))
+		if err := sadb.AddStipulation(account, stip); err != nil {
+			return err
+		}
+		fmt.Printf("Code: %s\n", stip.Code)
+	case (&db.ManualStipulation{}).Type():
+		err = sadb.AddStipulation(account, &db.ManualStipulation{})
+	case (&db.PasswordResetStipulation{}).Type():
+		err = sadb.RequirePasswordChange(account)
+	case (&db.TermsStipulation{}).Type():
+		if c.String("version") == "" {
+			return errors.New("missing --version")
+		}
+		_, err = sadb.RequireTerms(account, c.String("version"))
+	case (&db.TwoFactorEnrollStipulation{}).Type():
+		err = sadb.RequireTwoFactorEnrollment(account, c.Int("grace"))
+	default:
+		return fmt.Errorf("unsupported stipulation type: %s", stipType)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Done.")
+	return nil
+}
+
+func funcRemoveStipulations(c *cli.Context) error {
+	email := c.Args().Get(0)
+	stipType := db.StipulationType(c.Args().Get(1))
+
+	if email == "" || stipType == "" {
+		return errors.New("missing email or type")
+	}
+
+	sadb := getDB()
+
+	account, err := sadb.FindAccountByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Account: %s\n", account.UUID)
+
+	count, err := sadb.RemoveStipulations(account, stipType)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %d stipulations.\n", count)
+	return nil
+}
+
 func funcRemoveAllStipulations(c *cli.Context) error {
 	email := c.Args().First()
 
@@ -45,3 +198,34 @@ func funcRemoveAllStipulations(c *cli.Context) error {
 	fmt.Println("Done.")
 	return nil
 }
+
+func funcRequirePasswordChange(c *cli.Context) error {
+	if c.Bool("all") == c.Args().Present() {
+		return errors.New("pass either emails or --all")
+	}
+
+	db := getDB()
+
+	if c.Bool("all") {
+		count, err := db.RequirePasswordChangeAll()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Required %d accounts to change their password.\n", count)
+		return nil
+	}
+
+	for _, email := range c.Args().Slice() {
+		account, err := db.FindAccountByEmail(email)
+		if err != nil {
+			return fmt.Errorf("unable to find account %s: %w", email, err)
+		}
+		if err := db.RequirePasswordChange(account); err != nil {
+			return err
+		}
+		fmt.Printf("Account: %s\n", account.UUID)
+	}
+
+	fmt.Println("Done.")
+	return nil
+}
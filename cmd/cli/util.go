@@ -12,7 +12,7 @@ import (
 
 func readPassword(prompt string) (string, error) {
 	originalState, _ := terminal.GetState(int(syscall.Stdin))
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 
 	go func() {
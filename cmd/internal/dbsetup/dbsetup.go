@@ -0,0 +1,74 @@
+// Package dbsetup opens the configured database the same way for the server and the cli
+package dbsetup
+
+import (
+	"simple-auth/pkg/config"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/lib/auditsink"
+	"simple-auth/pkg/lib/passhash"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Open connects to the configured database, and either migrates it, or
+// refuses to operate on a schema that isn't current
+func Open(cfg *config.Config) db.SADB {
+	opts := Options(cfg)
+	if cfg.Db.AutoMigrate {
+		return db.New(cfg.Db.Driver, cfg.Db.URL, opts...)
+	}
+
+	sadb := db.Open(cfg.Db.Driver, cfg.Db.URL, opts...)
+	if err := db.CheckSchema(sadb); err != nil {
+		logrus.Fatal(err)
+	}
+	return sadb
+}
+
+// Options are the db options from the config
+func Options(cfg *config.Config) []db.Option {
+	hasher, err := passhash.NewFromConfig(&cfg.Providers.Local.PasswordHash)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	ipPolicy, err := db.ParseAuditIPPolicy(cfg.Audit.IPAddress)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if ipPolicy == db.AuditIPHash && cfg.Db.TokenSecret == "" {
+		logrus.Fatal("audit.ipaddress 'hash' requires db.tokensecret to be set, otherwise IPs can be recovered from their hash")
+	}
+
+	opts := []db.Option{
+		db.WithTokenSecret(cfg.Db.TokenSecret),
+		db.WithPasswordHasher(hasher),
+		db.WithAuditIPPolicy(ipPolicy),
+	}
+	if sink := openAuditSink(cfg); sink != nil {
+		opts = append(opts, db.WithAuditSink(sink))
+	}
+	return opts
+}
+
+// auditSink is opened once, and closed on exit so that pending records are flushed
+var auditSink auditsink.Sink
+
+func openAuditSink(cfg *config.Config) auditsink.Sink {
+	if auditSink == nil {
+		sink, err := auditsink.NewFromConfig(&cfg.Audit.Sinks)
+		if err != nil {
+			logrus.Fatalf("Unable to open audit sinks: %v", err)
+		}
+		auditSink = sink
+	}
+	return auditSink
+}
+
+// Close flushes and closes the audit sink, if it was opened
+func Close() {
+	if auditSink != nil {
+		if err := auditSink.Close(); err != nil {
+			logrus.Warnf("Error closing audit sinks: %v", err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"simple-auth/pkg/config"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/lib/checkpoint"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// startAuditCheckpoints periodically appends a signed checkpoint of the audit chain to a file
+func startAuditCheckpoints(sadb db.SADB, cfg *config.ConfigAuditCheckpoint) {
+	if cfg.Interval == "" || cfg.Interval == "0" {
+		return
+	}
+
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil || interval <= 0 {
+		logrus.Fatalf("Invalid audit checkpoint interval '%s'", cfg.Interval)
+	}
+	signer, err := checkpoint.NewFromConfig(cfg)
+	if err != nil {
+		logrus.Fatalf("Unable to write audit checkpoints: %v", err)
+	}
+
+	logrus.Infof("Writing audit checkpoints to %s every %s", cfg.Path, interval)
+	go func() {
+		for {
+			time.Sleep(interval)
+			if err := writeAuditCheckpoint(sadb, signer, cfg.Path); err != nil {
+				logrus.Warnf("Unable to write audit checkpoint: %v", err)
+			}
+		}
+	}()
+}
+
+func writeAuditCheckpoint(sadb db.SADB, signer *checkpoint.Signer, path string) error {
+	_, signed, err := signer.SignHead(sadb)
+	if err == checkpoint.ErrEmptyChain {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return checkpoint.AppendFile(path, signed)
+}
@@ -5,14 +5,18 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"simple-auth/cmd/internal/dbsetup"
 	"simple-auth/pkg/appcontext"
 	"simple-auth/pkg/box"
 	"simple-auth/pkg/box/echobox"
 	"simple-auth/pkg/config"
 	"simple-auth/pkg/db"
+	"simple-auth/pkg/email"
+	"simple-auth/pkg/lib/attributes"
 	"simple-auth/pkg/routes/api"
 	"simple-auth/pkg/routes/api/providers"
 	saMiddleware "simple-auth/pkg/routes/middleware"
+	"simple-auth/pkg/services"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -55,6 +59,9 @@ func simpleAuthServer(config *config.Config) error {
 	if config.Web.Login.Cookie.JWT.SigningKey == "" {
 		logrus.Warn("No web.login.cookie.jwt.signingkey is set, user will not be able to login")
 	}
+	if config.Db.TokenSecret == "" {
+		logrus.Warn("No db.tokensecret is set, stored tokens are hashed without a key, so a copy of the db can be used to guess them")
+	}
 
 	box.Global.Verbose = config.Verbose
 	box.Global.CheckDisk = config.StaticFromDisk
@@ -64,8 +71,17 @@ func simpleAuthServer(config *config.Config) error {
 	}
 
 	// Dependencies
-	db := db.New(config.Db.Driver, config.Db.URL)
+	attributeSchema, err := attributes.NewFromConfig(config.Attributes)
+	if err != nil {
+		logrus.Fatalf("Invalid attributes config: %v", err)
+	}
+
+	db := dbsetup.Open(config)
 	db.EnableLogging(config.Db.Debug)
+	requireTerms(db, &config.Providers.Settings.Terms)
+	startReaper(db, &config.Db.Reaper)
+	startAuditCheckpoints(db, &config.Audit.Checkpoint)
+	startPasswordExpiryReminders(db, config)
 
 	e := echo.New()
 	e.Debug = !config.Production
@@ -80,11 +96,14 @@ func simpleAuthServer(config *config.Config) error {
 	e.Use(saMiddleware.NewCorrelationMiddleware(false, true))
 	e.Use(saMiddleware.NewRequestLoggerMiddleware())
 	e.Use(appcontext.WithSADB(db).Middleware())
+	e.Use(saMiddleware.NewOrganizationMiddleware())
+	e.Use(appcontext.WithAttributeSchema(attributeSchema).Middleware())
 
 	// Gateway
 	if config.Web.Gateway.Enabled {
 		log.Infof("Enabling authentication gateway: %v", config.Web.Gateway.Targets)
-		e.Use(saMiddleware.AuthenticationGateway(&config.Web.Gateway, &config.Web.Login.Cookie))
+		localLogin := services.NewLocalLoginService(email.NewFromConfig(&config.Email), &config.Metadata, &config.Providers.Local, &config.Providers.Settings.Terms, config.Web.GetBaseURL())
+		e.Use(saMiddleware.AuthenticationGateway(&config.Web.Gateway, &config.Web.Login.Cookie, localLogin))
 	}
 
 	// Static app router
@@ -133,6 +152,7 @@ func simpleAuthServer(config *config.Config) error {
 
 func main() {
 	err := simpleAuthServer(config.Load(os.Args[1:]...))
+	dbsetup.Close()
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logrus.Fatal(err)
 	}
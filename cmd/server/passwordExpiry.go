@@ -0,0 +1,44 @@
+package main
+
+import (
+	"simple-auth/pkg/appcontext"
+	"simple-auth/pkg/config"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/email"
+	"simple-auth/pkg/services"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// startPasswordExpiryReminders periodically emails accounts whose password expires soon
+func startPasswordExpiryReminders(sadb db.SADB, cfg *config.Config) {
+	expiry := &cfg.Providers.Local.PasswordExpiry
+	if expiry.MaxAgeDays <= 0 || expiry.WarnDays <= 0 {
+		return
+	}
+
+	interval, err := time.ParseDuration(expiry.CheckInterval)
+	if err != nil || interval <= 0 {
+		logrus.Fatalf("Invalid password expiry check interval '%s'", expiry.CheckInterval)
+	}
+
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+	ctx.Use(appcontext.WithLogger(logrus.StandardLogger()))
+	localLogin := services.NewLocalLoginService(email.NewFromConfig(&cfg.Email), &cfg.Metadata, &cfg.Providers.Local, &cfg.Providers.Settings.Terms, cfg.Web.GetBaseURL()).
+		WithContext(ctx)
+
+	logrus.Infof("Reminding of passwords expiring within %d days, every %s", expiry.WarnDays, interval)
+	go func() {
+		for {
+			reminded, err := localLogin.RemindExpiringPasswords()
+			if err != nil {
+				logrus.Warnf("Unable to remind of expiring passwords: %v", err)
+			} else if reminded > 0 {
+				logrus.Infof("Reminded %d accounts that their password expires", reminded)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
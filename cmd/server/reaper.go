@@ -0,0 +1,82 @@
+package main
+
+import (
+	"simple-auth/pkg/config"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/instrumentation"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var reaperCounter instrumentation.Counter = instrumentation.NewCounter("sa_reaper_deleted", "Rows removed by the database reaper", "kind")
+
+func parseReaperDuration(name, val string) time.Duration {
+	if val == "" || val == "0" {
+		return 0
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		logrus.Fatalf("Invalid reaper %s duration '%s': %v", name, val, err)
+	}
+	return d
+}
+
+// startReaper periodically removes expired and stale data from the database
+func startReaper(sadb db.SADB, cfg *config.ConfigDatabaseReaper) {
+	if !cfg.Enabled {
+		return
+	}
+
+	interval := parseReaperDuration("interval", cfg.Interval)
+	if interval <= 0 {
+		logrus.Fatal("Reaper interval must be greater than 0")
+	}
+	opts := db.ReapOptions{
+		Retention:             parseReaperDuration("retention", cfg.Retention),
+		DeleteUnverifiedAfter: parseReaperDuration("deleteunverifiedafter", cfg.DeleteUnverifiedAfter),
+		AuditRetention:        parseReaperDuration("auditretention", cfg.AuditRetention),
+	}
+
+	logrus.Infof("Starting database reaper every %s", interval)
+	if opts.DeleteUnverifiedAfter > 0 {
+		logrus.Warnf("Reaper will permanently delete accounts still unverified after %s", opts.DeleteUnverifiedAfter)
+	}
+	go func() {
+		for {
+			reap(sadb, opts)
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func reap(sadb db.SADB, opts db.ReapOptions) {
+	tx := sadb.BeginTransaction()
+	result, err := tx.Reap(opts)
+	if err != nil {
+		tx.Rollback()
+		logrus.Warnf("Database reaper failed: %v", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logrus.Warnf("Database reaper failed to commit: %v", err)
+		return
+	}
+
+	// Seal any audit records that were missed (eg. a failed seal after a commit)
+	if _, err := sadb.SealAuditChain(); err != nil {
+		logrus.Warnf("Database reaper failed to seal audit chain: %v", err)
+	}
+
+	reaperCounter.Add(float64(result.ExpiredOAuthTokens), "oauth_token")
+	reaperCounter.Add(float64(result.ExpiredOneTimeTokens), "onetime_token")
+	reaperCounter.Add(float64(result.SoftDeleted), "soft_deleted")
+	reaperCounter.Add(float64(result.UnverifiedAccounts), "unverified_account")
+	if result.UnverifiedAccounts > 0 {
+		logrus.Warnf("Reaper deleted %d unverified accounts", result.UnverifiedAccounts)
+	}
+	reaperCounter.Add(float64(result.AuditRecords), "audit_record")
+
+	logrus.Infof("Reaped %d expired oauth tokens, %d expired one-time tokens, %d deleted rows, %d unverified accounts, %d audit records",
+		result.ExpiredOAuthTokens, result.ExpiredOneTimeTokens, result.SoftDeleted, result.UnverifiedAccounts, result.AuditRecords)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"simple-auth/pkg/config"
+	"simple-auth/pkg/db"
+
+	"github.com/sirupsen/logrus"
+)
+
+// requireTerms requires every account that hasn't accepted the configured terms version to accept
+// it. Accounts created afterwards are required to when they login
+func requireTerms(sadb db.SADB, cfg *config.ConfigTerms) {
+	if cfg.Version == "" {
+		return
+	}
+
+	count, err := sadb.RequireTermsAll(cfg.Version)
+	if err != nil {
+		logrus.Fatalf("Unable to require terms version %s: %v", cfg.Version, err)
+	}
+	logrus.Infof("Terms version %s, newly required for %d accounts", cfg.Version, count)
+}
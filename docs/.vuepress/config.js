@@ -34,8 +34,12 @@ module.exports = {
           '/download',
           '/config',
           '/customization',
+          '/attributes',
+          '/roles',
+          '/organizations',
           '/email',
           '/database',
+          '/audit',
           '/cli',
         ],
       },
@@ -0,0 +1,24 @@
+package appcontext
+
+import (
+	"simple-auth/pkg/lib/attributes"
+)
+
+const attributesContextKey = "appcontext.attributes"
+
+func WithAttributeSchema(schema *attributes.Schema) ProviderFunc {
+	return func(c Context) (string, interface{}) {
+		return attributesContextKey, schema
+	}
+}
+
+// GetAttributeSchema returns the declared account attributes. If none were provided,
+// returns a nil schema, which has no attributes
+func GetAttributeSchema(c Context) *attributes.Schema {
+	if c != nil {
+		if schema, ok := c.Get(attributesContextKey).(*attributes.Schema); ok {
+			return schema
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package appcontext
+
+const correlationIDContextKey = "appcontext.correlationID"
+
+func SetCorrelationID(c RWContext, cid string) {
+	c.Set(correlationIDContextKey, cid)
+}
+
+// GetCorrelationID returns the request's correlation ID, or empty if there isn't one
+func GetCorrelationID(c Context) string {
+	if c != nil {
+		if cid, ok := c.Get(correlationIDContextKey).(string); ok {
+			return cid
+		}
+	}
+	return ""
+}
@@ -13,11 +13,19 @@ type sadbWrapper struct {
 	transaction db.SADBTransaction
 }
 
-func WithSADB(db db.SADB) ProviderFunc {
+func WithSADB(sadb db.SADB) ProviderFunc {
 	return func(c Context) (string, interface{}) {
 		log := GetLogger(c)
+		root := sadb.WithLogger(log)
+		if ec, ok := c.(echo.Context); ok {
+			root = root.WithAuditContext(&db.AuditContext{
+				IP:            ec.RealIP(),
+				UserAgent:     ec.Request().UserAgent(),
+				CorrelationID: GetCorrelationID(c),
+			})
+		}
 		return dbContextKey, &sadbWrapper{
-			root: db.WithLogger(log),
+			root: root,
 		}
 	}
 }
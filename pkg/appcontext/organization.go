@@ -0,0 +1,25 @@
+package appcontext
+
+import "simple-auth/pkg/db"
+
+const organizationContextKey = "appcontext.organization"
+
+// SetOrganization scopes the request's db, and any transaction later started from it, to the organization
+func SetOrganization(c RWContext, org *db.Organization) {
+	dbw := c.Get(dbContextKey).(*sadbWrapper)
+	if dbw.transaction != nil {
+		panic("Organization must be set before a transaction is started")
+	}
+	dbw.root = dbw.root.InOrganization(org)
+	c.Set(organizationContextKey, org)
+}
+
+// GetOrganization returns the organization the request is scoped to, or nil if none
+func GetOrganization(c Context) *db.Organization {
+	if c != nil {
+		if org, ok := c.Get(organizationContextKey).(*db.Organization); ok {
+			return org
+		}
+	}
+	return nil
+}
@@ -5,10 +5,77 @@ import (
 )
 
 // ConfigDatabase holds database-specific configuration
+type ConfigDatabaseReaper struct {
+	Enabled               bool
+	Interval              string // Parsed as Duration, how often the reaper runs
+	Retention             string // Parsed as Duration, how long to keep soft-deleted rows. 0 keeps forever
+	DeleteUnverifiedAfter string // Parsed as Duration, accounts whose email is still unverified after this are permanently deleted. 0 never deletes
+	AuditRetention        string // Parsed as Duration, how long to keep audit records. 0 keeps forever
+}
+
 type ConfigDatabase struct {
-	Driver string
-	URL    string
-	Debug  bool
+	Driver      string
+	URL         string
+	Debug       bool
+	AutoMigrate bool   // If true, pending schema migrations are applied on startup
+	TokenSecret string // Secret used to hash stored tokens. Changing it will invalidate all tokens
+	Reaper      ConfigDatabaseReaper
+}
+
+type ConfigAudit struct {
+	IPAddress  string // How client IPs are stored on audit records: full, truncate, hash, or none
+	Sinks      ConfigAuditSinks
+	Checkpoint ConfigAuditCheckpoint
+}
+
+// ConfigAuditCheckpoint signs the head of the audit hash-chain, to be kept outside of the database
+type ConfigAuditCheckpoint struct {
+	SigningMethod string // As defined by go-jwt. Commonly HS256, HS512, RS256, RS512
+	SigningKey    string // If RS based, will be parsed as PEM
+	Interval      string // Parsed as Duration, how often the server appends a checkpoint to Path. 0 disables
+	Path          string
+}
+
+// ConfigAuditSinks are destinations, besides the database, that every audit record is sent to
+type ConfigAuditSinks struct {
+	File    ConfigAuditFileSink
+	Syslog  ConfigAuditSyslogSink
+	Webhook ConfigAuditWebhookSink
+}
+
+type ConfigAuditFileSink struct {
+	Enabled    bool
+	Path       string // JSON-lines file that records are appended to
+	MaxSizeMB  int    // Size the file is rotated at. 0 never rotates
+	MaxBackups int    // Number of rotated files kept
+}
+
+type ConfigAuditSyslogSink struct {
+	Enabled  bool
+	Network  string // "udp", "tcp", "unix", or empty for the local syslog socket
+	Address  string
+	Tag      string // APP-NAME of each message
+	Facility string
+}
+
+type ConfigAuditWebhookSink struct {
+	Enabled      bool
+	URL          string
+	SharedSecret string // If set, signs the body in the X-Simple-Auth-Signature header
+	Timeout      string
+	Retries      int
+}
+
+// ConfigAccountAttribute declares a custom profile attribute stored on each account
+type ConfigAccountAttribute struct {
+	Name      string // Key the attribute is stored and returned by
+	Type      string // string, number, bool, or url
+	Pattern   string // If non-empty, regex the value must match
+	Editable  bool   // If true, the user can change their own value. Otherwise, only admins can
+	AdminOnly bool   // If true, only visible to admins, and never included in tokens
+	Claim     string // Claim name in tokens; defaults to Name
+	Scope     string // OAuth2 scope that includes the attribute in ID tokens and introspection. Empty never includes it
+	Session   bool   // If true, included in the session JWT
 }
 
 type ConfigMetadata struct {
@@ -26,8 +93,12 @@ type (
 	}
 
 	ConfigVouchAuthenticator struct {
-		Enabled    bool
-		UserHeader string // If non-empty, will respond with the user's ID in a header
+		Enabled            bool
+		UserHeader         string   // If non-empty, will respond with the user's ID in a header
+		RolesHeader        string   // If non-empty, will respond with the user's roles, comma-separated, in a header
+		GroupsHeader       string   // If non-empty, will respond with the user's groups, comma-separated, in a header
+		OrganizationHeader string   // If non-empty, will respond with the user's organization ID in a header
+		RequireGroups      []string // If non-empty, the user must be in at least one of the groups, otherwise 403
 	}
 
 	// ConfigOAuth2Client contains specific client settings
@@ -50,15 +121,16 @@ type (
 
 	// Common settings across all OAuth clients
 	ConfigOAuth2Settings struct {
-		IssueRefreshToken   *bool
-		CodeExpiresSeconds  *int
-		TokenExpiresSeconds *int
-		CodeLength          *int
-		AllowAutoGrant      *bool
-		AllowCredentials    *bool
-		ReuseToken          *bool
-		RevokeOldTokens     *bool
-		Issuer              *string
+		IssueRefreshToken          *bool
+		CodeExpiresSeconds         *int
+		TokenExpiresSeconds        *int
+		RefreshTokenExpiresSeconds *int // 0 never expires
+		CodeLength                 *int
+		AllowAutoGrant             *bool
+		AllowCredentials           *bool
+		ReuseToken                 *bool // Deprecated: tokens are stored hashed, and can no longer be reused
+		RevokeOldTokens            *bool
+		Issuer                     *string
 	}
 
 	ConfigOAuth2 struct {
@@ -92,14 +164,15 @@ type (
 	}
 
 	ConfigLoginGateway struct {
-		Enabled    bool
-		BasicAuth  bool
-		Targets    []string
-		Host       string
-		LogoutPath string
-		Rewrite    map[string]string
-		Headers    map[string]string
-		NoCache    bool
+		Enabled       bool
+		BasicAuth     bool
+		Targets       []string
+		Host          string
+		LogoutPath    string
+		Rewrite       map[string]string
+		Headers       map[string]string
+		NoCache       bool
+		RequireGroups []string // If non-empty, the user must be in at least one of the groups, otherwise 403
 	}
 
 	ConfigLoginCookie struct {
@@ -155,11 +228,30 @@ type (
 
 // Providers
 type (
+	// ConfigTwoFactorPolicy requires accounts without 2FA to enroll
+	ConfigTwoFactorPolicy struct {
+		Everyone    bool     // Every account with a local login
+		Groups      []string // Accounts in any of the groups
+		Clients     []string // Accounts that use any of the OAuth2 clients
+		GraceLogins int      // Logins allowed before enrollment is enforced
+	}
+
 	ConfigTwoFactor struct {
-		Enabled   bool
-		KeyLength int
-		Issuer    string
-		Drift     int
+		Enabled       bool
+		KeyLength     int
+		Issuer        string
+		Drift         int
+		RecoveryCodes int // Single-use codes generated when 2FA is activated, to use if the device is lost. 0 disables
+		Require       ConfigTwoFactorPolicy
+	}
+
+	ConfigLockout struct {
+		Enabled            bool
+		Threshold          int    // Failed logins before the login is locked
+		Duration           string // Parsed as Duration, how long the first lock lasts. Doubled by each further failed login
+		MaxDuration        string // Parsed as Duration, caps how long a lock lasts. 0 is uncapped
+		PermanentThreshold int    // Failed logins after which the login stays locked until unlocked. 0 disables
+		UnlockExpires      string // Parsed as Duration, how long the emailed unlock link is valid
 	}
 
 	ConfigOIDCProvider struct {
@@ -180,14 +272,66 @@ type (
 		UsernameMaxLength int
 	}
 
+	ConfigPasswordPolicy struct {
+		RequireUpper   bool
+		RequireLower   bool
+		RequireDigit   bool
+		RequireSymbol  bool
+		RejectUserInfo bool   // Reject passwords containing the username or email
+		BreachedList   string // Path to a HIBP-format list of breached SHA-1 hashes: a file sorted by hash, or a directory of prefix files. Empty disables
+		History        int    // How many of the most recent passwords can't be reused. 0 disables
+	}
+
+	ConfigPasswordExpiry struct {
+		MaxAgeDays    int    // Days after being set that a password expires. 0 disables
+		WarnDays      int    // Days before expiry that the account is emailed a reminder. 0 disables
+		CheckInterval string // Parsed as Duration, how often to look for passwords due a reminder
+	}
+
+	ConfigPasswordHashBcrypt struct {
+		Cost int
+	}
+
+	ConfigPasswordHashArgon2id struct {
+		Time    uint32
+		Memory  uint32 // KiB
+		Threads uint8
+	}
+
+	ConfigPasswordHashScrypt struct {
+		N int // Must be a power of 2
+		R int
+		P int
+	}
+
+	ConfigPasswordHash struct {
+		Algorithm string // bcrypt, argon2id, scrypt
+		Bcrypt    ConfigPasswordHashBcrypt
+		Argon2id  ConfigPasswordHashArgon2id
+		Scrypt    ConfigPasswordHashScrypt
+	}
+
 	ConfigLocalProvider struct {
-		EmailValidationRequired bool
-		Requirements            ConfigLocalLoginRequirements
-		TwoFactor               ConfigTwoFactor
+		EmailValidationRequired       bool
+		EmailValidationExpires        string // Parsed as Duration, how long a verification link is valid. 0 never expires
+		EmailValidationResendInterval string // Parsed as Duration, how often a new verification link can be sent
+		Requirements                  ConfigLocalLoginRequirements
+		TwoFactor                     ConfigTwoFactor
+		Lockout                       ConfigLockout        // Locks a local login after repeated failed logins
+		PasswordPolicy                ConfigPasswordPolicy // Applied whenever a password is set, along with the length requirements
+		PasswordExpiry                ConfigPasswordExpiry // Requires passwords to be changed periodically
+		PasswordHash                  ConfigPasswordHash   // How new passwords are hashed. Existing hashes are upgraded on login
+	}
+
+	ConfigTerms struct {
+		Version string // Version of the terms every account must accept. Empty disables
+		URL     string // Where the terms can be read
 	}
 
 	ConfigProviderSettings struct {
 		CreateAccountEnabled bool
+		RequireApproval      bool // If true, new accounts can't login until approved by an admin
+		Terms                ConfigTerms
 	}
 
 	ConfigProviders struct {
@@ -213,10 +357,17 @@ type (
 	}
 )
 
+type ConfigAPIAdmin struct {
+	Enabled      bool
+	SharedSecret string   // Secret that grants admin access via the Authorization header
+	Accounts     []string // Account UUIDs that are granted admin access via their session
+}
+
 type ConfigAPI struct {
 	External         bool // If true, allows external API calls (outside of session API)
 	SharedSecret     string
 	ThrottleDuration string // Parsed as Duration, represents a delay from any major action (Helps mitigate brute-force attacks)
+	Admin            ConfigAPIAdmin
 }
 
 // Config represents the root configuration
@@ -224,14 +375,16 @@ type Config struct {
 	Include        []string
 	Metadata       ConfigMetadata
 	Db             ConfigDatabase
-	Web            ConfigWeb              // Configure how the user interacts with the web
-	Email          ConfigEmail            // SMTP/Email sending config
-	Providers      ConfigProviders        // Login providers
-	Authenticators ConfigAuthenticatorSet // Describes API Authenticators
-	API            ConfigAPI              // API configuration
-	Production     bool                   // Production changes how logs are generated and tighter security checks
-	Verbose        bool                   // Turns on additional logging
-	StaticFromDisk bool                   // Checks the disk for static files
+	Web            ConfigWeb                 // Configure how the user interacts with the web
+	Email          ConfigEmail               // SMTP/Email sending config
+	Providers      ConfigProviders           // Login providers
+	Authenticators ConfigAuthenticatorSet    // Describes API Authenticators
+	API            ConfigAPI                 // API configuration
+	Audit          ConfigAudit               // Account audit trail
+	Attributes     []*ConfigAccountAttribute // Custom profile attributes
+	Production     bool                      // Production changes how logs are generated and tighter security checks
+	Verbose        bool                      // Turns on additional logging
+	StaticFromDisk bool                      // Checks the disk for static files
 
 	// Meta config
 	Version bool // Show version
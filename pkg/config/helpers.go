@@ -15,6 +15,7 @@ func (s *ConfigOAuth2Settings) Coalesce(other *ConfigOAuth2Settings) *ConfigOAut
 		CoalesceBool(s.IssueRefreshToken, other.IssueRefreshToken),
 		CoalesceInt(s.CodeExpiresSeconds, other.CodeExpiresSeconds),
 		CoalesceInt(s.TokenExpiresSeconds, other.TokenExpiresSeconds),
+		CoalesceInt(s.RefreshTokenExpiresSeconds, other.RefreshTokenExpiresSeconds),
 		CoalesceInt(s.CodeLength, other.CodeLength),
 		CoalesceBool(s.AllowAutoGrant, other.AllowAutoGrant),
 		CoalesceBool(s.AllowCredentials, other.AllowCredentials),
@@ -3,6 +3,7 @@ package db
 import (
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
@@ -12,8 +13,20 @@ type AccountStore interface {
 	CreateAccount(name, email string) (*Account, error)
 	FindAccount(uuid string) (*Account, error)
 	FindAccountByEmail(email string) (*Account, error)
+	// FindAccountsByID returns the accounts that exist, keyed by ID
+	FindAccountsByID(ids ...uint) (map[uint]*Account, error)
 
 	GetAllAccounts(itr func(account *Account) bool) error
+
+	// RequestEmailChange replaces any pending email change with a new one, confirmed by the returned stipulation's code
+	// until it expires after ttl (0 never expires)
+	RequestEmailChange(account *Account, email string, ttl time.Duration) (*EmailChangeStipulation, error)
+	// ConfirmEmailChange changes the account's email to the pending address with the code, and returns the previous
+	ConfirmEmailChange(account *Account, code string) (previous string, err error)
+
+	DeactivateAccount(account *Account) error
+	ReactivateAccount(account *Account) error
+	DeleteAccount(account *Account) error
 }
 
 type AccountProvider interface {
@@ -23,10 +36,12 @@ type AccountProvider interface {
 // Account represents a user
 type Account struct {
 	gorm.Model
-	UUID   string `gorm:"type:varchar(64);unique_index;not null"`
-	Name   string `gorm:"type:varchar(256);not null"`
-	Email  string `gorm:"type:varchar(256);unique_index;not null"`
-	Active bool   `gorm:"not null"`
+	UUID              string `gorm:"type:varchar(64);unique_index;not null"`
+	Name              string `gorm:"type:varchar(256);not null"`
+	Email             string `gorm:"type:varchar(256);unique_index:idx_accounts_organization_id_email;not null"`
+	Active            bool   `gorm:"not null"`
+	OrganizationID    uint   `gorm:"unique_index:idx_accounts_organization_id_email;not null;default:0"` // 0 if in no organization
+	OrganizationAdmin bool   `gorm:"not null;default:false"`                                             // If the account administers its organization
 }
 
 func (s *Account) Account() *Account {
@@ -40,10 +55,11 @@ func (s *sadb) CreateAccount(name, email string) (*Account, error) {
 	}
 
 	account := &Account{
-		UUID:   uuid.New().String(),
-		Name:   name,
-		Email:  email,
-		Active: true,
+		UUID:           uuid.New().String(),
+		Name:           name,
+		Email:          email,
+		Active:         true,
+		OrganizationID: s.organization,
 	}
 
 	if result := s.db.Create(&account); result.Error != nil {
@@ -72,13 +88,29 @@ func (s *sadb) FindAccountByEmail(email string) (*Account, error) {
 		return nil, errors.New("missing email")
 	}
 	var account Account
-	err := s.db.Where(&Account{Email: email}).First(&account).Error
+	err := s.db.Where("organization_id = ? AND email = ?", s.organization, email).First(&account).Error
 	if err != nil {
 		return nil, err
 	}
 	return &account, err
 }
 
+func (s *sadb) FindAccountsByID(ids ...uint) (map[uint]*Account, error) {
+	ret := make(map[uint]*Account, len(ids))
+	if len(ids) == 0 {
+		return ret, nil
+	}
+
+	var accounts []*Account
+	if err := s.db.Where("id IN (?)", ids).Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		ret[account.ID] = account
+	}
+	return ret, nil
+}
+
 func (s *sadb) GetAllAccounts(itr func(account *Account) bool) error {
 	rows, err := s.db.Model(&Account{}).Rows()
 	if err != nil {
@@ -96,3 +128,74 @@ func (s *sadb) GetAllAccounts(itr func(account *Account) bool) error {
 
 	return nil
 }
+
+// DeactivateAccount disables an account, and revokes any outstanding tokens
+// that would otherwise allow access to it
+func (s *sadb) DeactivateAccount(account *Account) error {
+	if account == nil {
+		return InvalidAccount.New()
+	}
+
+	if err := s.db.Model(account).Update("active", false).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+	if err := s.db.Where("account_id = ?", account.ID).Delete(&accountOAuthToken{}).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+	if err := s.db.Where("account_id = ?", account.ID).Delete(&accountAuthOneTime{}).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Account deactivated, all tokens revoked")
+
+	return nil
+}
+
+func (s *sadb) ReactivateAccount(account *Account) error {
+	if account == nil {
+		return InvalidAccount.New()
+	}
+
+	if err := s.db.Model(account).Update("active", true).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Account reactivated")
+
+	return nil
+}
+
+// accountOwnedModels are the tables that are purged when an account is deleted.
+// Audit records are intentionally kept
+var accountOwnedModels = []interface{}{
+	&accountAuthLocal{},
+	&accountAuthOneTime{},
+	&accountStipulation{},
+	&accountOAuthToken{},
+	&accountOIDC{},
+	&accountAttribute{},
+	&accountMembership{},
+	&accountTermsAcceptance{},
+	&accountPasswordHistory{},
+	&accountRecoveryCode{},
+}
+
+// DeleteAccount permanently removes the account and everything associated with it
+func (s *sadb) DeleteAccount(account *Account) error {
+	if account == nil {
+		return InvalidAccount.New()
+	}
+
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelAlert, "Account deleted: %s", account.Email)
+
+	for _, model := range accountOwnedModels {
+		if err := s.db.Unscoped().Where("account_id = ?", account.ID).Delete(model).Error; err != nil {
+			return InternalError.Wrap(err)
+		}
+	}
+	if err := s.db.Unscoped().Delete(account).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package db
+
+// AccountApprovals hold new accounts for an admin to approve or reject, using ManualStipulation
+type AccountApprovals interface {
+	RequireApproval(account *Account) error
+	AccountPendingApproval(account *Account) bool
+	FindAccountsPendingApproval() ([]*Account, error)
+	ApproveAccount(account *Account, reason string) error
+	RejectAccount(account *Account, reason string) error
+}
+
+func (s *sadb) RequireApproval(account *Account) error {
+	if account == nil {
+		return InvalidAccount.New()
+	}
+	if err := s.AddStipulation(account, &ManualStipulation{}); err != nil {
+		return InternalError.Wrap(err)
+	}
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelInfo, "Account pending approval")
+	return nil
+}
+
+func (s *sadb) AccountPendingApproval(account *Account) bool {
+	return s.AccountHasStipulation(account, (&ManualStipulation{}).Type())
+}
+
+// FindAccountsPendingApproval returns active accounts, in any organization, waiting on approval,
+// oldest first
+func (s *sadb) FindAccountsPendingApproval() ([]*Account, error) {
+	var accounts []*Account
+	err := s.db.
+		Where("active = ? AND id IN (?)", true, s.db.Model(&accountStipulation{}).
+			Select("account_id").
+			Where("type = ?", (&ManualStipulation{}).Type()).
+			QueryExpr()).
+		Order("created_at asc").
+		Find(&accounts).Error
+	if err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	return accounts, nil
+}
+
+func (s *sadb) ApproveAccount(account *Account, reason string) error {
+	if err := s.removeApproval(account); err != nil {
+		return err
+	}
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Account approved%s", formatReason(reason))
+	return nil
+}
+
+// RejectAccount deactivates the account, so that its email can't register again until an admin
+// reactivates or deletes it
+func (s *sadb) RejectAccount(account *Account, reason string) error {
+	if err := s.removeApproval(account); err != nil {
+		return err
+	}
+	if err := s.DeactivateAccount(account); err != nil {
+		return err
+	}
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Account rejected%s", formatReason(reason))
+	return nil
+}
+
+func (s *sadb) removeApproval(account *Account) error {
+	if account == nil {
+		return InvalidAccount.New()
+	}
+	if !s.AccountPendingApproval(account) {
+		return AccountNotPending.Newf("account is not pending approval")
+	}
+	err := s.db.Where("account_id = ? AND type = ?", account.ID, (&ManualStipulation{}).Type()).
+		Delete(&accountStipulation{}).Error
+	if err != nil {
+		return InternalError.Wrap(err)
+	}
+	return nil
+}
+
+func formatReason(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return ": " + reason
+}
@@ -0,0 +1,54 @@
+package db_test
+
+import (
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/saerrors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApproveAccount(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "approve@asdf.com")
+	assert.False(t, sadb.AccountPendingApproval(account))
+
+	assert.NoError(t, sadb.RequireApproval(account))
+	assert.True(t, sadb.AccountPendingApproval(account))
+	assert.True(t, sadb.AccountHasUnsatisfiedStipulations(account))
+
+	pending, err := sadb.FindAccountsPendingApproval()
+	assert.NoError(t, err)
+	assert.Contains(t, accountUUIDs(pending), account.UUID)
+
+	assert.NoError(t, sadb.ApproveAccount(account, "known employee"))
+	assert.False(t, sadb.AccountPendingApproval(account))
+	assert.False(t, sadb.AccountHasUnsatisfiedStipulations(account))
+
+	pending, _ = sadb.FindAccountsPendingApproval()
+	assert.NotContains(t, accountUUIDs(pending), account.UUID)
+
+	err = sadb.ApproveAccount(account, "")
+	assert.Equal(t, db.AccountNotPending, saerrors.UnwrapCode(err))
+}
+
+func TestRejectAccount(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "reject@asdf.com")
+	assert.NoError(t, sadb.RequireApproval(account))
+
+	assert.NoError(t, sadb.RejectAccount(account, "unknown"))
+	assert.False(t, sadb.AccountPendingApproval(account))
+
+	account, _ = sadb.FindAccount(account.UUID)
+	assert.False(t, account.Active)
+
+	err := sadb.RejectAccount(account, "")
+	assert.Equal(t, db.AccountNotPending, saerrors.UnwrapCode(err))
+}
+
+func accountUUIDs(accounts []*db.Account) []string {
+	ret := make([]string, len(accounts))
+	for i, account := range accounts {
+		ret[i] = account.UUID
+	}
+	return ret
+}
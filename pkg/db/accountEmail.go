@@ -0,0 +1,94 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// emailAvailable is true if no account in the account's organization, other than account, has the email
+func (s *sadb) emailAvailable(account *Account, email string) (bool, error) {
+	var count int
+	err := s.db.Model(&Account{}).
+		Where("organization_id = ? AND email = ? AND id <> ?", account.OrganizationID, email, account.ID).
+		Count(&count).Error
+	if err != nil {
+		return false, InternalError.Wrap(err)
+	}
+	return count == 0, nil
+}
+
+func (s *sadb) RequestEmailChange(account *Account, email string, ttl time.Duration) (*EmailChangeStipulation, error) {
+	if account == nil {
+		return nil, InvalidAccount.New()
+	}
+
+	email = strings.TrimSpace(strings.ToLower(email))
+	if email == "" || email == account.Email {
+		return nil, EmailInvalid.Newf("email must be different than the current")
+	}
+	if ok, err := s.emailAvailable(account, email); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, EmailUnavailable.New()
+	}
+
+	stip := NewEmailChangeStipulation(email, ttl)
+	if err := s.db.Where("account_id = ? AND type = ?", account.ID, stip.Type()).Delete(&accountStipulation{}).Error; err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	if err := s.AddStipulation(account, stip); err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelInfo, "Email change to %s requested, confirmation sent", email)
+
+	return stip, nil
+}
+
+func (s *sadb) ConfirmEmailChange(account *Account, code string) (string, error) {
+	if account == nil {
+		return "", InvalidAccount.New()
+	}
+
+	stips, err := s.findStipulations(account, (&EmailChangeStipulation{}).Type())
+	if err != nil {
+		return "", InternalError.Wrap(err)
+	}
+
+	for i := range stips {
+		var pending EmailChangeStipulation
+		if err := json.Unmarshal([]byte(stips[i].Specification), &pending); err != nil {
+			continue
+		}
+		if pending.Code == "" || pending.Code != code {
+			continue
+		}
+		if pending.Expired() {
+			s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Unable to confirm email change to %s, token expired", pending.Email)
+			return "", VerificationExpired.New()
+		}
+
+		if ok, err := s.emailAvailable(account, pending.Email); err != nil {
+			return "", err
+		} else if !ok {
+			s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Email change to %s failed, address is in use", pending.Email)
+			return "", EmailUnavailable.New()
+		}
+
+		previous := account.Email
+		// The unique index on email is the final check, if another account took the address concurrently
+		if err := s.db.Model(account).Update("email", pending.Email).Error; err != nil {
+			return "", EmailUnavailable.Wrap(err)
+		}
+		if err := s.db.Delete(&stips[i]).Error; err != nil {
+			return "", InternalError.Wrap(err)
+		}
+
+		s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Email changed from %s to %s", previous, pending.Email)
+		return previous, nil
+	}
+
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Unable to confirm email change, invalid token")
+	return "", VerificationInvalid.New()
+}
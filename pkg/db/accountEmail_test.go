@@ -0,0 +1,84 @@
+package db_test
+
+import (
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/saerrors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailChange(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "change-old@asdf.com")
+
+	stip, err := sadb.RequestEmailChange(account, " Change-New@asdf.com ", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, "change-new@asdf.com", stip.Email)
+	assert.NotEmpty(t, stip.Code)
+
+	// A pending change doesn't block login, and isn't force-satisfied
+	assert.False(t, sadb.AccountHasUnsatisfiedStipulations(account))
+	assert.NoError(t, sadb.ForceSatisfyStipulations(account))
+
+	previous, err := sadb.ConfirmEmailChange(account, "wrong-code")
+	assert.Error(t, err)
+	assert.Empty(t, previous)
+
+	previous, err = sadb.ConfirmEmailChange(account, stip.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, "change-old@asdf.com", previous)
+	assert.Equal(t, "change-new@asdf.com", account.Email)
+
+	found, _ := sadb.FindAccountByEmail("change-new@asdf.com")
+	assert.Equal(t, account.ID, found.ID)
+
+	// Single use
+	_, err = sadb.ConfirmEmailChange(account, stip.Code)
+	assert.Error(t, err)
+}
+
+func TestEmailChangeReplacesPending(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "change-replace@asdf.com")
+
+	first, _ := sadb.RequestEmailChange(account, "change-replace1@asdf.com", time.Hour)
+	second, _ := sadb.RequestEmailChange(account, "change-replace2@asdf.com", time.Hour)
+
+	_, err := sadb.ConfirmEmailChange(account, first.Code)
+	assert.Error(t, err)
+	_, err = sadb.ConfirmEmailChange(account, second.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, "change-replace2@asdf.com", account.Email)
+}
+
+func TestEmailChangeExpired(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "change-expired@asdf.com")
+
+	stip, err := sadb.RequestEmailChange(account, "change-expired-new@asdf.com", time.Millisecond)
+	assert.NoError(t, err)
+	assert.NotNil(t, stip.Expires)
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = sadb.ConfirmEmailChange(account, stip.Code)
+	assert.Equal(t, db.VerificationExpired, saerrors.UnwrapCode(err))
+	assert.Equal(t, "change-expired@asdf.com", account.Email)
+}
+
+func TestEmailChangeUnavailable(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "change-taken1@asdf.com")
+	other, _ := sadb.CreateAccount("test", "change-taken2@asdf.com")
+
+	_, err := sadb.RequestEmailChange(account, other.Email, time.Hour)
+	assert.Equal(t, db.EmailUnavailable, saerrors.UnwrapCode(err))
+	_, err = sadb.RequestEmailChange(account, account.Email, time.Hour)
+	assert.Equal(t, db.EmailInvalid, saerrors.UnwrapCode(err))
+
+	// Taken after the request was made
+	stip, err := sadb.RequestEmailChange(account, "change-taken3@asdf.com", time.Hour)
+	assert.NoError(t, err)
+	sadb.CreateAccount("test", "change-taken3@asdf.com")
+
+	_, err = sadb.ConfirmEmailChange(account, stip.Code)
+	assert.Equal(t, db.EmailUnavailable, saerrors.UnwrapCode(err))
+	assert.Equal(t, "change-taken1@asdf.com", account.Email)
+}
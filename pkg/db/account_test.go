@@ -1,7 +1,9 @@
 package db_test
 
 import (
+	"simple-auth/pkg/db"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -34,3 +36,43 @@ func TestFindAccountFail(t *testing.T) {
 	assert.Nil(t, account)
 	assert.Error(t, err)
 }
+
+func TestDeactivateAccount(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "deactivate@asdf.com")
+	sadb.CreateOAuthToken(account, "test-client", db.OAuthTypeAccessToken, "deactivate-token", nil, 1*time.Hour)
+	ott, _ := sadb.CreateAccountOneTimeToken(account, 5*time.Minute)
+
+	assert.NoError(t, sadb.DeactivateAccount(account))
+	assert.False(t, account.Active)
+
+	found, _ := sadb.FindAccount(account.UUID)
+	assert.False(t, found.Active)
+
+	tokens, err := sadb.GetAllValidOAuthTokens(account)
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	_, err = sadb.AssertOneTimeToken(ott)
+	assert.Error(t, err)
+
+	assert.NoError(t, sadb.ReactivateAccount(account))
+	found, _ = sadb.FindAccount(account.UUID)
+	assert.True(t, found.Active)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "delete@asdf.com")
+	sadb.CreateAuthLocal(account, "delete-me", "delete-pass")
+
+	assert.NoError(t, sadb.DeleteAccount(account))
+
+	_, err := sadb.FindAccount(account.UUID)
+	assert.Error(t, err)
+	_, err = sadb.FindAuthLocal(account)
+	assert.Error(t, err)
+
+	// Email is free to be re-used
+	recreated, err := sadb.CreateAccount("test", "delete@asdf.com")
+	assert.NoError(t, err)
+	assert.NotEqual(t, account.UUID, recreated.UUID)
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+type AccountAttributes interface {
+	// GetAccountAttributes returns the account's stored attribute values, keyed by name
+	GetAccountAttributes(account *Account) (map[string]string, error)
+	// SetAccountAttributes stores each value, or removes it if nil
+	SetAccountAttributes(account *Account, values map[string]*string) error
+}
+
+type accountAttribute struct {
+	gorm.Model
+	AccountID uint   `gorm:"not null"`
+	Name      string `gorm:"type:varchar(64);not null"`
+	Value     string `gorm:"type:varchar(1024);not null"`
+}
+
+func (s *sadb) GetAccountAttributes(account *Account) (map[string]string, error) {
+	if account == nil {
+		return nil, InvalidAccount.New()
+	}
+
+	var attrs []*accountAttribute
+	if err := s.db.Where("account_id = ?", account.ID).Find(&attrs).Error; err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+
+	ret := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		ret[attr.Name] = attr.Value
+	}
+	return ret, nil
+}
+
+func (s *sadb) SetAccountAttributes(account *Account, values map[string]*string) error {
+	if account == nil {
+		return InvalidAccount.New()
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(values))
+	for name, value := range values {
+		names = append(names, name)
+
+		// Removed rows are hard-deleted, so the name can be set again without conflicting with the unique index
+		if err := s.db.Unscoped().Where("account_id = ? AND name = ?", account.ID, name).Delete(&accountAttribute{}).Error; err != nil {
+			return InternalError.Wrap(err)
+		}
+		if value == nil {
+			continue
+		}
+		attr := &accountAttribute{
+			AccountID: account.ID,
+			Name:      name,
+			Value:     *value,
+		}
+		if err := s.db.Create(attr).Error; err != nil {
+			return InternalError.Wrap(err)
+		}
+	}
+
+	sort.Strings(names)
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelInfo, "Attributes updated: %s", strings.Join(names, ", "))
+
+	return nil
+}
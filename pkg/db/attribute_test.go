@@ -0,0 +1,49 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestAccountAttributes(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "attributes@asdf.com")
+
+	values, err := sadb.GetAccountAttributes(account)
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+
+	assert.NoError(t, sadb.SetAccountAttributes(account, map[string]*string{
+		"department": strPtr("Engineering"),
+		"locale":     strPtr("en-US"),
+	}))
+	values, _ = sadb.GetAccountAttributes(account)
+	assert.Equal(t, map[string]string{"department": "Engineering", "locale": "en-US"}, values)
+
+	// Update one, remove the other, then set it again
+	assert.NoError(t, sadb.SetAccountAttributes(account, map[string]*string{
+		"department": strPtr("Sales"),
+		"locale":     nil,
+	}))
+	values, _ = sadb.GetAccountAttributes(account)
+	assert.Equal(t, map[string]string{"department": "Sales"}, values)
+
+	assert.NoError(t, sadb.SetAccountAttributes(account, map[string]*string{
+		"locale": strPtr("fr-FR"),
+	}))
+	values, _ = sadb.GetAccountAttributes(account)
+	assert.Equal(t, map[string]string{"department": "Sales", "locale": "fr-FR"}, values)
+
+	// Attributes are per account
+	other, _ := sadb.CreateAccount("test", "attributes-other@asdf.com")
+	values, _ = sadb.GetAccountAttributes(other)
+	assert.Empty(t, values)
+
+	assert.NoError(t, sadb.DeleteAccount(account))
+	values, _ = sadb.GetAccountAttributes(account)
+	assert.Empty(t, values)
+}
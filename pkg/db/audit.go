@@ -2,6 +2,9 @@ package db
 
 import (
 	"fmt"
+	"simple-auth/pkg/lib/auditsink"
+	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
@@ -10,6 +13,8 @@ import (
 type AccountAudit interface {
 	CreateAuditRecord(account AccountProvider, module AuditModule, level AuditLevel, message string, params ...interface{}) error
 	GetAuditTrailForAccount(account *Account, offset, count int) ([]AccountAuditRecord, error)
+	// SearchAuditRecords returns the records matching the query, newest first
+	SearchAuditRecords(query *AuditQuery) ([]AccountAuditRecord, error)
 }
 
 type (
@@ -40,6 +45,53 @@ type AccountAuditRecord struct {
 	Module    AuditModule
 	Level     AuditLevel
 	Message   string
+
+	// Request the record was created in, if any
+	IPAddress     string `gorm:"type:varchar(64)"`
+	UserAgent     string `gorm:"type:varchar(512)"`
+	CorrelationID string `gorm:"type:varchar(64)"`
+
+	// Position in the tamper-evident hash chain, see SealAuditChain. nil until sealed
+	ChainIndex *uint64
+	Hash       string `gorm:"type:varchar(64)"`
+	PrevHash   string `gorm:"type:varchar(64)"`
+}
+
+const (
+	AuditQueryDefaultLimit = 10
+	AuditQueryMaxLimit     = 100
+)
+
+// AuditQuery filters audit records. Zero-values don't filter
+type AuditQuery struct {
+	Account *Account // nil searches all accounts
+	Modules []AuditModule
+	Levels  []AuditLevel
+	Since   time.Time
+	Until   time.Time
+	Message string // Case-insensitive substring of the message
+
+	// Before is a cursor, only returning records older than that record's ID.
+	// To page, pass the ID of the last record returned
+	Before uint
+	Offset int
+	Limit  int
+}
+
+// EffectiveLimit is the number of records that will be returned, at most
+func (s *AuditQuery) EffectiveLimit() int {
+	if s.Limit <= 0 {
+		return AuditQueryDefaultLimit
+	}
+	if s.Limit > AuditQueryMaxLimit {
+		return AuditQueryMaxLimit
+	}
+	return s.Limit
+}
+
+// escapeLike escapes the LIKE wildcards in s, for use with `ESCAPE '!'`
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
 }
 
 func (s *sadb) CreateAuditRecord(account AccountProvider, module AuditModule, level AuditLevel, message string, params ...interface{}) error {
@@ -49,11 +101,57 @@ func (s *sadb) CreateAuditRecord(account AccountProvider, module AuditModule, le
 		Level:     level,
 		Message:   fmt.Sprintf(message, params...),
 	}
+	if s.audit != nil {
+		record.IPAddress = anonymizeIP(s.opts, s.audit.IP)
+		record.UserAgent = truncateString(s.audit.UserAgent, auditUserAgentLength)
+		record.CorrelationID = s.audit.CorrelationID
+	}
 	err := s.db.Create(record).Error
 	if err != nil {
 		logrus.Warnf("Failed to create audit log: %s", err)
+		return err
+	}
+
+	if s.opts.auditSink != nil {
+		event := newAuditSinkEvent(account.Account(), record)
+		if s.pendingEvents != nil {
+			// Held until the transaction commits, so records that are rolled back never leave
+			*s.pendingEvents = append(*s.pendingEvents, event)
+		} else {
+			s.opts.auditSink.Send(event)
+		}
+	}
+
+	// Records in a transaction are sealed once it's committed
+	if !s.inTransaction() {
+		s.sealAuditChainOrWarn()
+	}
+	return nil
+}
+
+// flushAuditEvents sends a committed transaction's audit sink events
+func (s *sadb) flushAuditEvents() {
+	if s.pendingEvents == nil {
+		return
+	}
+	for _, event := range *s.pendingEvents {
+		s.opts.auditSink.Send(event)
+	}
+	*s.pendingEvents = nil
+}
+
+func newAuditSinkEvent(account *Account, record *AccountAuditRecord) *auditsink.Event {
+	return &auditsink.Event{
+		ID:            record.ID,
+		Timestamp:     record.CreatedAt,
+		Account:       account.UUID,
+		Module:        string(record.Module),
+		Level:         string(record.Level),
+		Message:       record.Message,
+		IP:            record.IPAddress,
+		UserAgent:     record.UserAgent,
+		CorrelationID: record.CorrelationID,
 	}
-	return err
 }
 
 func (s *sadb) GetAuditTrailForAccount(account *Account, offset, count int) ([]AccountAuditRecord, error) {
@@ -64,3 +162,35 @@ func (s *sadb) GetAuditTrailForAccount(account *Account, offset, count int) ([]A
 	}
 	return auditRecords, nil
 }
+
+func (s *sadb) SearchAuditRecords(query *AuditQuery) ([]AccountAuditRecord, error) {
+	q := s.db.Model(&AccountAuditRecord{})
+
+	if query.Account != nil {
+		q = q.Where("account_id = ?", query.Account.ID)
+	}
+	if len(query.Modules) > 0 {
+		q = q.Where("module IN (?)", query.Modules)
+	}
+	if len(query.Levels) > 0 {
+		q = q.Where("level IN (?)", query.Levels)
+	}
+	if !query.Since.IsZero() {
+		q = q.Where("created_at >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		q = q.Where("created_at < ?", query.Until)
+	}
+	if query.Message != "" {
+		q = q.Where("LOWER(message) LIKE ? ESCAPE '!'", "%"+escapeLike(strings.ToLower(query.Message))+"%")
+	}
+	if query.Before > 0 {
+		q = q.Where("id < ?", query.Before)
+	}
+
+	var auditRecords []AccountAuditRecord
+	if err := q.Order("id desc").Offset(query.Offset).Limit(query.EffectiveLimit()).Find(&auditRecords).Error; err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	return auditRecords, nil
+}
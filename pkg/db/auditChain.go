@@ -0,0 +1,235 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+type AuditChain interface {
+	// SealAuditChain links any committed, unsealed, audit records onto the end of the chain
+	SealAuditChain() (int, error)
+	// VerifyAuditChain walks the chain from its oldest record, stopping at the first broken link
+	VerifyAuditChain() (*AuditChainReport, error)
+	// AuditChainHead returns the newest link in the chain, or nil if nothing is sealed
+	AuditChainHead() (*AuditChainLink, error)
+	// FindAuditChainLink returns the link at index, or nil if it doesn't exist
+	FindAuditChainLink(index uint64) (*AuditChainLink, error)
+}
+
+type AuditChainLink struct {
+	Index    uint64
+	Hash     string
+	RecordID uint
+	Created  time.Time
+}
+
+type AuditChainBreak struct {
+	Index    uint64
+	RecordID uint
+	Reason   string
+}
+
+type AuditChainReport struct {
+	First    uint64 // Index of the oldest link; greater than 1 if older records were removed by retention
+	Last     uint64
+	Verified int64
+	Unsealed int64            // Records that haven't been linked into the chain yet
+	Broken   *AuditChainBreak // nil if the chain is intact
+}
+
+// auditChainLock serializes sealing within this process. Between processes, the unique
+// chain_index makes concurrent sealers fail rather than fork the chain
+var auditChainLock sync.Mutex
+
+const auditChainSealBatch = 500
+
+// auditChainPayload is the canonical encoding of a record that is hashed. Times are
+// in whole seconds, so the hash is the same regardless of the driver's precision
+type auditChainPayload struct {
+	Index         uint64      `json:"idx"`
+	PrevHash      string      `json:"prev"`
+	AccountID     uint        `json:"account"`
+	Created       int64       `json:"ts"`
+	Module        AuditModule `json:"module"`
+	Level         AuditLevel  `json:"level"`
+	Message       string      `json:"message"`
+	IPAddress     string      `json:"ip"`
+	UserAgent     string      `json:"ua"`
+	CorrelationID string      `json:"cid"`
+}
+
+func auditChainHash(record *AccountAuditRecord, index uint64, prevHash string) string {
+	b, _ := json.Marshal(&auditChainPayload{
+		Index:         index,
+		PrevHash:      prevHash,
+		AccountID:     record.AccountID,
+		Created:       record.CreatedAt.Round(time.Second).Unix(),
+		Module:        record.Module,
+		Level:         record.Level,
+		Message:       record.Message,
+		IPAddress:     record.IPAddress,
+		UserAgent:     record.UserAgent,
+		CorrelationID: record.CorrelationID,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func newAuditChainLink(record *AccountAuditRecord) *AuditChainLink {
+	return &AuditChainLink{
+		Index:    *record.ChainIndex,
+		Hash:     record.Hash,
+		RecordID: record.ID,
+		Created:  record.CreatedAt,
+	}
+}
+
+func (s *sadb) auditChainHead() (*AccountAuditRecord, error) {
+	var head AccountAuditRecord
+	err := s.db.Unscoped().Where("chain_index IS NOT NULL").Order("chain_index desc").First(&head).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &head, nil
+}
+
+func (s *sadb) SealAuditChain() (int, error) {
+	auditChainLock.Lock()
+	defer auditChainLock.Unlock()
+
+	head, err := s.auditChainHead()
+	if err != nil {
+		return 0, InternalError.Wrap(err)
+	}
+	var index uint64
+	var prevHash string
+	if head != nil {
+		index, prevHash = *head.ChainIndex, head.Hash
+	}
+
+	sealed := 0
+	for {
+		var records []*AccountAuditRecord
+		err := s.db.Unscoped().Where("chain_index IS NULL").Order("id").Limit(auditChainSealBatch).Find(&records).Error
+		if err != nil {
+			return sealed, InternalError.Wrap(err)
+		}
+		if len(records) == 0 {
+			return sealed, nil
+		}
+
+		for _, record := range records {
+			index++
+			hash := auditChainHash(record, index, prevHash)
+
+			res := s.db.Unscoped().Model(&AccountAuditRecord{}).
+				Where("id = ? AND chain_index IS NULL", record.ID).
+				UpdateColumns(map[string]interface{}{
+					"chain_index": index,
+					"hash":        hash,
+					"prev_hash":   prevHash,
+				})
+			if res.Error != nil {
+				return sealed, InternalError.Wrap(res.Error)
+			}
+			if res.RowsAffected == 0 {
+				// Sealed by another process; the next seal will continue from its head
+				return sealed, nil
+			}
+
+			prevHash = hash
+			sealed++
+		}
+	}
+}
+
+// sealAuditChainOrWarn seals the chain, only logging a failure, since any unsealed records
+// will be picked up by the next seal
+func (s *sadb) sealAuditChainOrWarn() {
+	if _, err := s.SealAuditChain(); err != nil {
+		logrus.Warnf("Unable to seal audit chain: %v", err)
+	}
+}
+
+func (s *sadb) AuditChainHead() (*AuditChainLink, error) {
+	head, err := s.auditChainHead()
+	if err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	if head == nil {
+		return nil, nil
+	}
+	return newAuditChainLink(head), nil
+}
+
+func (s *sadb) FindAuditChainLink(index uint64) (*AuditChainLink, error) {
+	var record AccountAuditRecord
+	if err := s.db.Unscoped().Where("chain_index = ?", index).First(&record).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, InternalError.Wrap(err)
+	}
+	return newAuditChainLink(&record), nil
+}
+
+func (s *sadb) VerifyAuditChain() (*AuditChainReport, error) {
+	report := &AuditChainReport{}
+
+	if err := s.db.Unscoped().Model(&AccountAuditRecord{}).Where("chain_index IS NULL").Count(&report.Unsealed).Error; err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+
+	rows, err := s.db.Unscoped().Model(&AccountAuditRecord{}).Where("chain_index IS NOT NULL").Order("chain_index").Rows()
+	if err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	defer rows.Close()
+
+	var prev *AccountAuditRecord
+	for rows.Next() {
+		var record AccountAuditRecord
+		if err := s.db.ScanRows(rows, &record); err != nil {
+			return nil, InternalError.Wrap(err)
+		}
+		index := *record.ChainIndex
+
+		if prev == nil {
+			// The oldest record anchors the chain, since earlier records may have been removed by retention
+			report.First = index
+		} else {
+			var reason string
+			switch {
+			case index != *prev.ChainIndex+1:
+				reason = fmt.Sprintf("records %d to %d are missing", *prev.ChainIndex+1, index-1)
+			case record.PrevHash != prev.Hash:
+				reason = "previous hash doesn't match the previous record"
+			}
+			if reason != "" {
+				report.Broken = &AuditChainBreak{Index: index, RecordID: record.ID, Reason: reason}
+				return report, nil
+			}
+		}
+
+		if auditChainHash(&record, index, record.PrevHash) != record.Hash {
+			report.Broken = &AuditChainBreak{Index: index, RecordID: record.ID, Reason: "record was modified"}
+			return report, nil
+		}
+
+		report.Last = index
+		report.Verified++
+		prev = &record
+	}
+
+	return report, nil
+}
@@ -0,0 +1,100 @@
+package db_test
+
+import (
+	"simple-auth/pkg/db"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+func createChainDB(t *testing.T, name string) (db.SADB, *gorm.DB) {
+	url := "file:" + name + "?mode=memory&cache=shared"
+	cdb := db.New("sqlite3", url)
+	account, _ := cdb.CreateAccount("test", "chain@asdf.com")
+	for i := 0; i < 5; i++ {
+		cdb.CreateAuditRecord(account, db.AuditModuleUI, db.AuditLevelInfo, "Chain %d", i)
+	}
+
+	raw, err := gorm.Open("sqlite3", url)
+	assert.NoError(t, err)
+	return cdb, raw
+}
+
+func TestAuditChainSealed(t *testing.T) {
+	cdb, raw := createChainDB(t, "audit-chain")
+	defer raw.Close()
+
+	report, err := cdb.VerifyAuditChain()
+	assert.NoError(t, err)
+	assert.Nil(t, report.Broken)
+	assert.Equal(t, uint64(1), report.First)
+	assert.Equal(t, uint64(6), report.Last)
+	assert.Equal(t, int64(6), report.Verified)
+	assert.Equal(t, int64(0), report.Unsealed)
+
+	head, err := cdb.AuditChainHead()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(6), head.Index)
+	assert.Len(t, head.Hash, 64)
+
+	link, err := cdb.FindAuditChainLink(6)
+	assert.NoError(t, err)
+	assert.Equal(t, head.Hash, link.Hash)
+	link, err = cdb.FindAuditChainLink(100)
+	assert.NoError(t, err)
+	assert.Nil(t, link)
+}
+
+func TestAuditChainSealsOnCommit(t *testing.T) {
+	cdb, raw := createChainDB(t, "audit-chain-commit")
+	defer raw.Close()
+
+	tx := cdb.BeginTransaction()
+	account, _ := tx.CreateAccount("test", "chain-tx@asdf.com")
+	tx.CreateAuditRecord(account, db.AuditModuleUI, db.AuditLevelInfo, "In transaction")
+	assert.NoError(t, tx.Commit())
+
+	report, _ := cdb.VerifyAuditChain()
+	assert.Nil(t, report.Broken)
+	assert.Equal(t, int64(0), report.Unsealed)
+	assert.Equal(t, uint64(8), report.Last)
+}
+
+func TestAuditChainDetectsModification(t *testing.T) {
+	cdb, raw := createChainDB(t, "audit-chain-modified")
+	defer raw.Close()
+
+	raw.Exec("UPDATE account_audit_records SET message = 'Nothing happened' WHERE chain_index = 3")
+
+	report, err := cdb.VerifyAuditChain()
+	assert.NoError(t, err)
+	if assert.NotNil(t, report.Broken) {
+		assert.Equal(t, uint64(3), report.Broken.Index)
+		assert.Equal(t, "record was modified", report.Broken.Reason)
+	}
+	assert.Equal(t, int64(2), report.Verified)
+}
+
+func TestAuditChainDetectsDeletion(t *testing.T) {
+	cdb, raw := createChainDB(t, "audit-chain-deleted")
+	defer raw.Close()
+
+	raw.Exec("DELETE FROM account_audit_records WHERE chain_index = 4")
+
+	report, err := cdb.VerifyAuditChain()
+	assert.NoError(t, err)
+	if assert.NotNil(t, report.Broken) {
+		assert.Equal(t, uint64(5), report.Broken.Index)
+	}
+
+	// Removing the oldest records (eg. retention) doesn't break the chain
+	cdb2, raw2 := createChainDB(t, "audit-chain-pruned")
+	defer raw2.Close()
+	raw2.Exec("DELETE FROM account_audit_records WHERE chain_index <= 2")
+
+	report, err = cdb2.VerifyAuditChain()
+	assert.NoError(t, err)
+	assert.Nil(t, report.Broken)
+	assert.Equal(t, uint64(3), report.First)
+}
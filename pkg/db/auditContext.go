@@ -0,0 +1,79 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"unicode/utf8"
+)
+
+// AuditContext describes the request an audit record was created in
+type AuditContext struct {
+	IP            string
+	UserAgent     string
+	CorrelationID string
+}
+
+// AuditIPPolicy is how a client's IP is stored on audit records
+type AuditIPPolicy string
+
+const (
+	AuditIPFull     AuditIPPolicy = "full"
+	AuditIPTruncate AuditIPPolicy = "truncate" // Zero the host portion: /24 for IPv4, /48 for IPv6
+	AuditIPHash     AuditIPPolicy = "hash"     // Keyed hash, so the same IP can be correlated without being stored. Requires a token secret
+	AuditIPNone     AuditIPPolicy = "none"
+)
+
+const (
+	auditIPHashLength    = 16
+	auditUserAgentLength = 512
+)
+
+func ParseAuditIPPolicy(s string) (AuditIPPolicy, error) {
+	switch policy := AuditIPPolicy(s); policy {
+	case AuditIPFull, AuditIPTruncate, AuditIPHash, AuditIPNone:
+		return policy, nil
+	case "":
+		return AuditIPFull, nil
+	default:
+		return "", fmt.Errorf("unknown audit ip policy '%s'", s)
+	}
+}
+
+// anonymizeIP applies the configured policy to a client IP
+func anonymizeIP(opts *options, ip string) string {
+	if ip == "" {
+		return ""
+	}
+	switch opts.auditIPPolicy {
+	case AuditIPNone:
+		return ""
+	case AuditIPHash:
+		if len(opts.tokenSecret) == 0 {
+			// Without a key, the few IPv4 addresses are trivially recovered from their hash
+			return ""
+		}
+		return hashToken(opts, ip)[:auditIPHashLength]
+	case AuditIPTruncate:
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return ""
+		}
+		if v4 := parsed.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return parsed.Mask(net.CIDRMask(48, 128)).String()
+	default:
+		return ip
+	}
+}
+
+// truncateString truncates s to at most length bytes, without splitting a rune
+func truncateString(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	for length > 0 && !utf8.RuneStart(s[length]) {
+		length--
+	}
+	return s[:length]
+}
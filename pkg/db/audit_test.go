@@ -2,7 +2,9 @@ package db_test
 
 import (
 	"simple-auth/pkg/db"
+	"simple-auth/pkg/lib/auditsink"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -37,3 +39,156 @@ func TestFetchAuditRecords(t *testing.T) {
 	assert.True(t, hasEvent(records, "Test1"))
 	assert.True(t, hasEvent(records, "Test2"))
 }
+
+func TestSearchAuditRecords(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "audit-search@asdf.com")
+	sadb.CreateAuditRecord(account, db.AuditModuleLocal, db.AuditLevelInfo, "Search login")
+	sadb.CreateAuditRecord(account, db.AuditModuleLocal, db.AuditLevelAlert, "Search 100%%_match")
+	sadb.CreateAuditRecord(account, db.AuditModuleOAuth2, db.AuditLevelAlert, "Search token")
+
+	records, err := sadb.SearchAuditRecords(&db.AuditQuery{Account: account, Message: "search"})
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, "Search token", records[0].Message)
+
+	records, _ = sadb.SearchAuditRecords(&db.AuditQuery{Account: account, Modules: []db.AuditModule{db.AuditModuleLocal}})
+	assert.Len(t, records, 2)
+
+	records, _ = sadb.SearchAuditRecords(&db.AuditQuery{Levels: []db.AuditLevel{db.AuditLevelAlert}, Since: time.Now().Add(-time.Hour)})
+	assert.True(t, hasEvent(records, "Search token"))
+	assert.False(t, hasEvent(records, "Search login"))
+
+	records, _ = sadb.SearchAuditRecords(&db.AuditQuery{Account: account, Message: "SEARCH 100%_"})
+	assert.Len(t, records, 1)
+	records, _ = sadb.SearchAuditRecords(&db.AuditQuery{Account: account, Message: "%"})
+	assert.Len(t, records, 1)
+
+	records, _ = sadb.SearchAuditRecords(&db.AuditQuery{Account: account, Until: time.Now().Add(-time.Hour)})
+	assert.Empty(t, records)
+}
+
+func TestSearchAuditRecordsCursor(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "audit-cursor@asdf.com")
+	for i := 0; i < 5; i++ {
+		sadb.CreateAuditRecord(account, db.AuditModuleUI, db.AuditLevelInfo, "Cursor %d", i)
+	}
+
+	page, err := sadb.SearchAuditRecords(&db.AuditQuery{Account: account, Modules: []db.AuditModule{db.AuditModuleUI}, Limit: 3})
+	assert.NoError(t, err)
+	assert.Len(t, page, 3)
+	assert.Equal(t, "Cursor 4", page[0].Message)
+
+	page, err = sadb.SearchAuditRecords(&db.AuditQuery{Account: account, Modules: []db.AuditModule{db.AuditModuleUI}, Limit: 3, Before: page[2].ID})
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	assert.Equal(t, "Cursor 1", page[0].Message)
+	assert.Equal(t, "Cursor 0", page[1].Message)
+}
+
+func TestAuditRecordContext(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "audit-context@asdf.com")
+	ctxdb := sadb.WithAuditContext(&db.AuditContext{
+		IP:            "192.168.1.23",
+		UserAgent:     "test-agent",
+		CorrelationID: "abc123",
+	})
+	ctxdb.CreateAuditRecord(account, db.AuditModuleUI, db.AuditLevelInfo, "With context")
+
+	records, err := sadb.SearchAuditRecords(&db.AuditQuery{Account: account, Message: "With context"})
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "192.168.1.23", records[0].IPAddress)
+		assert.Equal(t, "test-agent", records[0].UserAgent)
+		assert.Equal(t, "abc123", records[0].CorrelationID)
+	}
+}
+
+func TestAuditRecordIPPolicy(t *testing.T) {
+	tests := map[db.AuditIPPolicy]map[string]string{
+		db.AuditIPFull:     {"192.168.1.23": "192.168.1.23"},
+		db.AuditIPTruncate: {"192.168.1.23": "192.168.1.0", "2001:db8:1:2::1": "2001:db8:1::"},
+		db.AuditIPNone:     {"192.168.1.23": ""},
+	}
+
+	for policy, ips := range tests {
+		pdb := db.New("sqlite3", "file:audit-ip-"+string(policy)+"?mode=memory&cache=shared", db.WithAuditIPPolicy(policy))
+		account, _ := pdb.CreateAccount("test", "audit-ip@asdf.com")
+		for ip, expected := range ips {
+			pdb.WithAuditContext(&db.AuditContext{IP: ip}).CreateAuditRecord(account, db.AuditModuleUI, db.AuditLevelInfo, ip)
+			records, _ := pdb.SearchAuditRecords(&db.AuditQuery{Account: account, Message: ip})
+			if assert.Len(t, records, 1) {
+				assert.Equal(t, expected, records[0].IPAddress, "%s %s", policy, ip)
+			}
+		}
+	}
+
+	hdb := db.New("sqlite3", "file:audit-ip-hash?mode=memory&cache=shared", db.WithAuditIPPolicy(db.AuditIPHash), db.WithTokenSecret("secret"))
+	account, _ := hdb.CreateAccount("test", "audit-ip@asdf.com")
+	hdb.WithAuditContext(&db.AuditContext{IP: "192.168.1.23"}).CreateAuditRecord(account, db.AuditModuleUI, db.AuditLevelInfo, "hash")
+	records, _ := hdb.SearchAuditRecords(&db.AuditQuery{Account: account, Message: "hash"})
+	if assert.Len(t, records, 1) {
+		assert.NotEmpty(t, records[0].IPAddress)
+		assert.Len(t, records[0].IPAddress, 16)
+	}
+
+	// Not stored at all without a secret to key the hash
+	udb := db.New("sqlite3", "file:audit-ip-hash-unkeyed?mode=memory&cache=shared", db.WithAuditIPPolicy(db.AuditIPHash))
+	account, _ = udb.CreateAccount("test", "audit-ip@asdf.com")
+	udb.WithAuditContext(&db.AuditContext{IP: "192.168.1.23"}).CreateAuditRecord(account, db.AuditModuleUI, db.AuditLevelInfo, "hash")
+	records, _ = udb.SearchAuditRecords(&db.AuditQuery{Account: account, Message: "hash"})
+	if assert.Len(t, records, 1) {
+		assert.Empty(t, records[0].IPAddress)
+	}
+}
+
+type testAuditSink struct {
+	events []*auditsink.Event
+}
+
+func (s *testAuditSink) Send(event *auditsink.Event) {
+	s.events = append(s.events, event)
+}
+
+func (s *testAuditSink) Close() error {
+	return nil
+}
+
+func TestAuditSink(t *testing.T) {
+	sink := &testAuditSink{}
+	sdb := db.New("sqlite3", "file:audit-sink?mode=memory&cache=shared", db.WithAuditSink(sink))
+
+	account, _ := sdb.CreateAccount("test", "audit-sink@asdf.com")
+	sdb.WithAuditContext(&db.AuditContext{IP: "10.0.0.1"}).CreateAuditRecord(account, db.AuditModuleLocal, db.AuditLevelAlert, "To the sink")
+
+	if assert.Len(t, sink.events, 2) {
+		event := sink.events[1]
+		assert.NotZero(t, event.ID)
+		assert.Equal(t, account.UUID, event.Account)
+		assert.Equal(t, "auth:simple", event.Module)
+		assert.Equal(t, "alert", event.Level)
+		assert.Equal(t, "To the sink", event.Message)
+		assert.Equal(t, "10.0.0.1", event.IP)
+	}
+}
+
+func TestAuditSinkTransaction(t *testing.T) {
+	sink := &testAuditSink{}
+	sdb := db.New("sqlite3", "file:audit-sink-tx?mode=memory&cache=shared", db.WithAuditSink(sink))
+	account, _ := sdb.CreateAccount("test", "audit-sink-tx@asdf.com")
+	sent := len(sink.events)
+
+	// Held until committed
+	tx := sdb.BeginTransaction()
+	tx.WithAuditContext(&db.AuditContext{IP: "10.0.0.1"}).CreateAuditRecord(account, db.AuditModuleLocal, db.AuditLevelInfo, "Committed")
+	assert.Len(t, sink.events, sent)
+	assert.NoError(t, tx.Commit())
+	if assert.Len(t, sink.events, sent+1) {
+		assert.Equal(t, "Committed", sink.events[sent].Message)
+	}
+
+	// Never sent if rolled back
+	tx = sdb.BeginTransaction()
+	tx.CreateAuditRecord(account, db.AuditModuleLocal, db.AuditLevelInfo, "Rolled back")
+	assert.NoError(t, tx.Rollback())
+	assert.Len(t, sink.events, sent+1)
+}
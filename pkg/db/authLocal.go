@@ -2,11 +2,12 @@ package db
 
 import (
 	"errors"
+	"simple-auth/pkg/lib/passhash"
 	"simple-auth/pkg/lib/totp"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type AccountAuthLocal interface {
@@ -15,17 +16,38 @@ type AccountAuthLocal interface {
 	FindAuthLocalByEmail(email string) (*AuthLocal, error)
 
 	CreateAuthLocal(belongsTo *Account, username, password string) (*AuthLocal, error)
+	// CreateAuthLocalWithHash creates local auth from an already-hashed password (eg. when importing)
+	CreateAuthLocalWithHash(belongsTo *Account, username, passwordHash string) (*AuthLocal, error)
 
 	UpdateAuthLocalPassword(authLocal *AuthLocal, newPassword string) error
+	// RehashAuthLocalPassword re-hashes an already verified password if it was stored under a weaker policy
+	RehashAuthLocalPassword(authLocal *AuthLocal, password string) (bool, error)
 	UpdateAuthLocalTOTP(authLocal *AuthLocal, totpURL *string) error
+	// VerifyAuthLocalTOTP verifies a TOTP code, and records its time step so that neither it, nor any code
+	// before it, is accepted again
+	VerifyAuthLocalTOTP(authLocal *AuthLocal, code string, drift int) (bool, error)
+
+	AccountAuthLocalLockout
+	AccountAuthLocalHistory
+	AccountAuthLocalExpiry
+	AccountAuthLocalRecovery
 }
 
 type accountAuthLocal struct {
 	gorm.Model
 	AccountID      uint   `gorm:"index;not null"`
-	Username       string `gorm:"type:varchar(256);unique_index;not null"`
-	PasswordBcrypt string `gorm:"not null"`
+	Username       string `gorm:"type:varchar(256);unique_index:idx_account_auth_locals_organization_id_username;not null"`
+	OrganizationID uint   `gorm:"unique_index:idx_account_auth_locals_organization_id_username;not null;default:0"` // Same as the account's
+	PasswordBcrypt string `gorm:"not null"`                                                                         // Encoded hash of any supported algorithm, see passhash
 	TOTPSpec       *string
+	TOTPLastStep   int64 `gorm:"not null;default:0"` // Time step of the last accepted TOTP code, see VerifyAuthLocalTOTP
+	FailedLogins   int   `gorm:"not null;default:0"` // Since the last successful login
+	LockedUntil    *time.Time
+	Locked         bool `gorm:"not null;default:false"` // Until unlocked, see AccountAuthLocalLockout
+
+	PasswordChanged        *time.Time // When the password was last set
+	PasswordNeverExpires   bool       `gorm:"not null;default:false"` // Exempt from expiring, see AccountAuthLocalExpiry
+	PasswordExpiryReminded bool       `gorm:"not null;default:false"` // If reminded that the current password expires
 }
 
 type AuthLocal struct {
@@ -38,20 +60,29 @@ func (s *AuthLocal) Username() string {
 }
 
 func (s *AuthLocal) VerifyPassword(against string) bool {
-	return bcrypt.CompareHashAndPassword([]byte(s.auth.PasswordBcrypt), []byte(against)) == nil
+	ok, err := passhash.Verify(s.auth.PasswordBcrypt, against)
+	return err == nil && ok
 }
 
+// VerifyTOTP checks the code without recording it, so doesn't stop it being replayed. Logins
+// should use VerifyAuthLocalTOTP
 func (s *AuthLocal) VerifyTOTP(against string, drift int) bool {
+	_, ok := s.matchTOTP(against, drift)
+	return ok
+}
+
+// matchTOTP returns the time step the code matched. Always matches if the login has no TOTP
+func (s *AuthLocal) matchTOTP(against string, drift int) (int64, bool) {
 	if s.auth.TOTPSpec == nil {
-		return true
+		return 0, true
 	}
 
 	tfa, err := totp.ParseTOTP(*s.auth.TOTPSpec)
 	if err != nil {
-		return false
+		return 0, false
 	}
 
-	return tfa.Validate(against, drift)
+	return tfa.ValidateStep(against, drift)
 }
 
 func (s *AuthLocal) HasTOTP() bool {
@@ -85,7 +116,7 @@ func (s *sadb) FindAuthLocalByUsername(username string) (*AuthLocal, error) {
 	username = strings.ToLower(username)
 
 	var localAuth accountAuthLocal
-	if err := s.db.Where(&accountAuthLocal{Username: username}).First(&localAuth).Error; err != nil {
+	if err := s.db.Where("organization_id = ? AND username = ?", s.organization, username).First(&localAuth).Error; err != nil {
 		return nil, AuthInvalidUsername.Wrap(err)
 	}
 
@@ -133,18 +164,46 @@ func (s *sadb) CreateAuthLocal(belongsTo *Account, username, password string) (*
 		return nil, AuthInvalidUsername.New()
 	}
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), 0)
+	hashed, err := s.opts.passwordHasher.Hash(password)
 	if err != nil {
 		return nil, err
 	}
 
-	auth := &accountAuthLocal{
-		AccountID:      belongsTo.ID,
-		Username:       username,
-		PasswordBcrypt: string(hashed),
+	s.CreateAuditRecord(belongsTo, AuditModuleLocal, AuditLevelInfo, "Associated username: %s", username)
+
+	return s.createAuthLocal(belongsTo, username, hashed)
+}
+
+func (s *sadb) CreateAuthLocalWithHash(belongsTo *Account, username, passwordHash string) (*AuthLocal, error) {
+	if belongsTo == nil {
+		return nil, InvalidAccount.New()
+	}
+	if !belongsTo.Active {
+		return nil, InactiveAccount.Newf("Unable to associate with deactivated account")
 	}
 
-	s.CreateAuditRecord(belongsTo, AuditModuleLocal, AuditLevelInfo, "Associated username: %s", username)
+	username = strings.TrimSpace(strings.ToLower(username))
+	if username == "" {
+		return nil, AuthInvalidUsername.New()
+	}
+	if !passhash.Supported(passwordHash) {
+		return nil, AuthInvalidPassword.Newf("Unsupported password hash")
+	}
+
+	s.CreateAuditRecord(belongsTo, AuditModuleLocal, AuditLevelInfo, "Associated username: %s (imported)", username)
+
+	return s.createAuthLocal(belongsTo, username, passwordHash)
+}
+
+func (s *sadb) createAuthLocal(belongsTo *Account, username, passwordHash string) (*AuthLocal, error) {
+	now := time.Now()
+	auth := &accountAuthLocal{
+		AccountID:       belongsTo.ID,
+		Username:        username,
+		PasswordBcrypt:  passwordHash,
+		OrganizationID:  belongsTo.OrganizationID,
+		PasswordChanged: &now,
+	}
 
 	if err := s.db.Create(auth).Error; err != nil {
 		return nil, err
@@ -160,32 +219,99 @@ func (s *sadb) UpdateAuthLocalPassword(authLocal *AuthLocal, newPassword string)
 		return InternalError.Newf("Auth nil")
 	}
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(newPassword), 0)
+	hashed, err := s.opts.passwordHasher.Hash(newPassword)
 	if err != nil {
 		return InternalError.Wrap(err)
 	}
 
 	s.CreateAuditRecord(authLocal, AuditModuleLocal, AuditLevelInfo, "Password updated")
 
-	err = s.db.Model(authLocal.auth).Update(accountAuthLocal{PasswordBcrypt: string(hashed)}).Error
+	err = s.db.Model(authLocal.auth).Updates(map[string]interface{}{
+		"password_bcrypt":          hashed,
+		"password_changed":         time.Now(),
+		"password_expiry_reminded": false,
+	}).Error
 	if err != nil {
 		return InternalError.Wrap(err)
 	}
 	return nil
 }
 
+func (s *sadb) RehashAuthLocalPassword(authLocal *AuthLocal, password string) (bool, error) {
+	if authLocal == nil {
+		return false, InternalError.Newf("Auth nil")
+	}
+	if !s.opts.passwordHasher.NeedsRehash(authLocal.auth.PasswordBcrypt) {
+		return false, nil
+	}
+	if !authLocal.VerifyPassword(password) {
+		return false, AuthInvalidPassword.New()
+	}
+
+	hashed, err := s.opts.passwordHasher.Hash(password)
+	if err != nil {
+		return false, InternalError.Wrap(err)
+	}
+
+	err = s.db.Model(authLocal.auth).Update(accountAuthLocal{PasswordBcrypt: hashed}).Error
+	if err != nil {
+		return false, InternalError.Wrap(err)
+	}
+
+	s.CreateAuditRecord(authLocal, AuditModuleLocal, AuditLevelInfo, "Password hash upgraded")
+
+	return true, nil
+}
+
 func (s *sadb) UpdateAuthLocalTOTP(authLocal *AuthLocal, totpURL *string) error {
 	if authLocal == nil {
 		return InternalError.Newf("Auth nil")
 	}
 
-	// Disable
+	// Disable, along with the recovery codes that stand in for it
 	if totpURL == nil {
 		s.CreateAuditRecord(authLocal, AuditModuleLocal, AuditLevelInfo, "Disabled TOTP")
-		return s.db.Model(authLocal.auth).Update("TOTPSpec", nil).Error
+		if err := s.deleteRecoveryCodes(authLocal); err != nil {
+			return err
+		}
+		return s.db.Model(authLocal.auth).Updates(map[string]interface{}{
+			"totp_spec":      nil,
+			"totp_last_step": 0,
+		}).Error
 	}
 
 	s.CreateAuditRecord(authLocal, AuditModuleLocal, AuditLevelInfo, "Activated TOTP")
 
-	return s.db.Model(authLocal.auth).Update(accountAuthLocal{TOTPSpec: totpURL}).Error
+	// A new secret's codes weren't used before, whatever their time step
+	return s.db.Model(authLocal.auth).Updates(map[string]interface{}{
+		"totp_spec":      totpURL,
+		"totp_last_step": 0,
+	}).Error
+}
+
+func (s *sadb) VerifyAuthLocalTOTP(authLocal *AuthLocal, code string, drift int) (bool, error) {
+	if authLocal == nil {
+		return false, InternalError.Newf("Auth nil")
+	}
+	if !authLocal.HasTOTP() {
+		return true, nil
+	}
+
+	step, ok := authLocal.matchTOTP(code, drift)
+	if !ok {
+		return false, nil
+	}
+
+	// Conditional, so that of concurrent logins with the same code, only one is accepted
+	res := s.db.Model(authLocal.auth).
+		Where("totp_last_step < ?", step).
+		UpdateColumn("totp_last_step", step)
+	if res.Error != nil {
+		return false, InternalError.Wrap(res.Error)
+	}
+	if res.RowsAffected == 0 {
+		s.CreateAuditRecord(authLocal, AuditModuleLocal, AuditLevelWarn, "TOTP code replayed")
+		return false, nil
+	}
+	return true, nil
 }
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"time"
+)
+
+type AccountAuthLocalExpiry interface {
+	// SetPasswordNeverExpires exempts a login's password from expiring (eg. a service account), or removes the exemption
+	SetPasswordNeverExpires(authLocal *AuthLocal, neverExpires bool) error
+	// FindPasswordsDueReminder finds the logins of active accounts whose password was last set before changedBefore,
+	// and that haven't been reminded it expires. Exempt logins are never due
+	FindPasswordsDueReminder(changedBefore time.Time) ([]*AuthLocal, error)
+	// MarkPasswordExpiryReminded records that the login was reminded its current password expires
+	MarkPasswordExpiryReminded(authLocal *AuthLocal) error
+}
+
+// PasswordChanged is when the password was last set
+func (s *AuthLocal) PasswordChanged() time.Time {
+	if s.auth.PasswordChanged == nil {
+		return s.auth.CreatedAt
+	}
+	return *s.auth.PasswordChanged
+}
+
+func (s *AuthLocal) PasswordNeverExpires() bool {
+	return s.auth.PasswordNeverExpires
+}
+
+// PasswordExpires is when the password expires, once it's maxAge old. nil if it doesn't
+func (s *AuthLocal) PasswordExpires(maxAge time.Duration) *time.Time {
+	if maxAge <= 0 || s.auth.PasswordNeverExpires {
+		return nil
+	}
+	expires := s.PasswordChanged().Add(maxAge)
+	return &expires
+}
+
+// PasswordExpired is true if the password is older than maxAge, and isn't exempt
+func (s *AuthLocal) PasswordExpired(maxAge time.Duration) bool {
+	expires := s.PasswordExpires(maxAge)
+	return expires != nil && time.Now().After(*expires)
+}
+
+func (s *sadb) SetPasswordNeverExpires(authLocal *AuthLocal, neverExpires bool) error {
+	if authLocal == nil {
+		return InternalError.Newf("Auth nil")
+	}
+
+	if err := s.db.Model(authLocal.auth).Update("password_never_expires", neverExpires).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+
+	if neverExpires {
+		s.CreateAuditRecord(authLocal, AuditModuleLocal, AuditLevelWarn, "Password exempt from expiry")
+	} else {
+		s.CreateAuditRecord(authLocal, AuditModuleLocal, AuditLevelInfo, "Password no longer exempt from expiry")
+	}
+	return nil
+}
+
+func (s *sadb) FindPasswordsDueReminder(changedBefore time.Time) ([]*AuthLocal, error) {
+	var auths []*accountAuthLocal
+	err := s.db.
+		Where("password_never_expires = ? AND password_expiry_reminded = ?", false, false).
+		Where("password_changed < ?", changedBefore).
+		Find(&auths).Error
+	if err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+
+	ret := make([]*AuthLocal, 0, len(auths))
+	for _, auth := range auths {
+		var account Account
+		if err := s.db.Model(auth).Related(&account).Error; err != nil {
+			return nil, InternalError.Wrap(err)
+		}
+		if !account.Active {
+			continue
+		}
+		ret = append(ret, &AuthLocal{
+			auth:    auth,
+			account: &account,
+		})
+	}
+	return ret, nil
+}
+
+func (s *sadb) MarkPasswordExpiryReminded(authLocal *AuthLocal) error {
+	if authLocal == nil {
+		return InternalError.Newf("Auth nil")
+	}
+	if err := s.db.Model(authLocal.auth).Update("password_expiry_reminded", true).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+	return nil
+}
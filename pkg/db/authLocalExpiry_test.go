@@ -0,0 +1,59 @@
+package db_test
+
+import (
+	"simple-auth/pkg/db"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func findDueReminder(t *testing.T, authLocal *db.AuthLocal) bool {
+	due, err := sadb.FindPasswordsDueReminder(time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	for _, d := range due {
+		if d.Account().UUID == authLocal.Account().UUID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPasswordExpiry(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "expiry@asdf.com")
+	authLocal, _ := sadb.CreateAuthLocal(account, "expiry", "expiry-pass")
+
+	assert.WithinDuration(t, time.Now(), authLocal.PasswordChanged(), 5*time.Second)
+	assert.Nil(t, authLocal.PasswordExpires(0))
+	assert.False(t, authLocal.PasswordExpired(time.Hour))
+	assert.True(t, authLocal.PasswordExpired(time.Nanosecond))
+
+	assert.True(t, findDueReminder(t, authLocal))
+	assert.NoError(t, sadb.MarkPasswordExpiryReminded(authLocal))
+	assert.False(t, findDueReminder(t, authLocal))
+
+	// A new password resets the age, and the reminder
+	changed := authLocal.PasswordChanged()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, sadb.UpdateAuthLocalPassword(authLocal, "expiry-pass-2"))
+	assert.True(t, authLocal.PasswordChanged().After(changed))
+	authLocal, _ = sadb.FindAuthLocal(account)
+	assert.True(t, authLocal.PasswordChanged().After(changed))
+	assert.True(t, findDueReminder(t, authLocal))
+
+	// Exempt
+	assert.NoError(t, sadb.SetPasswordNeverExpires(authLocal, true))
+	authLocal, _ = sadb.FindAuthLocal(account)
+	assert.True(t, authLocal.PasswordNeverExpires())
+	assert.Nil(t, authLocal.PasswordExpires(time.Nanosecond))
+	assert.False(t, authLocal.PasswordExpired(time.Nanosecond))
+	assert.False(t, findDueReminder(t, authLocal))
+
+	assert.NoError(t, sadb.SetPasswordNeverExpires(authLocal, false))
+	authLocal, _ = sadb.FindAuthLocal(account)
+	assert.True(t, authLocal.PasswordExpired(time.Nanosecond))
+
+	// Inactive accounts aren't reminded
+	sadb.DeactivateAccount(account)
+	assert.False(t, findDueReminder(t, authLocal))
+}
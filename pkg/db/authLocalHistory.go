@@ -0,0 +1,91 @@
+package db
+
+import (
+	"simple-auth/pkg/lib/passhash"
+	"time"
+)
+
+type AccountAuthLocalHistory interface {
+	// RecordPasswordHistory remembers the current password hash before it's replaced, keeping
+	// only the most recent, so that together with the current password there are at most depth
+	RecordPasswordHistory(authLocal *AuthLocal, depth int) error
+	// PasswordInHistory is true if the password is the current one, or one of the depth-1 before it
+	PasswordInHistory(authLocal *AuthLocal, password string, depth int) (bool, error)
+}
+
+// accountPasswordHistory is a previous password hash of a local login
+type accountPasswordHistory struct {
+	ID           uint `gorm:"primary_key"`
+	CreatedAt    time.Time
+	AccountID    uint   `gorm:"index;not null"`
+	PasswordHash string `gorm:"not null"`
+}
+
+func (s *sadb) RecordPasswordHistory(authLocal *AuthLocal, depth int) error {
+	if authLocal == nil {
+		return InternalError.Newf("Auth nil")
+	}
+
+	if depth > 1 {
+		err := s.db.Create(&accountPasswordHistory{
+			AccountID:    authLocal.account.ID,
+			PasswordHash: authLocal.auth.PasswordBcrypt,
+		}).Error
+		if err != nil {
+			return InternalError.Wrap(err)
+		}
+	}
+
+	// Forget anything older than needed
+	var keepIDs []uint
+	if depth > 1 {
+		err := s.db.Model(&accountPasswordHistory{}).
+			Where("account_id = ?", authLocal.account.ID).
+			Order("id desc").
+			Limit(depth-1).
+			Pluck("id", &keepIDs).Error
+		if err != nil {
+			return InternalError.Wrap(err)
+		}
+	}
+
+	forget := s.db.Where("account_id = ?", authLocal.account.ID)
+	if len(keepIDs) > 0 {
+		forget = forget.Where("id NOT IN (?)", keepIDs)
+	}
+	if err := forget.Delete(&accountPasswordHistory{}).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+	return nil
+}
+
+func (s *sadb) PasswordInHistory(authLocal *AuthLocal, password string, depth int) (bool, error) {
+	if authLocal == nil {
+		return false, InternalError.Newf("Auth nil")
+	}
+	if depth <= 0 {
+		return false, nil
+	}
+	if authLocal.VerifyPassword(password) {
+		return true, nil
+	}
+	if depth == 1 {
+		return false, nil
+	}
+
+	var history []*accountPasswordHistory
+	err := s.db.Where("account_id = ?", authLocal.account.ID).
+		Order("id desc").
+		Limit(depth - 1).
+		Find(&history).Error
+	if err != nil {
+		return false, InternalError.Wrap(err)
+	}
+
+	for _, h := range history {
+		if ok, err := passhash.Verify(h.PasswordHash, password); err == nil && ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,46 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordHistory(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "history@asdf.com")
+	authLocal, _ := sadb.CreateAuthLocal(account, "history", "pass-1")
+
+	setPassword := func(password string) {
+		assert.NoError(t, sadb.RecordPasswordHistory(authLocal, 3))
+		assert.NoError(t, sadb.UpdateAuthLocalPassword(authLocal, password))
+		authLocal, _ = sadb.FindAuthLocal(account)
+	}
+
+	inHistory := func(password string, depth int) bool {
+		ok, err := sadb.PasswordInHistory(authLocal, password, depth)
+		assert.NoError(t, err)
+		return ok
+	}
+
+	assert.True(t, inHistory("pass-1", 3))
+	assert.False(t, inHistory("pass-1", 0))
+
+	setPassword("pass-2")
+	setPassword("pass-3")
+	assert.True(t, inHistory("pass-3", 3))
+	assert.True(t, inHistory("pass-2", 3))
+	assert.True(t, inHistory("pass-1", 3))
+	assert.False(t, inHistory("pass-1", 2))
+	assert.False(t, inHistory("pass-2", 1))
+	assert.False(t, inHistory("pass-4", 3))
+
+	// Only 2 previous hashes are kept
+	setPassword("pass-4")
+	assert.False(t, inHistory("pass-1", 10))
+	assert.True(t, inHistory("pass-2", 10))
+
+	// Lowering the depth forgets the rest
+	assert.NoError(t, sadb.RecordPasswordHistory(authLocal, 1))
+	assert.False(t, inHistory("pass-3", 10))
+	assert.True(t, inHistory("pass-4", 10))
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"math"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// LockoutPolicy is when repeated failed logins lock a local login
+type LockoutPolicy struct {
+	Threshold          int           // Failed logins before locking. 0 disables
+	Duration           time.Duration // How long the first lock lasts, doubled by each further failed login
+	MaxDuration        time.Duration // Caps how long a lock lasts. 0 is uncapped
+	PermanentThreshold int           // Failed logins after which it stays locked until unlocked. 0 disables
+}
+
+// lockDuration is how long the login is locked after a number of failed logins, or 0 if it isn't
+func (s *LockoutPolicy) lockDuration(failedLogins int) time.Duration {
+	if s.Threshold <= 0 || failedLogins < s.Threshold {
+		return 0
+	}
+
+	d := s.Duration
+	for i := s.Threshold; i < failedLogins && d < math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if s.MaxDuration > 0 && d > s.MaxDuration {
+		d = s.MaxDuration
+	}
+	return d
+}
+
+type AccountAuthLocalLockout interface {
+	// RecordLoginFailure counts a failed login, locking the login if the policy says so. Returns true if it's now locked
+	RecordLoginFailure(authLocal *AuthLocal, policy *LockoutPolicy) (bool, error)
+	// ResetLoginFailures clears the count of failed logins, after a successful login
+	ResetLoginFailures(authLocal *AuthLocal) error
+	// UnlockAuthLocal clears any lock, and the count of failed logins
+	UnlockAuthLocal(authLocal *AuthLocal) error
+}
+
+// IsLocked is true if the login can't be used, either until LockedUntil, or until unlocked
+func (s *AuthLocal) IsLocked() bool {
+	return s.auth.Locked || (s.auth.LockedUntil != nil && time.Now().Before(*s.auth.LockedUntil))
+}
+
+// LockedUntil is when a temporary lock ends, or nil if locked until unlocked (or not locked)
+func (s *AuthLocal) LockedUntil() *time.Time {
+	if s.auth.Locked || !s.IsLocked() {
+		return nil
+	}
+	return s.auth.LockedUntil
+}
+
+func (s *AuthLocal) FailedLogins() int {
+	return s.auth.FailedLogins
+}
+
+// LockedError returns the AuthLocked error for a locked login
+func (s *AuthLocal) LockedError() error {
+	if until := s.LockedUntil(); until != nil {
+		return AuthLocked.Newf("Login locked until %s", until.Format(time.RFC3339))
+	}
+	return AuthLocked.Newf("Login locked until unlocked")
+}
+
+func (s *sadb) RecordLoginFailure(authLocal *AuthLocal, policy *LockoutPolicy) (bool, error) {
+	if authLocal == nil {
+		return false, InternalError.Newf("Auth nil")
+	}
+
+	// Incremented in the db rather than from the loaded count, so concurrent failures are all counted
+	err := s.db.Model(authLocal.auth).UpdateColumn("failed_logins", gorm.Expr("failed_logins + 1")).Error
+	if err != nil {
+		return false, InternalError.Wrap(err)
+	}
+	var failedLogins int
+	if err := s.db.Model(&accountAuthLocal{}).Where("id = ?", authLocal.auth.ID).Select("failed_logins").Row().Scan(&failedLogins); err != nil {
+		return false, InternalError.Wrap(err)
+	}
+	authLocal.auth.FailedLogins = failedLogins
+
+	update := map[string]interface{}{}
+	locked := false
+	if policy.PermanentThreshold > 0 && failedLogins >= policy.PermanentThreshold {
+		update["locked"] = true
+		locked = true
+		s.CreateAuditRecord(authLocal, AuditModuleLocal, AuditLevelWarn, "Login locked after %d failed logins", failedLogins)
+	} else if d := policy.lockDuration(failedLogins); d > 0 {
+		update["locked_until"] = time.Now().Add(d)
+		locked = true
+		s.CreateAuditRecord(authLocal, AuditModuleLocal, AuditLevelWarn, "Login locked for %s after %d failed logins", d, failedLogins)
+	}
+
+	if len(update) > 0 {
+		if err := s.db.Model(authLocal.auth).Updates(update).Error; err != nil {
+			return false, InternalError.Wrap(err)
+		}
+	}
+	return locked, nil
+}
+
+func (s *sadb) ResetLoginFailures(authLocal *AuthLocal) error {
+	if authLocal == nil {
+		return InternalError.Newf("Auth nil")
+	}
+	if authLocal.auth.FailedLogins == 0 && authLocal.auth.LockedUntil == nil {
+		return nil
+	}
+
+	err := s.db.Model(authLocal.auth).Updates(map[string]interface{}{
+		"failed_logins": 0,
+		"locked_until":  nil,
+	}).Error
+	if err != nil {
+		return InternalError.Wrap(err)
+	}
+	return nil
+}
+
+func (s *sadb) UnlockAuthLocal(authLocal *AuthLocal) error {
+	if authLocal == nil {
+		return InternalError.Newf("Auth nil")
+	}
+
+	err := s.db.Model(authLocal.auth).Updates(map[string]interface{}{
+		"failed_logins": 0,
+		"locked_until":  nil,
+		"locked":        false,
+	}).Error
+	if err != nil {
+		return InternalError.Wrap(err)
+	}
+
+	// The emailed link is no longer needed
+	if err := s.db.Where("account_id = ? AND type = ?", authLocal.account.ID, (&UnlockStipulation{}).Type()).Delete(&accountStipulation{}).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+
+	s.CreateAuditRecord(authLocal, AuditModuleLocal, AuditLevelInfo, "Login unlocked")
+	return nil
+}
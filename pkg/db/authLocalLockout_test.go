@@ -0,0 +1,111 @@
+package db_test
+
+import (
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/saerrors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthLocalLockout(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "lockout@asdf.com")
+	authLocal, _ := sadb.CreateAuthLocal(account, "lockout", "lockout-pass")
+	policy := &db.LockoutPolicy{
+		Threshold:   2,
+		Duration:    time.Minute,
+		MaxDuration: 3 * time.Minute,
+	}
+
+	locked, err := sadb.RecordLoginFailure(authLocal, policy)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+	assert.False(t, authLocal.IsLocked())
+
+	locked, err = sadb.RecordLoginFailure(authLocal, policy)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+
+	// Persisted
+	authLocal, _ = sadb.FindAuthLocal(account)
+	assert.True(t, authLocal.IsLocked())
+	assert.Equal(t, 2, authLocal.FailedLogins())
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *authLocal.LockedUntil(), 5*time.Second)
+	assert.Equal(t, db.AuthLocked, saerrors.UnwrapCode(authLocal.LockedError()))
+
+	// Doubles, up to the max
+	sadb.RecordLoginFailure(authLocal, policy)
+	authLocal, _ = sadb.FindAuthLocal(account)
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), *authLocal.LockedUntil(), 5*time.Second)
+	sadb.RecordLoginFailure(authLocal, policy)
+	authLocal, _ = sadb.FindAuthLocal(account)
+	assert.WithinDuration(t, time.Now().Add(3*time.Minute), *authLocal.LockedUntil(), 5*time.Second)
+
+	assert.NoError(t, sadb.UnlockAuthLocal(authLocal))
+	authLocal, _ = sadb.FindAuthLocal(account)
+	assert.False(t, authLocal.IsLocked())
+	assert.Equal(t, 0, authLocal.FailedLogins())
+}
+
+func TestAuthLocalLockoutPermanent(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "lockout-perm@asdf.com")
+	authLocal, _ := sadb.CreateAuthLocal(account, "lockout-perm", "lockout-pass")
+	policy := &db.LockoutPolicy{
+		PermanentThreshold: 2,
+	}
+
+	sadb.RecordLoginFailure(authLocal, policy)
+	assert.NoError(t, sadb.ResetLoginFailures(authLocal))
+	sadb.RecordLoginFailure(authLocal, policy)
+	assert.False(t, authLocal.IsLocked())
+
+	locked, err := sadb.RecordLoginFailure(authLocal, policy)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+
+	authLocal, _ = sadb.FindAuthLocal(account)
+	assert.True(t, authLocal.IsLocked())
+	assert.Nil(t, authLocal.LockedUntil())
+
+	// Only unlocking clears a permanent lock
+	assert.NoError(t, sadb.ResetLoginFailures(authLocal))
+	authLocal, _ = sadb.FindAuthLocal(account)
+	assert.True(t, authLocal.IsLocked())
+
+	assert.NoError(t, sadb.UnlockAuthLocal(authLocal))
+	authLocal, _ = sadb.FindAuthLocal(account)
+	assert.False(t, authLocal.IsLocked())
+}
+
+func TestAuthLocalLockoutConcurrent(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "lockout-concurrent@asdf.com")
+	sadb.CreateAuthLocal(account, "lockout-concurrent", "lockout-pass")
+	policy := &db.LockoutPolicy{
+		PermanentThreshold: 10,
+	}
+
+	// Each guess loads the login before any failure is recorded, like parallel requests
+	var logins []*db.AuthLocal
+	for i := 0; i < 10; i++ {
+		authLocal, _ := sadb.FindAuthLocal(account)
+		logins = append(logins, authLocal)
+	}
+
+	var wg sync.WaitGroup
+	for _, authLocal := range logins {
+		wg.Add(1)
+		go func(authLocal *db.AuthLocal) {
+			defer wg.Done()
+			_, err := sadb.RecordLoginFailure(authLocal, policy)
+			assert.NoError(t, err)
+		}(authLocal)
+	}
+	wg.Wait()
+
+	authLocal, _ := sadb.FindAuthLocal(account)
+	assert.Equal(t, 10, authLocal.FailedLogins())
+	assert.True(t, authLocal.IsLocked())
+	assert.Nil(t, authLocal.LockedUntil())
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type AccountAuthLocalRecovery interface {
+	// ReplaceRecoveryCodes forgets any existing recovery codes of the login, and stores codes in their place
+	ReplaceRecoveryCodes(authLocal *AuthLocal, codes []string) error
+	// UseRecoveryCode consumes the code if it's one of the login's unused recovery codes. Case, spaces and
+	// dashes are ignored
+	UseRecoveryCode(authLocal *AuthLocal, code string) (bool, error)
+	// CountRecoveryCodes is how many unused recovery codes the login has
+	CountRecoveryCodes(authLocal *AuthLocal) (int, error)
+}
+
+// accountRecoveryCode is a single-use code that can be used in place of a TOTP code
+type accountRecoveryCode struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	AccountID uint   `gorm:"index;not null"`
+	Code      string `gorm:"not null"` // Hashed, see hashToken
+}
+
+// normalizeRecoveryCode strips the formatting a user may have added, or left out, when typing the code
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+func (s *sadb) ReplaceRecoveryCodes(authLocal *AuthLocal, codes []string) error {
+	if authLocal == nil {
+		return InternalError.Newf("Auth nil")
+	}
+
+	if err := s.deleteRecoveryCodes(authLocal); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		err := s.db.Create(&accountRecoveryCode{
+			AccountID: authLocal.account.ID,
+			Code:      hashToken(s.opts, normalizeRecoveryCode(code)),
+		}).Error
+		if err != nil {
+			return InternalError.Wrap(err)
+		}
+	}
+
+	s.CreateAuditRecord(authLocal, AuditModuleLocal, AuditLevelInfo, "Generated %d recovery codes", len(codes))
+	return nil
+}
+
+func (s *sadb) UseRecoveryCode(authLocal *AuthLocal, code string) (bool, error) {
+	if authLocal == nil {
+		return false, InternalError.Newf("Auth nil")
+	}
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return false, nil
+	}
+
+	var recoveryCode accountRecoveryCode
+	err := s.db.Where("account_id = ? AND code = ?", authLocal.account.ID, hashToken(s.opts, code)).First(&recoveryCode).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, InternalError.Wrap(err)
+	}
+
+	// Only whoever deletes it gets to use it
+	deleted := s.db.Delete(&recoveryCode)
+	if deleted.Error != nil {
+		return false, InternalError.Wrap(deleted.Error)
+	}
+	if deleted.RowsAffected == 0 {
+		return false, nil
+	}
+
+	s.CreateAuditRecord(authLocal, AuditModuleLocal, AuditLevelWarn, "Recovery code used")
+	return true, nil
+}
+
+func (s *sadb) CountRecoveryCodes(authLocal *AuthLocal) (int, error) {
+	if authLocal == nil {
+		return 0, InternalError.Newf("Auth nil")
+	}
+
+	var count int
+	if err := s.db.Model(&accountRecoveryCode{}).Where("account_id = ?", authLocal.account.ID).Count(&count).Error; err != nil {
+		return 0, InternalError.Wrap(err)
+	}
+	return count, nil
+}
+
+func (s *sadb) deleteRecoveryCodes(authLocal *AuthLocal) error {
+	if err := s.db.Where("account_id = ?", authLocal.account.ID).Delete(&accountRecoveryCode{}).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoveryCodes(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "recovery@asdf.com")
+	authLocal, _ := sadb.CreateAuthLocal(account, "recovery", "pass")
+
+	count, err := sadb.CountRecoveryCodes(authLocal)
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+
+	assert.NoError(t, sadb.ReplaceRecoveryCodes(authLocal, []string{"aaaaa-bbbbb", "ccccc-ddddd"}))
+	count, _ = sadb.CountRecoveryCodes(authLocal)
+	assert.Equal(t, 2, count)
+
+	used, err := sadb.UseRecoveryCode(authLocal, "wrong")
+	assert.NoError(t, err)
+	assert.False(t, used)
+
+	// Formatting is ignored
+	used, err = sadb.UseRecoveryCode(authLocal, " AAAAA bbbbb")
+	assert.NoError(t, err)
+	assert.True(t, used)
+
+	// Single-use
+	used, _ = sadb.UseRecoveryCode(authLocal, "aaaaa-bbbbb")
+	assert.False(t, used)
+	count, _ = sadb.CountRecoveryCodes(authLocal)
+	assert.Equal(t, 1, count)
+
+	// Replacing forgets the old codes
+	assert.NoError(t, sadb.ReplaceRecoveryCodes(authLocal, []string{"eeeee-fffff"}))
+	used, _ = sadb.UseRecoveryCode(authLocal, "ccccc-ddddd")
+	assert.False(t, used)
+
+	// As does disabling TOTP
+	assert.NoError(t, sadb.UpdateAuthLocalTOTP(authLocal, nil))
+	count, _ = sadb.CountRecoveryCodes(authLocal)
+	assert.Zero(t, count)
+}
@@ -2,8 +2,10 @@ package db_test
 
 import (
 	"simple-auth/pkg/db"
+	"simple-auth/pkg/lib/passhash"
 	"simple-auth/pkg/lib/totp"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -112,3 +114,63 @@ func TestUpdateTOTP(t *testing.T) {
 	assert.True(t, authLocalUpdated.HasTOTP())
 	assert.True(t, authLocal.VerifyTOTP(tfa.GetTOTP(), 1))
 }
+
+func TestVerifyTOTPReplay(t *testing.T) {
+	tfa, _ := totp.NewTOTP(12, "test", "test")
+
+	account, _ := sadb.CreateAccount("test", "totp-replay@asdf.com")
+	authLocal, _ := sadb.CreateAuthLocal(account, "totp-replay", "totp-replay")
+
+	tStr := tfa.String()
+	sadb.UpdateAuthLocalTOTP(authLocal, &tStr)
+
+	step := time.Now().Unix() / 30
+	ok, err := sadb.VerifyAuthLocalTOTP(authLocal, tfa.GetHOTP(step), 2)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Neither the same code, nor an earlier one, is accepted again
+	ok, _ = sadb.VerifyAuthLocalTOTP(authLocal, tfa.GetHOTP(step), 2)
+	assert.False(t, ok)
+	authLocal, _ = sadb.FindAuthLocal(account)
+	ok, _ = sadb.VerifyAuthLocalTOTP(authLocal, tfa.GetHOTP(step-1), 2)
+	assert.False(t, ok)
+
+	ok, _ = sadb.VerifyAuthLocalTOTP(authLocal, tfa.GetHOTP(step+1), 2)
+	assert.True(t, ok)
+}
+
+func TestRehashPassword(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "rehash@asdf.com")
+	sadb.CreateAuthLocal(account, "rehash", "rehash-pass")
+
+	argonDB := db.New("sqlite3", "file::memory:?cache=shared", db.WithPasswordHasher(passhash.NewArgon2id(1, 1024, 1)))
+	authLocal, _ := argonDB.FindAuthLocal(account)
+
+	_, err := argonDB.RehashAuthLocalPassword(authLocal, "wrong-pass")
+	assert.Error(t, err)
+
+	rehashed, err := argonDB.RehashAuthLocalPassword(authLocal, "rehash-pass")
+	assert.NoError(t, err)
+	assert.True(t, rehashed)
+
+	authLocal, _ = argonDB.FindAuthLocal(account)
+	assert.True(t, authLocal.VerifyPassword("rehash-pass"))
+
+	rehashed, err = argonDB.RehashAuthLocalPassword(authLocal, "rehash-pass")
+	assert.NoError(t, err)
+	assert.False(t, rehashed)
+}
+
+func TestCreateAuthLocalWithHash(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "import@asdf.com")
+
+	_, err := sadb.CreateAuthLocalWithHash(account, "import-bad", "$apr1$salt$hash")
+	assert.Error(t, err)
+
+	hash, _ := passhash.NewBcrypt(4).Hash("imported-pass")
+	authLocal, err := sadb.CreateAuthLocalWithHash(account, "Import", hash)
+	assert.NoError(t, err)
+	assert.Equal(t, "import", authLocal.Username())
+	assert.True(t, authLocal.VerifyPassword("imported-pass"))
+}
@@ -30,12 +30,13 @@ const (
 
 type accountOAuthToken struct {
 	gorm.Model
-	AccountID uint `gorm:"index; not null"`
-	ClientID  string
-	Type      OAuthTokenType
-	Token     string `gorm:"uniqueIndex; not null"`
-	Scope     string
-	Expires   time.Time
+	AccountID   uint `gorm:"index; not null"`
+	ClientID    string
+	Type        OAuthTokenType
+	Token       string `gorm:"uniqueIndex; not null"` // Hashed, see hashToken
+	TokenPrefix string
+	Scope       string
+	Expires     time.Time
 }
 
 func (s *accountOAuthToken) Expired() bool {
@@ -43,13 +44,14 @@ func (s *accountOAuthToken) Expired() bool {
 }
 
 type OAuthToken struct {
-	Account  *Account
-	Scopes   OAuthScope
-	Token    string
-	ClientID string
-	Type     OAuthTokenType
-	Created  time.Time
-	Expires  time.Time
+	Account     *Account
+	Scopes      OAuthScope
+	Token       string // Only known when the token was looked up by its value; otherwise empty
+	TokenPrefix string // The first few characters of the token, for identification
+	ClientID    string
+	Type        OAuthTokenType
+	Created     time.Time
+	Expires     time.Time
 }
 
 func (s *OAuthToken) Expired() bool {
@@ -62,12 +64,13 @@ func (s *sadb) CreateOAuthToken(account *Account, clientID string, tokenType OAu
 	}
 
 	oauth := &accountOAuthToken{
-		AccountID: account.ID,
-		ClientID:  clientID,
-		Type:      tokenType,
-		Token:     token,
-		Scope:     scopes.String(),
-		Expires:   time.Now().Add(expiresIn),
+		AccountID:   account.ID,
+		ClientID:    clientID,
+		Type:        tokenType,
+		Token:       hashToken(s.opts, token),
+		TokenPrefix: tokenPrefix(token),
+		Scope:       scopes.String(),
+		Expires:     time.Now().Add(expiresIn),
 	}
 
 	if err := s.db.Create(oauth).Error; err != nil {
@@ -91,7 +94,7 @@ func (s *sadb) AssertOAuthToken(clientID, token string, tokenType OAuthTokenType
 	}
 
 	var oauth accountOAuthToken
-	if err := s.db.Where("token = ? AND type = ? AND client_id = ?", token, tokenType, clientID).First(&oauth).Error; err != nil {
+	if err := s.db.Where("token = ? AND type = ? AND client_id = ?", hashToken(s.opts, token), tokenType, clientID).First(&oauth).Error; err != nil {
 		return nil, err
 	}
 
@@ -114,7 +117,7 @@ func (s *sadb) AssertOAuthToken(clientID, token string, tokenType OAuthTokenType
 		}
 	}
 
-	return dbTokenToOAuthToken(&account, &oauth), nil
+	return dbTokenToOAuthToken(&account, &oauth).withToken(token), nil
 }
 
 func (s *sadb) GetValidOAuthTokens(clientID string, account *Account) ([]*OAuthToken, error) {
@@ -166,7 +169,7 @@ func (s *sadb) GetValidOAuthToken(token string) (*OAuthToken, error) {
 	}
 
 	var oauth accountOAuthToken
-	if err := s.db.Where("token = ?", token).Find(&oauth).Error; err != nil {
+	if err := s.db.Where("token = ?", hashToken(s.opts, token)).Find(&oauth).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
 		}
@@ -182,7 +185,7 @@ func (s *sadb) GetValidOAuthToken(token string) (*OAuthToken, error) {
 		return nil, err
 	}
 
-	return dbTokenToOAuthToken(&account, &oauth), nil
+	return dbTokenToOAuthToken(&account, &oauth).withToken(token), nil
 }
 
 func (s *sadb) InvalidateToken(clientId string, account *Account, token string) error {
@@ -196,7 +199,7 @@ func (s *sadb) InvalidateToken(clientId string, account *Account, token string)
 		return errors.New("invalid token")
 	}
 
-	return s.db.Where("client_id = ? and account_id = ? and token = ?", clientId, account.ID, token).Delete(&accountOAuthToken{}).Error
+	return s.db.Where("client_id = ? and account_id = ? and token = ?", clientId, account.ID, hashToken(s.opts, token)).Delete(&accountOAuthToken{}).Error
 }
 
 func (s *sadb) InvalidateAllOAuth(clientId string, account *Account, exceptType []OAuthTokenType) error {
@@ -219,10 +222,16 @@ func dbTokenToOAuthToken(account *Account, token *accountOAuthToken) *OAuthToken
 	return &OAuthToken{
 		account,
 		NewOAuthScope(token.Scope),
-		token.Token,
+		"",
+		token.TokenPrefix,
 		token.ClientID,
 		token.Type,
 		token.CreatedAt,
 		token.Expires,
 	}
 }
+
+func (s *OAuthToken) withToken(token string) *OAuthToken {
+	s.Token = token
+	return s
+}
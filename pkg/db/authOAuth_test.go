@@ -75,3 +75,33 @@ func TestExpiredGetToken(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Nil(t, got)
 }
+
+func TestTokenStoredHashed(t *testing.T) {
+	token := uuid.New().String()
+	sadb.CreateOAuthToken(oauthTestAccount, oauthTestClientID, db.OAuthTypeAccessToken, token, nil, 1*time.Hour)
+
+	tokens, err := sadb.GetValidOAuthTokens(oauthTestClientID, oauthTestAccount)
+	assert.NoError(t, err)
+	found := false
+	for _, v := range tokens {
+		assert.Empty(t, v.Token)
+		if v.TokenPrefix == token[:5] {
+			found = true
+		}
+	}
+	assert.True(t, found)
+
+	got, err := sadb.AssertOAuthToken(oauthTestClientID, token, db.OAuthTypeAccessToken, false)
+	assert.NoError(t, err)
+	assert.Equal(t, token, got.Token)
+}
+
+func TestTokenSecretChangesHash(t *testing.T) {
+	token := uuid.New().String()
+	sadb.CreateOAuthToken(oauthTestAccount, oauthTestClientID, db.OAuthTypeAccessToken, token, nil, 1*time.Hour)
+
+	peppered := db.New("sqlite3", "file::memory:?cache=shared", db.WithTokenSecret("pepper"))
+	got, err := peppered.GetValidOAuthToken(token)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
@@ -16,7 +16,7 @@ type AccountAuthOneTime interface {
 type accountAuthOneTime struct {
 	gorm.Model
 	AccountID uint   `gorm:"index;not null"`
-	Token     string `gorm:"index;not null"`
+	Token     string `gorm:"index;not null"` // Hashed, see hashToken
 	Expires   time.Time
 }
 
@@ -28,9 +28,10 @@ func (s *sadb) CreateAccountOneTimeToken(account *Account, maxAge time.Duration)
 		return "", InactiveAccount.New()
 	}
 
+	plainToken := uuid.New().String()
 	token := accountAuthOneTime{
 		AccountID: account.ID,
-		Token:     uuid.New().String(),
+		Token:     hashToken(s.opts, plainToken),
 		Expires:   time.Now().Add(maxAge),
 	}
 
@@ -39,7 +40,7 @@ func (s *sadb) CreateAccountOneTimeToken(account *Account, maxAge time.Duration)
 	}
 
 	s.CreateAuditRecord(account, AuditModuleOneTime, AuditLevelInfo, "One time token issued for account, expires in %s", maxAge.String())
-	return token.Token, nil
+	return plainToken, nil
 }
 
 func (s *sadb) AssertOneTimeToken(token string) (*Account, error) {
@@ -48,7 +49,7 @@ func (s *sadb) AssertOneTimeToken(token string) (*Account, error) {
 	}
 
 	var oneTimeToken accountAuthOneTime
-	if err := s.db.Where(&accountAuthOneTime{Token: token}).First(&oneTimeToken).Error; err != nil {
+	if err := s.db.Where(&accountAuthOneTime{Token: hashToken(s.opts, token)}).First(&oneTimeToken).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, SAOneTimeInvalidToken.New()
 		}
@@ -0,0 +1,429 @@
+package db
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"reflect"
+	"simple-auth/pkg/saerrors"
+	"sort"
+	"time"
+)
+
+type BackupRestorer interface {
+	// Backup writes every table to a driver-independent archive
+	Backup(w io.Writer) (*BackupManifest, error)
+	// Restore loads an archive into an empty database, preserving IDs, UUIDs, and timestamps
+	Restore(r io.Reader) (*BackupManifest, error)
+	// CurrentManifest summarizes the current state of the database, as a backup would
+	CurrentManifest() (*BackupManifest, error)
+}
+
+const (
+	BackupInvalid  saerrors.ErrorCode = "backup-invalid"
+	BackupMismatch saerrors.ErrorCode = "backup-mismatch"
+	RestoreFailed  saerrors.ErrorCode = "restore-failed"
+)
+
+const (
+	backupFormat  = "simple-auth-backup"
+	backupVersion = 1
+)
+
+type BackupTableSummary struct {
+	Count  int64  `json:"count"`
+	SHA256 string `json:"sha256"`
+}
+
+type BackupManifest struct {
+	Version int                            `json:"version"`
+	Schema  int                            `json:"schema"`
+	Created time.Time                      `json:"created"`
+	Tables  map[string]*BackupTableSummary `json:"tables"`
+}
+
+// Compare returns a description of every table that differs between the manifests
+func (s *BackupManifest) Compare(other *BackupManifest) (diffs []string) {
+	names := make(map[string]bool)
+	for name := range s.Tables {
+		names[name] = true
+	}
+	for name := range other.Tables {
+		names[name] = true
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	empty := &BackupTableSummary{}
+	for _, name := range sorted {
+		a, b := s.Tables[name], other.Tables[name]
+		if a == nil {
+			a = empty
+		}
+		if b == nil {
+			b = empty
+		}
+		if a.Count != b.Count {
+			diffs = append(diffs, fmt.Sprintf("%s: %d records, expected %d", name, b.Count, a.Count))
+		} else if a.SHA256 != b.SHA256 {
+			diffs = append(diffs, fmt.Sprintf("%s: checksum mismatch", name))
+		}
+	}
+	return
+}
+
+type backupTable struct {
+	Name string
+	New  func() interface{}
+}
+
+// backupTables are every table in an archive, in the order they are restored.
+// Any new table must be added here
+var backupTables = []*backupTable{
+	{"organizations", func() interface{} { return &Organization{} }},
+	{"accounts", func() interface{} { return &Account{} }},
+	{"account_auth_locals", func() interface{} { return &accountAuthLocal{} }},
+	{"account_o_id_cs", func() interface{} { return &accountOIDC{} }},
+	{"account_stipulations", func() interface{} { return &accountStipulation{} }},
+	{"account_o_auth_tokens", func() interface{} { return &accountOAuthToken{} }},
+	{"account_auth_one_times", func() interface{} { return &accountAuthOneTime{} }},
+	{"account_attributes", func() interface{} { return &accountAttribute{} }},
+	{"account_memberships", func() interface{} { return &accountMembership{} }},
+	{"account_terms_acceptances", func() interface{} { return &accountTermsAcceptance{} }},
+	{"account_password_histories", func() interface{} { return &accountPasswordHistory{} }},
+	{"account_recovery_codes", func() interface{} { return &accountRecoveryCode{} }},
+	{"account_audit_records", func() interface{} { return &AccountAuditRecord{} }},
+}
+
+func findBackupTable(name string) *backupTable {
+	for _, t := range backupTables {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// Archive format: gzip'd JSON lines.  A header, followed by a line for each row, and a trailer with the manifest
+type backupHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Schema  int       `json:"schema"`
+	Created time.Time `json:"created"`
+}
+
+type backupLine struct {
+	Table    string          `json:"t,omitempty"`
+	Row      json.RawMessage `json:"r,omitempty"`
+	Manifest *BackupManifest `json:"manifest,omitempty"`
+}
+
+// backupChecksum accumulates the checksum of a table.  Rows are normalized so that the
+// checksum is the same regardless of the driver (eg. time precision) they were read from
+type backupChecksum struct {
+	count int64
+	hash  hash.Hash
+}
+
+func (s *backupChecksum) add(row interface{}) error {
+	normalizeBackupTimes(reflect.ValueOf(row))
+	b, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	s.hash.Write(b)
+	s.hash.Write([]byte{'\n'})
+	s.count++
+	return nil
+}
+
+type backupChecksums map[string]*backupChecksum
+
+func newBackupChecksums() backupChecksums {
+	ret := make(backupChecksums)
+	for _, t := range backupTables {
+		ret[t.Name] = &backupChecksum{hash: sha256.New()}
+	}
+	return ret
+}
+
+func (s backupChecksums) manifest(schema int, created time.Time) *BackupManifest {
+	ret := &BackupManifest{
+		Version: backupVersion,
+		Schema:  schema,
+		Created: created,
+		Tables:  make(map[string]*BackupTableSummary),
+	}
+	for name, c := range s {
+		ret.Tables[name] = &BackupTableSummary{
+			Count:  c.count,
+			SHA256: hex.EncodeToString(c.hash.Sum(nil)),
+		}
+	}
+	return ret
+}
+
+// normalizeBackupTimes rounds all times to UTC seconds, the lowest precision of the supported drivers
+func normalizeBackupTimes(v reflect.Value) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	if t, ok := v.Addr().Interface().(*time.Time); ok {
+		*t = t.UTC().Round(time.Second)
+		return
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		if field.Kind() == reflect.Struct || field.Kind() == reflect.Ptr {
+			normalizeBackupTimes(field)
+		}
+	}
+}
+
+// eachBackupRow iterates every row of every table, including soft-deleted rows, in id order
+func (s *sadb) eachBackupRow(itr func(table *backupTable, row interface{}) error) error {
+	for _, table := range backupTables {
+		rows, err := s.db.Unscoped().Model(table.New()).Order("id").Rows()
+		if err != nil {
+			return InternalError.Wrap(err)
+		}
+
+		for rows.Next() {
+			row := table.New()
+			if err := s.db.ScanRows(rows, row); err != nil {
+				rows.Close()
+				return InternalError.Wrap(err)
+			}
+			if err := itr(table, row); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+	}
+	return nil
+}
+
+func (s *sadb) currentSchemaVersion() (int, error) {
+	status, err := s.MigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for _, m := range status {
+		if m.Applied {
+			version = m.Version
+		}
+	}
+	return version, nil
+}
+
+func (s *sadb) Backup(w io.Writer) (*BackupManifest, error) {
+	schema, err := s.currentSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if schema != LatestSchemaVersion() {
+		return nil, MigrationPending.Newf("Database schema is at version %d, expected %d", schema, LatestSchemaVersion())
+	}
+
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+
+	header := backupHeader{
+		Format:  backupFormat,
+		Version: backupVersion,
+		Schema:  schema,
+		Created: time.Now().UTC(),
+	}
+	if err := enc.Encode(&header); err != nil {
+		return nil, err
+	}
+
+	checksums := newBackupChecksums()
+	err = s.eachBackupRow(func(table *backupTable, row interface{}) error {
+		b, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(&backupLine{Table: table.Name, Row: b}); err != nil {
+			return err
+		}
+		return checksums[table.Name].add(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := checksums.manifest(header.Schema, header.Created)
+	if err := enc.Encode(&backupLine{Manifest: manifest}); err != nil {
+		return nil, err
+	}
+
+	return manifest, gz.Close()
+}
+
+func (s *sadb) CurrentManifest() (*BackupManifest, error) {
+	schema, err := s.currentSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := newBackupChecksums()
+	err = s.eachBackupRow(func(table *backupTable, row interface{}) error {
+		return checksums[table.Name].add(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return checksums.manifest(schema, time.Now().UTC()), nil
+}
+
+// readBackup reads and validates an archive, calling onHeader before reading any rows, and itr for every row
+func readBackup(r io.Reader, onHeader func(header *backupHeader) error, itr func(table *backupTable, row interface{}) error) (*BackupManifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, BackupInvalid.Wrap(err)
+	}
+	defer gz.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(gz))
+
+	var header backupHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, BackupInvalid.Wrapf(err, "Unable to read header")
+	}
+	if header.Format != backupFormat {
+		return nil, BackupInvalid.Newf("Not a simple-auth backup")
+	}
+	if header.Version != backupVersion {
+		return nil, BackupInvalid.Newf("Unsupported backup version %d", header.Version)
+	}
+	if onHeader != nil {
+		if err := onHeader(&header); err != nil {
+			return nil, err
+		}
+	}
+
+	checksums := newBackupChecksums()
+	for {
+		var line backupLine
+		if err := dec.Decode(&line); err != nil {
+			if err == io.EOF {
+				return nil, BackupInvalid.Newf("Backup is truncated, missing manifest")
+			}
+			return nil, BackupInvalid.Wrap(err)
+		}
+
+		if line.Manifest != nil {
+			actual := checksums.manifest(header.Schema, header.Created)
+			if diffs := line.Manifest.Compare(actual); len(diffs) > 0 {
+				return nil, BackupMismatch.Newf("Backup is corrupt: %v", diffs)
+			}
+			if line.Manifest.Schema != header.Schema {
+				return nil, BackupInvalid.Newf("Manifest schema doesn't match header")
+			}
+			return line.Manifest, nil
+		}
+
+		table := findBackupTable(line.Table)
+		if table == nil {
+			return nil, BackupInvalid.Newf("Unknown table %s", line.Table)
+		}
+		row := table.New()
+		if err := json.Unmarshal(line.Row, row); err != nil {
+			return nil, BackupInvalid.Wrapf(err, "Invalid row in %s", line.Table)
+		}
+		if itr != nil {
+			if err := itr(table, row); err != nil {
+				return nil, err
+			}
+		}
+		if err := checksums[table.Name].add(row); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ReadBackupManifest validates the archive's integrity, and returns its manifest
+func ReadBackupManifest(r io.Reader) (*BackupManifest, error) {
+	return readBackup(r, nil, nil)
+}
+
+func (s *sadb) Restore(r io.Reader) (*BackupManifest, error) {
+	schema, err := s.currentSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, table := range backupTables {
+		var count int
+		if err := s.db.Unscoped().Model(table.New()).Count(&count).Error; err != nil {
+			return nil, InternalError.Wrap(err)
+		}
+		if count > 0 {
+			return nil, RestoreFailed.Newf("Table %s is not empty, refusing to restore", table.Name)
+		}
+	}
+
+	checkSchema := func(header *backupHeader) error {
+		if header.Schema != schema {
+			return RestoreFailed.Newf("Backup is schema version %d, database is %d", header.Schema, schema)
+		}
+		return nil
+	}
+	restoreRow := func(table *backupTable, row interface{}) error {
+		if err := s.db.Create(row).Error; err != nil {
+			return RestoreFailed.Wrapf(err, "Unable to restore row in %s", table.Name)
+		}
+		return nil
+	}
+
+	// Checksums are only verified once the trailer is read, so the caller is
+	// expected to run this in a transaction and roll back on error
+	manifest, err := readBackup(r, checkSchema, restoreRow)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.resetSequences(); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// resetSequences advances auto-increment sequences past the restored ids, for
+// drivers that don't do it on their own
+func (s *sadb) resetSequences() error {
+	if s.db.Dialect().GetName() != "postgres" {
+		return nil
+	}
+	for _, table := range backupTables {
+		name := s.db.NewScope(table.New()).TableName()
+		sql := fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %s", name, name)
+		if err := s.db.Exec(sql).Error; err != nil {
+			return InternalError.Wrap(err)
+		}
+	}
+	return nil
+}
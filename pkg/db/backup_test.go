@@ -0,0 +1,73 @@
+package db_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/saerrors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createBackupArchive(t *testing.T) ([]byte, *db.BackupManifest) {
+	if _, err := sadb.FindAccountByEmail("backup@example.com"); err != nil {
+		account, _ := sadb.CreateAccount("test", "backup@example.com")
+		sadb.CreateAccountOneTimeToken(account, time.Hour)
+		sadb.CreateOAuthToken(account, "backup-client", db.OAuthTypeAccessToken, "backup-token", nil, time.Hour)
+		sadb.DeactivateAccount(account) // soft-deletes tokens
+	}
+
+	var buf bytes.Buffer
+	manifest, err := sadb.Backup(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, db.LatestSchemaVersion(), manifest.Schema)
+	assert.Greater(t, manifest.Tables["accounts"].Count, int64(0))
+	return buf.Bytes(), manifest
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	archive, manifest := createBackupArchive(t)
+
+	read, err := db.ReadBackupManifest(bytes.NewReader(archive))
+	assert.NoError(t, err)
+	assert.Empty(t, manifest.Compare(read))
+
+	rdb := db.New("sqlite3", "file:restore-test?mode=memory&cache=shared")
+	restored, err := rdb.Restore(bytes.NewReader(archive))
+	assert.NoError(t, err)
+	assert.Empty(t, manifest.Compare(restored))
+
+	current, err := rdb.CurrentManifest()
+	assert.NoError(t, err)
+	assert.Empty(t, manifest.Compare(current))
+
+	account, err := rdb.FindAccountByEmail("backup@example.com")
+	assert.NoError(t, err)
+	assert.False(t, account.Active)
+
+	// Refuses to restore over existing data
+	_, err = rdb.Restore(bytes.NewReader(archive))
+	assert.Equal(t, db.RestoreFailed, saerrors.UnwrapCode(err))
+}
+
+func TestBackupDetectsCorruption(t *testing.T) {
+	archive, _ := createBackupArchive(t)
+
+	gz, _ := gzip.NewReader(bytes.NewReader(archive))
+	raw, _ := ioutil.ReadAll(gz)
+	tampered := bytes.Replace(raw, []byte("backup@example.com"), []byte("tamper@example.com"), 1)
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(tampered)
+	w.Close()
+
+	_, err := db.ReadBackupManifest(&buf)
+	assert.Equal(t, db.BackupMismatch, saerrors.UnwrapCode(err))
+
+	_, err = db.ReadBackupManifest(bytes.NewReader(raw))
+	assert.Equal(t, db.BackupInvalid, saerrors.UnwrapCode(err))
+}
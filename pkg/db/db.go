@@ -1,23 +1,43 @@
 package db
 
 import (
+	"simple-auth/pkg/lib/auditsink"
+
 	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
 )
 
 type sadb struct {
-	db *gorm.DB
+	db    *gorm.DB
+	opts  *options
+	audit *AuditContext // Request the db is being used for, if any
+	root  *sadb         // If a transaction, the db it was started from
+
+	pendingEvents *[]*auditsink.Event // If a transaction, audit sink events that are sent once it's committed
+
+	organization uint // Organization that account lookups and creation are scoped to; 0 for none
 }
 
 type SADB interface {
 	AccountAuthLocal
 	AccountStore
+	AccountAttributes
+	AccountMemberships
+	AccountOrganizations
 	AccountAudit
 	AccountOIDC
 	AccountAuthOneTime
 	AccountStipulations
+	AccountApprovals
+	AccountTerms
 	AccountOAuth
+	SchemaMigrator
+	Reaper
+	BackupRestorer
+	AuditChain
 	WithLogger(logger logrus.FieldLogger) SADB
+	// WithAuditContext returns a db that records the request's details on any audit records
+	WithAuditContext(ctx *AuditContext) SADB
 	EnableLogging(enable bool)
 	IsAlive() bool
 	BeginTransaction() SADBTransaction
@@ -29,7 +49,19 @@ type SADBTransaction interface {
 	Rollback() error
 }
 
-func New(driver string, args string) SADB {
+// New connects to the database and applies any pending schema migrations
+func New(driver string, args string, opts ...Option) SADB {
+	sadb := Open(driver, args, opts...)
+
+	if _, err := sadb.MigrateUp(0); err != nil {
+		logrus.Fatal(err)
+	}
+
+	return sadb
+}
+
+// Open connects to the database without touching the schema
+func Open(driver string, args string, opts ...Option) SADB {
 	logrus.Infof("Connecting to %s at %s...", driver, args)
 
 	db, err := gorm.Open(driver, args)
@@ -39,27 +71,33 @@ func New(driver string, args string) SADB {
 
 	db.SetLogger(logrus.StandardLogger())
 
-	db.AutoMigrate(&Account{})
-	db.AutoMigrate(&AccountAuditRecord{})
-	db.AutoMigrate(&accountAuthLocal{})
-	db.AutoMigrate(&accountAuthOneTime{})
-	db.AutoMigrate(&accountStipulation{})
-	db.AutoMigrate(&accountOAuthToken{})
-
-	db.AutoMigrate(&accountOIDC{})
-	db.Model(&accountOIDC{}).AddUniqueIndex("idx_provider_subject", "provider", "subject")
-
-	return &sadb{db}
+	return &sadb{db: db, opts: newOptions(opts...)}
 }
 
 func (s *sadb) WithLogger(logger logrus.FieldLogger) SADB {
 	wl := &sadb{
-		s.db.New(),
+		db:            s.db.New(),
+		opts:          s.opts,
+		audit:         s.audit,
+		root:          s.root,
+		pendingEvents: s.pendingEvents,
+		organization:  s.organization,
 	}
 	wl.db.SetLogger(logger)
 	return wl
 }
 
+func (s *sadb) WithAuditContext(ctx *AuditContext) SADB {
+	return &sadb{
+		db:            s.db,
+		opts:          s.opts,
+		audit:         ctx,
+		root:          s.root,
+		pendingEvents: s.pendingEvents,
+		organization:  s.organization,
+	}
+}
+
 func (s *sadb) IsAlive() bool {
 	return s.db.DB().Ping() == nil
 }
@@ -69,13 +107,35 @@ func (s *sadb) EnableLogging(enable bool) {
 }
 
 func (s *sadb) BeginTransaction() SADBTransaction {
-	return &sadb{s.db.Begin()}
+	return &sadb{
+		db:            s.db.Begin(),
+		opts:          s.opts,
+		audit:         s.audit,
+		root:          s,
+		pendingEvents: &[]*auditsink.Event{},
+		organization:  s.organization,
+	}
+}
+
+func (s *sadb) inTransaction() bool {
+	return s.root != nil
 }
 
 func (s *sadb) Commit() error {
-	return s.db.Commit().Error
+	if err := s.db.Commit().Error; err != nil {
+		return err
+	}
+	if s.root != nil {
+		s.root.sealAuditChainOrWarn()
+	}
+	s.flushAuditEvents()
+	return nil
 }
 
+// Rollback also drops the transaction's audit sink events, since their records no longer exist
 func (s *sadb) Rollback() error {
+	if s.pendingEvents != nil {
+		*s.pendingEvents = nil
+	}
 	return s.db.Rollback().Error
 }
@@ -9,16 +9,40 @@ const (
 	InvalidAccount  saerrors.ErrorCode = "invalid-account"
 	InactiveAccount saerrors.ErrorCode = "inactive-account"
 
+	// account approval
+	PendingApproval   saerrors.ErrorCode = "pending-approval"
+	AccountNotPending saerrors.ErrorCode = "account-not-pending"
+
+	// terms
+	TermsRequired saerrors.ErrorCode = "terms-required"
+
+	// account email
+	EmailInvalid     saerrors.ErrorCode = "email-invalid"
+	EmailUnavailable saerrors.ErrorCode = "email-unavailable"
+
+	// organization
+	OrganizationInvalid  saerrors.ErrorCode = "organization-invalid"
+	OrganizationNotFound saerrors.ErrorCode = "organization-not-found"
+	OrganizationNotEmpty saerrors.ErrorCode = "organization-not-empty"
+	OrganizationClosed   saerrors.ErrorCode = "organization-closed"
+
+	// membership
+	MembershipInvalid saerrors.ErrorCode = "membership-invalid"
+
 	// authOneTime
 	SAOneTimeInvalidToken saerrors.ErrorCode = "invalid-token"
 	SAOneTimeExpired      saerrors.ErrorCode = "expired"
 
 	// authLocal
-	AuthInvalidUsername saerrors.ErrorCode = "invalid-username"
+	AuthInvalidUsername     saerrors.ErrorCode = "invalid-username"
+	AuthInvalidPassword     saerrors.ErrorCode = "invalid-password"
+	AuthUsernameUnavailable saerrors.ErrorCode = "username-unavailable"
+	AuthLocked              saerrors.ErrorCode = "account-locked"
 
 	// authToken
-	VerificationMissing  saerrors.ErrorCode = "verification-missing"
-	VerificationConsumed saerrors.ErrorCode = "verification-consumed"
-	VerificationExpired  saerrors.ErrorCode = "verification-expired"
-	VerificationInvalid  saerrors.ErrorCode = "verification-invalid"
+	VerificationMissing   saerrors.ErrorCode = "verification-missing"
+	VerificationConsumed  saerrors.ErrorCode = "verification-consumed"
+	VerificationExpired   saerrors.ErrorCode = "verification-expired"
+	VerificationInvalid   saerrors.ErrorCode = "verification-invalid"
+	VerificationThrottled saerrors.ErrorCode = "verification-throttled"
 )
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/jinzhu/gorm"
+)
+
+type MembershipKind string
+
+const (
+	MembershipRole  MembershipKind = "role"
+	MembershipGroup MembershipKind = "group"
+)
+
+type AccountMemberships interface {
+	// AddMembership adds the account to the role or group, if not already a member
+	AddMembership(account *Account, kind MembershipKind, name string) error
+	RemoveMembership(account *Account, kind MembershipKind, name string) error
+	GetMemberships(account *Account) (*Memberships, error)
+	// FindMembers returns every account with the role or group
+	FindMembers(kind MembershipKind, name string) ([]*Account, error)
+}
+
+// Memberships are the roles and groups of an account, sorted by name
+type Memberships struct {
+	Roles  []string
+	Groups []string
+}
+
+type accountMembership struct {
+	gorm.Model
+	AccountID uint           `gorm:"not null"`
+	Kind      MembershipKind `gorm:"type:varchar(16);not null"`
+	Name      string         `gorm:"type:varchar(64);not null"`
+}
+
+var validMembershipName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:-]{0,63}$`)
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func validateMembership(kind MembershipKind, name string) error {
+	if kind != MembershipRole && kind != MembershipGroup {
+		return MembershipInvalid.Newf("unknown membership kind '%s'", kind)
+	}
+	if !validMembershipName.MatchString(name) {
+		return MembershipInvalid.Newf("invalid %s name '%s'", kind, name)
+	}
+	return nil
+}
+
+func (s *sadb) AddMembership(account *Account, kind MembershipKind, name string) error {
+	if account == nil {
+		return InvalidAccount.New()
+	}
+	if err := validateMembership(kind, name); err != nil {
+		return err
+	}
+
+	var count int
+	err := s.db.Model(&accountMembership{}).
+		Where("account_id = ? AND kind = ? AND name = ?", account.ID, kind, name).
+		Count(&count).Error
+	if err != nil {
+		return InternalError.Wrap(err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	membership := &accountMembership{
+		AccountID: account.ID,
+		Kind:      kind,
+		Name:      name,
+	}
+	if err := s.db.Create(membership).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Added %s %s", kind, name)
+
+	return nil
+}
+
+func (s *sadb) RemoveMembership(account *Account, kind MembershipKind, name string) error {
+	if account == nil {
+		return InvalidAccount.New()
+	}
+	if err := validateMembership(kind, name); err != nil {
+		return err
+	}
+
+	// Hard-deleted, so the membership can be added again without conflicting with the unique index
+	res := s.db.Unscoped().Where("account_id = ? AND kind = ? AND name = ?", account.ID, kind, name).Delete(&accountMembership{})
+	if res.Error != nil {
+		return InternalError.Wrap(res.Error)
+	}
+	if res.RowsAffected > 0 {
+		s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Removed %s %s", kind, name)
+	}
+
+	return nil
+}
+
+func (s *sadb) GetMemberships(account *Account) (*Memberships, error) {
+	if account == nil {
+		return nil, InvalidAccount.New()
+	}
+
+	var memberships []*accountMembership
+	if err := s.db.Where("account_id = ?", account.ID).Order("name").Find(&memberships).Error; err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+
+	ret := &Memberships{}
+	for _, m := range memberships {
+		switch m.Kind {
+		case MembershipRole:
+			ret.Roles = append(ret.Roles, m.Name)
+		case MembershipGroup:
+			ret.Groups = append(ret.Groups, m.Name)
+		}
+	}
+	sort.Strings(ret.Roles)
+	sort.Strings(ret.Groups)
+	return ret, nil
+}
+
+func (s *sadb) FindMembers(kind MembershipKind, name string) ([]*Account, error) {
+	if err := validateMembership(kind, name); err != nil {
+		return nil, err
+	}
+
+	var accounts []*Account
+	err := s.db.
+		Where("id IN (?)", s.db.Model(&accountMembership{}).Select("account_id").Where("kind = ? AND name = ?", kind, name).QueryExpr()).
+		Order("email").
+		Find(&accounts).Error
+	if err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	return accounts, nil
+}
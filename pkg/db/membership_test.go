@@ -0,0 +1,70 @@
+package db_test
+
+import (
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/saerrors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountMemberships(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "memberships@asdf.com")
+
+	memberships, err := sadb.GetMemberships(account)
+	assert.NoError(t, err)
+	assert.Empty(t, memberships.Roles)
+	assert.Empty(t, memberships.Groups)
+
+	assert.NoError(t, sadb.AddMembership(account, db.MembershipRole, "editor"))
+	assert.NoError(t, sadb.AddMembership(account, db.MembershipRole, "admin"))
+	assert.NoError(t, sadb.AddMembership(account, db.MembershipGroup, "staff"))
+	// Idempotent
+	assert.NoError(t, sadb.AddMembership(account, db.MembershipRole, "editor"))
+
+	memberships, _ = sadb.GetMemberships(account)
+	assert.Equal(t, []string{"admin", "editor"}, memberships.Roles)
+	assert.Equal(t, []string{"staff"}, memberships.Groups)
+
+	// Remove, then add again
+	assert.NoError(t, sadb.RemoveMembership(account, db.MembershipRole, "editor"))
+	assert.NoError(t, sadb.RemoveMembership(account, db.MembershipRole, "editor"))
+	memberships, _ = sadb.GetMemberships(account)
+	assert.Equal(t, []string{"admin"}, memberships.Roles)
+	assert.NoError(t, sadb.AddMembership(account, db.MembershipRole, "editor"))
+	memberships, _ = sadb.GetMemberships(account)
+	assert.Equal(t, []string{"admin", "editor"}, memberships.Roles)
+
+	// Deleting the account removes its memberships
+	assert.NoError(t, sadb.DeleteAccount(account))
+	memberships, _ = sadb.GetMemberships(account)
+	assert.Empty(t, memberships.Roles)
+	assert.Empty(t, memberships.Groups)
+}
+
+func TestAccountMembershipInvalid(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "memberships-invalid@asdf.com")
+
+	err := sadb.AddMembership(account, db.MembershipGroup, "a,b")
+	assert.Equal(t, db.MembershipInvalid, saerrors.UnwrapCode(err))
+	err = sadb.AddMembership(account, db.MembershipGroup, "")
+	assert.Equal(t, db.MembershipInvalid, saerrors.UnwrapCode(err))
+	err = sadb.AddMembership(account, db.MembershipKind("team"), "ops")
+	assert.Equal(t, db.MembershipInvalid, saerrors.UnwrapCode(err))
+}
+
+func TestFindMembers(t *testing.T) {
+	a, _ := sadb.CreateAccount("test", "members-a@asdf.com")
+	b, _ := sadb.CreateAccount("test", "members-b@asdf.com")
+	c, _ := sadb.CreateAccount("test", "members-c@asdf.com")
+	sadb.AddMembership(a, db.MembershipGroup, "find-members")
+	sadb.AddMembership(b, db.MembershipGroup, "find-members")
+	sadb.AddMembership(c, db.MembershipRole, "find-members")
+
+	accounts, err := sadb.FindMembers(db.MembershipGroup, "find-members")
+	assert.NoError(t, err)
+	if assert.Len(t, accounts, 2) {
+		assert.Equal(t, a.UUID, accounts[0].UUID)
+		assert.Equal(t, b.UUID, accounts[1].UUID)
+	}
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"fmt"
+	"simple-auth/pkg/saerrors"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+type SchemaMigrator interface {
+	MigrationStatus() ([]MigrationState, error)
+	// MigrateUp applies pending migrations up to, and including, target. A target of 0 applies all
+	MigrateUp(target int) ([]MigrationState, error)
+	// MigrateDown reverts applied migrations until the schema is at target version
+	MigrateDown(target int) ([]MigrationState, error)
+}
+
+type MigrationState struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+type migrationFunc func(tx *gorm.DB, opts *options) error
+
+// migration is a single, numbered schema change. Migrations should only ever
+// reference their own snapshot of a model, never the live models, so that
+// they behave the same regardless of when they are run
+type migration struct {
+	Version int
+	Name    string
+	Up      migrationFunc
+	Down    migrationFunc // nil if irreversible
+}
+
+// schemaMigration records an applied migration
+type schemaMigration struct {
+	Version   int    `gorm:"primary_key;auto_increment:false"`
+	Name      string `gorm:"type:varchar(256);not null"`
+	AppliedAt time.Time
+}
+
+const (
+	MigrationIrreversible saerrors.ErrorCode = "migration-irreversible"
+	MigrationFailed       saerrors.ErrorCode = "migration-failed"
+	MigrationPending      saerrors.ErrorCode = "migration-pending"
+)
+
+// LatestSchemaVersion is the version of the schema this build expects
+func LatestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// CheckSchema returns an error if there are any migrations that have yet to be applied
+func CheckSchema(migrator SchemaMigrator) error {
+	status, err := migrator.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, m := range status {
+		if !m.Applied {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return MigrationPending.Newf("Database schema has %d pending migration(s), run `simple-auth-cli migrate up`", pending)
+	}
+	return nil
+}
+
+func init() {
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			panic(fmt.Sprintf("duplicate schema migration version %d", migrations[i].Version))
+		}
+	}
+}
+
+func (s *sadb) appliedMigrations() (map[int]*schemaMigration, error) {
+	if err := s.db.AutoMigrate(&schemaMigration{}).Error; err != nil {
+		return nil, err
+	}
+
+	var applied []*schemaMigration
+	if err := s.db.Find(&applied).Error; err != nil {
+		return nil, err
+	}
+
+	ret := make(map[int]*schemaMigration, len(applied))
+	for _, m := range applied {
+		ret[m.Version] = m
+	}
+	return ret, nil
+}
+
+func (s *sadb) MigrationStatus() ([]MigrationState, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, MigrationFailed.Wrap(err)
+	}
+
+	ret := make([]MigrationState, len(migrations))
+	for i, m := range migrations {
+		ret[i] = MigrationState{
+			Version: m.Version,
+			Name:    m.Name,
+		}
+		if record, ok := applied[m.Version]; ok {
+			ret[i].Applied = true
+			ret[i].AppliedAt = &record.AppliedAt
+		}
+	}
+	return ret, nil
+}
+
+func (s *sadb) MigrateUp(target int) ([]MigrationState, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, MigrationFailed.Wrap(err)
+	}
+
+	var ret []MigrationState
+	for _, m := range migrations {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		logrus.Infof("Applying schema migration %d: %s...", m.Version, m.Name)
+		err := s.runMigration(m.Up, func(tx *gorm.DB, opts *options) error {
+			return tx.Create(&schemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return ret, MigrationFailed.Wrapf(err, "Migration %d (%s) failed", m.Version, m.Name)
+		}
+
+		ret = append(ret, MigrationState{Version: m.Version, Name: m.Name, Applied: true})
+	}
+
+	return ret, nil
+}
+
+func (s *sadb) MigrateDown(target int) ([]MigrationState, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, MigrationFailed.Wrap(err)
+	}
+
+	var ret []MigrationState
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= target {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return ret, MigrationIrreversible.Newf("Migration %d (%s) can not be reverted", m.Version, m.Name)
+		}
+
+		logrus.Infof("Reverting schema migration %d: %s...", m.Version, m.Name)
+		err := s.runMigration(m.Down, func(tx *gorm.DB, opts *options) error {
+			return tx.Delete(&schemaMigration{Version: m.Version}).Error
+		})
+		if err != nil {
+			return ret, MigrationFailed.Wrapf(err, "Revert of migration %d (%s) failed", m.Version, m.Name)
+		}
+
+		ret = append(ret, MigrationState{Version: m.Version, Name: m.Name, Applied: false})
+	}
+
+	return ret, nil
+}
+
+// runMigration runs a migration step and its bookkeeping in a single transaction.
+// NOTE: mysql implicitly commits on DDL, so a failure there may leave a partial migration
+func (s *sadb) runMigration(step migrationFunc, record migrationFunc) error {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if err := step(tx, s.opts); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := record(tx, s.opts); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
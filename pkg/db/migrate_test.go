@@ -0,0 +1,66 @@
+package db_test
+
+import (
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/saerrors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationStatus(t *testing.T) {
+	status, err := sadb.MigrationStatus()
+	assert.NoError(t, err)
+	assert.Len(t, status, db.LatestSchemaVersion())
+	for _, m := range status {
+		assert.True(t, m.Applied)
+		assert.NotNil(t, m.AppliedAt)
+	}
+	assert.NoError(t, db.CheckSchema(sadb))
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	mdb := db.Open("sqlite3", "file:migrate-test?mode=memory&cache=shared")
+	assert.Error(t, db.CheckSchema(mdb))
+
+	applied, err := mdb.MigrateUp(1)
+	assert.NoError(t, err)
+	assert.Len(t, applied, 1)
+
+	again, err := mdb.MigrateUp(1)
+	assert.NoError(t, err)
+	assert.Empty(t, again)
+
+	_, err = mdb.CreateAccount("test", "migrate@example.com")
+	assert.NoError(t, err)
+
+	reverted, err := mdb.MigrateDown(0)
+	assert.NoError(t, err)
+	assert.Len(t, reverted, 1)
+
+	_, err = mdb.MigrateUp(1)
+	assert.NoError(t, err)
+	account, err := mdb.FindAccountByEmail("migrate@example.com")
+	assert.Error(t, err)
+	assert.Nil(t, account)
+
+	applied, err = mdb.MigrateUp(0)
+	assert.NoError(t, err)
+	assert.Len(t, applied, db.LatestSchemaVersion()-1)
+	assert.NoError(t, db.CheckSchema(mdb))
+}
+
+func TestMigrateDownIrreversible(t *testing.T) {
+	mdb := db.New("sqlite3", "file:migrate-irreversible-test?mode=memory&cache=shared")
+
+	// Reverts down to the first irreversible migration, and stops
+	reverted, err := mdb.MigrateDown(0)
+	assert.Equal(t, db.MigrationIrreversible, saerrors.UnwrapCode(err))
+	for _, m := range reverted {
+		assert.Greater(t, m.Version, 2)
+	}
+
+	_, err = mdb.MigrateUp(0)
+	assert.NoError(t, err)
+	assert.NoError(t, db.CheckSchema(mdb))
+}
@@ -0,0 +1,648 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// migrations is the ordered list of every schema change. Append new migrations
+// to the end with the next version number; never edit one that has shipped
+var migrations = []*migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up:      migrateBaselineUp,
+		Down:    migrateBaselineDown,
+	},
+	{
+		Version: 2,
+		Name:    "hash-tokens",
+		Up:      migrateHashTokensUp,
+		Down:    nil, // Plaintext tokens can't be recovered
+	},
+	{
+		Version: 3,
+		Name:    "audit-indexes",
+		Up:      migrateAuditIndexesUp,
+		Down:    migrateAuditIndexesDown,
+	},
+	{
+		Version: 4,
+		Name:    "audit-request-context",
+		Up:      migrateAuditRequestContextUp,
+		Down:    migrateAuditRequestContextDown,
+	},
+	{
+		Version: 5,
+		Name:    "audit-hash-chain",
+		Up:      migrateAuditHashChainUp,
+		Down:    migrateAuditHashChainDown,
+	},
+	{
+		Version: 6,
+		Name:    "account-attributes",
+		Up:      migrateAccountAttributesUp,
+		Down:    migrateAccountAttributesDown,
+	},
+	{
+		Version: 7,
+		Name:    "account-memberships",
+		Up:      migrateAccountMembershipsUp,
+		Down:    migrateAccountMembershipsDown,
+	},
+	{
+		Version: 8,
+		Name:    "organizations",
+		Up:      migrateOrganizationsUp,
+		Down:    migrateOrganizationsDown,
+	},
+	{
+		Version: 9,
+		Name:    "terms-acceptances",
+		Up:      migrateTermsAcceptancesUp,
+		Down:    migrateTermsAcceptancesDown,
+	},
+	{
+		Version: 10,
+		Name:    "auth-local-lockout",
+		Up:      migrateAuthLocalLockoutUp,
+		Down:    migrateAuthLocalLockoutDown,
+	},
+	{
+		Version: 11,
+		Name:    "password-history",
+		Up:      migratePasswordHistoryUp,
+		Down:    migratePasswordHistoryDown,
+	},
+	{
+		Version: 12,
+		Name:    "password-expiry",
+		Up:      migratePasswordExpiryUp,
+		Down:    migratePasswordExpiryDown,
+	},
+	{
+		Version: 13,
+		Name:    "recovery-codes",
+		Up:      migrateRecoveryCodesUp,
+		Down:    migrateRecoveryCodesDown,
+	},
+	{
+		Version: 14,
+		Name:    "totp-last-step",
+		Up:      migrateTOTPLastStepUp,
+		Down:    migrateTOTPLastStepDown,
+	},
+}
+
+// Version 1: Baseline
+// Matches the schema that was previously created by AutoMigrate, so that
+// existing databases adopt it without any change
+
+type v1Account struct {
+	gorm.Model
+	UUID   string `gorm:"type:varchar(64);unique_index;not null"`
+	Name   string `gorm:"type:varchar(256);not null"`
+	Email  string `gorm:"type:varchar(256);unique_index;not null"`
+	Active bool   `gorm:"not null"`
+}
+
+func (v1Account) TableName() string { return "accounts" }
+
+type v1AccountAuditRecord struct {
+	gorm.Model
+	AccountID uint
+	Module    AuditModule
+	Level     AuditLevel
+	Message   string
+}
+
+func (v1AccountAuditRecord) TableName() string { return "account_audit_records" }
+
+type v1AccountAuthLocal struct {
+	gorm.Model
+	AccountID      uint   `gorm:"index;not null"`
+	Username       string `gorm:"type:varchar(256);unique_index;not null"`
+	PasswordBcrypt string `gorm:"not null"`
+	TOTPSpec       *string
+}
+
+func (v1AccountAuthLocal) TableName() string { return "account_auth_locals" }
+
+type v1AccountAuthOneTime struct {
+	gorm.Model
+	AccountID uint   `gorm:"index;not null"`
+	Token     string `gorm:"index;not null"`
+	Expires   time.Time
+}
+
+func (v1AccountAuthOneTime) TableName() string { return "account_auth_one_times" }
+
+type v1AccountStipulation struct {
+	gorm.Model
+	AccountID     uint `gorm:"index;not null"`
+	Type          StipulationType
+	Specification string
+}
+
+func (v1AccountStipulation) TableName() string { return "account_stipulations" }
+
+type v1AccountOAuthToken struct {
+	gorm.Model
+	AccountID uint `gorm:"index; not null"`
+	ClientID  string
+	Type      OAuthTokenType
+	Token     string `gorm:"not null"`
+	Scope     string
+	Expires   time.Time
+}
+
+func (v1AccountOAuthToken) TableName() string { return "account_o_auth_tokens" }
+
+type v1AccountOIDC struct {
+	gorm.Model
+	AccountID uint   `gorm:"index;not null"`
+	Provider  string `gorm:"not null"`
+	Subject   string `gorm:"not null"`
+}
+
+func (v1AccountOIDC) TableName() string { return "account_o_id_cs" }
+
+var v1Tables = []interface{}{
+	&v1Account{},
+	&v1AccountAuditRecord{},
+	&v1AccountAuthLocal{},
+	&v1AccountAuthOneTime{},
+	&v1AccountStipulation{},
+	&v1AccountOAuthToken{},
+	&v1AccountOIDC{},
+}
+
+func migrateBaselineUp(tx *gorm.DB, opts *options) error {
+	if err := tx.AutoMigrate(v1Tables...).Error; err != nil {
+		return err
+	}
+	if !tx.Dialect().HasIndex("account_o_id_cs", "idx_provider_subject") {
+		return tx.Model(&v1AccountOIDC{}).AddUniqueIndex("idx_provider_subject", "provider", "subject").Error
+	}
+	return nil
+}
+
+func migrateBaselineDown(tx *gorm.DB, opts *options) error {
+	return tx.DropTableIfExists(v1Tables...).Error
+}
+
+// Version 2: Hash tokens
+// OAuth2 and one-time tokens are stored as a keyed hash, rather than plaintext
+
+type v2AccountOAuthToken struct {
+	gorm.Model
+	Token       string
+	TokenPrefix string
+}
+
+func (v2AccountOAuthToken) TableName() string { return "account_o_auth_tokens" }
+
+type v2AccountAuthOneTime struct {
+	gorm.Model
+	Token string
+}
+
+func (v2AccountAuthOneTime) TableName() string { return "account_auth_one_times" }
+
+func migrateHashTokensUp(tx *gorm.DB, opts *options) error {
+	if err := tx.AutoMigrate(&v2AccountOAuthToken{}).Error; err != nil {
+		return err
+	}
+
+	var oauthTokens []*v2AccountOAuthToken
+	if err := tx.Unscoped().Find(&oauthTokens).Error; err != nil {
+		return err
+	}
+	for _, t := range oauthTokens {
+		err := tx.Unscoped().Model(t).UpdateColumns(map[string]interface{}{
+			"token":        hashToken(opts, t.Token),
+			"token_prefix": tokenPrefix(t.Token),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	var oneTimeTokens []*v2AccountAuthOneTime
+	if err := tx.Unscoped().Find(&oneTimeTokens).Error; err != nil {
+		return err
+	}
+	for _, t := range oneTimeTokens {
+		if err := tx.Unscoped().Model(t).UpdateColumn("token", hashToken(opts, t.Token)).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Version 3: Audit indexes
+// Audit records are searched by account, time, and level
+
+var v3AuditIndexes = map[string][]string{
+	"idx_account_audit_records_account_id": {"account_id"},
+	"idx_account_audit_records_created_at": {"created_at"},
+	"idx_account_audit_records_level":      {"level"},
+}
+
+func migrateAuditIndexesUp(tx *gorm.DB, opts *options) error {
+	for name, columns := range v3AuditIndexes {
+		if tx.Dialect().HasIndex("account_audit_records", name) {
+			continue
+		}
+		if err := tx.Model(&v1AccountAuditRecord{}).AddIndex(name, columns...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateAuditIndexesDown(tx *gorm.DB, opts *options) error {
+	for name := range v3AuditIndexes {
+		if err := tx.Model(&v1AccountAuditRecord{}).RemoveIndex(name).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Version 4: Audit request context
+// Records the client IP, user agent, and correlation ID of the request that created an audit record
+
+type v4AccountAuditRecord struct {
+	gorm.Model
+	IPAddress     string `gorm:"type:varchar(64)"`
+	UserAgent     string `gorm:"type:varchar(512)"`
+	CorrelationID string `gorm:"type:varchar(64)"`
+}
+
+func (v4AccountAuditRecord) TableName() string { return "account_audit_records" }
+
+func migrateAuditRequestContextUp(tx *gorm.DB, opts *options) error {
+	return tx.AutoMigrate(&v4AccountAuditRecord{}).Error
+}
+
+func migrateAuditRequestContextDown(tx *gorm.DB, opts *options) error {
+	if tx.Dialect().GetName() == "sqlite3" {
+		// sqlite can't drop columns; the unused columns are left behind, and adopted again on Up
+		return nil
+	}
+	for _, column := range []string{"ip_address", "user_agent", "correlation_id"} {
+		if err := tx.Model(&v4AccountAuditRecord{}).DropColumn(column).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Version 5: Audit hash chain
+// Each audit record is linked to the previous by a hash, so that edits and deletions can be detected.
+// Existing records are sealed into the chain on the first seal after the migration
+
+type v5AccountAuditRecord struct {
+	gorm.Model
+	ChainIndex *uint64
+	Hash       string `gorm:"type:varchar(64)"`
+	PrevHash   string `gorm:"type:varchar(64)"`
+}
+
+func (v5AccountAuditRecord) TableName() string { return "account_audit_records" }
+
+const v5ChainIndexName = "idx_account_audit_records_chain_index"
+
+func migrateAuditHashChainUp(tx *gorm.DB, opts *options) error {
+	if err := tx.AutoMigrate(&v5AccountAuditRecord{}).Error; err != nil {
+		return err
+	}
+	if !tx.Dialect().HasIndex("account_audit_records", v5ChainIndexName) {
+		return tx.Model(&v5AccountAuditRecord{}).AddUniqueIndex(v5ChainIndexName, "chain_index").Error
+	}
+	return nil
+}
+
+func migrateAuditHashChainDown(tx *gorm.DB, opts *options) error {
+	if err := tx.Model(&v5AccountAuditRecord{}).RemoveIndex(v5ChainIndexName).Error; err != nil {
+		return err
+	}
+	if tx.Dialect().GetName() == "sqlite3" {
+		// sqlite can't drop columns; clear the chain so it's rebuilt on Up
+		return tx.Model(&v5AccountAuditRecord{}).Unscoped().UpdateColumns(map[string]interface{}{
+			"chain_index": nil,
+			"hash":        "",
+			"prev_hash":   "",
+		}).Error
+	}
+	for _, column := range []string{"chain_index", "hash", "prev_hash"} {
+		if err := tx.Model(&v5AccountAuditRecord{}).DropColumn(column).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Version 6: Account attributes
+// Custom profile attributes, as declared in config, stored per account
+
+type v6AccountAttribute struct {
+	gorm.Model
+	AccountID uint   `gorm:"not null"`
+	Name      string `gorm:"type:varchar(64);not null"`
+	Value     string `gorm:"type:varchar(1024);not null"`
+}
+
+func (v6AccountAttribute) TableName() string { return "account_attributes" }
+
+const v6AccountNameIndexName = "idx_account_attributes_account_id_name"
+
+func migrateAccountAttributesUp(tx *gorm.DB, opts *options) error {
+	if err := tx.AutoMigrate(&v6AccountAttribute{}).Error; err != nil {
+		return err
+	}
+	if !tx.Dialect().HasIndex("account_attributes", v6AccountNameIndexName) {
+		return tx.Model(&v6AccountAttribute{}).AddUniqueIndex(v6AccountNameIndexName, "account_id", "name").Error
+	}
+	return nil
+}
+
+func migrateAccountAttributesDown(tx *gorm.DB, opts *options) error {
+	return tx.DropTableIfExists(&v6AccountAttribute{}).Error
+}
+
+// Version 7: Account memberships
+// Roles and groups an account belongs to
+
+type v7AccountMembership struct {
+	gorm.Model
+	AccountID uint   `gorm:"not null"`
+	Kind      string `gorm:"type:varchar(16);not null"`
+	Name      string `gorm:"type:varchar(64);not null"`
+}
+
+func (v7AccountMembership) TableName() string { return "account_memberships" }
+
+var v7MembershipIndexes = map[string][]string{
+	"idx_account_memberships_account_id_kind_name": {"account_id", "kind", "name"},
+	"idx_account_memberships_kind_name":            {"kind", "name"},
+}
+
+func migrateAccountMembershipsUp(tx *gorm.DB, opts *options) error {
+	if err := tx.AutoMigrate(&v7AccountMembership{}).Error; err != nil {
+		return err
+	}
+	if name := "idx_account_memberships_account_id_kind_name"; !tx.Dialect().HasIndex("account_memberships", name) {
+		if err := tx.Model(&v7AccountMembership{}).AddUniqueIndex(name, v7MembershipIndexes[name]...).Error; err != nil {
+			return err
+		}
+	}
+	if name := "idx_account_memberships_kind_name"; !tx.Dialect().HasIndex("account_memberships", name) {
+		if err := tx.Model(&v7AccountMembership{}).AddIndex(name, v7MembershipIndexes[name]...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateAccountMembershipsDown(tx *gorm.DB, opts *options) error {
+	return tx.DropTableIfExists(&v7AccountMembership{}).Error
+}
+
+// Version 8: Organizations
+// Accounts can belong to an organization, within which their email and username are unique
+
+type v8Organization struct {
+	gorm.Model
+	UUID             string `gorm:"type:varchar(64);unique_index;not null"`
+	Slug             string `gorm:"type:varchar(64);unique_index;not null"`
+	Name             string `gorm:"type:varchar(256);not null"`
+	AllowSignup      bool   `gorm:"not null"`
+	AllowedClients   string `gorm:"type:varchar(1024);not null"`
+	AllowedProviders string `gorm:"type:varchar(1024);not null"`
+}
+
+func (v8Organization) TableName() string { return "organizations" }
+
+type v8Account struct {
+	gorm.Model
+	OrganizationID    uint `gorm:"not null;default:0"`
+	OrganizationAdmin bool `gorm:"not null;default:false"`
+}
+
+func (v8Account) TableName() string { return "accounts" }
+
+type v8AccountAuthLocal struct {
+	gorm.Model
+	OrganizationID uint `gorm:"not null;default:0"`
+}
+
+func (v8AccountAuthLocal) TableName() string { return "account_auth_locals" }
+
+// v8UniqueIndexes replace the global unique indexes on email and username
+var v8UniqueIndexes = []struct {
+	model  interface{}
+	table  string
+	global string
+	scoped string
+	column string
+}{
+	{&v8Account{}, "accounts", "uix_accounts_email", "idx_accounts_organization_id_email", "email"},
+	{&v8AccountAuthLocal{}, "account_auth_locals", "uix_account_auth_locals_username", "idx_account_auth_locals_organization_id_username", "username"},
+}
+
+func migrateOrganizationsUp(tx *gorm.DB, opts *options) error {
+	if err := tx.AutoMigrate(&v8Organization{}, &v8Account{}, &v8AccountAuthLocal{}).Error; err != nil {
+		return err
+	}
+
+	for _, idx := range v8UniqueIndexes {
+		if tx.Dialect().HasIndex(idx.table, idx.global) {
+			if err := tx.Model(idx.model).RemoveIndex(idx.global).Error; err != nil {
+				return err
+			}
+		}
+		if !tx.Dialect().HasIndex(idx.table, idx.scoped) {
+			if err := tx.Model(idx.model).AddUniqueIndex(idx.scoped, "organization_id", idx.column).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func migrateOrganizationsDown(tx *gorm.DB, opts *options) error {
+	for _, idx := range v8UniqueIndexes {
+		if tx.Dialect().HasIndex(idx.table, idx.scoped) {
+			if err := tx.Model(idx.model).RemoveIndex(idx.scoped).Error; err != nil {
+				return err
+			}
+		}
+		// Fails if the same email or username is now used in more than one organization
+		if err := tx.Model(idx.model).AddUniqueIndex(idx.global, idx.column).Error; err != nil {
+			return err
+		}
+	}
+
+	if tx.Dialect().GetName() != "sqlite3" {
+		// sqlite can't drop columns; the unused columns are left behind, and adopted again on Up
+		for _, column := range []string{"organization_id", "organization_admin"} {
+			if err := tx.Model(&v8Account{}).DropColumn(column).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&v8AccountAuthLocal{}).DropColumn("organization_id").Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.DropTableIfExists(&v8Organization{}).Error
+}
+
+// Version 9: Terms acceptances
+// Which version of the terms of service an account accepted, and when
+
+type v9AccountTermsAcceptance struct {
+	gorm.Model
+	AccountID uint   `gorm:"index;not null"`
+	Version   string `gorm:"type:varchar(64);not null"`
+}
+
+func (v9AccountTermsAcceptance) TableName() string { return "account_terms_acceptances" }
+
+func migrateTermsAcceptancesUp(tx *gorm.DB, opts *options) error {
+	return tx.AutoMigrate(&v9AccountTermsAcceptance{}).Error
+}
+
+func migrateTermsAcceptancesDown(tx *gorm.DB, opts *options) error {
+	return tx.DropTableIfExists(&v9AccountTermsAcceptance{}).Error
+}
+
+// Version 10: Local login lockout
+// Counts failed logins per local login, which can lock it for a while, or until unlocked
+
+type v10AccountAuthLocal struct {
+	gorm.Model
+	FailedLogins int `gorm:"not null;default:0"`
+	LockedUntil  *time.Time
+	Locked       bool `gorm:"not null;default:false"`
+}
+
+func (v10AccountAuthLocal) TableName() string { return "account_auth_locals" }
+
+func migrateAuthLocalLockoutUp(tx *gorm.DB, opts *options) error {
+	return tx.AutoMigrate(&v10AccountAuthLocal{}).Error
+}
+
+func migrateAuthLocalLockoutDown(tx *gorm.DB, opts *options) error {
+	if tx.Dialect().GetName() == "sqlite3" {
+		// sqlite can't drop columns; the unused columns are left behind, and adopted again on Up
+		return nil
+	}
+	for _, column := range []string{"failed_logins", "locked_until", "locked"} {
+		if err := tx.Model(&v10AccountAuthLocal{}).DropColumn(column).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Version 11: Password history
+// Previous password hashes of local logins, so they can't be reused
+
+type v11AccountPasswordHistory struct {
+	ID           uint `gorm:"primary_key"`
+	CreatedAt    time.Time
+	AccountID    uint   `gorm:"index;not null"`
+	PasswordHash string `gorm:"not null"`
+}
+
+func (v11AccountPasswordHistory) TableName() string { return "account_password_histories" }
+
+func migratePasswordHistoryUp(tx *gorm.DB, opts *options) error {
+	return tx.AutoMigrate(&v11AccountPasswordHistory{}).Error
+}
+
+func migratePasswordHistoryDown(tx *gorm.DB, opts *options) error {
+	return tx.DropTableIfExists(&v11AccountPasswordHistory{}).Error
+}
+
+// Version 12: Password expiry
+// When each local login's password was last set, so that it can expire
+
+type v12AccountAuthLocal struct {
+	gorm.Model
+	PasswordChanged        *time.Time
+	PasswordNeverExpires   bool `gorm:"not null;default:false"`
+	PasswordExpiryReminded bool `gorm:"not null;default:false"`
+}
+
+func (v12AccountAuthLocal) TableName() string { return "account_auth_locals" }
+
+func migratePasswordExpiryUp(tx *gorm.DB, opts *options) error {
+	if err := tx.AutoMigrate(&v12AccountAuthLocal{}).Error; err != nil {
+		return err
+	}
+	// When existing passwords were set isn't known, so their age starts now, rather than expiring them all at once
+	return tx.Model(&v12AccountAuthLocal{}).
+		Where("password_changed IS NULL").
+		UpdateColumn("password_changed", time.Now()).Error
+}
+
+func migratePasswordExpiryDown(tx *gorm.DB, opts *options) error {
+	if tx.Dialect().GetName() == "sqlite3" {
+		// sqlite can't drop columns; the unused columns are left behind, and adopted again on Up
+		return nil
+	}
+	for _, column := range []string{"password_changed", "password_never_expires", "password_expiry_reminded"} {
+		if err := tx.Model(&v12AccountAuthLocal{}).DropColumn(column).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Version 13: Recovery codes
+// Single-use codes that stand in for a TOTP code, if the device is lost
+
+type v13AccountRecoveryCode struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	AccountID uint   `gorm:"index;not null"`
+	Code      string `gorm:"not null"`
+}
+
+func (v13AccountRecoveryCode) TableName() string { return "account_recovery_codes" }
+
+func migrateRecoveryCodesUp(tx *gorm.DB, opts *options) error {
+	return tx.AutoMigrate(&v13AccountRecoveryCode{}).Error
+}
+
+func migrateRecoveryCodesDown(tx *gorm.DB, opts *options) error {
+	return tx.DropTableIfExists(&v13AccountRecoveryCode{}).Error
+}
+
+// Version 14: TOTP replay
+// The time step of the last accepted TOTP code of each local login, so that it can't be used again
+
+type v14AccountAuthLocal struct {
+	gorm.Model
+	TOTPLastStep int64 `gorm:"not null;default:0"`
+}
+
+func (v14AccountAuthLocal) TableName() string { return "account_auth_locals" }
+
+func migrateTOTPLastStepUp(tx *gorm.DB, opts *options) error {
+	return tx.AutoMigrate(&v14AccountAuthLocal{}).Error
+}
+
+func migrateTOTPLastStepDown(tx *gorm.DB, opts *options) error {
+	if tx.Dialect().GetName() == "sqlite3" {
+		// sqlite can't drop columns; the unused column is left behind, and adopted again on Up
+		return nil
+	}
+	return tx.Model(&v14AccountAuthLocal{}).DropColumn("totp_last_step").Error
+}
@@ -7,12 +7,14 @@ import (
 )
 
 type AccountOIDC interface {
+	// FindAccountForOIDC finds the account linked to the provider's subject. A deactivated account
+	// can't login, and is returned as InactiveAccount
 	FindAccountForOIDC(provider, subject string) (*Account, error)
 	CreateOIDCForAccount(account *Account, provider, subject string) error
 	FindOIDCForAccount(account *Account) ([]OIDCDescriptor, error)
 }
 
-// NOTE: extra index created in db.go
+// NOTE: extra index created in migrations.go
 type accountOIDC struct {
 	gorm.Model
 	AccountID uint   `gorm:"index;not null"`
@@ -31,6 +33,11 @@ func (s *sadb) FindAccountForOIDC(provider, subject string) (*Account, error) {
 		return nil, err
 	}
 
+	if !account.Active {
+		s.CreateAuditRecord(&account, AuditModuleOIDC, AuditLevelWarn, "OIDC login for %s rejected, account is deactivated", provider)
+		return nil, InactiveAccount.New()
+	}
+
 	s.CreateAuditRecord(&account, AuditModuleOIDC, AuditLevelInfo, "OIDC lookup succeeded for %s", provider)
 
 	return &account, nil
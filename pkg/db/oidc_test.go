@@ -2,6 +2,7 @@ package db_test
 
 import (
 	"simple-auth/pkg/db"
+	"simple-auth/pkg/saerrors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -51,3 +52,18 @@ func TestTwoProviderLookup(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, providers, 2)
 }
+
+func TestDeactivatedOIDCAccount(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "deactivated-"+oidcEmail)
+	assert.NoError(t, sadb.CreateOIDCForAccount(account, "test", "deactivated"))
+	assert.NoError(t, sadb.DeactivateAccount(account))
+
+	find, err := sadb.FindAccountForOIDC("test", "deactivated")
+	assert.Nil(t, find)
+	assert.Equal(t, db.InactiveAccount, saerrors.UnwrapCode(err))
+
+	assert.NoError(t, sadb.ReactivateAccount(account))
+	find, err = sadb.FindAccountForOIDC("test", "deactivated")
+	assert.NoError(t, err)
+	assert.Equal(t, account.UUID, find.UUID)
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"simple-auth/pkg/lib/auditsink"
+	"simple-auth/pkg/lib/passhash"
+)
+
+type options struct {
+	tokenSecret    []byte
+	passwordHasher passhash.Hasher
+	auditIPPolicy  AuditIPPolicy
+	auditSink      auditsink.Sink
+}
+
+type Option func(*options)
+
+// WithTokenSecret sets the server-side secret (pepper) that tokens are hashed with
+// before being stored. Changing it invalidates all outstanding tokens
+func WithTokenSecret(secret string) Option {
+	return func(o *options) {
+		o.tokenSecret = []byte(secret)
+	}
+}
+
+// WithPasswordHasher sets the policy new passwords are hashed with. Defaults to bcrypt
+func WithPasswordHasher(hasher passhash.Hasher) Option {
+	return func(o *options) {
+		o.passwordHasher = hasher
+	}
+}
+
+// WithAuditIPPolicy sets how client IPs are stored on audit records. Defaults to AuditIPFull
+func WithAuditIPPolicy(policy AuditIPPolicy) Option {
+	return func(o *options) {
+		o.auditIPPolicy = policy
+	}
+}
+
+// WithAuditSink sends a copy of every audit record to the sink, once it's written to the database
+func WithAuditSink(sink auditsink.Sink) Option {
+	return func(o *options) {
+		o.auditSink = sink
+	}
+}
+
+func newOptions(opts ...Option) *options {
+	ret := &options{
+		passwordHasher: passhash.NewBcrypt(0),
+		auditIPPolicy:  AuditIPFull,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	return ret
+}
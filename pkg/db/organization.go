@@ -0,0 +1,259 @@
+package db
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+type AccountOrganizations interface {
+	CreateOrganization(slug, name string) (*Organization, error)
+	// FindOrganization finds an organization by its UUID or slug
+	FindOrganization(uuidOrSlug string) (*Organization, error)
+	GetOrganizations() ([]*Organization, error)
+	// UpdateOrganization saves the organization's name, signup, and allowed clients and providers
+	UpdateOrganization(org *Organization) error
+	// DeleteOrganization deletes an organization that has no accounts
+	DeleteOrganization(org *Organization) error
+
+	// GetAccountOrganization returns the account's organization, or nil if it isn't in one
+	GetAccountOrganization(account *Account) (*Organization, error)
+	// SetAccountOrganization moves an account to the organization, or out of any if nil
+	SetAccountOrganization(account *Account, org *Organization, admin bool) error
+	CountOrganizationAccounts(org *Organization) (int, error)
+
+	// InOrganization returns a db whose account lookups by email and username, and created accounts,
+	// are in the organization. nil is the default namespace, of accounts in no organization
+	InOrganization(org *Organization) SADB
+}
+
+// Organization partitions accounts. Emails and usernames are only unique within an organization
+type Organization struct {
+	gorm.Model
+	UUID             string `gorm:"type:varchar(64);unique_index;not null"`
+	Slug             string `gorm:"type:varchar(64);unique_index;not null"`
+	Name             string `gorm:"type:varchar(256);not null"`
+	AllowSignup      bool   `gorm:"not null"`                    // If users can create their own account in the organization
+	AllowedClients   string `gorm:"type:varchar(1024);not null"` // Space-separated OAuth2 client IDs. Empty allows all
+	AllowedProviders string `gorm:"type:varchar(1024);not null"` // Space-separated OIDC provider IDs. Empty allows all
+}
+
+var validOrganizationSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// AllowsClient is true if accounts in the organization can use the OAuth2 client. A nil organization allows all
+func (s *Organization) AllowsClient(clientID string) bool {
+	return s == nil || allowsListed(s.AllowedClients, clientID)
+}
+
+// AllowsProvider is true if accounts in the organization can login with the OIDC provider. A nil organization allows all
+func (s *Organization) AllowsProvider(provider string) bool {
+	return s == nil || allowsListed(s.AllowedProviders, provider)
+}
+
+// OrganizationUUID returns the organization's UUID, or empty if nil
+func (s *Organization) OrganizationUUID() string {
+	if s == nil {
+		return ""
+	}
+	return s.UUID
+}
+
+func allowsListed(list, item string) bool {
+	fields := strings.Fields(list)
+	return len(fields) == 0 || containsString(fields, item)
+}
+
+func (s *sadb) CreateOrganization(slug, name string) (*Organization, error) {
+	slug = strings.TrimSpace(strings.ToLower(slug))
+	if !validOrganizationSlug.MatchString(slug) {
+		return nil, OrganizationInvalid.Newf("invalid slug '%s'", slug)
+	}
+	if _, err := uuid.Parse(slug); err == nil {
+		return nil, OrganizationInvalid.Newf("slug can't be a UUID")
+	}
+	if name == "" {
+		name = slug
+	}
+
+	var count int
+	if err := s.db.Model(&Organization{}).Where("slug = ?", slug).Count(&count).Error; err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	if count > 0 {
+		return nil, OrganizationInvalid.Newf("slug '%s' is already used", slug)
+	}
+
+	org := &Organization{
+		UUID: uuid.New().String(),
+		Slug: slug,
+		Name: name,
+	}
+	if err := s.db.Create(org).Error; err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	return org, nil
+}
+
+func (s *sadb) FindOrganization(uuidOrSlug string) (*Organization, error) {
+	if uuidOrSlug == "" {
+		return nil, OrganizationNotFound.New()
+	}
+
+	var org Organization
+	err := s.db.Where("uuid = ? OR slug = ?", uuidOrSlug, strings.ToLower(uuidOrSlug)).First(&org).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, OrganizationNotFound.Newf("organization '%s' not found", uuidOrSlug)
+	} else if err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	return &org, nil
+}
+
+func (s *sadb) GetOrganizations() ([]*Organization, error) {
+	var orgs []*Organization
+	if err := s.db.Order("slug").Find(&orgs).Error; err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	return orgs, nil
+}
+
+func (s *sadb) UpdateOrganization(org *Organization) error {
+	if org == nil {
+		return OrganizationNotFound.New()
+	}
+	if org.Name == "" {
+		return OrganizationInvalid.Newf("name is required")
+	}
+
+	err := s.db.Model(org).UpdateColumns(map[string]interface{}{
+		"name":              org.Name,
+		"allow_signup":      org.AllowSignup,
+		"allowed_clients":   strings.Join(strings.Fields(org.AllowedClients), " "),
+		"allowed_providers": strings.Join(strings.Fields(org.AllowedProviders), " "),
+	}).Error
+	if err != nil {
+		return InternalError.Wrap(err)
+	}
+	return nil
+}
+
+func (s *sadb) DeleteOrganization(org *Organization) error {
+	if org == nil {
+		return OrganizationNotFound.New()
+	}
+
+	count, err := s.CountOrganizationAccounts(org)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return OrganizationNotEmpty.Newf("organization has %d accounts", count)
+	}
+
+	// Hard-deleted, so the slug can be used again
+	if err := s.db.Unscoped().Delete(org).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+	return nil
+}
+
+func (s *sadb) CountOrganizationAccounts(org *Organization) (int, error) {
+	if org == nil {
+		return 0, OrganizationNotFound.New()
+	}
+
+	var count int
+	if err := s.db.Model(&Account{}).Where("organization_id = ?", org.ID).Count(&count).Error; err != nil {
+		return 0, InternalError.Wrap(err)
+	}
+	return count, nil
+}
+
+func (s *sadb) GetAccountOrganization(account *Account) (*Organization, error) {
+	if account == nil {
+		return nil, InvalidAccount.New()
+	}
+	if account.OrganizationID == 0 {
+		return nil, nil
+	}
+
+	var org Organization
+	if err := s.db.First(&org, account.OrganizationID).Error; err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	return &org, nil
+}
+
+func (s *sadb) SetAccountOrganization(account *Account, org *Organization, admin bool) error {
+	if account == nil {
+		return InvalidAccount.New()
+	}
+
+	var orgID uint
+	if org != nil {
+		orgID = org.ID
+	} else if admin {
+		return OrganizationInvalid.Newf("an account must be in an organization to be its admin")
+	}
+
+	// Email and username must be available in the new organization
+	if orgID != account.OrganizationID {
+		var count int
+		err := s.db.Model(&Account{}).Where("organization_id = ? AND email = ?", orgID, account.Email).Count(&count).Error
+		if err != nil {
+			return InternalError.Wrap(err)
+		} else if count > 0 {
+			return EmailUnavailable.Newf("email is already used in the organization")
+		}
+
+		var authLocal accountAuthLocal
+		if err := s.db.Where("account_id = ?", account.ID).First(&authLocal).Error; err == nil {
+			err := s.db.Model(&accountAuthLocal{}).Where("organization_id = ? AND username = ?", orgID, authLocal.Username).Count(&count).Error
+			if err != nil {
+				return InternalError.Wrap(err)
+			} else if count > 0 {
+				return AuthUsernameUnavailable.Newf("username is already used in the organization")
+			}
+		}
+	}
+
+	err := s.db.Model(account).UpdateColumns(map[string]interface{}{
+		"organization_id":    orgID,
+		"organization_admin": admin,
+	}).Error
+	if err != nil {
+		return InternalError.Wrap(err)
+	}
+	if err := s.db.Model(&accountAuthLocal{}).Where("account_id = ?", account.ID).UpdateColumn("organization_id", orgID).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+
+	if org != nil {
+		adminText := ""
+		if admin {
+			adminText = " as admin"
+		}
+		s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Moved to organization %s%s", org.Slug, adminText)
+	} else {
+		s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Removed from organization")
+	}
+
+	return nil
+}
+
+func (s *sadb) InOrganization(org *Organization) SADB {
+	var orgID uint
+	if org != nil {
+		orgID = org.ID
+	}
+	return &sadb{
+		db:            s.db,
+		opts:          s.opts,
+		audit:         s.audit,
+		root:          s.root,
+		pendingEvents: s.pendingEvents,
+		organization:  orgID,
+	}
+}
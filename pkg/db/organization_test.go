@@ -0,0 +1,132 @@
+package db_test
+
+import (
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/saerrors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateOrganization(t *testing.T) {
+	org, err := sadb.CreateOrganization("Create-Org", "Create Org")
+	assert.NoError(t, err)
+	assert.Equal(t, "create-org", org.Slug)
+	assert.NotEmpty(t, org.UUID)
+
+	found, err := sadb.FindOrganization("create-org")
+	assert.NoError(t, err)
+	assert.Equal(t, org.ID, found.ID)
+	found, err = sadb.FindOrganization(org.UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, org.ID, found.ID)
+
+	_, err = sadb.FindOrganization("no-such-org")
+	assert.Equal(t, db.OrganizationNotFound, saerrors.UnwrapCode(err))
+
+	_, err = sadb.CreateOrganization("create-org", "Again")
+	assert.Equal(t, db.OrganizationInvalid, saerrors.UnwrapCode(err))
+	_, err = sadb.CreateOrganization("not a slug", "Bad")
+	assert.Equal(t, db.OrganizationInvalid, saerrors.UnwrapCode(err))
+}
+
+func TestOrganizationScoping(t *testing.T) {
+	acme, _ := sadb.CreateOrganization("scope-acme", "Acme")
+	globex, _ := sadb.CreateOrganization("scope-globex", "Globex")
+	acmeDB := sadb.InOrganization(acme)
+	globexDB := sadb.InOrganization(globex)
+
+	// Same email and username in each organization, and in none
+	a, err := acmeDB.CreateAccount("a", "scoped@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, acme.ID, a.OrganizationID)
+	_, err = acmeDB.CreateAuthLocal(a, "scoped-user", "password")
+	assert.NoError(t, err)
+
+	g, err := globexDB.CreateAccount("g", "scoped@example.com")
+	assert.NoError(t, err)
+	_, err = globexDB.CreateAuthLocal(g, "scoped-user", "password")
+	assert.NoError(t, err)
+
+	n, err := sadb.CreateAccount("n", "scoped@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(0), n.OrganizationID)
+
+	// But still unique within one
+	_, err = acmeDB.CreateAccount("a2", "scoped@example.com")
+	assert.Error(t, err)
+	a2, _ := acmeDB.CreateAccount("a2", "scoped2@example.com")
+	_, err = acmeDB.CreateAuthLocal(a2, "scoped-user", "password")
+	assert.Error(t, err)
+
+	// Lookups only find the organization's account
+	found, err := acmeDB.FindAccountByEmail("scoped@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, a.UUID, found.UUID)
+	found, err = sadb.FindAccountByEmail("scoped@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, n.UUID, found.UUID)
+
+	authLocal, err := globexDB.FindAuthLocalByUsername("scoped-user")
+	assert.NoError(t, err)
+	assert.Equal(t, g.UUID, authLocal.Account().UUID)
+	_, err = sadb.FindAuthLocalByUsername("scoped-user")
+	assert.Error(t, err)
+
+	// Scope is kept through a transaction
+	tx := acmeDB.BeginTransaction()
+	found, err = tx.FindAccountByEmail("scoped@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, a.UUID, found.UUID)
+	tx.Rollback()
+}
+
+func TestSetAccountOrganization(t *testing.T) {
+	org, _ := sadb.CreateOrganization("move-org", "Move")
+	account, _ := sadb.CreateAccount("move", "move@example.com")
+	sadb.CreateAuthLocal(account, "move-user", "password")
+
+	assert.NoError(t, sadb.SetAccountOrganization(account, org, true))
+	assert.Equal(t, org.ID, account.OrganizationID)
+	assert.True(t, account.OrganizationAdmin)
+
+	found, _ := sadb.GetAccountOrganization(account)
+	assert.Equal(t, org.UUID, found.UUID)
+	_, err := sadb.InOrganization(org).FindAuthLocalByUsername("move-user")
+	assert.NoError(t, err)
+	_, err = sadb.FindAuthLocalByUsername("move-user")
+	assert.Error(t, err)
+
+	count, _ := sadb.CountOrganizationAccounts(org)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, db.OrganizationNotEmpty, saerrors.UnwrapCode(sadb.DeleteOrganization(org)))
+
+	// Can't move into an organization with the same email or username
+	sadb.CreateAccount("move", "move@example.com")
+	err = sadb.SetAccountOrganization(account, nil, false)
+	assert.Equal(t, db.EmailUnavailable, saerrors.UnwrapCode(err))
+
+	other, _ := sadb.CreateAccount("other", "move-other@example.com")
+	sadb.CreateAuthLocal(other, "move-user", "password")
+	err = sadb.SetAccountOrganization(other, org, false)
+	assert.Equal(t, db.AuthUsernameUnavailable, saerrors.UnwrapCode(err))
+
+	err = sadb.SetAccountOrganization(other, nil, true)
+	assert.Equal(t, db.OrganizationInvalid, saerrors.UnwrapCode(err))
+
+	assert.NoError(t, sadb.DeleteAccount(account))
+	assert.NoError(t, sadb.DeleteOrganization(org))
+	_, err = sadb.FindOrganization("move-org")
+	assert.Equal(t, db.OrganizationNotFound, saerrors.UnwrapCode(err))
+}
+
+func TestOrganizationAllows(t *testing.T) {
+	var none *db.Organization
+	assert.True(t, none.AllowsClient("any"))
+	assert.True(t, none.AllowsProvider("any"))
+
+	org := &db.Organization{AllowedClients: "app-a app-b"}
+	assert.True(t, org.AllowsClient("app-b"))
+	assert.False(t, org.AllowsClient("app-c"))
+	assert.True(t, org.AllowsProvider("google"))
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Reaper interface {
+	// Reap permanently removes data that can no longer be used
+	Reap(opts ReapOptions) (*ReapResult, error)
+}
+
+type ReapOptions struct {
+	// Retention is how long soft-deleted (consumed, revoked, satisfied) rows are kept. 0 keeps forever
+	Retention time.Duration
+	// DeleteUnverifiedAfter is how long an account can keep an unsatisfied token stipulation (eg. unverified
+	// email) before the whole account is permanently deleted. This includes token stipulations added by an
+	// admin, or by an import. 0 never deletes
+	DeleteUnverifiedAfter time.Duration
+	// AuditRetention is how long audit records are kept. 0 keeps forever
+	AuditRetention time.Duration
+}
+
+type ReapResult struct {
+	ExpiredOAuthTokens   int64
+	ExpiredOneTimeTokens int64
+	SoftDeleted          int64
+	UnverifiedAccounts   int64
+	AuditRecords         int64
+}
+
+// reapableModels are the soft-deleted tables that are purged after the retention period
+var reapableModels = []interface{}{
+	&accountAuthLocal{},
+	&accountAuthOneTime{},
+	&accountStipulation{},
+	&accountOAuthToken{},
+	&accountOIDC{},
+}
+
+func (s *sadb) Reap(opts ReapOptions) (*ReapResult, error) {
+	now := time.Now()
+	ret := &ReapResult{}
+
+	res := s.db.Unscoped().Where("expires < ?", now).Delete(&accountOAuthToken{})
+	if res.Error != nil {
+		return ret, InternalError.Wrap(res.Error)
+	}
+	ret.ExpiredOAuthTokens = res.RowsAffected
+
+	res = s.db.Unscoped().Where("expires < ?", now).Delete(&accountAuthOneTime{})
+	if res.Error != nil {
+		return ret, InternalError.Wrap(res.Error)
+	}
+	ret.ExpiredOneTimeTokens = res.RowsAffected
+
+	if opts.Retention > 0 {
+		cutoff := now.Add(-opts.Retention)
+		for _, model := range reapableModels {
+			res = s.db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Delete(model)
+			if res.Error != nil {
+				return ret, InternalError.Wrap(res.Error)
+			}
+			ret.SoftDeleted += res.RowsAffected
+		}
+	}
+
+	if opts.DeleteUnverifiedAfter > 0 {
+		var accounts []*Account
+		err := s.db.Where("id IN (?)",
+			s.db.Model(&accountStipulation{}).
+				Select("account_id").
+				Where("type = ? AND created_at < ?", (&TokenStipulation{}).Type(), now.Add(-opts.DeleteUnverifiedAfter)).
+				QueryExpr(),
+		).Find(&accounts).Error
+		if err != nil {
+			return ret, InternalError.Wrap(err)
+		}
+
+		for _, account := range accounts {
+			logrus.Warnf("Reaper deleting account %s (%s), its email is still unverified after %s", account.UUID, account.Email, opts.DeleteUnverifiedAfter)
+			if err := s.DeleteAccount(account); err != nil {
+				return ret, err
+			}
+			ret.UnverifiedAccounts++
+		}
+	}
+
+	if opts.AuditRetention > 0 {
+		res = s.db.Unscoped().Where("created_at < ?", now.Add(-opts.AuditRetention)).Delete(&AccountAuditRecord{})
+		if res.Error != nil {
+			return ret, InternalError.Wrap(res.Error)
+		}
+		ret.AuditRecords = res.RowsAffected
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,66 @@
+package db_test
+
+import (
+	"simple-auth/pkg/db"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReapExpiredTokens(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "reap-tokens@asdf.com")
+	sadb.CreateOAuthToken(account, "reap-client", db.OAuthTypeAccessToken, "reap-expired", nil, -1*time.Minute)
+	sadb.CreateOAuthToken(account, "reap-client", db.OAuthTypeAccessToken, "reap-valid", nil, 1*time.Hour)
+	sadb.CreateAccountOneTimeToken(account, -1*time.Minute)
+
+	result, err := sadb.Reap(db.ReapOptions{})
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, result.ExpiredOAuthTokens, int64(1))
+	assert.GreaterOrEqual(t, result.ExpiredOneTimeTokens, int64(1))
+
+	tokens, _ := sadb.GetAllValidOAuthTokens(account)
+	assert.Len(t, tokens, 1)
+	valid, _ := sadb.GetValidOAuthToken("reap-valid")
+	assert.NotNil(t, valid)
+}
+
+func TestReapUnverifiedAccounts(t *testing.T) {
+	unverified, _ := sadb.CreateAccount("test", "reap-unverified@asdf.com")
+	sadb.AddStipulation(unverified, db.NewTokenStipulation())
+
+	verified, _ := sadb.CreateAccount("test", "reap-verified@asdf.com")
+	stip := db.NewTokenStipulation()
+	sadb.AddStipulation(verified, stip)
+	sadb.SatisfyStipulation(verified, stip)
+
+	// Disabled by default
+	result, err := sadb.Reap(db.ReapOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), result.UnverifiedAccounts)
+
+	result, err = sadb.Reap(db.ReapOptions{DeleteUnverifiedAfter: time.Nanosecond})
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, result.UnverifiedAccounts, int64(1))
+
+	_, err = sadb.FindAccount(unverified.UUID)
+	assert.Error(t, err)
+	_, err = sadb.FindAccount(verified.UUID)
+	assert.NoError(t, err)
+}
+
+func TestReapAuditRecords(t *testing.T) {
+	rdb := db.New("sqlite3", "file:reap-audit?mode=memory&cache=shared")
+	account, _ := rdb.CreateAccount("test", "reap-audit@asdf.com")
+
+	result, err := rdb.Reap(db.ReapOptions{AuditRetention: time.Hour})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), result.AuditRecords)
+
+	time.Sleep(time.Millisecond)
+	result, err = rdb.Reap(db.ReapOptions{AuditRetention: time.Nanosecond})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.AuditRecords)
+	records, _ := rdb.SearchAuditRecords(&db.AuditQuery{Account: account})
+	assert.Empty(t, records)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"reflect"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -20,8 +21,51 @@ type IStipulation interface {
 type AccountStipulations interface {
 	SatisfyStipulation(account *Account, satisfy IStipulation) error
 	AddStipulation(account *Account, spec IStipulation) error
-	AccountHasUnsatisfiedStipulations(account *Account) bool
+	AccountHasStipulation(account *Account, t StipulationType) bool
+	// AccountHasUnsatisfiedStipulations is true if any stipulation, other than the excepted types, prevents login
+	AccountHasUnsatisfiedStipulations(account *Account, except ...StipulationType) bool
 	ForceSatisfyStipulations(account *Account) error
+
+	// RequirePasswordChange adds a PasswordResetStipulation, if the account doesn't already have one
+	RequirePasswordChange(account *Account) error
+	// RequirePasswordChangeAll requires every active account with a local login, in the db's
+	// organization, to change their password. Returns the number of accounts newly required
+	RequirePasswordChangeAll() (int, error)
+
+	// RequireTwoFactorEnrollment adds a TwoFactorEnrollStipulation, with the grace logins, if the account doesn't
+	// already have one
+	RequireTwoFactorEnrollment(account *Account, graceLogins int) error
+	// TwoFactorGraceLogins returns how many logins the account has left before 2FA enrollment is
+	// enforced. 0 means it is, or the account isn't required to enroll
+	TwoFactorGraceLogins(account *Account) (int, error)
+	// UseTwoFactorGrace uses one of the account's grace logins, returning how many it had left
+	UseTwoFactorGrace(account *Account) (int, error)
+
+	// RotateTokenStipulation replaces the code of the account's TokenStipulation with a new one, that expires
+	// after ttl. It can only be rotated once per interval (VerificationThrottled)
+	RotateTokenStipulation(account *Account, ttl, interval time.Duration) (*TokenStipulation, error)
+	// ListStipulations returns all of the account's stipulations, oldest first
+	ListStipulations(account *Account) ([]*Stipulation, error)
+	// RemoveStipulations removes the account's stipulations of the type, returning how many were removed
+	RemoveStipulations(account *Account, t StipulationType) (int, error)
+}
+
+// Stipulation is a stipulation on an account, as stored
+type Stipulation struct {
+	Type          StipulationType
+	Specification string // JSON of the IStipulation
+	Created       time.Time
+}
+
+// expiringStipulation is a stipulation that can no longer be satisfied after some time
+type expiringStipulation interface {
+	Expired() bool
+}
+
+// nonBlockingStipulations are pending actions, rather than requirements, so don't count as unsatisfied
+var nonBlockingStipulations = []StipulationType{
+	(&EmailChangeStipulation{}).Type(),
+	(&UnlockStipulation{}).Type(),
 }
 
 type accountStipulation struct {
@@ -72,6 +116,10 @@ func (s *sadb) SatisfyStipulation(account *Account, satisfy IStipulation) error
 			continue
 		}
 		if spec.IsSatisfiedBy(satisfy) {
+			if expiring, ok := spec.(expiringStipulation); ok && expiring.Expired() {
+				s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Stipulation %s has expired", spec.Type())
+				return VerificationExpired.New()
+			}
 			err := s.db.Delete(&st).Error
 			if err != nil {
 				return err
@@ -86,9 +134,23 @@ func (s *sadb) SatisfyStipulation(account *Account, satisfy IStipulation) error
 	return errors.New("no stipulation satisfied")
 }
 
-func (s *sadb) AccountHasUnsatisfiedStipulations(account *Account) bool {
+func (s *sadb) AccountHasStipulation(account *Account, t StipulationType) bool {
 	var count int
-	if err := s.db.Model(&accountStipulation{}).Where("account_id = ?", account.ID).Count(&count).Error; err != nil {
+	err := s.db.Model(&accountStipulation{}).
+		Where("account_id = ? AND type = ?", account.ID, t).
+		Count(&count).Error
+	if err != nil {
+		return true
+	}
+	return count > 0
+}
+
+func (s *sadb) AccountHasUnsatisfiedStipulations(account *Account, except ...StipulationType) bool {
+	var count int
+	err := s.db.Model(&accountStipulation{}).
+		Where("account_id = ? AND type NOT IN (?)", account.ID, append(append([]StipulationType{}, nonBlockingStipulations...), except...)).
+		Count(&count).Error
+	if err != nil {
 		return true
 	}
 
@@ -100,5 +162,173 @@ func (s *sadb) AccountHasUnsatisfiedStipulations(account *Account) bool {
 }
 
 func (s *sadb) ForceSatisfyStipulations(account *Account) error {
-	return s.db.Where("account_id = ?", account.ID).Delete(&accountStipulation{}).Error
+	return s.db.Where("account_id = ? AND type NOT IN (?)", account.ID, nonBlockingStipulations).Delete(&accountStipulation{}).Error
+}
+
+func (s *sadb) RequirePasswordChange(account *Account) error {
+	if account == nil {
+		return InvalidAccount.New()
+	}
+
+	spec := &PasswordResetStipulation{}
+	if s.AccountHasStipulation(account, spec.Type()) {
+		return nil
+	}
+	if err := s.AddStipulation(account, spec); err != nil {
+		return InternalError.Wrap(err)
+	}
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Password change required")
+	return nil
+}
+
+func (s *sadb) RequirePasswordChangeAll() (int, error) {
+	var accounts []*Account
+	err := s.db.
+		Where("active = ? AND organization_id = ?", true, s.organization).
+		Where("id IN (?)", s.db.Model(&accountAuthLocal{}).Select("account_id").QueryExpr()).
+		Where("id NOT IN (?)", s.db.Model(&accountStipulation{}).
+			Select("account_id").
+			Where("type = ?", (&PasswordResetStipulation{}).Type()).
+			QueryExpr()).
+		Find(&accounts).Error
+	if err != nil {
+		return 0, InternalError.Wrap(err)
+	}
+
+	for _, account := range accounts {
+		if err := s.RequirePasswordChange(account); err != nil {
+			return 0, err
+		}
+	}
+	return len(accounts), nil
+}
+
+func (s *sadb) RequireTwoFactorEnrollment(account *Account, graceLogins int) error {
+	if account == nil {
+		return InvalidAccount.New()
+	}
+
+	spec := &TwoFactorEnrollStipulation{GraceLogins: graceLogins}
+	if s.AccountHasStipulation(account, spec.Type()) {
+		return nil
+	}
+	if err := s.AddStipulation(account, spec); err != nil {
+		return InternalError.Wrap(err)
+	}
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "2FA enrollment required, after %d logins", graceLogins)
+	return nil
+}
+
+func (s *sadb) TwoFactorGraceLogins(account *Account) (int, error) {
+	_, spec, err := s.findTwoFactorEnrollment(account)
+	if err != nil || spec == nil || spec.GraceLogins < 0 {
+		return 0, err
+	}
+	return spec.GraceLogins, nil
+}
+
+func (s *sadb) UseTwoFactorGrace(account *Account) (int, error) {
+	st, spec, err := s.findTwoFactorEnrollment(account)
+	if err != nil || spec == nil || spec.GraceLogins <= 0 {
+		return 0, err
+	}
+
+	remaining := spec.GraceLogins
+	spec.GraceLogins--
+	specBytes, err := json.Marshal(spec)
+	if err != nil {
+		return 0, InternalError.Wrap(err)
+	}
+	if err := s.db.Model(st).Update("specification", string(specBytes)).Error; err != nil {
+		return 0, InternalError.Wrap(err)
+	}
+	return remaining, nil
+}
+
+// findTwoFactorEnrollment returns the account's TwoFactorEnrollStipulation, or nil if it doesn't have one
+func (s *sadb) findTwoFactorEnrollment(account *Account) (*accountStipulation, *TwoFactorEnrollStipulation, error) {
+	if account == nil {
+		return nil, nil, InvalidAccount.New()
+	}
+
+	stips, err := s.findStipulations(account, (&TwoFactorEnrollStipulation{}).Type())
+	if err != nil {
+		return nil, nil, InternalError.Wrap(err)
+	}
+	if len(stips) == 0 {
+		return nil, nil, nil
+	}
+
+	var spec TwoFactorEnrollStipulation
+	if err := json.Unmarshal([]byte(stips[0].Specification), &spec); err != nil {
+		return nil, nil, InternalError.Wrap(err)
+	}
+	return &stips[0], &spec, nil
+}
+
+func (s *sadb) RotateTokenStipulation(account *Account, ttl, interval time.Duration) (*TokenStipulation, error) {
+	if account == nil {
+		return nil, InvalidAccount.New()
+	}
+
+	stips, err := s.findStipulations(account, (&TokenStipulation{}).Type())
+	if err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	if len(stips) == 0 {
+		return nil, VerificationMissing.New()
+	}
+
+	// Updated in-place, so the account's age as unverified is kept, see Reap
+	st := &stips[0]
+	if time.Since(st.UpdatedAt) < interval {
+		return nil, VerificationThrottled.Newf("a new code can be issued after %s", interval)
+	}
+
+	spec := NewExpiringTokenStipulation(ttl)
+	specBytes, err := json.Marshal(spec)
+	if err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	if err := s.db.Model(st).Update("specification", string(specBytes)).Error; err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelInfo, "Stipulation %s re-issued", spec.Type())
+	return spec, nil
+}
+
+func (s *sadb) ListStipulations(account *Account) ([]*Stipulation, error) {
+	if account == nil {
+		return nil, InvalidAccount.New()
+	}
+
+	var stips []accountStipulation
+	if err := s.db.Where("account_id = ?", account.ID).Order("id").Find(&stips).Error; err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+
+	ret := make([]*Stipulation, len(stips))
+	for i, st := range stips {
+		ret[i] = &Stipulation{
+			Type:          st.Type,
+			Specification: st.Specification,
+			Created:       st.CreatedAt,
+		}
+	}
+	return ret, nil
+}
+
+func (s *sadb) RemoveStipulations(account *Account, t StipulationType) (int, error) {
+	if account == nil {
+		return 0, InvalidAccount.New()
+	}
+
+	res := s.db.Where("account_id = ? AND type = ?", account.ID, t).Delete(&accountStipulation{})
+	if res.Error != nil {
+		return 0, InternalError.Wrap(res.Error)
+	}
+	if res.RowsAffected > 0 {
+		s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelWarn, "Stipulation %s removed", t)
+	}
+	return int(res.RowsAffected), nil
 }
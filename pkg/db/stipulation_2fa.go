@@ -0,0 +1,16 @@
+package db
+
+// TwoFactorEnrollStipulation requires the account to enroll in 2FA. Until its grace logins are used
+// up, the account can still login without enrolling. It's satisfied by enrolling
+type TwoFactorEnrollStipulation struct {
+	GraceLogins int `json:"graceLogins"`
+}
+
+func (s *TwoFactorEnrollStipulation) Type() StipulationType {
+	return StipulationType("enroll-2fa")
+}
+
+func (s *TwoFactorEnrollStipulation) IsSatisfiedBy(spec IStipulation) bool {
+	_, ok := spec.(*TwoFactorEnrollStipulation)
+	return ok
+}
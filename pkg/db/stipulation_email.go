@@ -0,0 +1,30 @@
+package db
+
+import "time"
+
+// EmailChangeStipulation is a pending change of the account's email, satisfied by the token sent to
+// the new address. Unlike other stipulations, it doesn't prevent login
+type EmailChangeStipulation struct {
+	TokenStipulation
+	Email string `json:"email"`
+}
+
+func (s *EmailChangeStipulation) Type() StipulationType {
+	return StipulationType("email-change")
+}
+
+func (s *EmailChangeStipulation) IsSatisfiedBy(spec IStipulation) bool {
+	other, ok := spec.(*EmailChangeStipulation)
+	if !ok {
+		return false
+	}
+	return other.Code == s.Code
+}
+
+// NewEmailChangeStipulation creates a pending change to email, whose token expires after ttl (0 never expires)
+func NewEmailChangeStipulation(email string, ttl time.Duration) *EmailChangeStipulation {
+	return &EmailChangeStipulation{
+		TokenStipulation: *NewExpiringTokenStipulation(ttl),
+		Email:            email,
+	}
+}
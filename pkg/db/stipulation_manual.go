@@ -1,5 +1,6 @@
 package db
 
+// ManualStipulation holds an account until an admin approves it, see AccountApprovals
 type ManualStipulation struct {
 }
 
@@ -0,0 +1,15 @@
+package db
+
+// PasswordResetStipulation requires the account to set a new password at its next login. It's
+// satisfied by changing the password
+type PasswordResetStipulation struct {
+}
+
+func (s *PasswordResetStipulation) Type() StipulationType {
+	return StipulationType("password-reset")
+}
+
+func (s *PasswordResetStipulation) IsSatisfiedBy(spec IStipulation) bool {
+	_, ok := spec.(*PasswordResetStipulation)
+	return ok
+}
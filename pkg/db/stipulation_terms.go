@@ -0,0 +1,18 @@
+package db
+
+// TermsStipulation requires the account to accept a version of the terms of service. It's only
+// satisfied by accepting that same version
+type TermsStipulation struct {
+	Version string `json:"version"`
+}
+
+func (s *TermsStipulation) Type() StipulationType {
+	return StipulationType("terms")
+}
+
+func (s *TermsStipulation) IsSatisfiedBy(spec IStipulation) bool {
+	if other, ok := spec.(*TermsStipulation); ok {
+		return other.Version == s.Version
+	}
+	return false
+}
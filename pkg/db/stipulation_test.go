@@ -2,7 +2,9 @@ package db_test
 
 import (
 	"simple-auth/pkg/db"
+	"simple-auth/pkg/saerrors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -35,6 +37,61 @@ func TestTokenStipulation(t *testing.T) {
 	}
 }
 
+func TestTokenStipulationExpired(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "stip-expired@asdf.com")
+
+	expired := time.Now().Add(-time.Minute)
+	stip := &db.TokenStipulation{Code: "expired", Expires: &expired}
+	assert.True(t, stip.Expired())
+	assert.NoError(t, sadb.AddStipulation(account, stip))
+
+	err := sadb.SatisfyStipulation(account, &db.TokenStipulation{Code: stip.Code})
+	assert.Equal(t, db.VerificationExpired, saerrors.UnwrapCode(err))
+	assert.True(t, sadb.AccountHasUnsatisfiedStipulations(account))
+}
+
+func TestRotateTokenStipulation(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "stip-rotate@asdf.com")
+
+	_, err := sadb.RotateTokenStipulation(account, time.Hour, 0)
+	assert.Equal(t, db.VerificationMissing, saerrors.UnwrapCode(err))
+
+	stip := db.NewTokenStipulation()
+	assert.NoError(t, sadb.AddStipulation(account, stip))
+
+	_, err = sadb.RotateTokenStipulation(account, time.Hour, time.Hour)
+	assert.Equal(t, db.VerificationThrottled, saerrors.UnwrapCode(err))
+
+	rotated, err := sadb.RotateTokenStipulation(account, time.Hour, 0)
+	assert.NoError(t, err)
+	assert.NotEqual(t, stip.Code, rotated.Code)
+	assert.NotNil(t, rotated.Expires)
+
+	assert.Error(t, sadb.SatisfyStipulation(account, &db.TokenStipulation{Code: stip.Code}))
+	assert.NoError(t, sadb.SatisfyStipulation(account, &db.TokenStipulation{Code: rotated.Code}))
+	assert.False(t, sadb.AccountHasUnsatisfiedStipulations(account))
+}
+
+func TestListRemoveStipulations(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "stip-list@asdf.com")
+
+	assert.NoError(t, sadb.AddStipulation(account, db.NewTokenStipulation()))
+	assert.NoError(t, sadb.AddStipulation(account, &db.ManualStipulation{}))
+
+	stips, err := sadb.ListStipulations(account)
+	assert.NoError(t, err)
+	assert.Len(t, stips, 2)
+	assert.Equal(t, (&db.TokenStipulation{}).Type(), stips[0].Type)
+	assert.Equal(t, (&db.ManualStipulation{}).Type(), stips[1].Type)
+
+	count, err := sadb.RemoveStipulations(account, (&db.TokenStipulation{}).Type())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	stips, _ = sadb.ListStipulations(account)
+	assert.Len(t, stips, 1)
+}
+
 func TestUpdateAllStipulations(t *testing.T) {
 	account, _ := sadb.CreateAccount("test", "stip2@asdf.com")
 
@@ -47,3 +104,59 @@ func TestUpdateAllStipulations(t *testing.T) {
 
 	assert.False(t, sadb.AccountHasUnsatisfiedStipulations(account))
 }
+
+func TestRequirePasswordChange(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "stip-passwd@asdf.com")
+	passwordReset := (&db.PasswordResetStipulation{}).Type()
+
+	assert.NoError(t, sadb.RequirePasswordChange(account))
+	assert.NoError(t, sadb.RequirePasswordChange(account)) // Idempotent
+	assert.True(t, sadb.AccountHasStipulation(account, passwordReset))
+	assert.True(t, sadb.AccountHasUnsatisfiedStipulations(account))
+	assert.False(t, sadb.AccountHasUnsatisfiedStipulations(account, passwordReset))
+
+	assert.NoError(t, sadb.SatisfyStipulation(account, &db.PasswordResetStipulation{}))
+	assert.False(t, sadb.AccountHasStipulation(account, passwordReset))
+	assert.Error(t, sadb.SatisfyStipulation(account, &db.PasswordResetStipulation{}))
+}
+
+func TestRequirePasswordChangeAll(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "stip-passwd-all@asdf.com")
+	sadb.CreateAuthLocal(account, "stip-passwd-all", "test-pass")
+	noLocal, _ := sadb.CreateAccount("test", "stip-passwd-nolocal@asdf.com")
+
+	count, err := sadb.RequirePasswordChangeAll()
+	assert.NoError(t, err)
+	assert.NotZero(t, count)
+	assert.True(t, sadb.AccountHasStipulation(account, (&db.PasswordResetStipulation{}).Type()))
+	assert.False(t, sadb.AccountHasStipulation(noLocal, (&db.PasswordResetStipulation{}).Type()))
+
+	// Already required accounts aren't counted again
+	count, err = sadb.RequirePasswordChangeAll()
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestTwoFactorEnrollment(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "stip-2fa@asdf.com")
+	enroll := (&db.TwoFactorEnrollStipulation{}).Type()
+
+	remaining, err := sadb.UseTwoFactorGrace(account)
+	assert.NoError(t, err)
+	assert.Zero(t, remaining)
+
+	assert.NoError(t, sadb.RequireTwoFactorEnrollment(account, 2))
+	assert.NoError(t, sadb.RequireTwoFactorEnrollment(account, 5)) // Doesn't reset the grace
+	assert.True(t, sadb.AccountHasStipulation(account, enroll))
+
+	for _, expected := range []int{2, 1, 0, 0} {
+		left, _ := sadb.TwoFactorGraceLogins(account)
+		assert.Equal(t, expected, left)
+		remaining, err := sadb.UseTwoFactorGrace(account)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, remaining)
+	}
+
+	assert.NoError(t, sadb.SatisfyStipulation(account, &db.TwoFactorEnrollStipulation{}))
+	assert.False(t, sadb.AccountHasStipulation(account, enroll))
+}
@@ -1,11 +1,14 @@
 package db
 
 import (
+	"time"
+
 	"github.com/labstack/gommon/random"
 )
 
 type TokenStipulation struct {
-	Code string `json:"code"`
+	Code    string     `json:"code"`
+	Expires *time.Time `json:"expires,omitempty"` // If nil, never expires
 }
 
 func (s *TokenStipulation) Type() StipulationType {
@@ -20,8 +23,23 @@ func (s *TokenStipulation) IsSatisfiedBy(spec IStipulation) bool {
 	return other.Code == s.Code
 }
 
+// Expired is true if the code can no longer satisfy the stipulation, see RotateTokenStipulation
+func (s *TokenStipulation) Expired() bool {
+	return s.Expires != nil && time.Now().After(*s.Expires)
+}
+
 func NewTokenStipulation() *TokenStipulation {
 	return &TokenStipulation{
 		Code: random.String(18),
 	}
 }
+
+// NewExpiringTokenStipulation creates a token stipulation that expires after ttl. 0 never expires
+func NewExpiringTokenStipulation(ttl time.Duration) *TokenStipulation {
+	stip := NewTokenStipulation()
+	if ttl > 0 {
+		expires := time.Now().Add(ttl)
+		stip.Expires = &expires
+	}
+	return stip
+}
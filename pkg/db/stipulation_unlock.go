@@ -0,0 +1,27 @@
+package db
+
+import "time"
+
+// UnlockStipulation is a pending unlock of a locked local login, satisfied by the token emailed to the
+// account. Like EmailChangeStipulation, it doesn't prevent login
+type UnlockStipulation struct {
+	TokenStipulation
+}
+
+func (s *UnlockStipulation) Type() StipulationType {
+	return StipulationType("unlock")
+}
+
+func (s *UnlockStipulation) IsSatisfiedBy(spec IStipulation) bool {
+	other, ok := spec.(*UnlockStipulation)
+	if !ok {
+		return false
+	}
+	return other.Code == s.Code
+}
+
+func NewUnlockStipulation(ttl time.Duration) *UnlockStipulation {
+	return &UnlockStipulation{
+		TokenStipulation: *NewExpiringTokenStipulation(ttl),
+	}
+}
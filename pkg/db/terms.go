@@ -0,0 +1,167 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AccountTerms tracks which version of the terms of service each account has accepted, and requires
+// the current version to be accepted with a TermsStipulation
+type AccountTerms interface {
+	// RequireTerms adds a TermsStipulation for the version, unless the account has already accepted it.
+	// Returns whether the account still needs to accept it
+	RequireTerms(account *Account, version string) (bool, error)
+	// RequireTermsAll requires every account, in any organization, that hasn't accepted the version
+	// to accept it, replacing any stipulation for an older version. Returns the number of accounts
+	// newly required
+	RequireTermsAll(version string) (int, error)
+	// AcceptTerms records the account's acceptance of the version, satisfying its TermsStipulation
+	AcceptTerms(account *Account, version string) error
+	// GetTermsAcceptance returns the most recently accepted terms, or nil if none have been
+	GetTermsAcceptance(account *Account) (*TermsAcceptance, error)
+}
+
+// TermsAcceptance is the proof that an account accepted a version of the terms of service
+type TermsAcceptance struct {
+	Version    string
+	AcceptedAt time.Time
+}
+
+type accountTermsAcceptance struct {
+	gorm.Model
+	AccountID uint   `gorm:"index;not null"`
+	Version   string `gorm:"type:varchar(64);not null"`
+}
+
+func (s *sadb) RequireTerms(account *Account, version string) (bool, error) {
+	if account == nil {
+		return false, InvalidAccount.New()
+	}
+	if s.hasAcceptedTerms(account, version) {
+		return false, nil
+	}
+
+	spec, err := termsSpecification(version)
+	if err != nil {
+		return false, InternalError.Wrap(err)
+	}
+	var count int
+	err = s.db.Model(&accountStipulation{}).
+		Where("account_id = ? AND type = ? AND specification = ?", account.ID, (&TermsStipulation{}).Type(), spec).
+		Count(&count).Error
+	if err != nil {
+		return false, InternalError.Wrap(err)
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	if err := s.replaceTermsStipulation(account, version); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *sadb) RequireTermsAll(version string) (int, error) {
+	spec, err := termsSpecification(version)
+	if err != nil {
+		return 0, InternalError.Wrap(err)
+	}
+
+	var accounts []*Account
+	err = s.db.
+		Where("id NOT IN (?)", s.db.Model(&accountTermsAcceptance{}).
+			Select("account_id").
+			Where("version = ?", version).
+			QueryExpr()).
+		Where("id NOT IN (?)", s.db.Model(&accountStipulation{}).
+			Select("account_id").
+			Where("type = ? AND specification = ?", (&TermsStipulation{}).Type(), spec).
+			QueryExpr()).
+		Find(&accounts).Error
+	if err != nil {
+		return 0, InternalError.Wrap(err)
+	}
+
+	for _, account := range accounts {
+		if err := s.replaceTermsStipulation(account, version); err != nil {
+			return 0, err
+		}
+	}
+	return len(accounts), nil
+}
+
+func (s *sadb) AcceptTerms(account *Account, version string) error {
+	if account == nil {
+		return InvalidAccount.New()
+	}
+
+	acceptance := &accountTermsAcceptance{
+		AccountID: account.ID,
+		Version:   version,
+	}
+	if err := s.db.Create(acceptance).Error; err != nil {
+		return InternalError.Wrap(err)
+	}
+
+	// Accepting the current version also supersedes any older one still required
+	err := s.db.Where("account_id = ? AND type = ?", account.ID, (&TermsStipulation{}).Type()).
+		Delete(&accountStipulation{}).Error
+	if err != nil {
+		return InternalError.Wrap(err)
+	}
+
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelInfo, "Accepted terms version %s", version)
+	return nil
+}
+
+func (s *sadb) GetTermsAcceptance(account *Account) (*TermsAcceptance, error) {
+	var acceptances []accountTermsAcceptance
+	err := s.db.Where("account_id = ?", account.ID).
+		Order("created_at desc, id desc").
+		Limit(1).
+		Find(&acceptances).Error
+	if err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	if len(acceptances) == 0 {
+		return nil, nil
+	}
+	return &TermsAcceptance{
+		Version:    acceptances[0].Version,
+		AcceptedAt: acceptances[0].CreatedAt,
+	}, nil
+}
+
+func (s *sadb) hasAcceptedTerms(account *Account, version string) bool {
+	var count int
+	err := s.db.Model(&accountTermsAcceptance{}).
+		Where("account_id = ? AND version = ?", account.ID, version).
+		Count(&count).Error
+	return err == nil && count > 0
+}
+
+// replaceTermsStipulation replaces any stipulation for another version with one for this version
+func (s *sadb) replaceTermsStipulation(account *Account, version string) error {
+	err := s.db.Where("account_id = ? AND type = ?", account.ID, (&TermsStipulation{}).Type()).
+		Delete(&accountStipulation{}).Error
+	if err != nil {
+		return InternalError.Wrap(err)
+	}
+	if err := s.AddStipulation(account, &TermsStipulation{Version: version}); err != nil {
+		return InternalError.Wrap(err)
+	}
+	s.CreateAuditRecord(account, AuditModuleAccount, AuditLevelInfo, "Terms version %s must be accepted", version)
+	return nil
+}
+
+// termsSpecification is how a TermsStipulation for the version is stored, to find it in queries
+func termsSpecification(version string) (string, error) {
+	b, err := json.Marshal(&TermsStipulation{Version: version})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,66 @@
+package db_test
+
+import (
+	"simple-auth/pkg/db"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireAndAcceptTerms(t *testing.T) {
+	account, _ := sadb.CreateAccount("test", "terms@asdf.com")
+	terms := (&db.TermsStipulation{}).Type()
+
+	acceptance, err := sadb.GetTermsAcceptance(account)
+	assert.NoError(t, err)
+	assert.Nil(t, acceptance)
+
+	required, err := sadb.RequireTerms(account, "v1")
+	assert.NoError(t, err)
+	assert.True(t, required)
+	assert.True(t, sadb.AccountHasStipulation(account, terms))
+	assert.True(t, sadb.AccountHasUnsatisfiedStipulations(account))
+
+	assert.NoError(t, sadb.AcceptTerms(account, "v1"))
+	assert.False(t, sadb.AccountHasStipulation(account, terms))
+
+	acceptance, err = sadb.GetTermsAcceptance(account)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", acceptance.Version)
+	assert.False(t, acceptance.AcceptedAt.IsZero())
+
+	required, err = sadb.RequireTerms(account, "v1")
+	assert.NoError(t, err)
+	assert.False(t, required)
+
+	// A new version must be accepted again
+	required, err = sadb.RequireTerms(account, "v2")
+	assert.NoError(t, err)
+	assert.True(t, required)
+	assert.Error(t, sadb.SatisfyStipulation(account, &db.TermsStipulation{Version: "v1"}))
+	assert.NoError(t, sadb.SatisfyStipulation(account, &db.TermsStipulation{Version: "v2"}))
+}
+
+func TestRequireTermsAll(t *testing.T) {
+	tdb := db.New("sqlite3", "file:terms-test?mode=memory&cache=shared")
+	accepted, _ := tdb.CreateAccount("test", "terms-accepted@asdf.com")
+	pending, _ := tdb.CreateAccount("test", "terms-pending@asdf.com")
+	tdb.AcceptTerms(accepted, "v1")
+
+	count, err := tdb.RequireTermsAll("v1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.False(t, tdb.AccountHasStipulation(accepted, (&db.TermsStipulation{}).Type()))
+	assert.True(t, tdb.AccountHasStipulation(pending, (&db.TermsStipulation{}).Type()))
+
+	count, err = tdb.RequireTermsAll("v1")
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+
+	// Bumping the version replaces the old stipulation
+	count, err = tdb.RequireTermsAll("v2")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Error(t, tdb.SatisfyStipulation(pending, &db.TermsStipulation{Version: "v1"}))
+	assert.NoError(t, tdb.SatisfyStipulation(pending, &db.TermsStipulation{Version: "v2"}))
+}
@@ -0,0 +1,27 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// tokenPrefixLength is how much of a token is kept in plaintext, to help a user identify it
+const tokenPrefixLength = 5
+
+// hashToken is how bearer tokens are persisted, so that read access to the
+// database (or a backup) doesn't grant access to accounts
+func hashToken(opts *options, token string) string {
+	mac := hmac.New(sha256.New, opts.tokenSecret)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// tokenPrefix is the part of the token that's kept in plaintext.  Short tokens (eg. codes)
+// don't get a prefix, since it would give away most of the token
+func tokenPrefix(token string) string {
+	if len(token) < tokenPrefixLength*4 {
+		return ""
+	}
+	return token[:tokenPrefixLength]
+}
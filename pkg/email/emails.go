@@ -38,8 +38,70 @@ func (s *EmailService) SendForgotPasswordEmail(to string, data *ForgotPasswordDa
 type VerificationData struct {
 	EmailData
 	ActivationLink template.HTML
+	Expires        string // How long the link is valid, if it expires
 }
 
 func (s *EmailService) SendVerificationEmail(to string, data *VerificationData) error {
 	return s.sendEmail(to, "verification", data)
 }
+
+type EmailChangeData struct {
+	EmailData
+	NewEmail string
+}
+
+// SendEmailChangeEmail notifies the current address that a change to NewEmail was requested
+func (s *EmailService) SendEmailChangeEmail(to string, data *EmailChangeData) error {
+	return s.sendEmail(to, "emailChange", data)
+}
+
+type ApprovalData struct {
+	EmailData
+	Name   string
+	Reason string // Optional, given by the admin
+}
+
+// SendApprovedEmail tells an applicant their new account was approved
+func (s *EmailService) SendApprovedEmail(to string, data *ApprovalData) error {
+	return s.sendEmail(to, "approved", data)
+}
+
+// SendRejectedEmail tells an applicant their new account was rejected
+func (s *EmailService) SendRejectedEmail(to string, data *ApprovalData) error {
+	return s.sendEmail(to, "rejected", data)
+}
+
+type LockedData struct {
+	EmailData
+	FailedLogins   int
+	LockedUntil    string // Empty if locked until unlocked
+	UnlockLink     template.HTML
+	UnlockDuration string
+}
+
+// SendLockedEmail tells an account its local login was locked, with a link to unlock it
+func (s *EmailService) SendLockedEmail(to string, data *LockedData) error {
+	return s.sendEmail(to, "locked", data)
+}
+
+type PasswordExpiringData struct {
+	EmailData
+	Expires    string
+	Expired    bool // Already expired, eg. when expiry was first enabled
+	MaxAgeDays int
+}
+
+// SendPasswordExpiringEmail reminds an account that its password expires, and must then be changed
+func (s *EmailService) SendPasswordExpiringEmail(to string, data *PasswordExpiringData) error {
+	return s.sendEmail(to, "passwordExpiring", data)
+}
+
+type RecoveryCodeUsedData struct {
+	EmailData
+	Remaining int // Unused recovery codes left
+}
+
+// SendRecoveryCodeUsedEmail notifies an account that one of its 2FA recovery codes was used to login
+func (s *EmailService) SendRecoveryCodeUsedEmail(to string, data *RecoveryCodeUsedData) error {
+	return s.sendEmail(to, "recoveryCodeUsed", data)
+}
@@ -61,3 +61,106 @@ func TestChangePasswordEmail(t *testing.T) {
 	assert.Contains(t, mock.LastEmail(), "SimpleAuth")
 	assert.Contains(t, mock.LastEmail(), "example.com")
 }
+
+func TestEmailChangeEmail(t *testing.T) {
+	mock := engine.NewMockEngine(nil)
+	service := New(mock, "test@test.com")
+	service.SendEmailChangeEmail("old@to.com", &EmailChangeData{
+		NewEmail: "new@to.com",
+		EmailData: EmailData{
+			Company: "SimpleAuth",
+			BaseURL: "http://example.com",
+		},
+	})
+
+	assert.Equal(t, 1, mock.SendCount())
+	assert.Contains(t, mock.LastEmail(), "old@to.com")
+	assert.Contains(t, mock.LastEmail(), "new@to.com")
+	assert.Contains(t, mock.LastEmail(), "SimpleAuth")
+}
+
+func TestApprovalEmails(t *testing.T) {
+	mock := engine.NewMockEngine(nil)
+	service := New(mock, "test@test.com")
+	data := &ApprovalData{
+		Name:   "Bob",
+		Reason: "Welcome aboard",
+		EmailData: EmailData{
+			Company: "SimpleAuth",
+			BaseURL: "http://example.com",
+		},
+	}
+
+	service.SendApprovedEmail("to@to.com", data)
+	assert.Equal(t, 1, mock.SendCount())
+	assert.Contains(t, mock.LastEmail(), "approved")
+	assert.Contains(t, mock.LastEmail(), "Welcome aboard")
+
+	service.SendRejectedEmail("to@to.com", data)
+	assert.Equal(t, 2, mock.SendCount())
+	assert.Contains(t, mock.LastEmail(), "not approved")
+	assert.Contains(t, mock.LastEmail(), "Reason: Welcome aboard")
+}
+
+func TestLockedEmail(t *testing.T) {
+	mock := engine.NewMockEngine(nil)
+	service := New(mock, "test@test.com")
+	service.SendLockedEmail("to@to.com", &LockedData{
+		FailedLogins:   5,
+		UnlockLink:     "http://bla.com/unlock",
+		UnlockDuration: "24h",
+		EmailData: EmailData{
+			Company: "SimpleAuth",
+			BaseURL: "http://example.com",
+		},
+	})
+
+	assert.Equal(t, 1, mock.SendCount())
+	assert.Contains(t, mock.LastEmail(), "http://bla.com/unlock")
+	assert.Contains(t, mock.LastEmail(), "after 5 failed")
+	assert.NotContains(t, mock.LastEmail(), "unlock by itself")
+}
+
+func TestPasswordExpiringEmail(t *testing.T) {
+	mock := engine.NewMockEngine(nil)
+	service := New(mock, "test@test.com")
+	data := &PasswordExpiringData{
+		Expires:    "Mon, 02 Jan 2006",
+		MaxAgeDays: 90,
+		EmailData: EmailData{
+			Company: "SimpleAuth",
+			BaseURL: "http://example.com",
+		},
+	}
+	service.SendPasswordExpiringEmail("to@to.com", data)
+
+	assert.Equal(t, 1, mock.SendCount())
+	assert.Contains(t, mock.LastEmail(), "expires soon")
+	assert.Contains(t, mock.LastEmail(), "expires on Mon, 02 Jan 2006")
+	assert.Contains(t, mock.LastEmail(), "every 90 days")
+
+	data.Expired = true
+	service.SendPasswordExpiringEmail("to@to.com", data)
+	assert.Contains(t, mock.LastEmail(), "has expired")
+	assert.NotContains(t, mock.LastEmail(), "Once it expires")
+}
+
+func TestRecoveryCodeUsedEmail(t *testing.T) {
+	mock := engine.NewMockEngine(nil)
+	service := New(mock, "test@test.com")
+	data := &RecoveryCodeUsedData{
+		Remaining: 3,
+		EmailData: EmailData{
+			Company: "SimpleAuth",
+			BaseURL: "http://example.com",
+		},
+	}
+	service.SendRecoveryCodeUsedEmail("to@to.com", data)
+
+	assert.Equal(t, 1, mock.SendCount())
+	assert.Contains(t, mock.LastEmail(), "3 recovery codes left")
+
+	data.Remaining = 0
+	service.SendRecoveryCodeUsedEmail("to@to.com", data)
+	assert.Contains(t, mock.LastEmail(), "no recovery codes left")
+}
@@ -6,9 +6,15 @@ import (
 )
 
 var templateDefinitions = map[string][]string{
-	"welcome":        {"templates/email/welcome.tmpl"},
-	"forgotPassword": {"templates/email/forgotPassword.tmpl"},
-	"verification":   {"templates/email/verification.tmpl"},
+	"welcome":          {"templates/email/welcome.tmpl"},
+	"forgotPassword":   {"templates/email/forgotPassword.tmpl"},
+	"verification":     {"templates/email/verification.tmpl"},
+	"emailChange":      {"templates/email/emailChange.tmpl"},
+	"approved":         {"templates/email/approved.tmpl"},
+	"rejected":         {"templates/email/rejected.tmpl"},
+	"locked":           {"templates/email/locked.tmpl"},
+	"passwordExpiring": {"templates/email/passwordExpiring.tmpl"},
+	"recoveryCodeUsed": {"templates/email/recoveryCodeUsed.tmpl"},
 }
 var templateEngine multitemplate.TemplateRenderer
 
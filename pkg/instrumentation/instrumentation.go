@@ -2,4 +2,5 @@ package instrumentation
 
 type Counter interface {
 	Inc(values ...interface{})
+	Add(delta float64, values ...interface{})
 }
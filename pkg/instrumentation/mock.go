@@ -6,6 +6,8 @@ type mockCounter struct{}
 
 func (s *mockCounter) Inc(values ...interface{}) {}
 
+func (s *mockCounter) Add(delta float64, values ...interface{}) {}
+
 func NewCounter(name, help string, labels ...string) Counter {
 	return &mockCounter{}
 }
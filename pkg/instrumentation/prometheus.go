@@ -26,6 +26,10 @@ func (s *promCounter) Inc(values ...interface{}) {
 	s.WithLabelValues(mapIntfToStringArray(values)...).Inc()
 }
 
+func (s *promCounter) Add(delta float64, values ...interface{}) {
+	s.WithLabelValues(mapIntfToStringArray(values)...).Add(delta)
+}
+
 func mapIntfToStringArray(items []interface{}) []string {
 	sArr := make([]string, len(items))
 	for i, v := range items {
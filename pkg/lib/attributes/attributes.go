@@ -0,0 +1,310 @@
+// Package attributes validates custom profile attributes against the schema declared in config,
+// and maps them into token claims
+package attributes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"simple-auth/pkg/config"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Attribute types
+const (
+	TypeString = "string"
+	TypeNumber = "number"
+	TypeBool   = "bool"
+	TypeURL    = "url"
+)
+
+// MaxValueLength is the longest value, in characters, that can be stored
+const MaxValueLength = 1024
+
+var (
+	ErrUnknownAttribute = errors.New("unknown attribute")
+	ErrNotEditable      = errors.New("attribute is not editable")
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{0,63}$`)
+
+// reservedClaims are already set by the session JWT, ID token, or introspection response
+var reservedClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+	"src": true, "org": true, "roles": true, "groups": true, "email": true, "name": true, "username": true,
+	"active": true, "scope": true, "client_id": true, "token_type": true,
+}
+
+// Attribute is a single declared attribute
+type Attribute struct {
+	Name      string
+	Type      string
+	Claim     string
+	Scope     string
+	Session   bool
+	Editable  bool
+	AdminOnly bool
+	pattern   *regexp.Regexp
+}
+
+// Schema is the set of declared attributes. A nil schema has no attributes
+type Schema struct {
+	attributes []*Attribute
+	byName     map[string]*Attribute
+}
+
+// NewFromConfig validates the declared attributes, and builds a schema from them
+func NewFromConfig(cfg []*config.ConfigAccountAttribute) (*Schema, error) {
+	ret := &Schema{
+		byName: make(map[string]*Attribute, len(cfg)),
+	}
+	claims := make(map[string]bool, len(cfg))
+
+	for _, ac := range cfg {
+		if !validName.MatchString(ac.Name) {
+			return nil, fmt.Errorf("invalid attribute name '%s'", ac.Name)
+		}
+		if _, ok := ret.byName[ac.Name]; ok {
+			return nil, fmt.Errorf("attribute '%s' declared more than once", ac.Name)
+		}
+
+		attr := &Attribute{
+			Name:      ac.Name,
+			Type:      ac.Type,
+			Claim:     ac.Claim,
+			Scope:     ac.Scope,
+			Session:   ac.Session,
+			Editable:  ac.Editable && !ac.AdminOnly,
+			AdminOnly: ac.AdminOnly,
+		}
+		if attr.Type == "" {
+			attr.Type = TypeString
+		}
+		switch attr.Type {
+		case TypeString, TypeNumber, TypeBool, TypeURL:
+		default:
+			return nil, fmt.Errorf("attribute '%s' has unknown type '%s'", ac.Name, ac.Type)
+		}
+		if ac.Pattern != "" {
+			re, err := regexp.Compile(ac.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("attribute '%s' has invalid pattern: %v", ac.Name, err)
+			}
+			attr.pattern = re
+		}
+		if attr.Claim == "" {
+			attr.Claim = attr.Name
+		}
+		if !attr.AdminOnly && (attr.Session || attr.Scope != "") {
+			if reservedClaims[attr.Claim] {
+				return nil, fmt.Errorf("attribute '%s' can't use reserved claim '%s'", ac.Name, attr.Claim)
+			}
+			if claims[attr.Claim] {
+				return nil, fmt.Errorf("attribute '%s' uses claim '%s' more than once", ac.Name, attr.Claim)
+			}
+			claims[attr.Claim] = true
+		}
+
+		ret.attributes = append(ret.attributes, attr)
+		ret.byName[attr.Name] = attr
+	}
+
+	return ret, nil
+}
+
+// Attributes returns every declared attribute, in the order declared
+func (s *Schema) Attributes() []*Attribute {
+	if s == nil {
+		return nil
+	}
+	return s.attributes
+}
+
+// Get returns the named attribute, or nil if not declared
+func (s *Schema) Get(name string) *Attribute {
+	if s == nil {
+		return nil
+	}
+	return s.byName[name]
+}
+
+// Parse validates a set of JSON values to update, as the user or an admin, and returns them as stored
+func (s *Schema) Parse(values map[string]interface{}, admin bool) (map[string]*string, error) {
+	ret := make(map[string]*string, len(values))
+	for name, val := range values {
+		attr := s.Get(name)
+		if attr == nil || (attr.AdminOnly && !admin) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownAttribute, name)
+		}
+		if !attr.Editable && !admin {
+			return nil, fmt.Errorf("%w: %s", ErrNotEditable, name)
+		}
+
+		normalized, err := attr.Normalize(val)
+		if err != nil {
+			return nil, err
+		}
+		ret[name] = normalized
+	}
+	return ret, nil
+}
+
+// Normalize validates a JSON value for the attribute, and returns it as stored. nil, or an
+// empty string, returns nil to remove the value
+func (a *Attribute) Normalize(val interface{}) (*string, error) {
+	var s string
+	switch v := val.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		return nil, fmt.Errorf("%s: unsupported value", a.Name)
+	}
+
+	if s == "" {
+		return nil, nil
+	}
+	if err := a.Validate(s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Validate checks a stored value against the attribute's type and pattern
+func (a *Attribute) Validate(value string) error {
+	if utf8.RuneCountInString(value) > MaxValueLength {
+		return fmt.Errorf("%s: longer than %d characters", a.Name, MaxValueLength)
+	}
+
+	switch a.Type {
+	case TypeNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%s: not a number", a.Name)
+		}
+	case TypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s: not a boolean", a.Name)
+		}
+	case TypeURL:
+		u, err := url.ParseRequestURI(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s: not an http(s) url", a.Name)
+		}
+	}
+
+	if a.pattern != nil && !a.pattern.MatchString(value) {
+		return fmt.Errorf("%s: doesn't match pattern", a.Name)
+	}
+	return nil
+}
+
+// Value converts a stored value to its typed value. Values that no longer validate are returned as-is
+func (a *Attribute) Value(value string) interface{} {
+	switch a.Type {
+	case TypeNumber:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case TypeBool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// Visible returns the typed values that can be seen by the user, or, if admin, all of them.
+// Stored values for attributes that are no longer declared are omitted
+func (s *Schema) Visible(values map[string]string, admin bool) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for _, attr := range s.Attributes() {
+		if attr.AdminOnly && !admin {
+			continue
+		}
+		if val, ok := values[attr.Name]; ok {
+			ret[attr.Name] = attr.Value(val)
+		}
+	}
+	return ret
+}
+
+// HasSessionClaims is true if any attribute is included in the session JWT
+func (s *Schema) HasSessionClaims() bool {
+	for _, attr := range s.Attributes() {
+		if attr.Session && !attr.AdminOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionClaims returns the claims for the session JWT
+func (s *Schema) SessionClaims(values map[string]string) map[string]interface{} {
+	return s.claims(values, func(attr *Attribute) bool {
+		return attr.Session
+	})
+}
+
+// HasScopeClaims is true if any attribute is granted by an OAuth2 token's scopes
+func (s *Schema) HasScopeClaims(hasScope func(scope string) bool) bool {
+	for _, attr := range s.Attributes() {
+		if attr.Scope != "" && !attr.AdminOnly && hasScope(attr.Scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeClaims returns the claims granted by an OAuth2 token's scopes
+func (s *Schema) ScopeClaims(values map[string]string, hasScope func(scope string) bool) map[string]interface{} {
+	return s.claims(values, func(attr *Attribute) bool {
+		return attr.Scope != "" && hasScope(attr.Scope)
+	})
+}
+
+func (s *Schema) claims(values map[string]string, include func(attr *Attribute) bool) map[string]interface{} {
+	var ret map[string]interface{}
+	for _, attr := range s.Attributes() {
+		if attr.AdminOnly || !include(attr) {
+			continue
+		}
+		if val, ok := values[attr.Name]; ok {
+			if ret == nil {
+				ret = make(map[string]interface{})
+			}
+			ret[attr.Claim] = attr.Value(val)
+		}
+	}
+	return ret
+}
+
+// MarshalWithClaims marshals v, a JSON object, with the additional claims merged in at the top level.
+// Existing keys are never overwritten
+func MarshalWithClaims(v interface{}, claims map[string]interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil || len(claims) == 0 {
+		return b, err
+	}
+
+	var merged map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&merged); err != nil {
+		return nil, err
+	}
+	for k, val := range claims {
+		if _, ok := merged[k]; !ok {
+			merged[k] = val
+		}
+	}
+	return json.Marshal(merged)
+}
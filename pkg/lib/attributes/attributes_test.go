@@ -0,0 +1,147 @@
+package attributes
+
+import (
+	"encoding/json"
+	"errors"
+	"simple-auth/pkg/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSchema(t *testing.T) *Schema {
+	schema, err := NewFromConfig([]*config.ConfigAccountAttribute{
+		{Name: "locale", Pattern: `^[a-z]{2}(-[A-Z]{2})?$`, Editable: true, Scope: "profile", Session: true},
+		{Name: "department", Editable: false, Scope: "profile", Claim: "dept"},
+		{Name: "employeeId", Type: TypeNumber, AdminOnly: true, Scope: "profile"},
+		{Name: "avatar", Type: TypeURL, Editable: true, Claim: "picture", Scope: "picture"},
+		{Name: "contractor", Type: TypeBool, Editable: true, Session: true},
+	})
+	assert.NoError(t, err)
+	return schema
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, cfg := range [][]*config.ConfigAccountAttribute{
+		{{Name: ""}},
+		{{Name: "has space"}},
+		{{Name: "a"}, {Name: "a"}},
+		{{Name: "a", Type: "date"}},
+		{{Name: "a", Pattern: "("}},
+		{{Name: "a", Claim: "sub", Session: true}},
+		{{Name: "a", Scope: "x"}, {Name: "b", Claim: "a", Scope: "y"}},
+	} {
+		_, err := NewFromConfig(cfg)
+		assert.Error(t, err, "%+v", cfg[len(cfg)-1])
+	}
+
+	// Reserved claims are fine if never put in a token
+	_, err := NewFromConfig([]*config.ConfigAccountAttribute{{Name: "email"}})
+	assert.NoError(t, err)
+}
+
+func TestParse(t *testing.T) {
+	schema := testSchema(t)
+
+	values, err := schema.Parse(map[string]interface{}{
+		"locale":     "en-US",
+		"avatar":     "https://example.com/me.png",
+		"contractor": true,
+	}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "en-US", *values["locale"])
+	assert.Equal(t, "true", *values["contractor"])
+
+	values, err = schema.Parse(map[string]interface{}{"locale": nil, "avatar": ""}, false)
+	assert.NoError(t, err)
+	assert.Len(t, values, 2)
+	assert.Nil(t, values["locale"])
+	assert.Nil(t, values["avatar"])
+
+	_, err = schema.Parse(map[string]interface{}{"locale": "english"}, false)
+	assert.Error(t, err)
+	_, err = schema.Parse(map[string]interface{}{"avatar": "javascript:alert(1)"}, false)
+	assert.Error(t, err)
+	_, err = schema.Parse(map[string]interface{}{"contractor": "maybe"}, false)
+	assert.Error(t, err)
+	_, err = schema.Parse(map[string]interface{}{"nope": "x"}, false)
+	assert.True(t, errors.Is(err, ErrUnknownAttribute))
+
+	// Non-editable and admin-only
+	_, err = schema.Parse(map[string]interface{}{"department": "Sales"}, false)
+	assert.True(t, errors.Is(err, ErrNotEditable))
+	_, err = schema.Parse(map[string]interface{}{"employeeId": 1234}, false)
+	assert.True(t, errors.Is(err, ErrUnknownAttribute))
+
+	values, err = schema.Parse(map[string]interface{}{"department": "Sales", "employeeId": float64(1234)}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", *values["employeeId"])
+	_, err = schema.Parse(map[string]interface{}{"employeeId": "abc"}, true)
+	assert.Error(t, err)
+}
+
+func TestVisibleAndClaims(t *testing.T) {
+	schema := testSchema(t)
+	values := map[string]string{
+		"locale":     "en-US",
+		"department": "Sales",
+		"employeeId": "1234",
+		"avatar":     "https://example.com/me.png",
+		"contractor": "false",
+		"removed":    "stale",
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"locale":     "en-US",
+		"department": "Sales",
+		"avatar":     "https://example.com/me.png",
+		"contractor": false,
+	}, schema.Visible(values, false))
+	assert.Equal(t, float64(1234), schema.Visible(values, true)["employeeId"])
+
+	assert.True(t, schema.HasSessionClaims())
+	assert.Equal(t, map[string]interface{}{
+		"locale":     "en-US",
+		"contractor": false,
+	}, schema.SessionClaims(values))
+
+	profile := func(scope string) bool { return scope == "profile" }
+	assert.True(t, schema.HasScopeClaims(profile))
+	assert.Equal(t, map[string]interface{}{
+		"locale": "en-US",
+		"dept":   "Sales",
+	}, schema.ScopeClaims(values, profile))
+
+	none := func(scope string) bool { return false }
+	assert.False(t, schema.HasScopeClaims(none))
+	assert.Nil(t, schema.ScopeClaims(values, none))
+}
+
+func TestNilSchema(t *testing.T) {
+	var schema *Schema
+	assert.Nil(t, schema.Get("locale"))
+	assert.False(t, schema.HasSessionClaims())
+	assert.Empty(t, schema.Visible(map[string]string{"locale": "en"}, true))
+	_, err := schema.Parse(map[string]interface{}{"locale": "en"}, false)
+	assert.Error(t, err)
+}
+
+func TestMarshalWithClaims(t *testing.T) {
+	type claims struct {
+		Subject string `json:"sub"`
+		Expires int64  `json:"exp"`
+	}
+
+	b, err := MarshalWithClaims(claims{"abc", 1600000000}, map[string]interface{}{
+		"sub":    "overwritten",
+		"locale": "en-US",
+	})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"sub":"abc","exp":1600000000,"locale":"en-US"}`, string(b))
+
+	b, err = MarshalWithClaims(claims{"abc", 1}, nil)
+	assert.NoError(t, err)
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Len(t, decoded, 2)
+}
@@ -0,0 +1,123 @@
+// Package auditsink forwards audit records to destinations outside of the database (eg. a SIEM)
+package auditsink
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Event is a single audit record, as sent to a sink
+type Event struct {
+	ID            uint      `json:"id"`
+	Timestamp     time.Time `json:"ts"`
+	Account       string    `json:"account"` // UUID
+	Module        string    `json:"module"`
+	Level         string    `json:"level"`
+	Message       string    `json:"message"`
+	IP            string    `json:"ip,omitempty"`
+	UserAgent     string    `json:"userAgent,omitempty"`
+	CorrelationID string    `json:"correlationId,omitempty"`
+}
+
+// Sink receives audit events. Send must never block the caller
+type Sink interface {
+	Send(event *Event)
+	// Close flushes any pending events, and releases the sink
+	Close() error
+}
+
+// writer synchronously delivers a single event
+type writer interface {
+	Name() string
+	Write(event *Event) error
+	Close() error
+}
+
+const (
+	asyncBufferSize   = 1024
+	asyncCloseTimeout = 10 * time.Second
+)
+
+// asyncSink delivers events to a writer in the background, so a slow destination
+// doesn't slow down requests.  Events are dropped if the buffer is full
+type asyncSink struct {
+	w     writer
+	queue chan *Event
+	done  chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newAsyncSink(w writer) Sink {
+	s := &asyncSink{
+		w:     w,
+		queue: make(chan *Event, asyncBufferSize),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *asyncSink) run() {
+	defer close(s.done)
+	for event := range s.queue {
+		if err := s.w.Write(event); err != nil {
+			logrus.Warnf("Audit sink %s failed to write event %d: %v", s.w.Name(), event.ID, err)
+		}
+	}
+}
+
+func (s *asyncSink) Send(event *Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.queue <- event:
+	default:
+		logrus.Warnf("Audit sink %s is full, dropping event %d", s.w.Name(), event.ID)
+	}
+}
+
+func (s *asyncSink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+	case <-time.After(asyncCloseTimeout):
+		logrus.Warnf("Timed out flushing audit sink %s", s.w.Name())
+	}
+	return s.w.Close()
+}
+
+type fanout []Sink
+
+// Fanout sends every event to each of the sinks
+func Fanout(sinks ...Sink) Sink {
+	return fanout(sinks)
+}
+
+func (s fanout) Send(event *Event) {
+	for _, sink := range s {
+		sink.Send(event)
+	}
+}
+
+func (s fanout) Close() (err error) {
+	for _, sink := range s {
+		if cerr := sink.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return
+}
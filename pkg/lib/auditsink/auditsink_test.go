@@ -0,0 +1,147 @@
+package auditsink
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEvent(id uint) *Event {
+	return &Event{
+		ID:        id,
+		Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Account:   "00000000-0000-0000-0000-000000000000",
+		Module:    "auth:simple",
+		Level:     "alert",
+		Message:   `Bad "password" [attempt]`,
+		IP:        "127.0.0.1",
+	}
+}
+
+func TestFileSinkRotates(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "auditsink")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	line, _ := json.Marshal(testEvent(1))
+	w, err := newFileWriter(path, int64(len(line)+1)*2, 2)
+	assert.NoError(t, err)
+	for i := uint(1); i <= 7; i++ {
+		assert.NoError(t, w.Write(testEvent(i)))
+	}
+	assert.NoError(t, w.Close())
+
+	readIDs := func(path string) (ids []uint) {
+		f, err := os.Open(path)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var event Event
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+			ids = append(ids, event.ID)
+		}
+		return
+	}
+
+	assert.Equal(t, []uint{7}, readIDs(path))
+	assert.Equal(t, []uint{5, 6}, readIDs(path+".1"))
+	assert.Equal(t, []uint{3, 4}, readIDs(path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAsyncSinkFlushesOnClose(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "auditsink")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	sink, err := NewFile(path, 0, 0)
+	assert.NoError(t, err)
+	for i := uint(1); i <= 10; i++ {
+		sink.Send(testEvent(i))
+	}
+	assert.NoError(t, sink.Close())
+	sink.Send(testEvent(11)) // Dropped after close
+
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal(t, 10, strings.Count(string(data), "\n"))
+}
+
+func TestSyslogFormat(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	w, err := newSyslogWriter("udp", conn.LocalAddr().String(), "sa", "authpriv")
+	assert.NoError(t, err)
+	defer w.Close()
+	w.hostname = "host"
+
+	assert.NoError(t, w.Write(testEvent(1)))
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err)
+
+	msg := string(buf[:n])
+	assert.True(t, strings.HasPrefix(msg, "<81>1 2020-01-02T03:04:05.000000Z host sa "), msg)
+	assert.Contains(t, msg, ` auth:simple [audit@32473 id="1" account="00000000-0000-0000-0000-000000000000" level="alert" ip="127.0.0.1"] Bad "password" [attempt]`)
+}
+
+func TestSyslogOctetCounting(t *testing.T) {
+	w := &syslogWriter{network: "tcp"}
+	assert.Equal(t, "5 hello", w.frame("hello"))
+	w.network = "udp"
+	assert.Equal(t, "hello", w.frame("hello"))
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event Event
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		assert.Equal(t, uint(1), event.ID)
+		assert.True(t, strings.HasPrefix(r.Header.Get(webhookSignatureHeader), "sha256="))
+	}))
+	defer server.Close()
+
+	w := newWebhookWriter(server.URL, "secret", time.Second, 3)
+	w.backoff = time.Millisecond
+	assert.NoError(t, w.Write(testEvent(1)))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestWebhookDoesntRetryClientErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	w := newWebhookWriter(server.URL, "", time.Second, 3)
+	w.backoff = time.Millisecond
+	assert.Error(t, w.Write(testEvent(1)))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,56 @@
+package auditsink
+
+import (
+	"fmt"
+	"simple-auth/pkg/config"
+	"time"
+)
+
+const defaultWebhookTimeout = 10 * time.Second
+
+// NewFromConfig creates a sink for each enabled destination. Returns nil if there are none
+func NewFromConfig(cfg *config.ConfigAuditSinks) (Sink, error) {
+	var sinks []Sink
+
+	if cfg.File.Enabled {
+		if cfg.File.Path == "" {
+			return nil, fmt.Errorf("audit file sink requires a path")
+		}
+		sink, err := NewFile(cfg.File.Path, int64(cfg.File.MaxSizeMB)*1024*1024, cfg.File.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if cfg.Syslog.Enabled {
+		sink, err := NewSyslog(cfg.Syslog.Network, cfg.Syslog.Address, cfg.Syslog.Tag, cfg.Syslog.Facility)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if cfg.Webhook.Enabled {
+		if cfg.Webhook.URL == "" {
+			return nil, fmt.Errorf("audit webhook sink requires a url")
+		}
+		timeout := defaultWebhookTimeout
+		if cfg.Webhook.Timeout != "" {
+			var err error
+			if timeout, err = time.ParseDuration(cfg.Webhook.Timeout); err != nil {
+				return nil, fmt.Errorf("invalid audit webhook timeout: %w", err)
+			}
+		}
+		sinks = append(sinks, NewWebhook(cfg.Webhook.URL, cfg.Webhook.SharedSecret, timeout, cfg.Webhook.Retries))
+	}
+
+	switch len(sinks) {
+	case 0:
+		return nil, nil
+	case 1:
+		return sinks[0], nil
+	default:
+		return Fanout(sinks...), nil
+	}
+}
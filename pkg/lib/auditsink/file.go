@@ -0,0 +1,112 @@
+package auditsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// fileWriter appends events as JSON lines, rotating the file once it reaches maxSize.
+// Rotated files are renamed with a numeric suffix (.1 being the newest), keeping maxBackups
+type fileWriter struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func newFileWriter(path string, maxSize int64, maxBackups int) (*fileWriter, error) {
+	w := &fileWriter{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewFile creates a sink that appends JSON lines to path. A maxSize of 0 never rotates
+func NewFile(path string, maxSize int64, maxBackups int) (Sink, error) {
+	w, err := newFileWriter(path, maxSize, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return newAsyncSink(w), nil
+}
+
+func (s *fileWriter) Name() string {
+	return "file"
+}
+
+func (s *fileWriter) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = stat.Size()
+	return nil
+}
+
+func (s *fileWriter) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+
+	if s.maxBackups <= 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxBackups))
+		for i := s.maxBackups - 1; i >= 1; i-- {
+			err := os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return err
+		}
+	}
+
+	return s.open()
+}
+
+func (s *fileWriter) Write(event *Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileWriter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,103 @@
+package auditsink
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	webhookSignatureHeader = "X-Simple-Auth-Signature"
+	webhookInitialBackoff  = 1 * time.Second
+)
+
+// webhookWriter POSTs each event as JSON, retrying with exponential backoff on
+// network errors, 429s, and 5xx responses
+type webhookWriter struct {
+	url     string
+	secret  []byte
+	retries int
+	backoff time.Duration
+	client  *http.Client
+}
+
+// NewWebhook creates a sink that POSTs each event to url.  If secret is set, the body is signed
+// with HMAC-SHA256 in the X-Simple-Auth-Signature header
+func NewWebhook(url, secret string, timeout time.Duration, retries int) Sink {
+	return newAsyncSink(newWebhookWriter(url, secret, timeout, retries))
+}
+
+func newWebhookWriter(url, secret string, timeout time.Duration, retries int) *webhookWriter {
+	return &webhookWriter{
+		url:     url,
+		secret:  []byte(secret),
+		retries: retries,
+		backoff: webhookInitialBackoff,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func (s *webhookWriter) Name() string {
+	return "webhook"
+}
+
+func (s *webhookWriter) sign(body []byte) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// post sends the body once, returning whether a failure can be retried
+func (s *webhookWriter) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.secret) > 0 {
+		req.Header.Set(webhookSignatureHeader, s.sign(body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook returned %s", resp.Status)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+func (s *webhookWriter) Write(event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil || !retry || attempt >= s.retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *webhookWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,47 @@
+package checkpoint
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"simple-auth/pkg/db"
+	"time"
+)
+
+var ErrEmptyChain = errors.New("audit chain is empty")
+
+// SignHead seals any committed audit records onto the chain, and signs a checkpoint of its newest link
+func (s *Signer) SignHead(chain db.AuditChain) (*Checkpoint, string, error) {
+	if _, err := chain.SealAuditChain(); err != nil {
+		return nil, "", err
+	}
+	head, err := chain.AuditChainHead()
+	if err != nil {
+		return nil, "", err
+	}
+	if head == nil {
+		return nil, "", ErrEmptyChain
+	}
+
+	cp := &Checkpoint{
+		Index:   head.Index,
+		Hash:    head.Hash,
+		Created: time.Now(),
+	}
+	signed, err := s.Sign(cp)
+	if err != nil {
+		return nil, "", err
+	}
+	return cp, signed, nil
+}
+
+// AppendFile appends a signed checkpoint to a file of checkpoints, one per line
+func AppendFile(path, signed string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, signed)
+	return err
+}
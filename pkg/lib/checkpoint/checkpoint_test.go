@@ -0,0 +1,63 @@
+package checkpoint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"simple-auth/pkg/db"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	signer, err := NewSigner("hs256", "my-checkpoint-secret")
+	assert.NoError(t, err)
+
+	cp := &Checkpoint{Index: 42, Hash: "abcdef", Created: time.Unix(1600000000, 0)}
+	signed, err := signer.Sign(cp)
+	assert.NoError(t, err)
+
+	verified, err := signer.Verify(signed)
+	assert.NoError(t, err)
+	assert.Equal(t, cp.Index, verified.Index)
+	assert.Equal(t, cp.Hash, verified.Hash)
+	assert.True(t, cp.Created.Equal(verified.Created))
+
+	other, _ := NewSigner("hs256", "some-other-secret")
+	_, err = other.Verify(signed)
+	assert.Error(t, err)
+}
+
+func TestInvalidSigner(t *testing.T) {
+	_, err := NewSigner("nope", "my-checkpoint-secret")
+	assert.Error(t, err)
+	_, err = NewSigner("hs256", "")
+	assert.Error(t, err)
+	_, err = NewSigner("rs256", "not-a-pem")
+	assert.Error(t, err)
+}
+
+func TestSignHead(t *testing.T) {
+	sadb := db.New("sqlite3", "file:checkpoint?mode=memory&cache=shared")
+	signer, _ := NewSigner("hs256", "my-checkpoint-secret")
+
+	_, _, err := signer.SignHead(sadb)
+	assert.Equal(t, ErrEmptyChain, err)
+
+	sadb.CreateAccount("test", "checkpoint@asdf.com")
+	cp, signed, err := signer.SignHead(sadb)
+	assert.NoError(t, err)
+	head, _ := sadb.AuditChainHead()
+	assert.Equal(t, head.Index, cp.Index)
+	assert.Equal(t, head.Hash, cp.Hash)
+
+	dir, _ := ioutil.TempDir("", "checkpoint")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoints.jwt")
+	assert.NoError(t, AppendFile(path, signed))
+	assert.NoError(t, AppendFile(path, signed))
+	contents, _ := ioutil.ReadFile(path)
+	assert.Equal(t, signed+"\n"+signed+"\n", string(contents))
+}
@@ -0,0 +1,16 @@
+package checkpoint
+
+import (
+	"errors"
+	"simple-auth/pkg/config"
+)
+
+var ErrNotConfigured = errors.New("audit checkpoint signing key isn't configured")
+
+// NewFromConfig creates the configured signer
+func NewFromConfig(cfg *config.ConfigAuditCheckpoint) (*Signer, error) {
+	if cfg.SigningKey == "" {
+		return nil, ErrNotConfigured
+	}
+	return NewSigner(cfg.SigningMethod, cfg.SigningKey)
+}
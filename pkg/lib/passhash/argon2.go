@@ -0,0 +1,75 @@
+package passhash
+
+import (
+	"golang.org/x/crypto/argon2"
+)
+
+const AlgorithmArgon2id = "argon2id"
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+type argon2idHasher struct {
+	time    uint32
+	memory  uint32 // KiB
+	threads uint8
+}
+
+// NewArgon2id creates an argon2id hasher. Memory is in KiB
+func NewArgon2id(time, memory uint32, threads uint8) Hasher {
+	return &argon2idHasher{time, memory, threads}
+}
+
+func (s *argon2idHasher) Hash(password string) (string, error) {
+	salt, err := newSalt(argon2SaltLength)
+	if err != nil {
+		return "", err
+	}
+
+	h := &phcHash{
+		ID:      AlgorithmArgon2id,
+		Version: argon2.Version,
+		Params: map[string]int{
+			"m": int(s.memory),
+			"t": int(s.time),
+			"p": int(s.threads),
+		},
+		Salt: salt,
+		Hash: argon2.IDKey([]byte(password), salt, s.time, s.memory, s.threads, argon2KeyLength),
+	}
+	return h.String("m", "t", "p"), nil
+}
+
+func (s *argon2idHasher) NeedsRehash(encoded string) bool {
+	if algorithmOf(encoded) != AlgorithmArgon2id {
+		return true
+	}
+	h, err := parsePHC(encoded)
+	if err != nil {
+		return true
+	}
+	return h.Version != argon2.Version ||
+		h.Params["m"] < int(s.memory) ||
+		h.Params["t"] < int(s.time) ||
+		h.Params["p"] < int(s.threads) ||
+		len(h.Hash) < argon2KeyLength
+}
+
+func verifyArgon2id(encoded, password string) (bool, error) {
+	h, err := parsePHC(encoded)
+	if err != nil {
+		return false, err
+	}
+	if h.Version != argon2.Version {
+		return false, ErrMalformedHash
+	}
+	m, t, p := h.Params["m"], h.Params["t"], h.Params["p"]
+	if m <= 0 || t <= 0 || p <= 0 || p > 255 {
+		return false, ErrMalformedHash
+	}
+
+	key := argon2.IDKey([]byte(password), h.Salt, uint32(t), uint32(m), uint8(p), uint32(len(h.Hash)))
+	return constantTimeEqual(key, h.Hash), nil
+}
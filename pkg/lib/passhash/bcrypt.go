@@ -0,0 +1,49 @@
+package passhash
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+const AlgorithmBcrypt = "bcrypt"
+
+type bcryptHasher struct {
+	cost int
+}
+
+// NewBcrypt creates a bcrypt hasher. A cost of 0 uses bcrypt's default
+func NewBcrypt(cost int) Hasher {
+	if cost <= 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return &bcryptHasher{cost}
+}
+
+func (s *bcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func (s *bcryptHasher) NeedsRehash(encoded string) bool {
+	if algorithmOf(encoded) != AlgorithmBcrypt {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return true
+	}
+	return cost < s.cost
+}
+
+func verifyBcrypt(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,26 @@
+package passhash
+
+import (
+	"fmt"
+	"simple-auth/pkg/config"
+	"strings"
+)
+
+// NewFromConfig creates the hasher for the configured password policy
+func NewFromConfig(cfg *config.ConfigPasswordHash) (Hasher, error) {
+	switch strings.ToLower(cfg.Algorithm) {
+	case AlgorithmBcrypt:
+		return NewBcrypt(cfg.Bcrypt.Cost), nil
+	case AlgorithmArgon2id:
+		if cfg.Argon2id.Time == 0 || cfg.Argon2id.Memory == 0 || cfg.Argon2id.Threads == 0 {
+			return nil, fmt.Errorf("invalid argon2id parameters: %+v", cfg.Argon2id)
+		}
+		return NewArgon2id(cfg.Argon2id.Time, cfg.Argon2id.Memory, cfg.Argon2id.Threads), nil
+	case AlgorithmScrypt:
+		if cfg.Scrypt.N <= 1 || cfg.Scrypt.N&(cfg.Scrypt.N-1) != 0 || cfg.Scrypt.R <= 0 || cfg.Scrypt.P <= 0 {
+			return nil, fmt.Errorf("invalid scrypt parameters: %+v", cfg.Scrypt)
+		}
+		return NewScrypt(cfg.Scrypt.N, cfg.Scrypt.R, cfg.Scrypt.P), nil
+	}
+	return nil, fmt.Errorf("unknown password hash algorithm '%s'", cfg.Algorithm)
+}
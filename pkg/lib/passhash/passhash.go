@@ -0,0 +1,152 @@
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Hasher creates encoded password hashes under a given policy (algorithm and parameters)
+type Hasher interface {
+	// Hash returns the encoded hash of the password
+	Hash(password string) (string, error)
+	// NeedsRehash is true if the encoded hash was created with a different algorithm,
+	// or weaker parameters, than this hasher
+	NeedsRehash(encoded string) bool
+}
+
+type verifier func(encoded, password string) (bool, error)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+	ErrMalformedHash    = errors.New("malformed password hash")
+)
+
+var phcEncoding = base64.RawStdEncoding
+
+// Verify checks the password against an encoded hash of any supported algorithm
+func Verify(encoded, password string) (bool, error) {
+	verify, ok := verifiers[algorithmOf(encoded)]
+	if !ok {
+		return false, ErrUnknownAlgorithm
+	}
+	return verify(encoded, password)
+}
+
+// Supported is true if the encoded hash is of an algorithm that can be verified
+func Supported(encoded string) bool {
+	_, ok := verifiers[algorithmOf(encoded)]
+	return ok
+}
+
+var verifiers = map[string]verifier{
+	AlgorithmBcrypt:   verifyBcrypt,
+	AlgorithmArgon2id: verifyArgon2id,
+	AlgorithmScrypt:   verifyScrypt,
+}
+
+// algorithmOf returns the algorithm identifier of a PHC (or bcrypt MCF) string
+func algorithmOf(encoded string) string {
+	parts := strings.SplitN(encoded, "$", 3)
+	if len(parts) < 3 || parts[0] != "" {
+		return ""
+	}
+	switch parts[1] {
+	case "2a", "2b", "2y":
+		return AlgorithmBcrypt
+	}
+	return parts[1]
+}
+
+// phcHash is a parsed PHC string: $id[$v=version][$param=value,...]$salt$hash
+type phcHash struct {
+	ID      string
+	Version int
+	Params  map[string]int
+	Salt    []byte
+	Hash    []byte
+}
+
+func parsePHC(encoded string) (*phcHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) < 4 || parts[0] != "" {
+		return nil, ErrMalformedHash
+	}
+
+	ret := &phcHash{
+		ID:     parts[1],
+		Params: make(map[string]int),
+	}
+	fields := parts[2 : len(parts)-2]
+
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "v=") {
+		v, err := strconv.Atoi(strings.TrimPrefix(fields[0], "v="))
+		if err != nil {
+			return nil, ErrMalformedHash
+		}
+		ret.Version = v
+		fields = fields[1:]
+	}
+	if len(fields) > 1 {
+		return nil, ErrMalformedHash
+	}
+	if len(fields) == 1 {
+		for _, kv := range strings.Split(fields[0], ",") {
+			pair := strings.SplitN(kv, "=", 2)
+			if len(pair) != 2 {
+				return nil, ErrMalformedHash
+			}
+			v, err := strconv.Atoi(pair[1])
+			if err != nil {
+				return nil, ErrMalformedHash
+			}
+			ret.Params[pair[0]] = v
+		}
+	}
+
+	var err error
+	if ret.Salt, err = phcEncoding.DecodeString(parts[len(parts)-2]); err != nil {
+		return nil, ErrMalformedHash
+	}
+	if ret.Hash, err = phcEncoding.DecodeString(parts[len(parts)-1]); err != nil {
+		return nil, ErrMalformedHash
+	}
+
+	return ret, nil
+}
+
+func (s *phcHash) String(params ...string) string {
+	var sb strings.Builder
+	sb.WriteString("$" + s.ID)
+	if s.Version > 0 {
+		sb.WriteString(fmt.Sprintf("$v=%d", s.Version))
+	}
+	if len(params) > 0 {
+		sb.WriteString("$")
+		for i, name := range params {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString(fmt.Sprintf("%s=%d", name, s.Params[name]))
+		}
+	}
+	sb.WriteString("$" + phcEncoding.EncodeToString(s.Salt))
+	sb.WriteString("$" + phcEncoding.EncodeToString(s.Hash))
+	return sb.String()
+}
+
+func newSalt(length int) ([]byte, error) {
+	salt := make([]byte, length)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+func constantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
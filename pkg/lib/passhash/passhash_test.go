@@ -0,0 +1,66 @@
+package passhash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testHasher(t *testing.T, hasher Hasher, prefix string) {
+	encoded, err := hasher.Hash("my-password")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, prefix), encoded)
+
+	ok, err := Verify(encoded, "my-password")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = Verify(encoded, "not-my-password")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.False(t, hasher.NeedsRehash(encoded))
+}
+
+func TestBcrypt(t *testing.T) {
+	testHasher(t, NewBcrypt(4), "$2a$04$")
+}
+
+func TestArgon2id(t *testing.T) {
+	testHasher(t, NewArgon2id(1, 1024, 1), "$argon2id$v=19$m=1024,t=1,p=1$")
+}
+
+func TestScrypt(t *testing.T) {
+	testHasher(t, NewScrypt(1024, 8, 1), "$scrypt$ln=10,r=8,p=1$")
+}
+
+func TestNeedsRehash(t *testing.T) {
+	weakBcrypt, _ := NewBcrypt(4).Hash("pass")
+	assert.True(t, NewBcrypt(5).NeedsRehash(weakBcrypt))
+	assert.True(t, NewArgon2id(1, 1024, 1).NeedsRehash(weakBcrypt))
+
+	weakArgon, _ := NewArgon2id(1, 1024, 1).Hash("pass")
+	assert.False(t, NewArgon2id(1, 512, 1).NeedsRehash(weakArgon))
+	assert.True(t, NewArgon2id(1, 2048, 1).NeedsRehash(weakArgon))
+	assert.True(t, NewArgon2id(2, 1024, 1).NeedsRehash(weakArgon))
+	assert.True(t, NewScrypt(1024, 8, 1).NeedsRehash(weakArgon))
+
+	weakScrypt, _ := NewScrypt(1024, 8, 1).Hash("pass")
+	assert.True(t, NewScrypt(2048, 8, 1).NeedsRehash(weakScrypt))
+}
+
+func TestSupported(t *testing.T) {
+	assert.True(t, Supported("$2y$05$KyQ9xRr6eTK1Tw8yDXy9z.7Bzvm/6LkmBzLJZmtnVdUTqSS0bg2Sy"))
+	assert.True(t, Supported("$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$aGFzaA"))
+	assert.False(t, Supported("$apr1$salt$hash"))
+	assert.False(t, Supported("{SHA}hash"))
+}
+
+func TestVerifyUnknown(t *testing.T) {
+	_, err := Verify("plaintext", "plaintext")
+	assert.Equal(t, ErrUnknownAlgorithm, err)
+
+	_, err = Verify("$argon2id$v=19$m=bad$salt$hash", "pass")
+	assert.Error(t, err)
+}
@@ -0,0 +1,80 @@
+package passhash
+
+import (
+	"math/bits"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const AlgorithmScrypt = "scrypt"
+
+const (
+	scryptSaltLength = 16
+	scryptKeyLength  = 32
+)
+
+type scryptHasher struct {
+	logN int // Cost, as log2(N)
+	r    int
+	p    int
+}
+
+// NewScrypt creates an scrypt hasher. N must be a power of 2
+func NewScrypt(n, r, p int) Hasher {
+	return &scryptHasher{bits.Len(uint(n)) - 1, r, p}
+}
+
+func (s *scryptHasher) Hash(password string) (string, error) {
+	salt, err := newSalt(scryptSaltLength)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<s.logN, s.r, s.p, scryptKeyLength)
+	if err != nil {
+		return "", err
+	}
+
+	h := &phcHash{
+		ID: AlgorithmScrypt,
+		Params: map[string]int{
+			"ln": s.logN,
+			"r":  s.r,
+			"p":  s.p,
+		},
+		Salt: salt,
+		Hash: key,
+	}
+	return h.String("ln", "r", "p"), nil
+}
+
+func (s *scryptHasher) NeedsRehash(encoded string) bool {
+	if algorithmOf(encoded) != AlgorithmScrypt {
+		return true
+	}
+	h, err := parsePHC(encoded)
+	if err != nil {
+		return true
+	}
+	return h.Params["ln"] < s.logN ||
+		h.Params["r"] < s.r ||
+		h.Params["p"] < s.p ||
+		len(h.Hash) < scryptKeyLength
+}
+
+func verifyScrypt(encoded, password string) (bool, error) {
+	h, err := parsePHC(encoded)
+	if err != nil {
+		return false, err
+	}
+	ln, r, p := h.Params["ln"], h.Params["r"], h.Params["p"]
+	if ln <= 0 || ln >= 32 || r <= 0 || p <= 0 {
+		return false, ErrMalformedHash
+	}
+
+	key, err := scrypt.Key([]byte(password), h.Salt, 1<<ln, r, p, len(h.Hash))
+	if err != nil {
+		return false, err
+	}
+	return constantTimeEqual(key, h.Hash), nil
+}
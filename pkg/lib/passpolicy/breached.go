@@ -0,0 +1,143 @@
+package passpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachedList is a list of passwords known to have appeared in data breaches
+type BreachedList interface {
+	Contains(password string) (bool, error)
+}
+
+const hashPrefixLength = 5
+
+// OpenBreachedList opens a list in the format published by Have I Been Pwned, of uppercase SHA-1 hashes,
+// each optionally followed by `:count`. Either a single file, sorted by hash, of full hashes; or a directory
+// of files named by the first 5 characters of the hash (eg. `21BD1.txt`), each listing the rest of the hash
+func OpenBreachedList(path string) (BreachedList, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open breached password list: %w", err)
+	}
+	if stat.IsDir() {
+		return &breachedPrefixDir{path}, nil
+	}
+	return &breachedHashFile{path}, nil
+}
+
+func hashPassword(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// hashOfLine returns the hash part of a `HASH:count` line
+func hashOfLine(line string) string {
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.ToUpper(strings.TrimSpace(line))
+}
+
+type breachedPrefixDir struct {
+	path string
+}
+
+func (s *breachedPrefixDir) Contains(password string) (bool, error) {
+	hash := hashPassword(password)
+
+	f, err := os.Open(filepath.Join(s.path, hash[:hashPrefixLength]+".txt"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	suffix := hash[hashPrefixLength:]
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if hashOfLine(scanner.Text()) == suffix {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+type breachedHashFile struct {
+	path string
+}
+
+// Contains binary searches the file, since the full list is far too large to scan
+func (s *breachedHashFile) Contains(password string) (bool, error) {
+	hash := hashPassword(password)
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	// lo is always the start of a line
+	lo, hi := int64(0), stat.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, err := lineAt(f, mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+
+		switch lineHash := hashOfLine(line); {
+		case lineHash == hash:
+			return true, nil
+		case lineHash < hash:
+			lo = start + int64(len(line)) + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// lineAt reads the first line that starts at, or after, offset. start is the end of the file if there is none
+func lineAt(f io.ReaderAt, offset int64) (start int64, line string, err error) {
+	start = offset
+	if offset > 0 {
+		// Unless offset is the start of a line, skip to the next
+		start = offset - 1
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(f, start, 1<<62))
+	if offset > 0 {
+		skipped, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return start + int64(len(skipped)), "", nil
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		start += int64(len(skipped))
+	}
+
+	line, err = reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, "", err
+	}
+	return start, strings.TrimSuffix(line, "\n"), nil
+}
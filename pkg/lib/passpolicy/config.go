@@ -0,0 +1,26 @@
+package passpolicy
+
+import (
+	"simple-auth/pkg/config"
+)
+
+// NewFromConfig builds the policy from the length requirements and password policy
+func NewFromConfig(requirements *config.ConfigLocalLoginRequirements, cfg *config.ConfigPasswordPolicy) (*Policy, error) {
+	policy := &Policy{
+		MinLength:      requirements.PasswordMinLength,
+		MaxLength:      requirements.PasswordMaxLength,
+		RequireUpper:   cfg.RequireUpper,
+		RequireLower:   cfg.RequireLower,
+		RequireDigit:   cfg.RequireDigit,
+		RequireSymbol:  cfg.RequireSymbol,
+		RejectUserInfo: cfg.RejectUserInfo,
+	}
+	if cfg.BreachedList != "" {
+		list, err := OpenBreachedList(cfg.BreachedList)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = list
+	}
+	return policy, nil
+}
@@ -0,0 +1,147 @@
+package passpolicy
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violation is a machine-readable reason a password was rejected
+type Violation string
+
+const (
+	TooShort         Violation = "too-short"
+	TooLong          Violation = "too-long"
+	MissingUpper     Violation = "missing-upper"
+	MissingLower     Violation = "missing-lower"
+	MissingDigit     Violation = "missing-digit"
+	MissingSymbol    Violation = "missing-symbol"
+	ContainsUserInfo Violation = "contains-user-info"
+	Breached         Violation = "breached"
+	Reused           Violation = "reused" // Checked by the caller against the password history
+)
+
+var violationMessages = map[Violation]string{
+	TooShort:         "password too short",
+	TooLong:          "password too long",
+	MissingUpper:     "password must contain an uppercase letter",
+	MissingLower:     "password must contain a lowercase letter",
+	MissingDigit:     "password must contain a digit",
+	MissingSymbol:    "password must contain a symbol",
+	ContainsUserInfo: "password must not contain the username or email",
+	Breached:         "password has appeared in a data breach",
+	Reused:           "password was used recently",
+}
+
+func (s Violation) Message() string {
+	if msg, ok := violationMessages[s]; ok {
+		return msg
+	}
+	return string(s)
+}
+
+// Violations is the error of a password that failed the policy
+type Violations []Violation
+
+func (s Violations) Error() string {
+	messages := make([]string, len(s))
+	for i, v := range s {
+		messages[i] = v.Message()
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Details are the violation codes, see saerrors.DetailedError
+func (s Violations) Details() []string {
+	ret := make([]string, len(s))
+	for i, v := range s {
+		ret[i] = string(v)
+	}
+	return ret
+}
+
+// userInfoMinLength avoids rejecting passwords that happen to contain a very short username
+const userInfoMinLength = 3
+
+// Policy is what a new password must satisfy
+type Policy struct {
+	MinLength      int
+	MaxLength      int // 0 is unlimited
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSymbol  bool
+	RejectUserInfo bool
+	Breached       BreachedList // nil disables
+}
+
+// Check returns every rule the password violates, or an error if the breached list couldn't be read.
+// userInfo is the username, email, etc. that the password must not contain (if RejectUserInfo)
+func (s *Policy) Check(password string, userInfo ...string) (Violations, error) {
+	var ret Violations
+
+	plen := utf8.RuneCountInString(password)
+	if plen < s.MinLength {
+		ret = append(ret, TooShort)
+	}
+	if s.MaxLength > 0 && plen > s.MaxLength {
+		ret = append(ret, TooLong)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsLetter(r):
+			hasSymbol = true
+		}
+	}
+	if s.RequireUpper && !hasUpper {
+		ret = append(ret, MissingUpper)
+	}
+	if s.RequireLower && !hasLower {
+		ret = append(ret, MissingLower)
+	}
+	if s.RequireDigit && !hasDigit {
+		ret = append(ret, MissingDigit)
+	}
+	if s.RequireSymbol && !hasSymbol {
+		ret = append(ret, MissingSymbol)
+	}
+
+	if s.RejectUserInfo && containsUserInfo(password, userInfo) {
+		ret = append(ret, ContainsUserInfo)
+	}
+
+	if s.Breached != nil {
+		breached, err := s.Breached.Contains(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			ret = append(ret, Breached)
+		}
+	}
+
+	return ret, nil
+}
+
+func containsUserInfo(password string, userInfo []string) bool {
+	password = strings.ToLower(password)
+	for _, info := range userInfo {
+		info = strings.ToLower(strings.TrimSpace(info))
+		// An email is matched by its local part, which is how it'd appear in a password
+		if at := strings.IndexByte(info, '@'); at >= 0 {
+			info = info[:at]
+		}
+		if utf8.RuneCountInString(info) >= userInfoMinLength && strings.Contains(password, info) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package passpolicy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyLength(t *testing.T) {
+	policy := &Policy{MinLength: 4, MaxLength: 8}
+
+	v, err := policy.Check("abc")
+	assert.NoError(t, err)
+	assert.Equal(t, Violations{TooShort}, v)
+
+	v, _ = policy.Check("abcdefghi")
+	assert.Equal(t, Violations{TooLong}, v)
+
+	v, _ = policy.Check("abcd")
+	assert.Empty(t, v)
+}
+
+func TestPolicyCharacterClasses(t *testing.T) {
+	policy := &Policy{RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+
+	v, _ := policy.Check("password")
+	assert.Equal(t, Violations{MissingUpper, MissingDigit, MissingSymbol}, v)
+	assert.Equal(t, []string{"missing-upper", "missing-digit", "missing-symbol"}, v.Details())
+	assert.Equal(t, "password must contain an uppercase letter, password must contain a digit, password must contain a symbol", v.Error())
+
+	v, _ = policy.Check("Pässw0rd!")
+	assert.Empty(t, v)
+}
+
+func TestPolicyUserInfo(t *testing.T) {
+	policy := &Policy{RejectUserInfo: true}
+
+	v, _ := policy.Check("xxBobbyxx", "bobby", "bob@example.com")
+	assert.Equal(t, Violations{ContainsUserInfo}, v)
+
+	v, _ = policy.Check("my-bob-pass", "bobby", "bob@example.com")
+	assert.Equal(t, Violations{ContainsUserInfo}, v)
+
+	// Too short to count
+	v, _ = policy.Check("my-al-pass", "al", "al@example.com")
+	assert.Empty(t, v)
+
+	policy.RejectUserInfo = false
+	v, _ = policy.Check("bobby", "bobby")
+	assert.Empty(t, v)
+}
+
+var breachedPasswords = []string{"password", "123456", "qwerty", "letmein", "monkey", "dragon"}
+
+func TestBreachedHashFile(t *testing.T) {
+	passwords := append([]string{}, breachedPasswords...)
+	for i := 0; i < 500; i++ {
+		passwords = append(passwords, fmt.Sprintf("generated-%d", i))
+	}
+
+	var lines []string
+	for i, pass := range passwords {
+		lines = append(lines, hashPassword(pass)+":"+strings.Repeat("1", i%7+1))
+	}
+	sort.Strings(lines)
+
+	f, err := ioutil.TempFile("", "breached")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString(strings.Join(lines, "\r\n"))
+	f.Close()
+
+	list, err := OpenBreachedList(f.Name())
+	assert.NoError(t, err)
+
+	for _, pass := range passwords {
+		found, err := list.Contains(pass)
+		assert.NoError(t, err)
+		assert.True(t, found, pass)
+	}
+	for _, pass := range []string{"not-breached", "Password", "", "zzzzzzzz"} {
+		found, err := list.Contains(pass)
+		assert.NoError(t, err)
+		assert.False(t, found, pass)
+	}
+
+	policy := &Policy{Breached: list}
+	v, _ := policy.Check("letmein")
+	assert.Equal(t, Violations{Breached}, v)
+}
+
+func TestBreachedPrefixDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "breached")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, pass := range breachedPasswords {
+		hash := hashPassword(pass)
+		f, _ := os.OpenFile(filepath.Join(dir, hash[:5]+".txt"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		f.WriteString(hash[5:] + ":10\n")
+		f.Close()
+	}
+
+	list, err := OpenBreachedList(dir)
+	assert.NoError(t, err)
+
+	found, err := list.Contains("qwerty")
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	found, err = list.Contains("not-breached")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestOpenBreachedListMissing(t *testing.T) {
+	_, err := OpenBreachedList("/does/not/exist")
+	assert.Error(t, err)
+}
@@ -140,18 +140,24 @@ func (s *Totp) GetTOTP() string {
 
 // Validate the code, allowing a certain amount of time-drift
 func (s *Totp) Validate(code string, drift int) bool {
+	_, ok := s.ValidateStep(code, drift)
+	return ok
+}
+
+// ValidateStep validates the code like Validate, and returns the time step (interval) it matched
+func (s *Totp) ValidateStep(code string, drift int) (int64, bool) {
 	interval := time.Now().Unix() / 30
 	if code == s.GetHOTP(interval) {
-		return true
+		return interval, true
 	}
 
 	for i := 1; i < drift; i++ {
 		if code == s.GetHOTP(interval+int64(i)) {
-			return true
+			return interval + int64(i), true
 		}
 		if code == s.GetHOTP(interval-int64(i)) {
-			return true
+			return interval - int64(i), true
 		}
 	}
-	return false
+	return 0, false
 }
@@ -56,4 +56,8 @@ func TestTOTPValidationDrift(t *testing.T) {
 	// Test validate
 	assert.True(t, otp.Validate(code, 2))
 	assert.False(t, otp.Validate(code, 0))
+
+	step, ok := otp.ValidateStep(code, 2)
+	assert.True(t, ok)
+	assert.Equal(t, interval, step)
 }
@@ -47,7 +47,7 @@ func MountAPI(e *echo.Group, config *config.Config, db db.SADB) {
 	transactional := appcontext.Transaction()
 
 	emailService := email.NewFromConfig(&config.Email)
-	loginService := services.NewLocalLoginService(emailService, &config.Metadata, &config.Providers.Local, config.Web.GetBaseURL())
+	loginService := services.NewLocalLoginService(emailService, &config.Metadata, &config.Providers.Local, &config.Providers.Settings.Terms, config.Web.GetBaseURL())
 	oAuthController := authAPI.NewOAuth2Controller(&config.Authenticators.OAuth2, loginService)
 
 	v1api := e.Group("/v1")
@@ -65,6 +65,9 @@ func MountAPI(e *echo.Group, config *config.Config, db db.SADB) {
 			}
 
 			v1api.POST("/stipulation", v1Env.RouteSatisfyTokenStipulation, publicAuth)
+			v1api.POST("/stipulation/resend", v1Env.RouteResendTokenStipulation, publicAuthWithRecaptcha, transactional)
+			v1api.POST("/local/unlock", v1Env.RouteUnlockLocalLogin, publicAuth, transactional)
+			v1api.GET("/account/email/confirm", v1Env.RouteConfirmEmailChange, publicAuth, transactional)
 
 			v1api.POST("/auth/session", v1Env.RouteSessionLogin, publicAuth)
 			v1api.DELETE("/auth/session", v1Env.RouteSessionLogout, publicAuthNoDelay)
@@ -79,17 +82,25 @@ func MountAPI(e *echo.Group, config *config.Config, db db.SADB) {
 
 		// Private auth
 		{
-			privateAuth := buildPrivateAuthMiddleware(&config.Web.Login.Cookie, &config.API)
+			privateAuth := buildPrivateAuthMiddleware(&config.Web.Login.Cookie, &config.API, "")
+			passwordChangeAuth := buildPrivateAuthMiddleware(&config.Web.Login.Cookie, &config.API, auth.RestrictPasswordChange)
+			termsAuth := buildPrivateAuthMiddleware(&config.Web.Login.Cookie, &config.API, auth.RestrictTerms)
+			twoFactorEnrollAuth := buildPrivateAuthMiddleware(&config.Web.Login.Cookie, &config.API, auth.RestrictTwoFactorEnroll)
 			v1api.GET("/account", v1Env.RouteGetAccount, privateAuth)
+			v1api.PATCH("/account", v1Env.RouteUpdateAccount, privateAuth, transactional)
 			v1api.GET("/account/audit", v1Env.RouteGetAccountAudit, privateAuth)
+			v1api.POST("/account/email", v1Env.RouteChangeEmail, privateAuth, transactional)
+			v1api.GET("/account/terms", v1Env.RouteGetTerms, termsAuth)
+			v1api.POST("/account/terms", v1Env.RouteAcceptTerms, termsAuth, transactional)
 
 			v1api.GET("/local", v1Env.RouteGetLocalLogin, privateAuth)
-			v1api.POST("/local/password", v1Env.RouteChangePassword, privateAuth)
+			v1api.POST("/local/password", v1Env.RouteChangePassword, passwordChangeAuth, transactional)
 			if config.Providers.Local.TwoFactor.Enabled {
-				v1api.GET("/local/2fa", v1Env.RouteSetup2FA, privateAuth)
-				v1api.GET("/local/2fa/qrcode", v1Env.Route2FAQRCodeImage, privateAuth)
-				v1api.POST("/local/2fa", v1Env.RouteConfirm2FA, privateAuth)
+				v1api.GET("/local/2fa", v1Env.RouteSetup2FA, twoFactorEnrollAuth)
+				v1api.GET("/local/2fa/qrcode", v1Env.Route2FAQRCodeImage, twoFactorEnrollAuth)
+				v1api.POST("/local/2fa", v1Env.RouteConfirm2FA, twoFactorEnrollAuth, transactional)
 				v1api.DELETE("/local/2fa", v1Env.RouteDeactivate2FA, privateAuth)
+				v1api.POST("/local/2fa/recovery-codes", v1Env.RouteRegenerateRecoveryCodes, privateAuth, transactional)
 			}
 
 			v1api.GET("/auth/oauth2", oAuthController.RouteGetTokensForUser, privateAuth)
@@ -99,6 +110,36 @@ func MountAPI(e *echo.Group, config *config.Config, db db.SADB) {
 			}
 		}
 
+		// Admin
+		if config.API.Admin.Enabled {
+			adminAuth := buildAdminAuthMiddleware(&config.Web.Login.Cookie, &config.API.Admin)
+			v1api.GET("/admin/accounts/pending", v1Env.RouteAdminListPendingAccounts, adminAuth)
+			v1api.POST("/admin/accounts/require-password-change", v1Env.RouteAdminRequirePasswordChangeBulk, adminAuth, transactional)
+			v1api.GET("/admin/accounts/:id", v1Env.RouteAdminGetAccount, adminAuth)
+			v1api.PATCH("/admin/accounts/:id", v1Env.RouteAdminUpdateAccount, adminAuth, transactional)
+			v1api.PUT("/admin/accounts/:id/roles/:name", v1Env.RouteAdminAddRole, adminAuth, transactional)
+			v1api.DELETE("/admin/accounts/:id/roles/:name", v1Env.RouteAdminRemoveRole, adminAuth, transactional)
+			v1api.PUT("/admin/accounts/:id/groups/:name", v1Env.RouteAdminAddGroup, adminAuth, transactional)
+			v1api.DELETE("/admin/accounts/:id/groups/:name", v1Env.RouteAdminRemoveGroup, adminAuth, transactional)
+			v1api.PUT("/admin/accounts/:id/organization", v1Env.RouteAdminSetAccountOrganization, adminAuth, transactional)
+			v1api.POST("/admin/accounts/:id/approve", v1Env.RouteAdminApproveAccount, adminAuth, transactional)
+			v1api.POST("/admin/accounts/:id/reject", v1Env.RouteAdminRejectAccount, adminAuth, transactional)
+			v1api.POST("/admin/accounts/:id/require-password-change", v1Env.RouteAdminRequirePasswordChange, adminAuth, transactional)
+			v1api.POST("/admin/accounts/:id/unlock", v1Env.RouteAdminUnlockAccount, adminAuth, transactional)
+			v1api.PUT("/admin/accounts/:id/password-never-expires", v1Env.RouteAdminExemptPasswordExpiry, adminAuth, transactional)
+			v1api.DELETE("/admin/accounts/:id/password-never-expires", v1Env.RouteAdminRemovePasswordExpiryExemption, adminAuth, transactional)
+			v1api.POST("/admin/accounts/:id/deactivate", v1Env.RouteAdminDeactivateAccount, adminAuth, transactional)
+			v1api.POST("/admin/accounts/:id/reactivate", v1Env.RouteAdminReactivateAccount, adminAuth, transactional)
+			v1api.DELETE("/admin/accounts/:id", v1Env.RouteAdminDeleteAccount, adminAuth, transactional)
+			v1api.GET("/admin/organizations", v1Env.RouteAdminListOrganizations, adminAuth)
+			v1api.POST("/admin/organizations", v1Env.RouteAdminCreateOrganization, adminAuth, transactional)
+			v1api.GET("/admin/organizations/:org", v1Env.RouteAdminGetOrganization, adminAuth)
+			v1api.PATCH("/admin/organizations/:org", v1Env.RouteAdminUpdateOrganization, adminAuth, transactional)
+			v1api.DELETE("/admin/organizations/:org", v1Env.RouteAdminDeleteOrganization, adminAuth, transactional)
+			v1api.GET("/admin/audit", v1Env.RouteAdminSearchAudit, adminAuth)
+			v1api.GET("/admin/audit/checkpoint", v1Env.RouteAdminAuditCheckpoint, adminAuth)
+		}
+
 		// Attach authenticator routes
 		{
 			if config.Authenticators.Simple.Enabled {
@@ -148,12 +189,12 @@ func buildPublicAuthMiddleware(config *config.ConfigAPI, recaptcha *config.Confi
 	return selector.NewSelectorMiddleware(selectorGroups...)
 }
 
-// Allow a session token, or private key access
-func buildPrivateAuthMiddleware(sessionConfig *config.ConfigLoginCookie, apiConfig *config.ConfigAPI) echo.MiddlewareFunc {
+// Allow a session token, or private key access. Sessions with the given restriction are also allowed, if any
+func buildPrivateAuthMiddleware(sessionConfig *config.ConfigLoginCookie, apiConfig *config.ConfigAPI, allow auth.SessionRestriction) echo.MiddlewareFunc {
 	var selectorGroups []selector.SelectorGroup
 
 	csrf := middleware.CSRF()
-	selectorGroups = append(selectorGroups, auth.NewSessionAuthProvider(sessionConfig, csrf))
+	selectorGroups = append(selectorGroups, auth.NewRestrictedSessionAuthProvider(sessionConfig, allow, csrf))
 
 	if apiConfig.External {
 		selectorGroups = append(selectorGroups, auth.NewSharedSecretWithAccountAuth(apiConfig.SharedSecret))
@@ -164,6 +205,26 @@ func buildPrivateAuthMiddleware(sessionConfig *config.ConfigLoginCookie, apiConf
 	return selector.NewSelectorMiddleware(selectorGroups...)
 }
 
+// Allow the admin shared secret, or a session for one of the admin accounts, or an organization admin
+func buildAdminAuthMiddleware(sessionConfig *config.ConfigLoginCookie, adminConfig *config.ConfigAPIAdmin) echo.MiddlewareFunc {
+	var selectorGroups []selector.SelectorGroup
+
+	logrus.Info("Enabling admin API...")
+	if adminConfig.SharedSecret == "" && len(adminConfig.Accounts) == 0 {
+		logrus.Fatal("Admin API enabled, but no shared-secret or accounts configured")
+	}
+
+	if adminConfig.SharedSecret != "" {
+		selectorGroups = append(selectorGroups, selector.NewSelectorGroup(auth.SharedSecretSelector(adminConfig.SharedSecret)))
+	}
+
+	selectorGroups = append(selectorGroups, auth.NewSessionAuthProvider(sessionConfig, middleware.CSRF(), auth.RequireAdmin(adminConfig.Accounts...)))
+
+	selectorGroups = append(selectorGroups, selector.HandlerUnauthorized())
+
+	return selector.NewSelectorMiddleware(selectorGroups...)
+}
+
 func buildRecaptchaMiddleware(config *config.ConfigRecaptchaV2) echo.MiddlewareFunc {
 	if config == nil || !config.Enabled {
 		return nil
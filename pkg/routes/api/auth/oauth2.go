@@ -7,6 +7,7 @@ import (
 	"simple-auth/pkg/appcontext"
 	"simple-auth/pkg/config"
 	"simple-auth/pkg/db"
+	"simple-auth/pkg/lib/attributes"
 	"simple-auth/pkg/routes/common"
 	"simple-auth/pkg/routes/middleware/selector/auth"
 	"simple-auth/pkg/services"
@@ -24,6 +25,7 @@ const (
 	InvalidScope         OAuth2Error = "invalid_scope"
 	UnauthorizedClient   OAuth2Error = "unauthorized_client"
 	UnsupportedGrantType OAuth2Error = "unsupported_grant_type"
+	AccessDenied         OAuth2Error = "access_denied"
 	InternalError        OAuth2Error = "server_error"
 )
 
@@ -128,7 +130,7 @@ func (s *OAuth2Controller) RouteGetTokensForUser(c echo.Context) error {
 		ret[i] = &oauth2GetTokenResponseToken{
 			ClientID:   t.ClientID,
 			ClientName: s.config.Clients[t.ClientID].Name,
-			ShortToken: t.Token[:5],
+			ShortToken: t.TokenPrefix,
 			Type:       string(t.Type),
 			Created:    t.Created,
 			Expires:    t.Expires,
@@ -160,7 +162,16 @@ type oauth2TokenIntrospectResponse struct {
 	Issuer     string `json:"iss,omitempty"`
 
 	// Custom fields
-	Email string `json:"email,omitempty"` // Email, if has 'email' scope
+	Organization string                 `json:"org,omitempty"`    // UUID of the account's organization, if any
+	Email        string                 `json:"email,omitempty"`  // Email, if has 'email' scope
+	Roles        []string               `json:"roles,omitempty"`  // Roles, if has 'roles' scope
+	Groups       []string               `json:"groups,omitempty"` // Groups, if has 'groups' scope
+	Attributes   map[string]interface{} `json:"-"`                // Account attributes granted by scope, merged in at the top level
+}
+
+func (s oauth2TokenIntrospectResponse) MarshalJSON() ([]byte, error) {
+	type response oauth2TokenIntrospectResponse
+	return attributes.MarshalWithClaims(response(s), s.Attributes)
 }
 
 // @Summary Introspect Token
@@ -193,6 +204,17 @@ func (s *OAuth2Controller) RouteIntrospectToken(c echo.Context) error {
 		})
 	}
 
+	// The organization may have stopped allowing the client since the token was issued
+	org, err := db.GetAccountOrganization(token.Account)
+	if err != nil {
+		return oauthError(c, InternalError, err.Error())
+	}
+	if !org.AllowsClient(token.ClientID) {
+		return c.JSON(http.StatusOK, &oauth2TokenIntrospectResponse{
+			Active: false,
+		})
+	}
+
 	ret := oauth2TokenIntrospectResponse{
 		Active:     true,
 		Scope:      token.Scopes.String(),
@@ -207,12 +229,32 @@ func (s *OAuth2Controller) RouteIntrospectToken(c echo.Context) error {
 	if service, ok := s.oauthServices[token.ClientID]; ok {
 		ret.Issuer = service.IssuerName()
 	}
+	ret.Organization = org.OrganizationUUID()
 	if token.Scopes.Contains(services.ScopeEmail) {
 		ret.Email = token.Account.Email
 	}
 	if token.Scopes.Contains(services.ScopeName) {
 		ret.Username = token.Account.Name
 	}
+	if token.Scopes.Contains(services.ScopeRoles) || token.Scopes.Contains(services.ScopeGroups) {
+		memberships, err := db.GetMemberships(token.Account)
+		if err != nil {
+			return oauthError(c, InternalError, err.Error())
+		}
+		if token.Scopes.Contains(services.ScopeRoles) {
+			ret.Roles = memberships.Roles
+		}
+		if token.Scopes.Contains(services.ScopeGroups) {
+			ret.Groups = memberships.Groups
+		}
+	}
+	if schema := appcontext.GetAttributeSchema(c); schema.HasScopeClaims(token.Scopes.Contains) {
+		values, err := db.GetAccountAttributes(token.Account)
+		if err != nil {
+			return oauthError(c, InternalError, err.Error())
+		}
+		ret.Attributes = schema.ScopeClaims(values, token.Scopes.Contains)
+	}
 
 	return c.JSON(http.StatusOK, &ret)
 }
@@ -327,7 +369,11 @@ func (s *OAuth2Controller) RouteAuthorizedGrantCode(c echo.Context) error {
 	}
 
 	code, err := oauthService.CreateAccessCode(account, scopes)
-	if err != nil {
+	if err == services.ErrClientNotAllowed {
+		return oauthError(c, UnauthorizedClient, err.Error())
+	} else if err == services.ErrTwoFactorRequired {
+		return oauthError(c, AccessDenied, err.Error())
+	} else if err != nil {
 		return oauthError(c, InternalError, err.Error())
 	}
 
@@ -417,6 +463,12 @@ func (s *OAuth2Controller) routeTokenGrantPassword(c echo.Context, clientService
 	retToken, err := clientService.TradeCredentialsForToken(req.ClientSecret, req.Username, req.Password, req.Totp, scopes)
 	if err != nil {
 		incAuthCounterError(MetricOAuth2Password, err)
+		if err == services.ErrClientNotAllowed {
+			return oauthError(c, UnauthorizedClient, err.Error())
+		}
+		if err == services.ErrTwoFactorRequired {
+			return oauthError(c, AccessDenied, err.Error())
+		}
 		return oauthError(c, InvalidRequest, err.Error())
 	}
 
@@ -440,6 +492,9 @@ func (s *OAuth2Controller) routeTokenGrantAuthorizationCode(c echo.Context, clie
 	retToken, err := clientService.TradeCodeForToken(req.ClientSecret, req.Code)
 	if err != nil {
 		incAuthCounterError(MetricOAuth2Token, err)
+		if err == services.ErrClientNotAllowed {
+			return oauthError(c, UnauthorizedClient, err.Error())
+		}
 		return oauthError(c, InternalError, err.Error())
 	}
 
@@ -459,6 +514,9 @@ func (s *OAuth2Controller) routeTokenGrantRefreshToken(c echo.Context, clientSer
 	retToken, err := clientService.TradeRefreshTokenForAccessToken(req.ClientSecret, req.RefreshToken)
 	if err != nil {
 		incAuthCounterError(MetricOAuth2Refresh, err)
+		if err == services.ErrClientNotAllowed {
+			return oauthError(c, UnauthorizedClient, err.Error())
+		}
 		return oauthError(c, InternalError, err.Error())
 	}
 
@@ -9,6 +9,7 @@ import (
 	"simple-auth/pkg/routes/common"
 	"simple-auth/pkg/routes/middleware/selector"
 	"simple-auth/pkg/routes/middleware/selector/auth"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
@@ -37,7 +38,11 @@ func (env *VouchAuthController) Mount(group *echo.Group) {
 		env.authRedirectIfNeeded,
 		selector.HandlerUnauthorized(),
 	)
-	group.GET("", env.routeVouchAuth, loggedInMiddleware)
+	if len(env.config.RequireGroups) > 0 {
+		group.GET("", env.routeVouchAuth, loggedInMiddleware, auth.RequireGroup(env.config.RequireGroups...))
+	} else {
+		group.GET("", env.routeVouchAuth, loggedInMiddleware)
+	}
 }
 
 // Resolves URL assuming forward headers (As defined in traefik)
@@ -89,14 +94,23 @@ func (env *VouchAuthController) authRedirectIfNeeded(next echo.HandlerFunc, c ec
 // @Param forward query boolean false "If true, will forward to login with a 307 rather than return a 401"
 // @Param continue query string false "Will override X-Forward headers to set the continue URL.  Must follow allowedContinueURL settings"
 // @Success 200 {object} common.OKResponse
-// @Failure 307,401 {object} common.ErrorResponse
+// @Failure 307,401,403 {object} common.ErrorResponse
 // @Router /auth/vouch [get]
 func (env *VouchAuthController) routeVouchAuth(c echo.Context) error {
 	incAuthCounterSuccess("vouch")
 
+	authContext := auth.MustGetAuthContext(c)
 	if env.config.UserHeader != "" {
-		accountUUID := auth.MustGetAccountUUID(c)
-		c.Response().Header().Set(env.config.UserHeader, accountUUID)
+		c.Response().Header().Set(env.config.UserHeader, authContext.UUID)
+	}
+	if env.config.RolesHeader != "" && len(authContext.Roles) > 0 {
+		c.Response().Header().Set(env.config.RolesHeader, strings.Join(authContext.Roles, ","))
+	}
+	if env.config.GroupsHeader != "" && len(authContext.Groups) > 0 {
+		c.Response().Header().Set(env.config.GroupsHeader, strings.Join(authContext.Groups, ","))
+	}
+	if env.config.OrganizationHeader != "" && authContext.Organization != "" {
+		c.Response().Header().Set(env.config.OrganizationHeader, authContext.Organization)
 	}
 
 	return common.HttpOK(c)
@@ -30,6 +30,7 @@ const (
 	errorOIDCInvalidState            saerrors.ErrorCode = "oidc-invalid-state"
 	errorOIDCTradeCode               saerrors.ErrorCode = "oidc-code-error"
 	errorOIDCAccountCreationDisabled saerrors.ErrorCode = "oidc-account-creation-disabled"
+	errorOIDCProviderNotAllowed      saerrors.ErrorCode = "oidc-provider-not-allowed"
 )
 
 type OIDCController struct {
@@ -159,32 +160,81 @@ func (env *OIDCController) routeAuthCallback(c echo.Context) error {
 
 	// TODO: Check if user already logged in (and associate)
 
+	sadb := appcontext.GetSADB(c)
+
 	// Check if exists
 	{
-		account, _ := env.db.FindAccountForOIDC(env.id, claims.Subject)
+		account, err := sadb.FindAccountForOIDC(env.id, claims.Subject)
+		if saerrors.UnwrapCode(err) == db.InactiveAccount {
+			// Linked, so must not fall through to creating another account
+			return common.HttpError(c, http.StatusForbidden, err)
+		}
 		if account != nil {
-			auth.CreateSession(c, env.cookieConfig, account, auth.SourceOIDC)
-			return c.Redirect(http.StatusTemporaryRedirect, continueURL)
+			org, err := sadb.GetAccountOrganization(account)
+			if err != nil {
+				return common.HttpInternalError(c, err)
+			}
+			if !org.AllowsProvider(env.id) {
+				return common.HttpError(c, http.StatusForbidden, errorOIDCProviderNotAllowed.Newf("Provider not allowed by the account's organization"))
+			}
+			if sadb.AccountPendingApproval(account) {
+				return common.HttpError(c, http.StatusForbidden, db.PendingApproval.Newf("Account is waiting to be approved"))
+			}
+			return env.completeLogin(c, account, continueURL)
 		}
 	}
 
-	// If not, try to create it
+	// If not, try to create it, in the request's organization
 	if env.providerConfig.CreateAccountEnabled {
-		account, err := env.db.CreateAccount(strCoalesce(claims.Name, claims.Email), claims.Email)
+		if org := appcontext.GetOrganization(c); org != nil {
+			if !org.AllowSignup {
+				return common.HttpError(c, http.StatusForbidden, db.OrganizationClosed.Newf("Organization doesn't allow signup"))
+			}
+			if !org.AllowsProvider(env.id) {
+				return common.HttpError(c, http.StatusForbidden, errorOIDCProviderNotAllowed.Newf("Provider not allowed by the organization"))
+			}
+		}
+
+		account, err := sadb.CreateAccount(strCoalesce(claims.Name, claims.Email), claims.Email)
 		if err != nil {
 			return common.HttpInternalError(c, err)
 		}
-		err2 := env.db.CreateOIDCForAccount(account, env.id, claims.Subject)
+		err2 := sadb.CreateOIDCForAccount(account, env.id, claims.Subject)
 		if err2 != nil {
 			return common.HttpInternalError(c, err2)
 		}
-		auth.CreateSession(c, env.cookieConfig, account, auth.SourceOIDC)
-		return c.Redirect(http.StatusTemporaryRedirect, continueURL)
+		if env.providerConfig.RequireApproval {
+			if err := sadb.RequireApproval(account); err != nil {
+				return common.HttpInternalError(c, err)
+			}
+			return common.HttpError(c, http.StatusForbidden, db.PendingApproval.Newf("Account created, and is waiting to be approved"))
+		}
+		return env.completeLogin(c, account, continueURL)
 	}
 
 	return common.HttpError(c, http.StatusForbidden, errorOIDCAccountCreationDisabled.Newf("Unable to create new OIDC for user. Account creation disabled."))
 }
 
+// completeLogin issues the session, or, if the account must accept the terms first, a session restricted
+// to accepting them, and redirects to the UI to do so
+func (env *OIDCController) completeLogin(c echo.Context, account *db.Account, continueURL string) error {
+	if version := env.providerConfig.Terms.Version; version != "" {
+		required, err := appcontext.GetSADB(c).RequireTerms(account, version)
+		if err != nil {
+			return common.HttpInternalError(c, err)
+		}
+		if required {
+			if err := auth.CreateRestrictedSession(c, env.cookieConfig, account, auth.SourceOIDC, auth.RestrictTerms); err != nil {
+				return common.HttpInternalError(c, err)
+			}
+			return c.Redirect(http.StatusTemporaryRedirect, "/?continue="+url.QueryEscape(continueURL)+"#/terms")
+		}
+	}
+
+	auth.CreateSession(c, env.cookieConfig, account, auth.SourceOIDC)
+	return c.Redirect(http.StatusTemporaryRedirect, continueURL)
+}
+
 func (env *OIDCController) tradeCodeForToken(code string) (string, error) {
 	form := url.Values{
 		"code":          {code},
@@ -7,6 +7,8 @@ import (
 	"simple-auth/pkg/lib/totp/otpimagery"
 	"simple-auth/pkg/routes/common"
 	"simple-auth/pkg/routes/middleware/selector/auth"
+	"simple-auth/pkg/saerrors"
+	"simple-auth/pkg/services"
 
 	"github.com/labstack/echo/v4"
 )
@@ -80,16 +82,21 @@ type tfaActivateRequest struct {
 	Code   string `json:"code" validate:"required"`
 }
 
+type tfaActivateResponse struct {
+	common.OKResponse
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"` // Only shown once. Empty if recovery codes are disabled
+}
+
 // RouteConfirm2FA confirm 2fa code and activate
 // @Summary Setup 2FA
-// @Description Activates 2FA
+// @Description Activates 2FA, and generates recovery codes. If the session was restricted to enrolling in 2FA, a full session is issued
 // @Tags Local
 // @Security ApiKeyAuth
 // @Security SessionAuth
 // @Accept json
 // @Produce json
 // @Param tfaActivateRequest body tfaActivateRequest true "Body"
-// @Success 200 {object} common.OKResponse
+// @Success 200 {object} tfaActivateResponse
 // @Failure 400,401,404,500 {object} common.ErrorResponse
 // @Router /local/2fa [post]
 func (env *Environment) RouteConfirm2FA(c echo.Context) error {
@@ -117,11 +124,22 @@ func (env *Environment) RouteConfirm2FA(c echo.Context) error {
 	}
 
 	log.Infof("Setting up TOTP for %s", accountUUID)
-	if err := loginService.ActivateTOTP(authLocal, t, req.Code); err != nil {
+	recoveryCodes, err := loginService.ActivateTOTP(authLocal, t, req.Code)
+	if err != nil {
 		return common.HttpError(c, http.StatusForbidden, err)
 	}
 
-	return common.HttpOK(c)
+	if authContext := auth.MustGetAuthContext(c); authContext.Restriction == auth.RestrictTwoFactorEnroll {
+		next := loginService.NextLoginStep(authLocal)
+		if status, err := env.issueLoginStepSession(c, authLocal.Account(), authContext.Source, next); err != nil {
+			return common.HttpError(c, status, err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, tfaActivateResponse{
+		OKResponse:    common.OKResponse{Success: true},
+		RecoveryCodes: recoveryCodes,
+	})
 }
 
 // RouteDeactivate2FA deactivates 2fa
@@ -132,9 +150,9 @@ func (env *Environment) RouteConfirm2FA(c echo.Context) error {
 // @Security SessionAuth
 // @Accept json
 // @Produce json
-// @Param code query string true "Code to check against before deactivating"
+// @Param code query string true "Code (or recovery code) to check against before deactivating"
 // @Success 200 {object} common.OKResponse
-// @Failure 400,401,404,500 {object} common.ErrorResponse
+// @Failure 400,401,403,404,500 {object} common.ErrorResponse
 // @Router /local/2fa [delete]
 func (env *Environment) RouteDeactivate2FA(c echo.Context) error {
 	loginService := env.localLoginService.WithContext(c)
@@ -150,8 +168,60 @@ func (env *Environment) RouteDeactivate2FA(c echo.Context) error {
 	}
 
 	if err := loginService.DeactivateTOTP(authLocal, code); err != nil {
+		if saerrors.UnwrapCode(err) == services.LocalTwoFactorRequired {
+			return common.HttpError(c, http.StatusForbidden, err)
+		}
 		return common.HttpError(c, http.StatusUnauthorized, err)
 	}
 
 	return common.HttpOK(c)
 }
+
+type tfaRecoveryCodesRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type tfaRecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// RouteRegenerateRecoveryCodes replaces the 2fa recovery codes
+// @Summary Regenerate Recovery Codes
+// @Description Replaces any remaining recovery codes with new ones
+// @Tags Local
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Accept json
+// @Produce json
+// @Param tfaRecoveryCodesRequest body tfaRecoveryCodesRequest true "Body"
+// @Success 200 {object} tfaRecoveryCodesResponse
+// @Failure 400,401,404,500 {object} common.ErrorResponse
+// @Router /local/2fa/recovery-codes [post]
+func (env *Environment) RouteRegenerateRecoveryCodes(c echo.Context) error {
+	loginService := env.localLoginService.WithContext(c)
+
+	var req tfaRecoveryCodesRequest
+	if err := c.Bind(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+
+	authLocal, err := loginService.FindAuthLocal(auth.MustGetAccountUUID(c))
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	recoveryCodes, err := loginService.RegenerateRecoveryCodes(authLocal, req.Code)
+	if err != nil {
+		if saerrors.UnwrapCode(err) == services.LocalTOTPFailed {
+			return common.HttpError(c, http.StatusUnauthorized, err)
+		}
+		return common.HttpBadRequest(c, err)
+	}
+
+	return c.JSON(http.StatusOK, tfaRecoveryCodesResponse{
+		RecoveryCodes: recoveryCodes,
+	})
+}
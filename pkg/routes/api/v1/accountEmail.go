@@ -0,0 +1,95 @@
+package v1
+
+import (
+	"net/http"
+	"simple-auth/pkg/appcontext"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/routes/common"
+	"simple-auth/pkg/routes/middleware/selector/auth"
+	"simple-auth/pkg/saerrors"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type changeEmailRequest struct {
+	Email string `json:"email" form:"email" validate:"required,email" example:"sa@example.com"`
+}
+
+// @Summary Change Email
+// @Description Request to change the account's email. A confirmation link is sent to the new address, and the current address is notified.
+// @Description The email is only changed once the link is followed
+// @Tags Account
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Accept json
+// @Produce json
+// @Param changeEmailRequest body changeEmailRequest true "New email"
+// @Success 200 {object} common.OKResponse
+// @Failure 400,401,409,500 {object} common.ErrorResponse
+// @Router /account/email [post]
+func (env *Environment) RouteChangeEmail(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+	accountUUID := auth.MustGetAccountUUID(c)
+
+	var req changeEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+
+	account, err := sadb.FindAccount(accountUUID)
+	if err != nil {
+		return common.HttpError(c, http.StatusInternalServerError, errorInvalidAccount.Wrapf(err, "Logged in with unknown account"))
+	}
+
+	logger.Infof("Requesting email change for %s", accountUUID)
+	if err := env.accountService.WithContext(c).RequestEmailChange(account, req.Email); err != nil {
+		switch saerrors.UnwrapCode(err) {
+		case db.EmailUnavailable:
+			return common.HttpError(c, http.StatusConflict, err)
+		case db.EmailInvalid:
+			return common.HttpError(c, http.StatusBadRequest, err)
+		}
+		return common.HttpInternalError(c, err)
+	}
+
+	return common.HttpOK(c)
+}
+
+// @Summary Confirm Email Change
+// @Description Confirm a pending email change, via the link sent to the new address, and redirect to the UI
+// @Tags Account
+// @Param account query string true "Account UUID"
+// @Param token query string true "Token sent to the new address"
+// @Success 302 {object} common.OKResponse
+// @Failure 400,401,409,500 {object} common.ErrorResponse
+// @Router /account/email/confirm [get]
+func (env *Environment) RouteConfirmEmailChange(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+
+	accountUUID := strings.TrimSpace(c.QueryParam("account"))
+	token := strings.TrimSpace(c.QueryParam("token"))
+	if accountUUID == "" || token == "" {
+		return common.HttpBadRequestf(c, "missing account or token")
+	}
+
+	account, err := sadb.FindAccount(accountUUID)
+	if err != nil {
+		return common.HttpError(c, http.StatusUnauthorized, errorInvalidAccount.Wrap(err))
+	}
+
+	logger.Infof("Confirming email change for %s", accountUUID)
+	if err := env.accountService.WithContext(c).ConfirmEmailChange(account, token); err != nil {
+		if saerrors.UnwrapCode(err) == db.EmailUnavailable {
+			return common.HttpError(c, http.StatusConflict, err)
+		}
+		return common.HttpError(c, http.StatusUnauthorized, err)
+	}
+
+	return c.Redirect(http.StatusTemporaryRedirect, "/")
+}
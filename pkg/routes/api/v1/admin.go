@@ -0,0 +1,486 @@
+package v1
+
+import (
+	"net/http"
+	"simple-auth/pkg/appcontext"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/lib/checkpoint"
+	"simple-auth/pkg/routes/common"
+	"simple-auth/pkg/routes/middleware/selector/auth"
+	"simple-auth/pkg/saerrors"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	errorAuditCheckpointDisabled saerrors.ErrorCode = "audit-checkpoint-disabled"
+	errorAuditCheckpointEmpty    saerrors.ErrorCode = "audit-checkpoint-empty"
+	errorGlobalAdminRequired     saerrors.ErrorCode = "global-admin-required"
+)
+
+type (
+	getAdminAccountResponse struct {
+		ID         string                 `json:"id" example:"00000000-0000-0000-0000-000000000000"`
+		Created    time.Time              `json:"created"`
+		Email      string                 `json:"email" example:"sa@example.com"`
+		Name       string                 `json:"name" example:"John Smith"`
+		Active     bool                   `json:"active"`
+		Attributes map[string]interface{} `json:"attributes,omitempty"` // Every attribute, including admin-only
+		Roles      []string               `json:"roles,omitempty"`
+		Groups     []string               `json:"groups,omitempty"`
+
+		Organization      string `json:"organization,omitempty" example:"00000000-0000-0000-0000-000000000000"` // UUID of the account's organization, if any
+		OrganizationAdmin bool   `json:"organizationAdmin,omitempty"`
+		PendingApproval   bool   `json:"pendingApproval,omitempty"` // Waiting for an admin to approve or reject it
+		PasswordChange    bool   `json:"passwordChange,omitempty"`  // Must change its password on next login
+		Locked            bool   `json:"locked,omitempty"`          // Local login is locked after failed logins
+
+		LockedUntil  *time.Time `json:"lockedUntil,omitempty"`  // When a temporary lock ends. Empty if locked until unlocked
+		FailedLogins int        `json:"failedLogins,omitempty"` // Since the last successful login
+
+		PasswordChanged      *time.Time `json:"passwordChanged,omitempty"`      // When the local login's password was last set
+		PasswordNeverExpires bool       `json:"passwordNeverExpires,omitempty"` // Exempt from password expiry (eg. a service account)
+
+		TermsAccepted   string     `json:"termsAccepted,omitempty" example:"2021-01"` // Terms version the account last accepted
+		TermsAcceptedAt *time.Time `json:"termsAcceptedAt,omitempty"`
+	}
+	getAdminAuditRecordResponse struct {
+		*getAccountAuditRecordResponse
+		Account string `json:"account" example:"00000000-0000-0000-0000-000000000000"` // Empty if the account was deleted
+	}
+	getAdminAuditCheckpointResponse struct {
+		Index   uint64    `json:"index"`
+		Hash    string    `json:"hash"`
+		Created time.Time `json:"created"`
+		Token   string    `json:"token"` // Signed JWT of the checkpoint
+	}
+	getAdminAuditResponse struct {
+		Records []*getAdminAuditRecordResponse `json:"records"`
+		Next    uint                           `json:"next,omitempty"` // Cursor for the next page, passed as `before`
+	}
+)
+
+func newAdminAccountResponse(account *db.Account) *getAdminAccountResponse {
+	return &getAdminAccountResponse{
+		ID:      account.UUID,
+		Created: account.CreatedAt,
+		Email:   account.Email,
+		Name:    account.Name,
+		Active:  account.Active,
+	}
+}
+
+// newAdminAccountAttributesResponse includes all of the account's attributes, roles, groups, and organization
+func newAdminAccountAttributesResponse(c echo.Context, account *db.Account) (*getAdminAccountResponse, error) {
+	sadb := appcontext.GetSADB(c)
+	values, err := sadb.GetAccountAttributes(account)
+	if err != nil {
+		return nil, err
+	}
+	memberships, err := sadb.GetMemberships(account)
+	if err != nil {
+		return nil, err
+	}
+	org, err := sadb.GetAccountOrganization(account)
+	if err != nil {
+		return nil, err
+	}
+	terms, err := sadb.GetTermsAcceptance(account)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := newAdminAccountResponse(account)
+	ret.Attributes = appcontext.GetAttributeSchema(c).Visible(values, true)
+	ret.Roles = memberships.Roles
+	ret.Groups = memberships.Groups
+	ret.Organization = org.OrganizationUUID()
+	ret.OrganizationAdmin = account.OrganizationAdmin
+	ret.PendingApproval = sadb.AccountPendingApproval(account)
+	ret.PasswordChange = sadb.AccountHasStipulation(account, (&db.PasswordResetStipulation{}).Type())
+	if terms != nil {
+		ret.TermsAccepted = terms.Version
+		ret.TermsAcceptedAt = &terms.AcceptedAt
+	}
+	if authLocal, err := sadb.FindAuthLocal(account); err == nil {
+		ret.Locked = authLocal.IsLocked()
+		ret.LockedUntil = authLocal.LockedUntil()
+		ret.FailedLogins = authLocal.FailedLogins()
+		passwordChanged := authLocal.PasswordChanged()
+		ret.PasswordChanged = &passwordChanged
+		ret.PasswordNeverExpires = authLocal.PasswordNeverExpires()
+	}
+	return ret, nil
+}
+
+// findAdminAccount finds an account the admin can manage. An organization admin can only manage
+// accounts in their organization
+func findAdminAccount(c echo.Context, uuid string) (*db.Account, error) {
+	sadb := appcontext.GetSADB(c)
+	account, err := sadb.FindAccount(uuid)
+	if err != nil {
+		return nil, errorInvalidAccount.Wrap(err)
+	}
+	if orgID, ok := auth.GetAdminOrganization(c); ok && account.OrganizationID != orgID {
+		return nil, errorInvalidAccount.Newf("Account not found")
+	}
+	return account, nil
+}
+
+func findAdminAccountParam(c echo.Context) (*db.Account, error) {
+	return findAdminAccount(c, c.Param("id"))
+}
+
+// requireGlobalAdmin fails if the admin is limited to an organization
+func requireGlobalAdmin(c echo.Context) error {
+	if _, ok := auth.GetAdminOrganization(c); ok {
+		return errorGlobalAdminRequired.Newf("Not available to organization admins")
+	}
+	return nil
+}
+
+// RouteAdminGetAccount gets any account
+// @Summary Get Account (Admin)
+// @Tags Admin
+// @Description Get details about any account
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 401,403,404 {object} common.ErrorResponse
+// @Router /admin/accounts/{id} [get]
+func (env *Environment) RouteAdminGetAccount(c echo.Context) error {
+	account, err := findAdminAccountParam(c)
+	if err != nil {
+		return common.HttpError(c, http.StatusNotFound, err)
+	}
+
+	ret, err := newAdminAccountAttributesResponse(c, account)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// RouteAdminUpdateAccount updates any account's attributes
+// @Summary Update Account (Admin)
+// @Tags Admin
+// @Description Set or remove any of an account's attributes, including admin-only and non-editable ones
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Param patchAccountRequest body patchAccountRequest true "Attributes"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 400,401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id} [patch]
+func (env *Environment) RouteAdminUpdateAccount(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+
+	account, err := findAdminAccountParam(c)
+	if err != nil {
+		return common.HttpError(c, http.StatusNotFound, err)
+	}
+
+	values, err := parseAccountAttributes(c, true)
+	if err != nil {
+		return common.HttpError(c, http.StatusBadRequest, err)
+	}
+
+	logger.Infof("Updating attributes of account %s", account.UUID)
+	if err := sadb.SetAccountAttributes(account, values); err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	ret, err := newAdminAccountAttributesResponse(c, account)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// RouteAdminAddRole grants a role to an account
+// @Summary Add Role (Admin)
+// @Tags Admin
+// @Description Grant a role to an account. Granting a role the account already has does nothing
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Param name path string true "Role name"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 400,401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id}/roles/{name} [put]
+func (env *Environment) RouteAdminAddRole(c echo.Context) error {
+	return routeAdminUpdateMembership(c, db.MembershipRole, true)
+}
+
+// RouteAdminRemoveRole revokes a role from an account
+// @Summary Remove Role (Admin)
+// @Tags Admin
+// @Description Revoke a role from an account
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Param name path string true "Role name"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 400,401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id}/roles/{name} [delete]
+func (env *Environment) RouteAdminRemoveRole(c echo.Context) error {
+	return routeAdminUpdateMembership(c, db.MembershipRole, false)
+}
+
+// RouteAdminAddGroup adds an account to a group
+// @Summary Add Group (Admin)
+// @Tags Admin
+// @Description Add an account to a group. Adding an account already in the group does nothing
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Param name path string true "Group name"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 400,401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id}/groups/{name} [put]
+func (env *Environment) RouteAdminAddGroup(c echo.Context) error {
+	return routeAdminUpdateMembership(c, db.MembershipGroup, true)
+}
+
+// RouteAdminRemoveGroup removes an account from a group
+// @Summary Remove Group (Admin)
+// @Tags Admin
+// @Description Remove an account from a group
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Param name path string true "Group name"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 400,401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id}/groups/{name} [delete]
+func (env *Environment) RouteAdminRemoveGroup(c echo.Context) error {
+	return routeAdminUpdateMembership(c, db.MembershipGroup, false)
+}
+
+func routeAdminUpdateMembership(c echo.Context, kind db.MembershipKind, add bool) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+
+	account, err := findAdminAccountParam(c)
+	if err != nil {
+		return common.HttpError(c, http.StatusNotFound, err)
+	}
+
+	name := c.Param("name")
+	if add {
+		logger.Infof("Adding %s %s to account %s", kind, name, account.UUID)
+		err = sadb.AddMembership(account, kind, name)
+	} else {
+		logger.Infof("Removing %s %s from account %s", kind, name, account.UUID)
+		err = sadb.RemoveMembership(account, kind, name)
+	}
+	if err != nil {
+		if saerrors.UnwrapCode(err) == db.MembershipInvalid {
+			return common.HttpError(c, http.StatusBadRequest, err)
+		}
+		return common.HttpInternalError(c, err)
+	}
+
+	ret, err := newAdminAccountAttributesResponse(c, account)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// RouteAdminDeactivateAccount deactivates an account, revoking its tokens
+// @Summary Deactivate Account (Admin)
+// @Tags Admin
+// @Description Deactivate an account, revoking all of its OAuth and one-time tokens
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id}/deactivate [post]
+func (env *Environment) RouteAdminDeactivateAccount(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+
+	account, err := findAdminAccountParam(c)
+	if err != nil {
+		return common.HttpError(c, http.StatusNotFound, err)
+	}
+
+	logger.Infof("Deactivating account %s", account.UUID)
+	if err := sadb.DeactivateAccount(account); err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, newAdminAccountResponse(account))
+}
+
+// RouteAdminReactivateAccount reactivates a deactivated account
+// @Summary Reactivate Account (Admin)
+// @Tags Admin
+// @Description Reactivate a previously deactivated account
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id}/reactivate [post]
+func (env *Environment) RouteAdminReactivateAccount(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+
+	account, err := findAdminAccountParam(c)
+	if err != nil {
+		return common.HttpError(c, http.StatusNotFound, err)
+	}
+
+	logger.Infof("Reactivating account %s", account.UUID)
+	if err := sadb.ReactivateAccount(account); err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, newAdminAccountResponse(account))
+}
+
+// RouteAdminDeleteAccount permanently deletes an account
+// @Summary Delete Account (Admin)
+// @Tags Admin
+// @Description Permanently delete an account and all of its credentials. Audit records are retained
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Success 200 {object} common.OKResponse
+// @Failure 401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id} [delete]
+func (env *Environment) RouteAdminDeleteAccount(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+
+	account, err := findAdminAccountParam(c)
+	if err != nil {
+		return common.HttpError(c, http.StatusNotFound, err)
+	}
+
+	logger.Warnf("Deleting account %s", account.UUID)
+	if err := sadb.DeleteAccount(account); err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	return common.HttpOK(c)
+}
+
+// RouteAdminSearchAudit searches the audit trail across all accounts
+// @Summary Search Audit (Admin)
+// @Tags Admin
+// @Description Search the audit records of all, or one, account, newest first. Organization admins must pass an account
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param account query string false "Account UUID"
+// @Param module query string false "Comma-separated modules to include"
+// @Param level query string false "Comma-separated levels to include"
+// @Param since query string false "RFC3339 time, or a duration before now (eg. 24h)"
+// @Param until query string false "RFC3339 time, or a duration before now (eg. 24h)"
+// @Param q query string false "Case-insensitive text to search messages for"
+// @Param before query number false "Cursor to fetch records before, from the previous page's next"
+// @Param limit query number false "Limit of records to fetch, default 10, max 100"
+// @Success 200 {object} getAdminAuditResponse
+// @Failure 400,401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/audit [get]
+func (env *Environment) RouteAdminSearchAudit(c echo.Context) error {
+	sadb := appcontext.GetSADB(c)
+
+	query, err := parseAuditQuery(c)
+	if err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+	if accountUUID := c.QueryParam("account"); accountUUID != "" {
+		account, err := findAdminAccount(c, accountUUID)
+		if err != nil {
+			return common.HttpError(c, http.StatusNotFound, err)
+		}
+		query.Account = account
+	} else if err := requireGlobalAdmin(c); err != nil {
+		// Organization admins can only search one account at a time
+		return common.HttpError(c, http.StatusForbidden, err)
+	}
+
+	records, err := sadb.SearchAuditRecords(query)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	accountIDs := make([]uint, len(records))
+	for i, record := range records {
+		accountIDs[i] = record.AccountID
+	}
+	accounts, err := sadb.FindAccountsByID(accountIDs...)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	ret := make([]*getAdminAuditRecordResponse, len(records))
+	for i := range records {
+		ret[i] = &getAdminAuditRecordResponse{
+			getAccountAuditRecordResponse: newAccountAuditRecordResponse(&records[i]),
+		}
+		if account, ok := accounts[records[i].AccountID]; ok {
+			ret[i].Account = account.UUID
+		}
+	}
+
+	return c.JSON(http.StatusOK, getAdminAuditResponse{
+		Records: ret,
+		Next:    nextAuditCursor(query, records),
+	})
+}
+
+// RouteAdminAuditCheckpoint signs the current head of the audit chain
+// @Summary Audit Checkpoint (Admin)
+// @Tags Admin
+// @Description Get a signed checkpoint of the newest record in the audit hash-chain, to store externally
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Success 200 {object} getAdminAuditCheckpointResponse
+// @Failure 401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/audit/checkpoint [get]
+func (env *Environment) RouteAdminAuditCheckpoint(c echo.Context) error {
+	sadb := appcontext.GetSADB(c)
+
+	if err := requireGlobalAdmin(c); err != nil {
+		return common.HttpError(c, http.StatusForbidden, err)
+	}
+
+	if env.checkpointSigner == nil {
+		return common.HttpError(c, http.StatusNotFound, errorAuditCheckpointDisabled.New())
+	}
+
+	cp, token, err := env.checkpointSigner.SignHead(sadb)
+	if err == checkpoint.ErrEmptyChain {
+		return common.HttpError(c, http.StatusNotFound, errorAuditCheckpointEmpty.New())
+	}
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, getAdminAuditCheckpointResponse{
+		Index:   cp.Index,
+		Hash:    cp.Hash,
+		Created: cp.Created,
+		Token:   token,
+	})
+}
@@ -0,0 +1,114 @@
+package v1
+
+import (
+	"net/http"
+	"simple-auth/pkg/appcontext"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/routes/common"
+	"simple-auth/pkg/routes/middleware/selector/auth"
+	"simple-auth/pkg/saerrors"
+
+	"github.com/labstack/echo/v4"
+)
+
+type approvalRequest struct {
+	Reason string `json:"reason" example:"Welcome to the team"` // Optional, included in the email to the applicant
+}
+
+// RouteAdminListPendingAccounts lists accounts waiting on approval
+// @Summary List Pending Accounts (Admin)
+// @Tags Admin
+// @Description List the accounts waiting to be approved, oldest first. Organization admins only see their organization's
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Success 200 {array} getAdminAccountResponse
+// @Failure 401,403,500 {object} common.ErrorResponse
+// @Router /admin/accounts/pending [get]
+func (env *Environment) RouteAdminListPendingAccounts(c echo.Context) error {
+	accounts, err := appcontext.GetSADB(c).FindAccountsPendingApproval()
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	orgID, limited := auth.GetAdminOrganization(c)
+	ret := make([]*getAdminAccountResponse, 0, len(accounts))
+	for _, account := range accounts {
+		if limited && account.OrganizationID != orgID {
+			continue
+		}
+		item := newAdminAccountResponse(account)
+		item.PendingApproval = true
+		ret = append(ret, item)
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// RouteAdminApproveAccount approves a pending account
+// @Summary Approve Account (Admin)
+// @Tags Admin
+// @Description Approve an account waiting on approval, allowing it to login. The applicant is emailed
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Param approvalRequest body approvalRequest false "Reason"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 400,401,403,404,409,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id}/approve [post]
+func (env *Environment) RouteAdminApproveAccount(c echo.Context) error {
+	return env.routeAdminResolveApproval(c, true)
+}
+
+// RouteAdminRejectAccount rejects a pending account
+// @Summary Reject Account (Admin)
+// @Tags Admin
+// @Description Reject an account waiting on approval, deactivating it. The applicant is emailed
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Param approvalRequest body approvalRequest false "Reason"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 400,401,403,404,409,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id}/reject [post]
+func (env *Environment) RouteAdminRejectAccount(c echo.Context) error {
+	return env.routeAdminResolveApproval(c, false)
+}
+
+func (env *Environment) routeAdminResolveApproval(c echo.Context, approve bool) error {
+	logger := appcontext.GetLogger(c)
+	accountService := env.accountService.WithContext(c)
+
+	account, err := findAdminAccountParam(c)
+	if err != nil {
+		return common.HttpError(c, http.StatusNotFound, err)
+	}
+
+	var req approvalRequest
+	if err := c.Bind(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+
+	if approve {
+		logger.Infof("Approving account %s", account.UUID)
+		err = accountService.ApproveAccount(account, req.Reason)
+	} else {
+		logger.Infof("Rejecting account %s", account.UUID)
+		err = accountService.RejectAccount(account, req.Reason)
+	}
+	if err != nil {
+		if saerrors.UnwrapCode(err) == db.AccountNotPending {
+			return common.HttpError(c, http.StatusConflict, err)
+		}
+		return common.HttpInternalError(c, err)
+	}
+
+	ret, err := newAdminAccountAttributesResponse(c, account)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+	return c.JSON(http.StatusOK, ret)
+}
@@ -0,0 +1,328 @@
+package v1
+
+import (
+	"net/http"
+	"simple-auth/pkg/appcontext"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/routes/common"
+	"simple-auth/pkg/routes/middleware/selector/auth"
+	"simple-auth/pkg/saerrors"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type (
+	getAdminOrganizationResponse struct {
+		ID               string    `json:"id" example:"00000000-0000-0000-0000-000000000000"`
+		Slug             string    `json:"slug" example:"acme"`
+		Name             string    `json:"name" example:"Acme Inc"`
+		Created          time.Time `json:"created"`
+		AllowSignup      bool      `json:"allowSignup"`
+		AllowedClients   []string  `json:"allowedClients"`   // OAuth2 client IDs. Empty allows all
+		AllowedProviders []string  `json:"allowedProviders"` // OIDC provider IDs. Empty allows all
+		Accounts         int       `json:"accounts"`         // Number of accounts in the organization
+	}
+	createOrganizationRequest struct {
+		Slug             string   `json:"slug" validate:"required" example:"acme"`
+		Name             string   `json:"name" example:"Acme Inc"`
+		AllowSignup      bool     `json:"allowSignup"`
+		AllowedClients   []string `json:"allowedClients"`
+		AllowedProviders []string `json:"allowedProviders"`
+	}
+	patchOrganizationRequest struct {
+		Name             *string   `json:"name"`
+		AllowSignup      *bool     `json:"allowSignup"`
+		AllowedClients   *[]string `json:"allowedClients"`
+		AllowedProviders *[]string `json:"allowedProviders"`
+	}
+	putAccountOrganizationRequest struct {
+		Organization string `json:"organization" example:"acme"` // UUID or slug. Empty removes the account from its organization
+		Admin        bool   `json:"admin"`                       // If the account administers the organization
+	}
+)
+
+func newAdminOrganizationResponse(c echo.Context, org *db.Organization) (*getAdminOrganizationResponse, error) {
+	count, err := appcontext.GetSADB(c).CountOrganizationAccounts(org)
+	if err != nil {
+		return nil, err
+	}
+	return &getAdminOrganizationResponse{
+		ID:               org.UUID,
+		Slug:             org.Slug,
+		Name:             org.Name,
+		Created:          org.CreatedAt,
+		AllowSignup:      org.AllowSignup,
+		AllowedClients:   nonNilFields(org.AllowedClients),
+		AllowedProviders: nonNilFields(org.AllowedProviders),
+		Accounts:         count,
+	}, nil
+}
+
+func nonNilFields(s string) []string {
+	if ret := strings.Fields(s); ret != nil {
+		return ret
+	}
+	return []string{}
+}
+
+// findAdminOrganizationParam finds an organization the admin can manage. An organization admin can
+// only see their own
+func findAdminOrganizationParam(c echo.Context) (*db.Organization, error) {
+	org, err := appcontext.GetSADB(c).FindOrganization(c.Param("org"))
+	if err != nil {
+		return nil, err
+	}
+	if orgID, ok := auth.GetAdminOrganization(c); ok && org.ID != orgID {
+		return nil, db.OrganizationNotFound.Newf("Organization not found")
+	}
+	return org, nil
+}
+
+func organizationErrorStatus(err error) int {
+	switch saerrors.UnwrapCode(err) {
+	case db.OrganizationInvalid:
+		return http.StatusBadRequest
+	case db.OrganizationNotFound:
+		return http.StatusNotFound
+	case db.OrganizationNotEmpty, db.EmailUnavailable, db.AuthUsernameUnavailable:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// RouteAdminListOrganizations lists every organization
+// @Summary List Organizations (Admin)
+// @Tags Admin
+// @Description List every organization
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Success 200 {array} getAdminOrganizationResponse
+// @Failure 401,403,500 {object} common.ErrorResponse
+// @Router /admin/organizations [get]
+func (env *Environment) RouteAdminListOrganizations(c echo.Context) error {
+	if err := requireGlobalAdmin(c); err != nil {
+		return common.HttpError(c, http.StatusForbidden, err)
+	}
+
+	orgs, err := appcontext.GetSADB(c).GetOrganizations()
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	ret := make([]*getAdminOrganizationResponse, len(orgs))
+	for i, org := range orgs {
+		if ret[i], err = newAdminOrganizationResponse(c, org); err != nil {
+			return common.HttpInternalError(c, err)
+		}
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// RouteAdminCreateOrganization creates an organization
+// @Summary Create Organization (Admin)
+// @Tags Admin
+// @Description Create an organization, which accounts can then be added to
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Accept json
+// @Produce json
+// @Param createOrganizationRequest body createOrganizationRequest true "Organization"
+// @Success 201 {object} getAdminOrganizationResponse
+// @Failure 400,401,403,500 {object} common.ErrorResponse
+// @Router /admin/organizations [post]
+func (env *Environment) RouteAdminCreateOrganization(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+
+	if err := requireGlobalAdmin(c); err != nil {
+		return common.HttpError(c, http.StatusForbidden, err)
+	}
+
+	var req createOrganizationRequest
+	if err := c.Bind(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+
+	org, err := sadb.CreateOrganization(req.Slug, req.Name)
+	if err != nil {
+		return common.HttpError(c, organizationErrorStatus(err), err)
+	}
+	org.AllowSignup = req.AllowSignup
+	org.AllowedClients = strings.Join(req.AllowedClients, " ")
+	org.AllowedProviders = strings.Join(req.AllowedProviders, " ")
+	if err := sadb.UpdateOrganization(org); err != nil {
+		return common.HttpError(c, organizationErrorStatus(err), err)
+	}
+	logger.Infof("Created organization %s", org.Slug)
+
+	ret, err := newAdminOrganizationResponse(c, org)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+	return c.JSON(http.StatusCreated, ret)
+}
+
+// RouteAdminGetOrganization gets an organization
+// @Summary Get Organization (Admin)
+// @Tags Admin
+// @Description Get an organization. Organization admins can only get their own
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param org path string true "Organization UUID or slug"
+// @Success 200 {object} getAdminOrganizationResponse
+// @Failure 401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/organizations/{org} [get]
+func (env *Environment) RouteAdminGetOrganization(c echo.Context) error {
+	org, err := findAdminOrganizationParam(c)
+	if err != nil {
+		return common.HttpError(c, organizationErrorStatus(err), err)
+	}
+
+	ret, err := newAdminOrganizationResponse(c, org)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// RouteAdminUpdateOrganization updates an organization
+// @Summary Update Organization (Admin)
+// @Tags Admin
+// @Description Change an organization's name, signup, or allowed OAuth2 clients and OIDC providers
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Accept json
+// @Produce json
+// @Param org path string true "Organization UUID or slug"
+// @Param patchOrganizationRequest body patchOrganizationRequest true "Changes"
+// @Success 200 {object} getAdminOrganizationResponse
+// @Failure 400,401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/organizations/{org} [patch]
+func (env *Environment) RouteAdminUpdateOrganization(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+
+	if err := requireGlobalAdmin(c); err != nil {
+		return common.HttpError(c, http.StatusForbidden, err)
+	}
+
+	org, err := findAdminOrganizationParam(c)
+	if err != nil {
+		return common.HttpError(c, organizationErrorStatus(err), err)
+	}
+
+	var req patchOrganizationRequest
+	if err := c.Bind(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+	if req.Name != nil {
+		org.Name = *req.Name
+	}
+	if req.AllowSignup != nil {
+		org.AllowSignup = *req.AllowSignup
+	}
+	if req.AllowedClients != nil {
+		org.AllowedClients = strings.Join(*req.AllowedClients, " ")
+	}
+	if req.AllowedProviders != nil {
+		org.AllowedProviders = strings.Join(*req.AllowedProviders, " ")
+	}
+
+	logger.Infof("Updating organization %s", org.Slug)
+	if err := appcontext.GetSADB(c).UpdateOrganization(org); err != nil {
+		return common.HttpError(c, organizationErrorStatus(err), err)
+	}
+
+	ret, err := newAdminOrganizationResponse(c, org)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// RouteAdminDeleteOrganization deletes an empty organization
+// @Summary Delete Organization (Admin)
+// @Tags Admin
+// @Description Delete an organization. It must not have any accounts
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param org path string true "Organization UUID or slug"
+// @Success 200 {object} common.OKResponse
+// @Failure 401,403,404,409,500 {object} common.ErrorResponse
+// @Router /admin/organizations/{org} [delete]
+func (env *Environment) RouteAdminDeleteOrganization(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+
+	if err := requireGlobalAdmin(c); err != nil {
+		return common.HttpError(c, http.StatusForbidden, err)
+	}
+
+	org, err := findAdminOrganizationParam(c)
+	if err != nil {
+		return common.HttpError(c, organizationErrorStatus(err), err)
+	}
+
+	logger.Warnf("Deleting organization %s", org.Slug)
+	if err := appcontext.GetSADB(c).DeleteOrganization(org); err != nil {
+		return common.HttpError(c, organizationErrorStatus(err), err)
+	}
+	return common.HttpOK(c)
+}
+
+// RouteAdminSetAccountOrganization moves an account to an organization
+// @Summary Set Account Organization (Admin)
+// @Tags Admin
+// @Description Move an account to an organization, or out of any, and set whether it administers it.
+// @Description Fails if the account's email or username is already used in the organization
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Param putAccountOrganizationRequest body putAccountOrganizationRequest true "Organization"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 400,401,403,404,409,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id}/organization [put]
+func (env *Environment) RouteAdminSetAccountOrganization(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+
+	if err := requireGlobalAdmin(c); err != nil {
+		return common.HttpError(c, http.StatusForbidden, err)
+	}
+
+	account, err := findAdminAccountParam(c)
+	if err != nil {
+		return common.HttpError(c, http.StatusNotFound, err)
+	}
+
+	var req putAccountOrganizationRequest
+	if err := c.Bind(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+
+	var org *db.Organization
+	if req.Organization != "" {
+		if org, err = sadb.FindOrganization(req.Organization); err != nil {
+			return common.HttpError(c, organizationErrorStatus(err), err)
+		}
+	}
+
+	logger.Infof("Setting organization of account %s to '%s'", account.UUID, req.Organization)
+	if err := sadb.SetAccountOrganization(account, org, req.Admin); err != nil {
+		return common.HttpError(c, organizationErrorStatus(err), err)
+	}
+
+	ret, err := newAdminAccountAttributesResponse(c, account)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+	return c.JSON(http.StatusOK, ret)
+}
@@ -0,0 +1,229 @@
+package v1
+
+import (
+	"net/http"
+	"simple-auth/pkg/appcontext"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/routes/common"
+	"simple-auth/pkg/routes/middleware/selector/auth"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo/v4"
+)
+
+type requirePasswordChangeRequest struct {
+	Accounts     []string `json:"accounts" example:"a2c5e8f0-ffbe-4b5b-9b5e-3c0d7e7b6f3a"` // Account UUIDs
+	All          bool     `json:"all" example:"false"`                                     // Every account with a local login in the organization
+	Organization string   `json:"organization" example:"acme"`                             // UUID or slug used with all. Organization admins always use their own
+}
+
+type requirePasswordChangeResponse struct {
+	Count int `json:"count" example:"3"` // Number of accounts newly required to change their password
+}
+
+// RouteAdminRequirePasswordChange requires an account to change its password
+// @Summary Require Password Change (Admin)
+// @Tags Admin
+// @Description Require an account to change its password on its next login, before it can do anything else
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id}/require-password-change [post]
+func (env *Environment) RouteAdminRequirePasswordChange(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+
+	account, err := findAdminAccountParam(c)
+	if err != nil {
+		return common.HttpError(c, http.StatusNotFound, err)
+	}
+
+	logger.Infof("Requiring password change for account %s", account.UUID)
+	if err := sadb.RequirePasswordChange(account); err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	ret, err := newAdminAccountAttributesResponse(c, account)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// RouteAdminUnlockAccount unlocks an account's local login
+// @Summary Unlock Login (Admin)
+// @Tags Admin
+// @Description Unlock an account's local login, locked after repeated failed logins, and reset its count of failed logins
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id}/unlock [post]
+func (env *Environment) RouteAdminUnlockAccount(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+
+	account, err := findAdminAccountParam(c)
+	if err != nil {
+		return common.HttpError(c, http.StatusNotFound, err)
+	}
+
+	authLocal, err := sadb.FindAuthLocal(account)
+	if err != nil {
+		return common.HttpError(c, http.StatusNotFound, db.InvalidAccount.Wrapf(err, "Account has no local login"))
+	}
+
+	logger.Infof("Unlocking account %s", account.UUID)
+	if err := sadb.UnlockAuthLocal(authLocal); err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	ret, err := newAdminAccountAttributesResponse(c, account)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// RouteAdminExemptPasswordExpiry exempts an account's password from expiring
+// @Summary Exempt Password From Expiry (Admin)
+// @Tags Admin
+// @Description Exempt an account's local login from password expiry, eg. a service account
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id}/password-never-expires [put]
+func (env *Environment) RouteAdminExemptPasswordExpiry(c echo.Context) error {
+	return env.setPasswordNeverExpires(c, true)
+}
+
+// RouteAdminRemovePasswordExpiryExemption makes an account's password expire again
+// @Summary Remove Password Expiry Exemption (Admin)
+// @Tags Admin
+// @Description Remove an account's exemption from password expiry. If its password is already too old, it must be changed on next login
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Param id path string true "Account UUID"
+// @Success 200 {object} getAdminAccountResponse
+// @Failure 401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/accounts/{id}/password-never-expires [delete]
+func (env *Environment) RouteAdminRemovePasswordExpiryExemption(c echo.Context) error {
+	return env.setPasswordNeverExpires(c, false)
+}
+
+func (env *Environment) setPasswordNeverExpires(c echo.Context, neverExpires bool) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+
+	account, err := findAdminAccountParam(c)
+	if err != nil {
+		return common.HttpError(c, http.StatusNotFound, err)
+	}
+
+	authLocal, err := sadb.FindAuthLocal(account)
+	if err != nil {
+		return common.HttpError(c, http.StatusNotFound, db.InvalidAccount.Wrapf(err, "Account has no local login"))
+	}
+
+	logger.Infof("Setting password never expires to %v for account %s", neverExpires, account.UUID)
+	if err := sadb.SetPasswordNeverExpires(authLocal, neverExpires); err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	ret, err := newAdminAccountAttributesResponse(c, account)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// RouteAdminRequirePasswordChangeBulk requires many accounts to change their password
+// @Summary Require Password Change in Bulk (Admin)
+// @Tags Admin
+// @Description Require the listed accounts, or all accounts with a local login in an organization, to change their password on their next login
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Accept json
+// @Produce json
+// @Param requirePasswordChangeRequest body requirePasswordChangeRequest true "Accounts"
+// @Success 200 {object} requirePasswordChangeResponse
+// @Failure 400,401,403,404,500 {object} common.ErrorResponse
+// @Router /admin/accounts/require-password-change [post]
+func (env *Environment) RouteAdminRequirePasswordChangeBulk(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+
+	var req requirePasswordChangeRequest
+	if err := c.Bind(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+	if !req.All && len(req.Accounts) == 0 {
+		return common.HttpBadRequest(c, errorInvalidAccount.Newf("No accounts given"))
+	}
+
+	if req.All {
+		scope, err := findAdminPasswordChangeScope(c, req.Organization)
+		if err != nil {
+			return common.HttpError(c, organizationErrorStatus(err), err)
+		}
+
+		logger.Infof("Requiring password change for all accounts in organization '%s'", req.Organization)
+		count, err := sadb.InOrganization(scope).RequirePasswordChangeAll()
+		if err != nil {
+			return common.HttpInternalError(c, err)
+		}
+		return c.JSON(http.StatusOK, requirePasswordChangeResponse{Count: count})
+	}
+
+	accounts := make([]*db.Account, 0, len(req.Accounts))
+	for _, uuid := range req.Accounts {
+		account, err := findAdminAccount(c, uuid)
+		if err != nil {
+			return common.HttpError(c, http.StatusNotFound, err)
+		}
+		accounts = append(accounts, account)
+	}
+
+	count := 0
+	for _, account := range accounts {
+		if sadb.AccountHasStipulation(account, (&db.PasswordResetStipulation{}).Type()) {
+			continue
+		}
+		logger.Infof("Requiring password change for account %s", account.UUID)
+		if err := sadb.RequirePasswordChange(account); err != nil {
+			return common.HttpInternalError(c, err)
+		}
+		count++
+	}
+	return c.JSON(http.StatusOK, requirePasswordChangeResponse{Count: count})
+}
+
+// findAdminPasswordChangeScope resolves the organization a bulk password change applies to. An
+// organization admin is always limited to their own; nil is accounts in no organization
+func findAdminPasswordChangeScope(c echo.Context, uuidOrSlug string) (*db.Organization, error) {
+	orgID, limited := auth.GetAdminOrganization(c)
+	if uuidOrSlug == "" {
+		if limited {
+			return &db.Organization{Model: gorm.Model{ID: orgID}}, nil
+		}
+		return nil, nil
+	}
+
+	org, err := appcontext.GetSADB(c).FindOrganization(uuidOrSlug)
+	if err != nil {
+		return nil, err
+	}
+	if limited && org.ID != orgID {
+		return nil, db.OrganizationNotFound.Newf("Organization not found")
+	}
+	return org, nil
+}
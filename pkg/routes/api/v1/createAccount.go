@@ -3,8 +3,10 @@ package v1
 import (
 	"net/http"
 	"simple-auth/pkg/appcontext"
+	"simple-auth/pkg/db"
 	"simple-auth/pkg/routes/common"
 	"simple-auth/pkg/routes/middleware/selector/auth"
+	"simple-auth/pkg/saerrors"
 	"simple-auth/pkg/services"
 	"strconv"
 
@@ -21,6 +23,7 @@ type createAccountResponse struct {
 	ID              string `json:"id"`                        // ID of the created user
 	CreatedSession  bool   `json:"createdSession,omitempty"`  // Did create session
 	HasStipulations bool   `json:"hasStipulations,omitempty"` // Upon success creating, whether or not account needs email
+	PendingApproval bool   `json:"pendingApproval,omitempty"` // Account needs to be approved by an admin before login
 }
 
 // RouteCreateAccount creates a new account from echo context
@@ -33,7 +36,7 @@ type createAccountResponse struct {
 // @Param createRequest body createAccountRequest true "Create request"
 // @Param createSession query boolean false "Attempts to create a session on successful login"
 // @Success 200 {object} createAccountResponse
-// @Failure 400,401,404,500 {object} common.ErrorResponse
+// @Failure 400,401,403,404,500 {object} common.ErrorResponse
 // @Router /account [post]
 func (env *Environment) RouteCreateAccount(c echo.Context) error {
 	logger := appcontext.GetLogger(c)
@@ -50,10 +53,22 @@ func (env *Environment) RouteCreateAccount(c echo.Context) error {
 
 	createSession, _ := strconv.ParseBool(c.QueryParam("createSession"))
 
+	if org := appcontext.GetOrganization(c); org != nil && !org.AllowSignup {
+		return common.HttpError(c, http.StatusForbidden, db.OrganizationClosed.Newf("Organization doesn't allow signup"))
+	}
+
 	if exists, err := loginService.UsernameExists(req.Username); exists || err != nil {
 		return common.HttpError(c, http.StatusConflict, services.LocalUsernameUnavailable.Wrap(err))
 	}
 
+	// Checked before the account is created, since an error response doesn't roll it back
+	if err := loginService.ValidatePassword(req.Username, req.Email, req.Password); err != nil {
+		if saerrors.UnwrapCode(err) == services.LocalCredentialRequirements {
+			return common.HttpError(c, http.StatusBadRequest, err)
+		}
+		return common.HttpInternalError(c, err)
+	}
+
 	account, err := accountService.CreateAccount(req.Username, req.Email)
 	if err != nil {
 		return common.HttpError(c, http.StatusBadRequest, err)
@@ -66,6 +81,7 @@ func (env *Environment) RouteCreateAccount(c echo.Context) error {
 	ret := &createAccountResponse{
 		ID:              account.UUID,
 		HasStipulations: accountService.HasUnsatisfiedStipulations(account),
+		PendingApproval: accountService.PendingApproval(account),
 	}
 
 	if createSession && !ret.HasStipulations {
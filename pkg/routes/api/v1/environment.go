@@ -3,7 +3,10 @@ package v1
 import (
 	"simple-auth/pkg/config"
 	"simple-auth/pkg/email"
+	"simple-auth/pkg/lib/checkpoint"
 	"simple-auth/pkg/services"
+
+	"github.com/sirupsen/logrus"
 )
 
 type Environment struct {
@@ -13,16 +16,26 @@ type Environment struct {
 	oidcService       services.OIDCService
 	sessionService    services.SessionService
 	loginConfig       *config.ConfigLoginCookie
+	termsConfig       *config.ConfigTerms
+	checkpointSigner  *checkpoint.Signer // nil if not configured
 }
 
 func NewEnvironment(config *config.Config) *Environment {
 	emailService := email.NewFromConfig(&config.Email)
+
+	signer, err := checkpoint.NewFromConfig(&config.Audit.Checkpoint)
+	if err != nil && err != checkpoint.ErrNotConfigured {
+		logrus.Fatalf("Invalid audit checkpoint config: %v", err)
+	}
+
 	return &Environment{
-		services.NewAccountService(&config.Metadata, &config.Web, emailService),
-		services.NewLocalLoginService(emailService, &config.Metadata, &config.Providers.Local, config.Web.GetBaseURL()),
+		services.NewAccountService(&config.Metadata, &config.Web, &config.Providers.Settings, &config.Providers.Local, emailService),
+		services.NewLocalLoginService(emailService, &config.Metadata, &config.Providers.Local, &config.Providers.Settings.Terms, config.Web.GetBaseURL()),
 		services.NewTwoFactorService(&config.Providers.Local.TwoFactor),
 		services.NewOIDCService(config.Providers.OIDC),
 		services.NewSessionService(emailService, &config.Web.Login.Cookie, &config.Web.Login.OneTime, &config.Web, &config.Metadata),
 		&config.Web.Login.Cookie,
+		&config.Providers.Settings.Terms,
+		signer,
 	}
 }
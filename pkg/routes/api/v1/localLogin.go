@@ -2,18 +2,21 @@ package v1
 
 import (
 	"net/http"
+	"simple-auth/pkg/appcontext"
 	"simple-auth/pkg/routes/common"
 	"simple-auth/pkg/routes/middleware/selector/auth"
 	"simple-auth/pkg/saerrors"
+	"simple-auth/pkg/services"
 
 	"github.com/labstack/echo/v4"
 )
 
 type getLocalLoginResponse struct {
-	Username           string `json:"username"`
-	HasTwoFactor       bool   `json:"twofactor"`
-	AllowTwoFactor     bool   `json:"twofactorallowed"`
-	RequireOldPassword bool   `json:"requireOldPassword"`
+	Username               string `json:"username"`
+	HasTwoFactor           bool   `json:"twofactor"`
+	AllowTwoFactor         bool   `json:"twofactorallowed"`
+	RecoveryCodesRemaining int    `json:"recoveryCodesRemaining"` // Unused 2fa recovery codes
+	RequireOldPassword     bool   `json:"requireOldPassword"`
 }
 
 const localLoginNotFound saerrors.ErrorCode = "local-login-not-found"
@@ -46,11 +49,17 @@ func (env *Environment) getLocalLoginResponse(c echo.Context) (*getLocalLoginRes
 		return nil, err
 	}
 
+	recoveryCodes, err := env.localLoginService.WithContext(c).RecoveryCodesRemaining(authLocal)
+	if err != nil {
+		return nil, err
+	}
+
 	return &getLocalLoginResponse{
-		Username:           authLocal.Username(),
-		HasTwoFactor:       authLocal.HasTOTP(),
-		AllowTwoFactor:     env.localLoginService.AllowTOTP(),
-		RequireOldPassword: !allowUnsafePasswordUpdate(authContext),
+		Username:               authLocal.Username(),
+		HasTwoFactor:           authLocal.HasTOTP(),
+		AllowTwoFactor:         env.localLoginService.AllowTOTP(),
+		RecoveryCodesRemaining: recoveryCodes,
+		RequireOldPassword:     !allowUnsafePasswordUpdate(authContext),
 	}, nil
 }
 
@@ -62,7 +71,8 @@ type changePasswordRequest struct {
 // RouteChangePassword change password for local auth
 // @Summary Change Password
 // @Tags Local
-// @Description Change password for local auth
+// @Description Change password for local auth. Also accepts the restricted session issued when a login
+// @Description requires a password change, and upgrades it to a full session once the password is changed
 // @Security ApiKeyAuth
 // @Security SessionAuth
 // @Accept json
@@ -91,17 +101,72 @@ func (env *Environment) RouteChangePassword(c echo.Context) error {
 	if allowUnsafePasswordUpdate(authContext) {
 		// Change password, but exempt from the oldPassword requirement
 		if err := loginService.UpdatePasswordUnsafe(authLocal, req.NewPassword); err != nil {
+			if isPasswordRejected(err) {
+				return common.HttpError(c, http.StatusBadRequest, err)
+			}
 			return common.HttpInternalError(c, err)
 		}
 	} else {
 		if err := loginService.UpdatePassword(authLocal, req.OldPassword, req.NewPassword); err != nil {
+			if isPasswordRejected(err) {
+				return common.HttpError(c, http.StatusBadRequest, err)
+			}
 			return common.HttpError(c, http.StatusUnauthorized, err)
 		}
 	}
 
+	if authContext.Restriction == auth.RestrictPasswordChange {
+		next := loginService.NextLoginStep(authLocal)
+		if status, err := env.issueLoginStepSession(c, authLocal.Account(), authContext.Source, next); err != nil {
+			return common.HttpError(c, status, err)
+		}
+	}
+
 	return common.HttpOK(c)
 }
 
+// isPasswordRejected is true if the new password was refused, rather than the request being unauthorized
+func isPasswordRejected(err error) bool {
+	code := saerrors.UnwrapCode(err)
+	return code == services.LocalPasswordUnchanged || code == services.LocalCredentialRequirements
+}
+
 func allowUnsafePasswordUpdate(ctx *auth.AuthContext) bool {
 	return ctx.Source == auth.SourceOneTime || ctx.Source == auth.SourceSecret
 }
+
+type unlockRequest struct {
+	AccountID string `json:"account" validate:"required,uuid"`
+	Token     string `json:"token" validate:"required"`
+}
+
+// RouteUnlockLocalLogin unlocks a local login with the emailed link
+// @Summary Unlock Login
+// @Tags Local
+// @Description Unlock a local login, locked after repeated failed logins, with the token emailed to the account
+// @Accept json
+// @Produce json
+// @Param unlockRequest body unlockRequest true "Body"
+// @Success 200 {object} common.OKResponse
+// @Failure 400,401,500 {object} common.ErrorResponse
+// @Router /local/unlock [post]
+func (env *Environment) RouteUnlockLocalLogin(c echo.Context) error {
+	var req unlockRequest
+	if err := c.Bind(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+
+	account, err := appcontext.GetSADB(c).FindAccount(req.AccountID)
+	if err != nil {
+		return common.HttpError(c, http.StatusUnauthorized, errorInvalidAccount.Wrap(err))
+	}
+
+	if err := env.localLoginService.WithContext(c).Unlock(account, req.Token); err != nil {
+		return common.HttpError(c, http.StatusUnauthorized, err)
+	}
+
+	return common.HttpOK(c)
+}
@@ -8,13 +8,15 @@ import (
 	"simple-auth/pkg/routes/middleware/selector/auth"
 	"simple-auth/pkg/saerrors"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
 const (
-	errorInvalidAccount saerrors.ErrorCode = "invalid-account"
+	errorInvalidAccount   saerrors.ErrorCode = "invalid-account"
+	errorInvalidAttribute saerrors.ErrorCode = "invalid-attribute"
 )
 
 type (
@@ -29,11 +31,15 @@ type (
 		OIDC  *[]getAccountOIDCAuthResponse `json:"oidc,omitempty"`
 	}
 	getAccountResponse struct {
-		ID      string                         `json:"id" example:"00000000-0000-0000-0000-000000000000"`
-		Created time.Time                      `json:"created"`
-		Email   string                         `json:"email" example:"sa@example.com"`
-		Name    string                         `json:"name" example:"John Smith"`
-		Auth    getAccountAuthProviderResponse `json:"auth"`
+		ID         string                         `json:"id" example:"00000000-0000-0000-0000-000000000000"`
+		Created    time.Time                      `json:"created"`
+		Email      string                         `json:"email" example:"sa@example.com"`
+		Name       string                         `json:"name" example:"John Smith"`
+		Attributes map[string]interface{}         `json:"attributes"` // Custom profile attributes, as declared in config
+		Auth       getAccountAuthProviderResponse `json:"auth"`
+	}
+	patchAccountRequest struct {
+		Attributes map[string]interface{} `json:"attributes"` // Attributes to set, or null to remove
 	}
 )
 
@@ -59,11 +65,17 @@ func (env *Environment) RouteGetAccount(c echo.Context) error {
 		return common.HttpError(c, http.StatusInternalServerError, errorInvalidAccount.Wrapf(err, "Logged in with unknown account"))
 	}
 
+	values, err := sadb.GetAccountAttributes(account)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
 	response := getAccountResponse{
-		ID:      account.UUID,
-		Created: account.CreatedAt,
-		Email:   account.Email,
-		Name:    account.Name,
+		ID:         account.UUID,
+		Created:    account.CreatedAt,
+		Email:      account.Email,
+		Name:       account.Name,
+		Attributes: appcontext.GetAttributeSchema(c).Visible(values, false),
 	}
 
 	if localLoginResponse, err := env.getLocalLoginResponse(c); err != nil {
@@ -89,27 +101,173 @@ func (env *Environment) RouteGetAccount(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+// parseAccountAttributes binds a patch request, and validates its attributes
+func parseAccountAttributes(c echo.Context, admin bool) (map[string]*string, error) {
+	var req patchAccountRequest
+	if err := c.Bind(&req); err != nil {
+		return nil, errorInvalidAttribute.Wrap(err)
+	}
+
+	values, err := appcontext.GetAttributeSchema(c).Parse(req.Attributes, admin)
+	if err != nil {
+		return nil, errorInvalidAttribute.Wrap(err)
+	}
+	return values, nil
+}
+
+// RouteUpdateAccount updates the account's attributes
+// @Summary Update Account
+// @Tags Account
+// @Description Set or remove the account's editable attributes
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Accept json
+// @Produce json
+// @Param patchAccountRequest body patchAccountRequest true "Attributes"
+// @Success 200 {object} getAccountResponse
+// @Failure 400,401,404,500 {object} common.ErrorResponse
+// @Router /account [patch]
+func (env *Environment) RouteUpdateAccount(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+	accountUUID := auth.MustGetAccountUUID(c)
+
+	account, err := sadb.FindAccount(accountUUID)
+	if err != nil {
+		return common.HttpError(c, http.StatusInternalServerError, errorInvalidAccount.Wrapf(err, "Logged in with unknown account"))
+	}
+
+	values, err := parseAccountAttributes(c, false)
+	if err != nil {
+		return common.HttpError(c, http.StatusBadRequest, err)
+	}
+
+	logger.Infof("Update account for %s", accountUUID)
+	if err := sadb.SetAccountAttributes(account, values); err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	return env.RouteGetAccount(c)
+}
+
 type (
 	getAccountAuditRecordResponse struct {
-		Timestamp time.Time      `json:"ts"`
-		Module    db.AuditModule `json:"module"`
-		Level     db.AuditLevel  `json:"level"`
-		Message   string         `json:"message"`
+		Timestamp     time.Time      `json:"ts"`
+		Module        db.AuditModule `json:"module"`
+		Level         db.AuditLevel  `json:"level"`
+		Message       string         `json:"message"`
+		IP            string         `json:"ip,omitempty"`
+		UserAgent     string         `json:"userAgent,omitempty"`
+		CorrelationID string         `json:"correlationId,omitempty"`
 	}
 	getAccountAuditResponse struct {
 		Records []*getAccountAuditRecordResponse `json:"records"`
+		Next    uint                             `json:"next,omitempty"` // Cursor for the next page, passed as `before`
 	}
 )
 
+const errorInvalidAuditQuery saerrors.ErrorCode = "invalid-audit-query"
+
+func newAccountAuditRecordResponse(record *db.AccountAuditRecord) *getAccountAuditRecordResponse {
+	return &getAccountAuditRecordResponse{
+		Timestamp:     record.CreatedAt,
+		Module:        record.Module,
+		Level:         record.Level,
+		Message:       record.Message,
+		IP:            record.IPAddress,
+		UserAgent:     record.UserAgent,
+		CorrelationID: record.CorrelationID,
+	}
+}
+
+// splitQueryParams returns every value of a query param, allowing either repeated or comma-separated values
+func splitQueryParams(c echo.Context, name string) (ret []string) {
+	for _, param := range c.QueryParams()[name] {
+		for _, val := range strings.Split(param, ",") {
+			if val = strings.TrimSpace(val); val != "" {
+				ret = append(ret, val)
+			}
+		}
+	}
+	return
+}
+
+// parseQueryTime parses either an RFC3339 time, or a duration before now (eg. 24h)
+func parseQueryTime(val string) (time.Time, error) {
+	if val == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(val); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, val)
+}
+
+// parseAuditQuery builds an audit query from the common query params
+func parseAuditQuery(c echo.Context) (*db.AuditQuery, error) {
+	query := &db.AuditQuery{
+		Message: c.QueryParam("q"),
+	}
+
+	for _, module := range splitQueryParams(c, "module") {
+		query.Modules = append(query.Modules, db.AuditModule(module))
+	}
+	for _, level := range splitQueryParams(c, "level") {
+		query.Levels = append(query.Levels, db.AuditLevel(level))
+	}
+
+	var err error
+	if query.Since, err = parseQueryTime(c.QueryParam("since")); err != nil {
+		return nil, errorInvalidAuditQuery.Wrapf(err, "Invalid since")
+	}
+	if query.Until, err = parseQueryTime(c.QueryParam("until")); err != nil {
+		return nil, errorInvalidAuditQuery.Wrapf(err, "Invalid until")
+	}
+
+	for _, param := range []struct {
+		name string
+		val  *int
+	}{{"offset", &query.Offset}, {"limit", &query.Limit}} {
+		if raw := c.QueryParam(param.name); raw != "" {
+			if *param.val, err = strconv.Atoi(raw); err != nil || *param.val < 0 {
+				return nil, errorInvalidAuditQuery.Newf("Invalid %s", param.name)
+			}
+		}
+	}
+	if before := c.QueryParam("before"); before != "" {
+		cursor, err := strconv.ParseUint(before, 10, 32)
+		if err != nil {
+			return nil, errorInvalidAuditQuery.Wrapf(err, "Invalid before")
+		}
+		query.Before = uint(cursor)
+	}
+
+	return query, nil
+}
+
+// nextAuditCursor returns the cursor for the page after records, or 0 if there are no more
+func nextAuditCursor(query *db.AuditQuery, records []db.AccountAuditRecord) uint {
+	if len(records) == 0 || len(records) < query.EffectiveLimit() {
+		return 0
+	}
+	return records[len(records)-1].ID
+}
+
 // @Summary Get Account Audit
 // @Tags Account
-// @Description Get account audit trail
+// @Description Get account audit trail, newest first
 // @Security ApiKeyAuth
 // @Security SessionAuth
 // @Accept json
 // @Produce json
+// @Param module query string false "Comma-separated modules to include"
+// @Param level query string false "Comma-separated levels to include"
+// @Param since query string false "RFC3339 time, or a duration before now (eg. 24h)"
+// @Param until query string false "RFC3339 time, or a duration before now (eg. 24h)"
+// @Param q query string false "Case-insensitive text to search messages for"
+// @Param before query number false "Cursor to fetch records before, from the previous page's next"
 // @Param offset query number false "Offset of record to fetch"
-// @Param limit query number false "Limit of records to fetch, default 10"
+// @Param limit query number false "Limit of records to fetch, default 10, max 100"
 // @Success 200 {object} getAccountAuditResponse
 // @Failure 400,401,404,500 {object} common.ErrorResponse
 // @Router /account/audit [get]
@@ -125,28 +283,24 @@ func (env *Environment) RouteGetAccountAudit(c echo.Context) error {
 		return common.HttpError(c, http.StatusInternalServerError, errorInvalidAccount.Wrapf(err, "Logged in with unknown account"))
 	}
 
-	offset, _ := strconv.Atoi(c.QueryParam("offset"))
-	limit, _ := strconv.Atoi(c.QueryParam("limit"))
-	if limit == 0 {
-		limit = 10
+	query, err := parseAuditQuery(c)
+	if err != nil {
+		return common.HttpBadRequest(c, err)
 	}
+	query.Account = account
 
-	records, err := sadb.GetAuditTrailForAccount(account, offset, limit)
+	records, err := sadb.SearchAuditRecords(query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
+		return common.HttpInternalError(c, err)
 	}
 
 	ret := make([]*getAccountAuditRecordResponse, len(records))
-	for i, record := range records {
-		ret[i] = &getAccountAuditRecordResponse{
-			Timestamp: record.CreatedAt,
-			Module:    record.Module,
-			Level:     record.Level,
-			Message:   record.Message,
-		}
+	for i := range records {
+		ret[i] = newAccountAuditRecordResponse(&records[i])
 	}
 
 	return c.JSON(http.StatusOK, getAccountAuditResponse{
 		Records: ret,
+		Next:    nextAuditCursor(query, records),
 	})
 }
@@ -3,16 +3,48 @@ package v1
 import (
 	"net/http"
 	"simple-auth/pkg/appcontext"
+	"simple-auth/pkg/db"
 	"simple-auth/pkg/instrumentation"
 	"simple-auth/pkg/routes/common"
 	"simple-auth/pkg/routes/middleware/selector/auth"
 	"simple-auth/pkg/saerrors"
+	"simple-auth/pkg/services"
 
 	"github.com/labstack/echo/v4"
 )
 
 var loginCounter instrumentation.Counter = instrumentation.NewCounter("sa_local_login", "Counter for local login", "success")
 
+// loginRestrictions are the login errors that leave the account a step to complete, with a session
+// restricted to completing it. Completing it then issues a full session
+var loginRestrictions = map[saerrors.ErrorCode]auth.SessionRestriction{
+	db.TermsRequired:                      auth.RestrictTerms,
+	services.LocalPasswordChangeRequired:  auth.RestrictPasswordChange,
+	services.LocalPasswordExpired:         auth.RestrictPasswordChange,
+	services.LocalTwoFactorEnrollRequired: auth.RestrictTwoFactorEnroll,
+}
+
+// issueLoginStepSession is called once a restricted session completed its step. It issues a session restricted
+// to the next step, if the login has one left (next, see services.LocalLoginService.NextLoginStep), or a full
+// session. If the login isn't complete, the status and error to respond with are returned
+func (env *Environment) issueLoginStepSession(c echo.Context, account *db.Account, source auth.SessionSource, next error) (int, error) {
+	if next != nil {
+		restriction, ok := loginRestrictions[saerrors.UnwrapCode(next)]
+		if !ok {
+			return http.StatusInternalServerError, next
+		}
+		if err := auth.CreateRestrictedSession(c, env.loginConfig, account, source, restriction); err != nil {
+			return http.StatusInternalServerError, ErrSessionDisabled.Wrap(err)
+		}
+		return http.StatusUnauthorized, next
+	}
+
+	if err := env.sessionService.IssueSession(c, account, source); err != nil {
+		return http.StatusInternalServerError, ErrSessionDisabled.Wrap(err)
+	}
+	return http.StatusOK, nil
+}
+
 type loginRequest struct {
 	Username string  `json:"username" validate:"required"`
 	Password string  `json:"password" validate:"required"`
@@ -49,6 +81,13 @@ func (env *Environment) RouteSessionLogin(c echo.Context) error {
 	logger.Infof("Attempting login for '%s'...", req.Username)
 
 	authLocal, err := env.localLoginService.WithContext(c).AssertLogin(req.Username, req.Password, req.Totp)
+	if restriction, ok := loginRestrictions[saerrors.UnwrapCode(err)]; ok {
+		logger.Infof("Login for user '%s' requires %s", req.Username, restriction)
+		if err := auth.CreateRestrictedSession(c, env.loginConfig, authLocal.Account(), auth.SourceLogin, restriction); err != nil {
+			return common.HttpError(c, http.StatusInternalServerError, ErrSessionDisabled.Wrap(err))
+		}
+		return common.HttpError(c, http.StatusUnauthorized, err)
+	}
 	if err != nil {
 		logger.Infof("Login for user '%s' rejected: %v", req.Username, err)
 		loginCounter.Inc(false)
@@ -6,6 +6,7 @@ import (
 	"simple-auth/pkg/db"
 	"simple-auth/pkg/routes/common"
 	"simple-auth/pkg/routes/middleware/selector/auth"
+	"simple-auth/pkg/saerrors"
 
 	"github.com/labstack/echo/v4"
 )
@@ -52,3 +53,58 @@ func (env *Environment) RouteSatisfyTokenStipulation(c echo.Context) error {
 
 	return common.HttpOK(c)
 }
+
+type resendStipulationRequest struct {
+	Email     string `json:"email" validate:"omitempty,email" example:"sa@example.com"`
+	AccountID string `json:"account" validate:"omitempty,uuid"` // Alternatively to email, eg. from an expired link
+}
+
+// RouteResendTokenStipulation re-sends the verification email
+// @Summary Resend Verification
+// @Description Sends a new verification email to an account that hasn't verified its email, invalidating the previous link. To prevent scanning for emails, succeeds even if there is nothing to resend
+// @Tags Stipulation
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param req body resendStipulationRequest true "Email or account"
+// @Success 200 {object} common.OKResponse
+// @Failure 400,401,500 {object} common.ErrorResponse
+// @Router /stipulation/resend [post]
+func (env *Environment) RouteResendTokenStipulation(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+
+	var req resendStipulationRequest
+	if err := c.Bind(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+	if (req.Email == "") == (req.AccountID == "") {
+		return common.HttpBadRequestf(c, "pass either email or account")
+	}
+
+	var account *db.Account
+	var err error
+	if req.Email != "" {
+		account, err = env.accountService.WithContext(c).FindAccountByEmail(req.Email)
+	} else {
+		account, err = appcontext.GetSADB(c).FindAccount(req.AccountID)
+	}
+	if err != nil {
+		logger.Warn("No account found to resend verification")
+		return common.HttpOK(c) // A mis-direct, to prevent scanning for emails
+	}
+
+	logger.Infof("Resending verification to %s...", account.UUID)
+	if err := env.localLoginService.WithContext(c).ResendVerification(account); err != nil {
+		switch saerrors.UnwrapCode(err) {
+		case db.VerificationMissing, db.VerificationThrottled:
+			logger.Warnf("Not resending verification to %s: %v", account.UUID, err)
+			return common.HttpOK(c)
+		}
+		return common.HttpInternalError(c, err)
+	}
+
+	return common.HttpOK(c)
+}
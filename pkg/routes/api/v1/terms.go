@@ -0,0 +1,125 @@
+package v1
+
+import (
+	"net/http"
+	"simple-auth/pkg/appcontext"
+	"simple-auth/pkg/routes/common"
+	"simple-auth/pkg/routes/middleware/selector/auth"
+	"simple-auth/pkg/saerrors"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	errorTermsDisabled saerrors.ErrorCode = "terms-disabled"
+	errorTermsOutdated saerrors.ErrorCode = "terms-outdated"
+)
+
+type getTermsResponse struct {
+	Version    string     `json:"version" example:"2021-01"` // Current version every account must accept
+	URL        string     `json:"url,omitempty" example:"https://example.com/terms"`
+	Required   bool       `json:"required"`                             // If the account still needs to accept the current version
+	Accepted   string     `json:"accepted,omitempty" example:"2020-06"` // Version the account last accepted
+	AcceptedAt *time.Time `json:"acceptedAt,omitempty"`                 // When the account last accepted the terms
+}
+
+type acceptTermsRequest struct {
+	Version string `json:"version" validate:"required" example:"2021-01"` // Version being accepted. Must be the current version
+}
+
+// @Summary Get Terms
+// @Description Get the current terms of service, and which version the account last accepted, and when.
+// @Description Also accepts the restricted session issued when a login requires accepting the terms
+// @Tags Account
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Produce json
+// @Success 200 {object} getTermsResponse
+// @Failure 401,404,500 {object} common.ErrorResponse
+// @Router /account/terms [get]
+func (env *Environment) RouteGetTerms(c echo.Context) error {
+	sadb := appcontext.GetSADB(c)
+
+	if env.termsConfig.Version == "" {
+		return common.HttpError(c, http.StatusNotFound, errorTermsDisabled.Newf("No terms to accept"))
+	}
+
+	account, err := sadb.FindAccount(auth.MustGetAccountUUID(c))
+	if err != nil {
+		return common.HttpError(c, http.StatusInternalServerError, errorInvalidAccount.Wrapf(err, "Logged in with unknown account"))
+	}
+	acceptance, err := sadb.GetTermsAcceptance(account)
+	if err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	ret := getTermsResponse{
+		Version:  env.termsConfig.Version,
+		URL:      env.termsConfig.URL,
+		Required: true,
+	}
+	if acceptance != nil {
+		ret.Accepted = acceptance.Version
+		ret.AcceptedAt = &acceptance.AcceptedAt
+		ret.Required = acceptance.Version != env.termsConfig.Version
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+// @Summary Accept Terms
+// @Description Accept the current terms of service, recording it in the audit trail. Also accepts the restricted
+// @Description session issued when a login requires accepting the terms, and upgrades it to a full session, unless
+// @Description the login still requires a password change
+// @Tags Account
+// @Security ApiKeyAuth
+// @Security SessionAuth
+// @Accept json
+// @Produce json
+// @Param acceptTermsRequest body acceptTermsRequest true "Version"
+// @Success 200 {object} common.OKResponse
+// @Failure 400,401,404,409,500 {object} common.ErrorResponse
+// @Router /account/terms [post]
+func (env *Environment) RouteAcceptTerms(c echo.Context) error {
+	logger := appcontext.GetLogger(c)
+	sadb := appcontext.GetSADB(c)
+	authContext := auth.MustGetAuthContext(c)
+
+	var req acceptTermsRequest
+	if err := c.Bind(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return common.HttpBadRequest(c, err)
+	}
+
+	if env.termsConfig.Version == "" {
+		return common.HttpError(c, http.StatusNotFound, errorTermsDisabled.Newf("No terms to accept"))
+	}
+	if req.Version != env.termsConfig.Version {
+		return common.HttpError(c, http.StatusConflict, errorTermsOutdated.Newf("Only the current terms, %s, can be accepted", env.termsConfig.Version))
+	}
+
+	account, err := sadb.FindAccount(authContext.UUID)
+	if err != nil {
+		return common.HttpError(c, http.StatusInternalServerError, errorInvalidAccount.Wrapf(err, "Logged in with unknown account"))
+	}
+
+	logger.Infof("Accepting terms %s for %s", req.Version, account.UUID)
+	if err := sadb.AcceptTerms(account, req.Version); err != nil {
+		return common.HttpInternalError(c, err)
+	}
+
+	if authContext.Restriction == auth.RestrictTerms {
+		// The login may still have a step left, unless the account only logs in with OIDC
+		var next error
+		if authLocal, err := sadb.FindAuthLocal(account); err == nil {
+			next = env.localLoginService.WithContext(c).NextLoginStep(authLocal)
+		}
+		if status, err := env.issueLoginStepSession(c, account, authContext.Source, next); err != nil {
+			return common.HttpError(c, status, err)
+		}
+	}
+
+	return common.HttpOK(c)
+}
@@ -17,9 +17,10 @@ const (
 )
 
 type ErrorResponse struct {
-	Error   bool   `json:"error" default:"true"`
-	Message string `json:"message" example:"A human-readable message"`
-	Reason  string `json:"reason" example:"machine-code"`
+	Error   bool     `json:"error" default:"true"`
+	Message string   `json:"message" example:"A human-readable message"`
+	Reason  string   `json:"reason" example:"machine-code"`
+	Details []string `json:"details,omitempty" example:"missing-digit"` // Further machine codes, if the reason has them
 }
 
 type OKResponse struct {
@@ -51,17 +52,18 @@ func HttpInternalErrorf(c echo.Context, err string) error {
 func HttpError(c echo.Context, code int, err error) error {
 	var saerr saerrors.CodedError
 	if errors.As(err, &saerr) {
-		return httpErrorCoded(c, code, string(saerr.Code()), saerr.Message(), saerr.Error())
+		return httpErrorCoded(c, code, string(saerr.Code()), saerr.Message(), saerr.Error(), saerrors.UnwrapDetails(err))
 	}
-	return httpErrorCoded(c, code, "no-code", err.Error(), err.Error())
+	return httpErrorCoded(c, code, "no-code", err.Error(), err.Error(), nil)
 }
 
-func httpErrorCoded(c echo.Context, code int, reason, message, fullError string) error {
+func httpErrorCoded(c echo.Context, code int, reason, message, fullError string, details []string) error {
 	log := appcontext.GetLogger(c)
 	log.Warnf("%d [%s]: %s", code, reason, fullError)
 	return c.JSON(code, ErrorResponse{
 		Error:   true,
 		Message: message,
 		Reason:  reason,
+		Details: details,
 	})
 }
@@ -10,8 +10,6 @@ import (
 const headerCorrelationID = "X-Correlation-ID"
 const correlationIDLength = 12
 
-const contextCorrelationIDKey = "correlationID"
-
 func NewCorrelationMiddleware(readHeader, writeHeader bool) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -23,7 +21,7 @@ func NewCorrelationMiddleware(readHeader, writeHeader bool) echo.MiddlewareFunc
 				cid = random.String(correlationIDLength)
 			}
 
-			c.Set(contextCorrelationIDKey, cid)
+			appcontext.SetCorrelationID(c, cid)
 
 			if logger := appcontext.GetLogger(c); logger != nil {
 				appcontext.SetLogger(c, logger.WithField("cid", cid))
@@ -39,6 +37,6 @@ func NewCorrelationMiddleware(readHeader, writeHeader bool) echo.MiddlewareFunc
 }
 
 func GetCorrelationId(c appcontext.Context) (string, bool) {
-	id, ok := c.Get(contextCorrelationIDKey).(string)
-	return id, ok
+	id := appcontext.GetCorrelationID(c)
+	return id, id != ""
 }
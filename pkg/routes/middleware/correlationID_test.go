@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 	"net/http/httptest"
+	"simple-auth/pkg/appcontext"
 	"testing"
 
 	"github.com/labstack/echo/v4"
@@ -22,6 +23,7 @@ func TestCorrelationIDMiddleware(t *testing.T) {
 	if assert.NoError(t, h(c)) {
 		assert.Equal(t, 200, rec.Code)
 		assert.NotEmpty(t, rec.Header().Get("x-correlation-id"))
+		assert.Equal(t, rec.Header().Get("x-correlation-id"), appcontext.GetCorrelationID(c))
 	}
 }
 
@@ -9,6 +9,8 @@ import (
 	"simple-auth/pkg/config"
 	"simple-auth/pkg/db"
 	"simple-auth/pkg/routes/middleware/selector/auth"
+	"simple-auth/pkg/saerrors"
+	"simple-auth/pkg/services"
 	"strings"
 
 	"github.com/labstack/echo/v4"
@@ -18,6 +20,9 @@ import (
 
 const (
 	gatewayAccountHeader = "X-SA-Account"
+	gatewayRolesHeader   = "X-SA-Roles"
+	gatewayGroupsHeader  = "X-SA-Groups"
+	gatewayOrgHeader     = HeaderOrganization
 	authorizationHeader  = "Authorization"
 	authorizationBasic   = "basic"
 )
@@ -40,7 +45,7 @@ func newRoundRobinBalancer(targets ...string) middleware.ProxyBalancer {
 	return middleware.NewRoundRobinBalancer(proxyTargets)
 }
 
-func AuthenticationGateway(gateway *config.ConfigLoginGateway, cookieConfig *config.ConfigLoginCookie) echo.MiddlewareFunc {
+func AuthenticationGateway(gateway *config.ConfigLoginGateway, cookieConfig *config.ConfigLoginCookie, localLogin services.LocalLoginService) echo.MiddlewareFunc {
 	const targetKey = "target"
 
 	proxyConfig := middleware.ProxyConfig{
@@ -56,20 +61,32 @@ func AuthenticationGateway(gateway *config.ConfigLoginGateway, cookieConfig *con
 			log := appcontext.GetLogger(c)
 			req := c.Request()
 
-			var subject string
+			var subject, organization string
+			var roles, groups []string
 			if authHeader := req.Header.Get(authorizationHeader); authHeader != "" && gateway.BasicAuth {
 				// Check authorization header (if allowed) for API access
 				uname, pass, err := decodeBasicAuth(authHeader)
 				if err != nil {
 					return c.HTML(http.StatusBadRequest, err.Error())
 				}
-				auth, err := validateBasicCredentials(c, uname, pass)
+				authLocal, err := validateBasicCredentials(c, localLogin.WithContext(c), uname, pass)
 				if err != nil {
 					return c.HTML(http.StatusUnauthorized, err.Error())
 				}
+				sadb := appcontext.GetSADB(c)
+				memberships, err := sadb.GetMemberships(authLocal.Account())
+				if err != nil {
+					return c.HTML(http.StatusInternalServerError, "Unable to load memberships")
+				}
+				org, err := sadb.GetAccountOrganization(authLocal.Account())
+				if err != nil {
+					return c.HTML(http.StatusInternalServerError, "Unable to load organization")
+				}
 
 				req.Header.Del(authorizationHeader)
-				subject = auth.Account().UUID
+				subject = authLocal.Account().UUID
+				roles, groups = memberships.Roles, memberships.Groups
+				organization = org.OrganizationUUID()
 			} else {
 				// Check for session
 				claims, err := auth.ParseContextSession(cookieConfig, c)
@@ -78,6 +95,8 @@ func AuthenticationGateway(gateway *config.ConfigLoginGateway, cookieConfig *con
 					return next(c)
 				}
 				subject = claims.Subject
+				roles, groups = claims.Roles, claims.Groups
+				organization = claims.Organization
 			}
 
 			// Safety check
@@ -91,8 +110,19 @@ func AuthenticationGateway(gateway *config.ConfigLoginGateway, cookieConfig *con
 				return c.Redirect(http.StatusTemporaryRedirect, "/")
 			}
 
+			if !auth.InAnyGroup(groups, gateway.RequireGroups...) {
+				return c.HTML(http.StatusForbidden, "Account is not in a permitted group")
+			}
+
 			// Headers
 			req.Header.Set(gatewayAccountHeader, subject)
+			setListHeader(req.Header, gatewayRolesHeader, roles)
+			setListHeader(req.Header, gatewayGroupsHeader, groups)
+			if organization != "" {
+				req.Header.Set(gatewayOrgHeader, organization)
+			} else {
+				req.Header.Del(gatewayOrgHeader)
+			}
 			for k, v := range gateway.Headers {
 				log.Debugf("Override header %s = %s", k, v)
 				req.Header.Set(k, v)
@@ -116,6 +146,15 @@ func AuthenticationGateway(gateway *config.ConfigLoginGateway, cookieConfig *con
 	}
 }
 
+// setListHeader sets a comma-separated header, or removes it if empty so that it can't be spoofed by the client
+func setListHeader(header http.Header, key string, values []string) {
+	if len(values) > 0 {
+		header.Set(key, strings.Join(values, ","))
+	} else {
+		header.Del(key)
+	}
+}
+
 func decodeBasicAuth(headerValue string) (uname, pass string, err error) {
 	authHeaderParts := strings.Fields(headerValue)
 	if len(authHeaderParts) != 2 {
@@ -138,7 +177,7 @@ func decodeBasicAuth(headerValue string) (uname, pass string, err error) {
 	return decodedParts[0], decodedParts[1], nil
 }
 
-func validateBasicCredentials(c appcontext.Context, username, password string) (*db.AuthLocal, error) {
+func validateBasicCredentials(c appcontext.Context, localLogin services.LocalLoginService, username, password string) (*db.AuthLocal, error) {
 	sadb := appcontext.GetSADB(c)
 
 	errInvalidCredentials := errors.New("invalid username or password")
@@ -148,13 +187,42 @@ func validateBasicCredentials(c appcontext.Context, username, password string) (
 		return nil, errInvalidCredentials
 	}
 
-	if !authLocal.VerifyPassword(password) {
+	// Counts towards the same lockout as a login
+	if err := localLogin.AssertPassword(authLocal, password); err != nil {
+		if saerrors.UnwrapCode(err) == db.AuthLocked {
+			return nil, errors.New("account is locked")
+		}
 		return nil, errInvalidCredentials
 	}
 
 	if authLocal.HasTOTP() {
 		return nil, errors.New("unable to validate 2fa")
 	}
+	// Checked before the login steps, so the account doesn't use up its 2FA grace logins through the gateway
+	if required, err := localLogin.TwoFactorRequired(authLocal.Account()); err != nil {
+		return nil, err
+	} else if required {
+		return nil, errors.New("account requires 2fa")
+	}
+
+	// Same holds as a login; pending and rejected accounts shouldn't get in through the gateway
+	if !authLocal.Account().Active {
+		return nil, errors.New("account is inactive")
+	}
+	if sadb.AccountHasUnsatisfiedStipulations(authLocal.Account()) {
+		return nil, errors.New("account has a hold on it")
+	}
+	if err := sadb.ResetLoginFailures(authLocal); err != nil {
+		return nil, err
+	}
+
+	// Basic auth can't complete a login step, so any outstanding one (eg. an expired password) is a rejection
+	if err := localLogin.NextLoginStep(authLocal); err != nil {
+		if saerrors.UnwrapCode(err) == services.LocalPasswordExpired {
+			return nil, errors.New("password has expired")
+		}
+		return nil, errors.New("account has a login step to complete")
+	}
 
 	return authLocal, nil
 }
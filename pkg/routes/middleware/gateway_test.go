@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"simple-auth/pkg/appcontext"
+	"simple-auth/pkg/config"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/email"
+	"simple-auth/pkg/email/engine"
+	"simple-auth/pkg/services"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+const gatewayTestDB = "file:gateway?mode=memory&cache=shared"
+
+func newGatewayTestContext(lpConfig *config.ConfigLocalProvider) (appcontext.Context, db.SADB, services.LocalLoginService) {
+	sadb := db.New("sqlite3", gatewayTestDB)
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+
+	localLogin := services.NewLocalLoginService(email.New(engine.NewMockEngine(nil), "test@example.com"),
+		&config.ConfigMetadata{}, lpConfig, &config.ConfigTerms{}, "http://example.com").WithContext(ctx)
+	return ctx, sadb, localLogin
+}
+
+func TestBasicCredentials(t *testing.T) {
+	ctx, sadb, localLogin := newGatewayTestContext(&config.ConfigLocalProvider{})
+
+	account, _ := sadb.CreateAccount("test", "gateway@asdf.com")
+	sadb.CreateAuthLocal(account, "gateway", "gateway-pass")
+
+	authLocal, err := validateBasicCredentials(ctx, localLogin, "gateway", "gateway-pass")
+	assert.NoError(t, err)
+	assert.Equal(t, account.UUID, authLocal.Account().UUID)
+
+	_, err = validateBasicCredentials(ctx, localLogin, "gateway", "wrong")
+	assert.Error(t, err)
+}
+
+func TestBasicCredentialsPasswordExpired(t *testing.T) {
+	ctx, sadb, localLogin := newGatewayTestContext(&config.ConfigLocalProvider{
+		PasswordExpiry: config.ConfigPasswordExpiry{
+			MaxAgeDays: 90,
+		},
+	})
+
+	account, _ := sadb.CreateAccount("test", "gateway-expired@asdf.com")
+	sadb.CreateAuthLocal(account, "gateway-expired", "expired-pass")
+
+	_, err := validateBasicCredentials(ctx, localLogin, "gateway-expired", "expired-pass")
+	assert.NoError(t, err)
+
+	raw, _ := gorm.Open("sqlite3", gatewayTestDB)
+	defer raw.Close()
+	raw.Exec("UPDATE account_auth_locals SET password_changed = ? WHERE username = ?", time.Now().AddDate(0, 0, -91), "gateway-expired")
+
+	authLocal, err := validateBasicCredentials(ctx, localLogin, "gateway-expired", "expired-pass")
+	assert.EqualError(t, err, "password has expired")
+	assert.Nil(t, authLocal)
+}
+
+func TestBasicCredentialsTwoFactorRequired(t *testing.T) {
+	ctx, sadb, localLogin := newGatewayTestContext(&config.ConfigLocalProvider{
+		TwoFactor: config.ConfigTwoFactor{
+			Enabled: true,
+			Require: config.ConfigTwoFactorPolicy{
+				Groups:      []string{"admins"},
+				GraceLogins: 3,
+			},
+		},
+	})
+
+	account, _ := sadb.CreateAccount("test", "gateway-2fa@asdf.com")
+	sadb.CreateAuthLocal(account, "gateway-2fa", "2fa-pass")
+
+	_, err := validateBasicCredentials(ctx, localLogin, "gateway-2fa", "2fa-pass")
+	assert.NoError(t, err)
+
+	// Refused even with grace logins left, and without using them
+	assert.NoError(t, sadb.AddMembership(account, db.MembershipGroup, "admins"))
+	_, err = validateBasicCredentials(ctx, localLogin, "gateway-2fa", "2fa-pass")
+	assert.EqualError(t, err, "account requires 2fa")
+	assert.False(t, sadb.AccountHasStipulation(account, (&db.TwoFactorEnrollStipulation{}).Type()))
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"simple-auth/pkg/appcontext"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/routes/common"
+	"simple-auth/pkg/saerrors"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HeaderOrganization selects the organization, by UUID or slug, that a request's accounts are in
+const HeaderOrganization = "X-SA-Organization"
+
+// NewOrganizationMiddleware scopes the request to the organization named in the header, if any.
+// Without the header, the request is in the default namespace of accounts in no organization
+func NewOrganizationMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			name := c.Request().Header.Get(HeaderOrganization)
+			if name == "" {
+				return next(c)
+			}
+
+			org, err := appcontext.GetSADB(c).FindOrganization(name)
+			if err != nil {
+				if saerrors.UnwrapCode(err) == db.OrganizationNotFound {
+					return common.HttpError(c, http.StatusBadRequest, err)
+				}
+				return common.HttpInternalError(c, err)
+			}
+
+			appcontext.SetOrganization(c, org)
+			return next(c)
+		}
+	}
+}
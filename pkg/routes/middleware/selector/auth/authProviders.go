@@ -11,8 +11,12 @@ import (
 type SessionSource string
 
 type AuthContext struct {
-	UUID   string
-	Source SessionSource
+	UUID         string
+	Source       SessionSource
+	Roles        []string // Roles and groups, if known to the auth provider
+	Groups       []string
+	Organization string             // UUID of the account's organization, if known to the auth provider
+	Restriction  SessionRestriction // If set, the session is only allowed on some routes
 }
 
 type AuthHandler func(c echo.Context) (*AuthContext, error)
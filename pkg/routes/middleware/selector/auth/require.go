@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"net/http"
+	"simple-auth/pkg/appcontext"
+
+	"github.com/labstack/echo/v4"
+)
+
+const contextAdminOrganization = "adminOrganization"
+
+// RequireAccountIn only allows an already-authenticated request to proceed if the account
+// is one of the given UUIDs; otherwise it is forbidden
+func RequireAccountIn(uuids ...string) echo.MiddlewareFunc {
+	allowed := make(map[string]bool, len(uuids))
+	for _, uuid := range uuids {
+		allowed[uuid] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			uuid, ok := GetAccountUUID(c)
+			if !ok || !allowed[uuid] {
+				return c.JSON(http.StatusForbidden, jsonErrorf("forbidden", "Account is not permitted to access this resource"))
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireGroup only allows an already-authenticated request to proceed if the account
+// is in at least one of the given groups; otherwise it is forbidden
+func RequireGroup(groups ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authContext, ok := GetAuthContext(c)
+			if !ok || !InAnyGroup(authContext.Groups, groups...) {
+				return c.JSON(http.StatusForbidden, jsonErrorf("forbidden", "Account is not in a group permitted to access this resource"))
+			}
+			return next(c)
+		}
+	}
+}
+
+// InAnyGroup is true if any of required is in groups, or if nothing is required
+func InAnyGroup(groups []string, required ...string) bool {
+	if len(required) == 0 {
+		return true
+	}
+	for _, r := range required {
+		for _, g := range groups {
+			if g == r {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequireAdmin only allows an already-authenticated request to proceed if the account is one of
+// the given UUIDs, or is an admin of its organization. An organization admin's access is limited to
+// the organization, see GetAdminOrganization
+func RequireAdmin(uuids ...string) echo.MiddlewareFunc {
+	allowed := make(map[string]bool, len(uuids))
+	for _, uuid := range uuids {
+		allowed[uuid] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			uuid, ok := GetAccountUUID(c)
+			if !ok {
+				return c.JSON(http.StatusForbidden, jsonErrorf("forbidden", "Account is not permitted to access this resource"))
+			}
+			if allowed[uuid] {
+				return next(c)
+			}
+
+			account, err := appcontext.GetSADB(c).FindAccount(uuid)
+			if err != nil || !account.Active || !account.OrganizationAdmin || account.OrganizationID == 0 {
+				return c.JSON(http.StatusForbidden, jsonErrorf("forbidden", "Account is not permitted to access this resource"))
+			}
+			c.Set(contextAdminOrganization, account.OrganizationID)
+			return next(c)
+		}
+	}
+}
+
+// GetAdminOrganization returns the ID of the organization an admin is limited to, if the admin
+// is an organization admin
+func GetAdminOrganization(c echo.Context) (uint, bool) {
+	ret, ok := c.Get(contextAdminOrganization).(uint)
+	return ret, ok
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"simple-auth/pkg/appcontext"
+	"simple-auth/pkg/db"
+	"simple-auth/pkg/routes/middleware/selector"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func staticAuthMiddleware(uuid string, middleware ...echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return selector.NewSelectorMiddleware(NewAuthSelectorGroup(selector.SelectorAlways, func(c echo.Context) (*AuthContext, error) {
+		return &AuthContext{
+			UUID:   uuid,
+			Source: SourceLogin,
+		}, nil
+	}, middleware...))
+}
+
+func TestRequireAccountInAllowed(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec, _ := makeMiddlewareRequest(req, staticAuthMiddleware("admin", RequireAccountIn("other", "admin")))
+	assert.Equal(t, 200, rec.Code)
+}
+
+func TestRequireAccountInForbidden(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec, _ := makeMiddlewareRequest(req, staticAuthMiddleware("user", RequireAccountIn("admin")))
+	assert.Equal(t, 403, rec.Code)
+}
+
+func TestRequireAccountInUnauthenticated(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec, _ := makeMiddlewareRequest(req, RequireAccountIn("admin"))
+	assert.Equal(t, 403, rec.Code)
+}
+
+func TestRequireGroup(t *testing.T) {
+	withGroups := func(groups ...string) echo.MiddlewareFunc {
+		return selector.NewSelectorMiddleware(NewAuthSelectorGroup(selector.SelectorAlways, func(c echo.Context) (*AuthContext, error) {
+			return &AuthContext{
+				UUID:   "user",
+				Source: SourceLogin,
+				Groups: groups,
+			}, nil
+		}, RequireGroup("staff", "ops")))
+	}
+
+	rec, _ := makeMiddlewareRequest(httptest.NewRequest(http.MethodGet, "/", nil), withGroups("users", "ops"))
+	assert.Equal(t, 200, rec.Code)
+
+	rec, _ = makeMiddlewareRequest(httptest.NewRequest(http.MethodGet, "/", nil), withGroups("users"))
+	assert.Equal(t, 403, rec.Code)
+
+	rec, _ = makeMiddlewareRequest(httptest.NewRequest(http.MethodGet, "/", nil), RequireGroup("staff"))
+	assert.Equal(t, 403, rec.Code)
+}
+
+func TestRequireAdmin(t *testing.T) {
+	sadb := db.New("sqlite3", "file:require-admin?mode=memory&cache=shared")
+	org, _ := sadb.CreateOrganization("require-admin", "")
+	orgAdmin, _ := sadb.CreateAccount("org-admin", "org-admin@example.com")
+	sadb.SetAccountOrganization(orgAdmin, org, true)
+	member, _ := sadb.CreateAccount("org-member", "org-member@example.com")
+	sadb.SetAccountOrganization(member, org, false)
+
+	withAccount := func(uuid string) echo.MiddlewareFunc {
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return appcontext.WithSADB(sadb).Middleware()(staticAuthMiddleware(uuid, RequireAdmin("admin"))(next))
+		}
+	}
+
+	rec, c := makeMiddlewareRequest(httptest.NewRequest(http.MethodGet, "/", nil), withAccount("admin"))
+	assert.Equal(t, 200, rec.Code)
+	_, limited := GetAdminOrganization(c)
+	assert.False(t, limited)
+
+	rec, c = makeMiddlewareRequest(httptest.NewRequest(http.MethodGet, "/", nil), withAccount(orgAdmin.UUID))
+	assert.Equal(t, 200, rec.Code)
+	orgID, limited := GetAdminOrganization(c)
+	assert.True(t, limited)
+	assert.Equal(t, org.ID, orgID)
+
+	rec, _ = makeMiddlewareRequest(httptest.NewRequest(http.MethodGet, "/", nil), withAccount(member.UUID))
+	assert.Equal(t, 403, rec.Code)
+}
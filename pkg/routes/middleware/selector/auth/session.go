@@ -4,9 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"simple-auth/pkg/appcontext"
 	"simple-auth/pkg/config"
 	"simple-auth/pkg/db"
 	"simple-auth/pkg/instrumentation"
+	"simple-auth/pkg/lib/attributes"
 	"simple-auth/pkg/routes/middleware/selector"
 	"strings"
 	"time"
@@ -25,9 +27,31 @@ const (
 	SourceOneTime SessionSource = "onetime"
 )
 
+// SessionRestriction limits a session to the routes that explicitly allow it, see NewRestrictedSessionAuthProvider
+type SessionRestriction string
+
+const (
+	RestrictPasswordChange  SessionRestriction = "password-change"
+	RestrictTerms           SessionRestriction = "terms"
+	RestrictTwoFactorEnroll SessionRestriction = "2fa-enroll"
+)
+
+// restrictedSessionDuration caps how long a restricted session lasts
+const restrictedSessionDuration = 15 * time.Minute
+
 type SimpleAuthClaims struct {
 	jwt.StandardClaims
-	Source SessionSource `json:"src,omitempty"`
+	Source       SessionSource          `json:"src,omitempty"`
+	Restriction  SessionRestriction     `json:"rst,omitempty"` // If set, the session can only be used where allowed
+	Organization string                 `json:"org,omitempty"` // UUID of the account's organization, if any
+	Roles        []string               `json:"roles,omitempty"`
+	Groups       []string               `json:"groups,omitempty"`
+	Attributes   map[string]interface{} `json:"-"` // Account attribute claims, merged in at the top level
+}
+
+func (s SimpleAuthClaims) MarshalJSON() ([]byte, error) {
+	type claims SimpleAuthClaims
+	return attributes.MarshalWithClaims(claims(s), s.Attributes)
 }
 
 func parseSigningKey(method, key string, verifying bool) (interface{}, error) {
@@ -48,7 +72,8 @@ func parseSigningKey(method, key string, verifying bool) (interface{}, error) {
 	return nil, fmt.Errorf("unable to parse key for %s", method)
 }
 
-func issueSessionJwt(config *config.ConfigJWT, account *db.Account, source SessionSource) (string, error) {
+// issueSessionJwt signs the claims, after setting the standard claims for the account
+func issueSessionJwt(config *config.ConfigJWT, account *db.Account, claims SimpleAuthClaims, expires time.Duration) (string, error) {
 	if len(config.SigningKey) < 8 {
 		logrus.Warn("No JWT secret set, or secret too short.  User not able to login")
 		return "", errors.New("server needs secret")
@@ -64,42 +89,102 @@ func issueSessionJwt(config *config.ConfigJWT, account *db.Account, source Sessi
 		return "", err
 	}
 
-	token := jwt.NewWithClaims(signingMethod, SimpleAuthClaims{
-		StandardClaims: jwt.StandardClaims{
-			Issuer:    config.Issuer,
-			Subject:   account.UUID,
-			Audience:  "simple-auth",
-			ExpiresAt: time.Now().Add(time.Duration(config.ExpiresMinutes) * time.Minute).Unix(),
-		},
-		Source: source,
-	})
+	claims.StandardClaims = jwt.StandardClaims{
+		Issuer:    config.Issuer,
+		Subject:   account.UUID,
+		Audience:  "simple-auth",
+		ExpiresAt: time.Now().Add(expires).Unix(),
+	}
+
+	token := jwt.NewWithClaims(signingMethod, claims)
 	return token.SignedString(decodedKey)
 }
 
+// sessionAttributeClaims returns the account's attributes that are declared to be in the session
+func sessionAttributeClaims(c echo.Context, account *db.Account) (map[string]interface{}, error) {
+	schema := appcontext.GetAttributeSchema(c)
+	if !schema.HasSessionClaims() {
+		return nil, nil
+	}
+
+	values, err := appcontext.GetSADB(c).GetAccountAttributes(account)
+	if err != nil {
+		return nil, err
+	}
+	return schema.SessionClaims(values), nil
+}
+
 func CreateSession(c echo.Context, config *config.ConfigLoginCookie, account *db.Account, source SessionSource) error {
-	signedToken, err := issueSessionJwt(&config.JWT, account, source)
+	attributeClaims, err := sessionAttributeClaims(c, account)
 	if err != nil {
 		logrus.Warn(err)
 		return err
 	}
 
-	cookie := &http.Cookie{
-		Name:     config.Name,
-		Value:    signedToken,
-		HttpOnly: config.HTTPOnly,
-		Secure:   config.SecureOnly,
-		Expires:  time.Now().Add(time.Duration(config.JWT.ExpiresMinutes) * time.Minute),
-		Domain:   config.Domain,
-		Path:     config.Path,
+	sadb := appcontext.GetSADB(c)
+	memberships, err := sadb.GetMemberships(account)
+	if err != nil {
+		logrus.Warn(err)
+		return err
+	}
+	org, err := sadb.GetAccountOrganization(account)
+	if err != nil {
+		logrus.Warn(err)
+		return err
 	}
 
-	c.SetCookie(cookie)
+	expires := time.Duration(config.JWT.ExpiresMinutes) * time.Minute
+	signedToken, err := issueSessionJwt(&config.JWT, account, SimpleAuthClaims{
+		Source:       source,
+		Organization: org.OrganizationUUID(),
+		Roles:        memberships.Roles,
+		Groups:       memberships.Groups,
+		Attributes:   attributeClaims,
+	}, expires)
+	if err != nil {
+		logrus.Warn(err)
+		return err
+	}
 
+	setSessionCookie(c, config, signedToken, expires)
 	sessionCounter.Inc(source)
 
 	return nil
 }
 
+// CreateRestrictedSession issues a short-lived session that can only be used on routes allowing the
+// restriction. It carries no roles, groups, or attributes
+func CreateRestrictedSession(c echo.Context, config *config.ConfigLoginCookie, account *db.Account, source SessionSource, restriction SessionRestriction) error {
+	expires := time.Duration(config.JWT.ExpiresMinutes) * time.Minute
+	if expires > restrictedSessionDuration {
+		expires = restrictedSessionDuration
+	}
+
+	signedToken, err := issueSessionJwt(&config.JWT, account, SimpleAuthClaims{
+		Source:      source,
+		Restriction: restriction,
+	}, expires)
+	if err != nil {
+		logrus.Warn(err)
+		return err
+	}
+
+	setSessionCookie(c, config, signedToken, expires)
+	return nil
+}
+
+func setSessionCookie(c echo.Context, config *config.ConfigLoginCookie, signedToken string, expires time.Duration) {
+	c.SetCookie(&http.Cookie{
+		Name:     config.Name,
+		Value:    signedToken,
+		HttpOnly: config.HTTPOnly,
+		Secure:   config.SecureOnly,
+		Expires:  time.Now().Add(expires),
+		Domain:   config.Domain,
+		Path:     config.Path,
+	})
+}
+
 func ClearSession(c echo.Context, config *config.ConfigLoginCookie) {
 	c.SetCookie(&http.Cookie{
 		Name:     config.Name,
@@ -112,7 +197,13 @@ func ClearSession(c echo.Context, config *config.ConfigLoginCookie) {
 	})
 }
 
+// ParseContextSession parses the session cookie, rejecting restricted sessions
 func ParseContextSession(config *config.ConfigLoginCookie, c echo.Context) (*SimpleAuthClaims, error) {
+	return parseContextSession(config, c, "")
+}
+
+// parseContextSession parses the session cookie, only allowing the given restriction, if any
+func parseContextSession(config *config.ConfigLoginCookie, c echo.Context, allow SessionRestriction) (*SimpleAuthClaims, error) {
 	cookie, err := c.Cookie(config.Name)
 	if err != nil || cookie == nil {
 		return nil, errors.New("auth cookie not set")
@@ -126,6 +217,9 @@ func ParseContextSession(config *config.ConfigLoginCookie, c echo.Context) (*Sim
 	}
 
 	if claims, ok := token.Claims.(*SimpleAuthClaims); ok && token.Valid {
+		if claims.Restriction != "" && claims.Restriction != allow {
+			return nil, fmt.Errorf("session is restricted to %s", claims.Restriction)
+		}
 		return claims, nil
 	}
 
@@ -146,6 +240,10 @@ func sessionSelector(cookieName string) selector.MiddlewareSelector {
 }
 
 func NewSessionAuthHandler(config *config.ConfigLoginCookie) AuthHandler {
+	return newSessionAuthHandler(config, "")
+}
+
+func newSessionAuthHandler(config *config.ConfigLoginCookie, allow SessionRestriction) AuthHandler {
 	_, parseErr := parseSigningKey(config.JWT.SigningMethod, config.JWT.SigningKey, false)
 	if config.JWT.SigningKey == "" || parseErr != nil {
 		logrus.Warn("No JWT secret specified, refusing to bind user management endpoints")
@@ -155,13 +253,17 @@ func NewSessionAuthHandler(config *config.ConfigLoginCookie) AuthHandler {
 	}
 
 	return func(c echo.Context) (*AuthContext, error) {
-		claims, err := ParseContextSession(config, c)
+		claims, err := parseContextSession(config, c, allow)
 		if err != nil {
 			return nil, err
 		}
 		return &AuthContext{
-			UUID:   claims.Subject,
-			Source: claims.Source,
+			UUID:         claims.Subject,
+			Source:       claims.Source,
+			Restriction:  claims.Restriction,
+			Roles:        claims.Roles,
+			Groups:       claims.Groups,
+			Organization: claims.Organization,
 		}, nil
 	}
 }
@@ -173,3 +275,12 @@ func NewSessionAuthProvider(config *config.ConfigLoginCookie, middleware ...echo
 		middleware...,
 	)
 }
+
+// NewRestrictedSessionAuthProvider accepts sessions, including those restricted to the given restriction
+func NewRestrictedSessionAuthProvider(config *config.ConfigLoginCookie, allow SessionRestriction, middleware ...echo.MiddlewareFunc) selector.SelectorGroup {
+	return NewAuthSelectorGroup(
+		sessionSelector(config.Name),
+		newSessionAuthHandler(config, allow),
+		middleware...,
+	)
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"simple-auth/pkg/config"
+	"simple-auth/pkg/db"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionJwtAttributes(t *testing.T) {
+	cfg := &config.ConfigJWT{
+		SigningMethod:  "HS256",
+		SigningKey:     "test-session-key",
+		ExpiresMinutes: 5,
+		Issuer:         "simple-auth",
+	}
+	account := &db.Account{UUID: "test-uuid"}
+
+	signed, err := issueSessionJwt(cfg, account, SimpleAuthClaims{
+		Source: SourceLogin,
+		Attributes: map[string]interface{}{
+			"locale": "en-US",
+			"sub":    "not-overwritten",
+		},
+	}, 5*time.Minute)
+	assert.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(signed, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(cfg.SigningKey), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "test-uuid", claims["sub"])
+	assert.Equal(t, "login", claims["src"])
+	assert.Equal(t, "en-US", claims["locale"])
+
+	// Still parses as a session
+	parsed := &SimpleAuthClaims{}
+	_, err = jwt.ParseWithClaims(signed, parsed, func(*jwt.Token) (interface{}, error) {
+		return []byte(cfg.SigningKey), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, SourceLogin, parsed.Source)
+}
+
+func TestSessionJwtMembershipsAndOrganization(t *testing.T) {
+	cfg := &config.ConfigJWT{
+		SigningMethod:  "HS256",
+		SigningKey:     "test-session-key",
+		ExpiresMinutes: 5,
+	}
+	account := &db.Account{UUID: "test-uuid"}
+
+	signed, err := issueSessionJwt(cfg, account, SimpleAuthClaims{
+		Source:       SourceLogin,
+		Organization: "org-uuid",
+		Roles:        []string{"editor"},
+		Groups:       []string{"ops", "staff"},
+	}, 5*time.Minute)
+	assert.NoError(t, err)
+
+	parsed := &SimpleAuthClaims{}
+	_, err = jwt.ParseWithClaims(signed, parsed, func(*jwt.Token) (interface{}, error) {
+		return []byte(cfg.SigningKey), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"editor"}, parsed.Roles)
+	assert.Equal(t, []string{"ops", "staff"}, parsed.Groups)
+	assert.Equal(t, "org-uuid", parsed.Organization)
+}
+
+func TestRestrictedSession(t *testing.T) {
+	cfg := &config.ConfigLoginCookie{
+		Name: "auth",
+		JWT: config.ConfigJWT{
+			SigningMethod:  "HS256",
+			SigningKey:     "test-session-key",
+			ExpiresMinutes: 60,
+		},
+	}
+	account := &db.Account{UUID: "test-uuid"}
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	assert.NoError(t, CreateRestrictedSession(e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec), cfg, account, SourceLogin, RestrictPasswordChange))
+
+	cookie := rec.Result().Cookies()[0]
+	assert.WithinDuration(t, time.Now().Add(restrictedSessionDuration), cookie.Expires, time.Minute)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	c := e.NewContext(req, httptest.NewRecorder())
+
+	_, err := ParseContextSession(cfg, c)
+	assert.Error(t, err)
+
+	authContext, err := newSessionAuthHandler(cfg, RestrictPasswordChange)(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "test-uuid", authContext.UUID)
+	assert.Equal(t, RestrictPasswordChange, authContext.Restriction)
+}
@@ -84,6 +84,21 @@ func (s ErrorCode) Wrapf(err error, msg string, args ...interface{}) error {
 	}
 }
 
+// DetailedError has machine-readable details, beyond its code (eg. each requirement a password failed)
+type DetailedError interface {
+	error
+	Details() []string
+}
+
+// UnwrapDetails finds the details of the closest DetailedError in the wrapped error, or nil if none
+func UnwrapDetails(err error) []string {
+	var detailedErr DetailedError
+	if errors.As(err, &detailedErr) {
+		return detailedErr.Details()
+	}
+	return nil
+}
+
 // UnwrapCode finds the closet error in the wrapped error that has a code
 func UnwrapCode(err error) ErrorCode {
 	var codedErr CodedError
@@ -2,6 +2,9 @@ package services
 
 import (
 	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
 	"regexp"
 	"simple-auth/pkg/appcontext"
 	"simple-auth/pkg/config"
@@ -20,25 +23,41 @@ type AccountService interface {
 	FindAccountByEmail(email string) (*db.Account, error)
 
 	HasUnsatisfiedStipulations(account *db.Account) bool
+
+	// RequestEmailChange sends a confirmation link to the new address, and notifies the current one
+	RequestEmailChange(account *db.Account, newEmail string) error
+	ConfirmEmailChange(account *db.Account, token string) error
+
+	// PendingApproval is true if the account is waiting on an admin, see config providers.settings.requireapproval
+	PendingApproval(account *db.Account) bool
+	// ApproveAccount and RejectAccount resolve a pending account, and email the applicant the outcome
+	ApproveAccount(account *db.Account, reason string) error
+	RejectAccount(account *db.Account, reason string) error
 }
 
 type accountService struct {
-	emailService   *email.EmailService
-	metaConfig     *config.ConfigMetadata
-	baseURL        string
-	dbAccount      db.AccountStore
-	dbStipulations db.AccountStipulations
+	emailService     *email.EmailService
+	metaConfig       *config.ConfigMetadata
+	providerSettings *config.ConfigProviderSettings
+	lpConfig         *config.ConfigLocalProvider
+	baseURL          string
+	dbAccount        db.AccountStore
+	dbStipulations   db.AccountStipulations
+	dbApprovals      db.AccountApprovals
 }
 
 var _ AccountService = &accountService{}
 
-func NewAccountService(configMeta *config.ConfigMetadata, configWeb *config.ConfigWeb, emailService *email.EmailService) AccountService {
+func NewAccountService(configMeta *config.ConfigMetadata, configWeb *config.ConfigWeb, configProviders *config.ConfigProviderSettings, configLocal *config.ConfigLocalProvider, emailService *email.EmailService) AccountService {
 	return &accountService{
 		emailService,
 		configMeta,
+		configProviders,
+		configLocal,
 		configWeb.GetBaseURL(),
 		nil,
 		nil,
+		nil,
 	}
 }
 
@@ -47,6 +66,7 @@ func (s *accountService) WithContext(ctx appcontext.Context) AccountService {
 	sadb := appcontext.GetSADB(ctx)
 	copy.dbAccount = sadb
 	copy.dbStipulations = sadb
+	copy.dbApprovals = sadb
 	return &copy
 }
 
@@ -64,6 +84,12 @@ func (s *accountService) CreateAccount(name, emailAddress string) (*db.Account,
 		return nil, err
 	}
 
+	if s.providerSettings.RequireApproval {
+		if err := s.dbApprovals.RequireApproval(account); err != nil {
+			return nil, err
+		}
+	}
+
 	go s.emailService.SendWelcomeEmail(emailAddress, &email.WelcomeEmailData{
 		EmailData: email.EmailData{
 			Company: s.metaConfig.Company,
@@ -98,3 +124,78 @@ func (s *accountService) FindAccountByEmail(email string) (*db.Account, error) {
 func (s *accountService) HasUnsatisfiedStipulations(account *db.Account) bool {
 	return s.dbStipulations.AccountHasUnsatisfiedStipulations(account)
 }
+
+func (s *accountService) RequestEmailChange(account *db.Account, newEmail string) error {
+	if err := validateEmail(newEmail); err != nil {
+		return db.EmailInvalid.Compose(err)
+	}
+
+	// Confirmation links expire like verification links
+	ttl, err := parseOptionalDuration(s.lpConfig.EmailValidationExpires)
+	if err != nil {
+		return fmt.Errorf("invalid email validation expiry: %w", err)
+	}
+	stip, err := s.dbAccount.RequestEmailChange(account, newEmail, ttl)
+	if err != nil {
+		return err
+	}
+
+	emailData := email.EmailData{
+		Company: s.metaConfig.Company,
+		BaseURL: s.baseURL,
+	}
+	confirmLink := fmt.Sprintf("%s/api/v1/account/email/confirm?account=%s&token=%s", s.baseURL, account.UUID, url.QueryEscape(stip.Code))
+
+	verificationData := &email.VerificationData{
+		EmailData:      emailData,
+		ActivationLink: template.HTML(confirmLink),
+	}
+	if stip.Expires != nil {
+		verificationData.Expires = s.lpConfig.EmailValidationExpires
+	}
+	go s.emailService.SendVerificationEmail(stip.Email, verificationData)
+	go s.emailService.SendEmailChangeEmail(account.Email, &email.EmailChangeData{
+		EmailData: emailData,
+		NewEmail:  stip.Email,
+	})
+
+	return nil
+}
+
+func (s *accountService) ConfirmEmailChange(account *db.Account, token string) error {
+	_, err := s.dbAccount.ConfirmEmailChange(account, token)
+	return err
+}
+
+func (s *accountService) PendingApproval(account *db.Account) bool {
+	return s.dbApprovals.AccountPendingApproval(account)
+}
+
+func (s *accountService) ApproveAccount(account *db.Account, reason string) error {
+	if err := s.dbApprovals.ApproveAccount(account, reason); err != nil {
+		return err
+	}
+
+	go s.emailService.SendApprovedEmail(account.Email, s.approvalData(account, reason))
+	return nil
+}
+
+func (s *accountService) RejectAccount(account *db.Account, reason string) error {
+	if err := s.dbApprovals.RejectAccount(account, reason); err != nil {
+		return err
+	}
+
+	go s.emailService.SendRejectedEmail(account.Email, s.approvalData(account, reason))
+	return nil
+}
+
+func (s *accountService) approvalData(account *db.Account, reason string) *email.ApprovalData {
+	return &email.ApprovalData{
+		EmailData: email.EmailData{
+			Company: s.metaConfig.Company,
+			BaseURL: s.baseURL,
+		},
+		Name:   account.Name,
+		Reason: reason,
+	}
+}
@@ -24,7 +24,7 @@ func TestCreateAccount(t *testing.T) {
 	emailService := email.New(mockEngine, "test@test.comm")
 	ctx := appcontext.NewContainer()
 	ctx.Use(appcontext.WithSADB(getDB()))
-	acctSrv := NewAccountService(&config.ConfigMetadata{}, &config.ConfigWeb{}, emailService).WithContext(ctx)
+	acctSrv := NewAccountService(&config.ConfigMetadata{}, &config.ConfigWeb{}, &config.ConfigProviderSettings{}, &config.ConfigLocalProvider{}, emailService).WithContext(ctx)
 
 	acct, err := acctSrv.CreateAccount("test create account", "create-acct-service@example.com")
 	assert.NoError(t, err)
@@ -33,3 +33,48 @@ func TestCreateAccount(t *testing.T) {
 		return mockEngine.SendCount() == 1
 	}, 2*time.Second, 100*time.Millisecond)
 }
+
+func TestRequestEmailChange(t *testing.T) {
+	mockEngine := engine.NewMockEngine(nil)
+	emailService := email.New(mockEngine, "test@test.comm")
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(getDB()))
+	acctSrv := NewAccountService(&config.ConfigMetadata{}, &config.ConfigWeb{}, &config.ConfigProviderSettings{}, &config.ConfigLocalProvider{}, emailService).WithContext(ctx)
+
+	acct, _ := getDB().CreateAccount("test email change", "email-change-service@example.com")
+
+	assert.Error(t, acctSrv.RequestEmailChange(acct, "not-an-email"))
+	assert.NoError(t, acctSrv.RequestEmailChange(acct, "email-change-service2@example.com"))
+
+	// Confirmation to the new address, and a notice to the old
+	assert.Eventually(t, func() bool {
+		return mockEngine.SendCount() == 2
+	}, 2*time.Second, 100*time.Millisecond)
+
+	assert.Error(t, acctSrv.ConfirmEmailChange(acct, "bad-token"))
+	assert.Equal(t, "email-change-service@example.com", acct.Email)
+}
+
+func TestAccountApproval(t *testing.T) {
+	mockEngine := engine.NewMockEngine(nil)
+	emailService := email.New(mockEngine, "test@test.comm")
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(getDB()))
+	acctSrv := NewAccountService(&config.ConfigMetadata{}, &config.ConfigWeb{}, &config.ConfigProviderSettings{
+		RequireApproval: true,
+	}, &config.ConfigLocalProvider{}, emailService).WithContext(ctx)
+
+	acct, err := acctSrv.CreateAccount("test approval", "approval-service@example.com")
+	assert.NoError(t, err)
+	assert.True(t, acctSrv.PendingApproval(acct))
+	assert.True(t, acctSrv.HasUnsatisfiedStipulations(acct))
+
+	assert.NoError(t, acctSrv.ApproveAccount(acct, ""))
+	assert.False(t, acctSrv.PendingApproval(acct))
+	assert.Error(t, acctSrv.RejectAccount(acct, ""))
+
+	// Welcome, and approval
+	assert.Eventually(t, func() bool {
+		return mockEngine.SendCount() == 2
+	}, 2*time.Second, 100*time.Millisecond)
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"html/template"
@@ -9,24 +10,67 @@ import (
 	"simple-auth/pkg/config"
 	"simple-auth/pkg/db"
 	"simple-auth/pkg/email"
+	"simple-auth/pkg/lib/passpolicy"
 	"simple-auth/pkg/lib/totp"
 	"simple-auth/pkg/saerrors"
+	"time"
 	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
 )
 
 type LocalLoginService interface {
 	FindAuthLocal(accountUUID string) (*db.AuthLocal, error)
 	Create(account *db.Account, username, password string) (*db.AuthLocal, error)
+	// ResendVerification sends a new verification link to an account that hasn't verified its email yet,
+	// invalidating the previous one. Throttled by the resend interval (db.VerificationThrottled)
+	ResendVerification(account *db.Account) error
+	// RequireVerification requires the account to verify its email, with a token that expires like one from
+	// signup. The token isn't sent, see SendVerification
+	RequireVerification(account *db.Account) (*db.TokenStipulation, error)
+	// SendVerification emails the account a link to verify its email with the token
+	SendVerification(account *db.Account, stip *db.TokenStipulation) error
 	UsernameExists(username string) (bool, error)
+	// ValidateUsername checks a new login's username against the username requirements
+	ValidateUsername(username string) error
 
+	// AssertLogin verifies the credentials. If the account has a step to complete before it can login,
+	// the AuthLocal is returned along with the step's error, see NextLoginStep
 	AssertLogin(usernameOrEmail, password string, totpCode *string) (*db.AuthLocal, error)
-
-	ActivateTOTP(authLocal *db.AuthLocal, otp *totp.Totp, code string) error
+	// AssertPassword verifies the password of a login that isn't locked (db.AuthLocked). A wrong password
+	// counts towards the lockout, see db.AccountAuthLocalLockout
+	AssertPassword(authLocal *db.AuthLocal, password string) error
+	// Unlock unlocks a login with the token emailed when it was locked
+	Unlock(account *db.Account, token string) error
+	// NextLoginStep returns the error of the first step the account must complete before it can have a full
+	// session (db.TermsRequired, LocalPasswordChangeRequired, LocalPasswordExpired, or LocalTwoFactorEnrollRequired), or nil if none.
+	// If the account is required to enroll in 2FA, one of its grace logins is used
+	NextLoginStep(authLocal *db.AuthLocal) error
+	// TwoFactorRequired is true if 2FA is enabled, and the policy requires the account to use it
+	TwoFactorRequired(account *db.Account) (bool, error)
+	// EnforceClientTwoFactor requires an account using an OAuth2 client that the 2FA policy applies to
+	// to enroll, returning LocalTwoFactorEnrollRequired once its grace logins are used up
+	EnforceClientTwoFactor(account *db.Account, clientID string) error
+
+	// ActivateTOTP activates 2FA once code is verified, returning the new recovery codes, if enabled
+	ActivateTOTP(authLocal *db.AuthLocal, otp *totp.Totp, code string) ([]string, error)
+	// DeactivateTOTP deactivates 2FA, and any recovery codes. code may be a recovery code
 	DeactivateTOTP(authLocal *db.AuthLocal, code string) error
 	AllowTOTP() bool
+	// RegenerateRecoveryCodes replaces the recovery codes of a login with 2FA, once code (or a recovery code) is verified
+	RegenerateRecoveryCodes(authLocal *db.AuthLocal, code string) ([]string, error)
+	// RecoveryCodesRemaining is how many unused recovery codes the login has
+	RecoveryCodesRemaining(authLocal *db.AuthLocal) (int, error)
 
+	// UpdatePassword and UpdatePasswordUnsafe return LocalCredentialRequirements, with the violated rules
+	// as details, if the new password doesn't satisfy the password policy
 	UpdatePassword(authLocal *db.AuthLocal, oldPassword string, newPassword string) error
 	UpdatePasswordUnsafe(authLocal *db.AuthLocal, newPassword string) error
+	// ValidatePassword checks the password of a new login against the password policy
+	ValidatePassword(username, email, password string) error
+	// RemindExpiringPasswords emails every account whose password expires within the warning period,
+	// once per password. Returns how many were reminded
+	RemindExpiringPasswords() (int, error)
 
 	WithContext(ctx appcontext.Context) LocalLoginService
 }
@@ -35,19 +79,25 @@ type localLoginService struct {
 	dbAuth         db.AccountAuthLocal
 	dbAudit        db.AccountAudit
 	dbStipulations db.AccountStipulations
+	dbApprovals    db.AccountApprovals
+	dbTerms        db.AccountTerms
+	dbMemberships  db.AccountMemberships
 	emailService   *email.EmailService
 	metaConfig     *config.ConfigMetadata
 	lpConfig       *config.ConfigLocalProvider
+	termsConfig    *config.ConfigTerms
 	baseURL        string
+	log            logrus.FieldLogger
 }
 
 var _ LocalLoginService = &localLoginService{}
 
-func NewLocalLoginService(emailService *email.EmailService, metaConfig *config.ConfigMetadata, localProviderConfig *config.ConfigLocalProvider, baseURL string) LocalLoginService {
+func NewLocalLoginService(emailService *email.EmailService, metaConfig *config.ConfigMetadata, localProviderConfig *config.ConfigLocalProvider, termsConfig *config.ConfigTerms, baseURL string) LocalLoginService {
 	return &localLoginService{
 		emailService: emailService,
 		metaConfig:   metaConfig,
 		lpConfig:     localProviderConfig,
+		termsConfig:  termsConfig,
 		baseURL:      baseURL,
 	}
 }
@@ -59,6 +109,10 @@ func (s *localLoginService) WithContext(ctx appcontext.Context) LocalLoginServic
 	copy.dbAudit = db
 	copy.dbAuth = db
 	copy.dbStipulations = db
+	copy.dbApprovals = db
+	copy.dbTerms = db
+	copy.dbMemberships = db
+	copy.log = appcontext.GetLogger(ctx)
 	return &copy
 }
 
@@ -71,6 +125,11 @@ const (
 	LocalUnsatisfiedStipulations saerrors.ErrorCode = "unsatisfied-stipulations"
 	LocalCredentialRequirements  saerrors.ErrorCode = "credentials-failed-requirements"
 	LocalUsernameUnavailable     saerrors.ErrorCode = "username-unavailable"
+	LocalPasswordChangeRequired  saerrors.ErrorCode = "password-change-required"
+	LocalPasswordUnchanged       saerrors.ErrorCode = "password-unchanged"
+	LocalPasswordExpired         saerrors.ErrorCode = "password-expired"
+	LocalTwoFactorEnrollRequired saerrors.ErrorCode = "2fa-enroll-required"
+	LocalTwoFactorRequired       saerrors.ErrorCode = "2fa-required"
 )
 
 func (s *localLoginService) FindAuthLocal(accountUUID string) (*db.AuthLocal, error) {
@@ -102,8 +161,8 @@ func (s *localLoginService) Create(account *db.Account, username, password strin
 	if err := s.validateUsername(username); err != nil {
 		return nil, LocalCredentialRequirements.Compose(err)
 	}
-	if err := s.validatePassword(password); err != nil {
-		return nil, LocalCredentialRequirements.Compose(err)
+	if err := s.validatePassword(nil, username, account.Email, password); err != nil {
+		return nil, err
 	}
 
 	if authLocal, _ := s.dbAuth.FindAuthLocalByUsername(username); authLocal != nil {
@@ -116,21 +175,67 @@ func (s *localLoginService) Create(account *db.Account, username, password strin
 	}
 
 	if s.lpConfig.EmailValidationRequired {
-		stip := db.NewTokenStipulation()
-		s.dbStipulations.AddStipulation(account, stip)
-
-		go s.emailService.SendVerificationEmail(account.Email, &email.VerificationData{
-			EmailData: email.EmailData{
-				Company: s.metaConfig.Company,
-				BaseURL: s.baseURL,
-			},
-			ActivationLink: template.HTML(fmt.Sprintf("%s/#/activate?account=%s&token=%s", s.baseURL, account.UUID, stip.Code)),
-		})
+		stip, err := s.RequireVerification(account)
+		if err != nil {
+			return nil, err
+		}
+		go s.SendVerification(account, stip)
 	}
 
 	return authLocal, err
 }
 
+func (s *localLoginService) RequireVerification(account *db.Account) (*db.TokenStipulation, error) {
+	ttl, err := parseOptionalDuration(s.lpConfig.EmailValidationExpires)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email validation expiry: %w", err)
+	}
+	stip := db.NewExpiringTokenStipulation(ttl)
+	if err := s.dbStipulations.AddStipulation(account, stip); err != nil {
+		return nil, err
+	}
+	return stip, nil
+}
+
+func (s *localLoginService) ResendVerification(account *db.Account) error {
+	ttl, err := parseOptionalDuration(s.lpConfig.EmailValidationExpires)
+	if err != nil {
+		return fmt.Errorf("invalid email validation expiry: %w", err)
+	}
+	interval, err := parseOptionalDuration(s.lpConfig.EmailValidationResendInterval)
+	if err != nil {
+		return fmt.Errorf("invalid email validation resend interval: %w", err)
+	}
+
+	stip, err := s.dbStipulations.RotateTokenStipulation(account, ttl, interval)
+	if err != nil {
+		return err
+	}
+	go s.SendVerification(account, stip)
+	return nil
+}
+
+func (s *localLoginService) SendVerification(account *db.Account, stip *db.TokenStipulation) error {
+	data := &email.VerificationData{
+		EmailData: email.EmailData{
+			Company: s.metaConfig.Company,
+			BaseURL: s.baseURL,
+		},
+		ActivationLink: template.HTML(fmt.Sprintf("%s/#/activate?account=%s&token=%s", s.baseURL, account.UUID, stip.Code)),
+	}
+	if stip.Expires != nil {
+		data.Expires = s.lpConfig.EmailValidationExpires
+	}
+	return s.emailService.SendVerificationEmail(account.Email, data)
+}
+
+func (s *localLoginService) ValidateUsername(username string) error {
+	if err := s.validateUsername(username); err != nil {
+		return LocalCredentialRequirements.Compose(err)
+	}
+	return nil
+}
+
 func (s *localLoginService) validateUsername(username string) error {
 	ulen := utf8.RuneCountInString(username)
 	if ulen < s.lpConfig.Requirements.UsernameMinLength {
@@ -153,13 +258,34 @@ func (s *localLoginService) validateUsername(username string) error {
 	return nil
 }
 
-func (s *localLoginService) validatePassword(password string) error {
-	plen := utf8.RuneCountInString(password)
-	if plen < s.lpConfig.Requirements.PasswordMinLength {
-		return errors.New("password too short")
+func (s *localLoginService) ValidatePassword(username, email, password string) error {
+	return s.validatePassword(nil, username, email, password)
+}
+
+// validatePassword checks the password against the policy, and if the login already exists, its history
+func (s *localLoginService) validatePassword(authLocal *db.AuthLocal, username, email, password string) error {
+	policy, err := passpolicy.NewFromConfig(&s.lpConfig.Requirements, &s.lpConfig.PasswordPolicy)
+	if err != nil {
+		return fmt.Errorf("invalid password policy: %w", err)
 	}
-	if plen > s.lpConfig.Requirements.PasswordMaxLength {
-		return errors.New("password too long")
+
+	violations, err := policy.Check(password, username, email)
+	if err != nil {
+		return err
+	}
+
+	if depth := s.lpConfig.PasswordPolicy.History; authLocal != nil && depth > 0 {
+		reused, err := s.dbAuth.PasswordInHistory(authLocal, password, depth)
+		if err != nil {
+			return err
+		}
+		if reused {
+			violations = append(violations, passpolicy.Reused)
+		}
+	}
+
+	if len(violations) > 0 {
+		return LocalCredentialRequirements.Wrapf(violations, "password does not meet requirements")
 	}
 	return nil
 }
@@ -181,45 +307,294 @@ func (s *localLoginService) AssertLogin(usernameOrEmail, password string, totpCo
 		if totpCode == nil || *totpCode == "" {
 			return nil, LocalTOTPMissing.New()
 		}
-		if !localAuth.VerifyTOTP(*totpCode, s.lpConfig.TwoFactor.Drift) {
+		if ok, err := s.verifySecondFactor(localAuth, *totpCode); err != nil {
+			return nil, err
+		} else if !ok {
 			s.dbAudit.CreateAuditRecord(localAuth, db.AuditModuleLocal, db.AuditLevelWarn, "TOTP Rejected")
+			s.recordLoginFailure(localAuth)
 			return nil, LocalTOTPFailed.New()
 		}
 	}
 
+	if err := s.dbAuth.ResetLoginFailures(localAuth); err != nil {
+		return nil, err
+	}
+
+	// Upgrade the stored hash to the current policy, while the password is known
+	if _, err := s.dbAuth.RehashAuthLocalPassword(localAuth, password); err != nil {
+		s.log.Warnf("Unable to rehash password for %s: %v", localAuth.Account().UUID, err)
+	}
+
+	if err := s.NextLoginStep(localAuth); err != nil {
+		return localAuth, err
+	}
+
 	s.dbAudit.CreateAuditRecord(localAuth, db.AuditModuleLocal, db.AuditLevelInfo, "Login Successful")
 
 	return localAuth, nil
 }
 
+func (s *localLoginService) NextLoginStep(authLocal *db.AuthLocal) error {
+	if s.termsConfig.Version != "" {
+		required, err := s.dbTerms.RequireTerms(authLocal.Account(), s.termsConfig.Version)
+		if err != nil {
+			return err
+		}
+		if required {
+			s.dbAudit.CreateAuditRecord(authLocal, db.AuditModuleLocal, db.AuditLevelInfo, "Login requires accepting terms")
+			return db.TermsRequired.New()
+		}
+	}
+
+	if s.dbStipulations.AccountHasStipulation(authLocal.Account(), (&db.PasswordResetStipulation{}).Type()) {
+		s.dbAudit.CreateAuditRecord(authLocal, db.AuditModuleLocal, db.AuditLevelInfo, "Login requires password change")
+		return LocalPasswordChangeRequired.New()
+	}
+
+	if authLocal.PasswordExpired(s.passwordMaxAge()) {
+		s.dbAudit.CreateAuditRecord(authLocal, db.AuditModuleLocal, db.AuditLevelInfo, "Login requires changing expired password")
+		return LocalPasswordExpired.New()
+	}
+
+	return s.enforceTwoFactor(authLocal)
+}
+
+// enforceTwoFactor requires an account without 2FA to enroll, if the policy applies to it. Until its grace
+// logins are used up, it can still login
+func (s *localLoginService) enforceTwoFactor(authLocal *db.AuthLocal) error {
+	if !s.lpConfig.TwoFactor.Enabled || authLocal.HasTOTP() {
+		return nil
+	}
+
+	account := authLocal.Account()
+	required, err := s.twoFactorRequired(account)
+	if err != nil {
+		return err
+	}
+	if required {
+		if err := s.dbStipulations.RequireTwoFactorEnrollment(account, s.lpConfig.TwoFactor.Require.GraceLogins); err != nil {
+			return err
+		}
+	} else if !s.dbStipulations.AccountHasStipulation(account, (&db.TwoFactorEnrollStipulation{}).Type()) {
+		return nil
+	}
+
+	remaining, err := s.dbStipulations.UseTwoFactorGrace(account)
+	if err != nil {
+		return err
+	}
+	if remaining > 0 {
+		s.dbAudit.CreateAuditRecord(authLocal, db.AuditModuleLocal, db.AuditLevelWarn, "Login without required 2FA, %d grace logins left", remaining-1)
+		return nil
+	}
+
+	s.dbAudit.CreateAuditRecord(authLocal, db.AuditModuleLocal, db.AuditLevelInfo, "Login requires 2FA enrollment")
+	return LocalTwoFactorEnrollRequired.New()
+}
+
+func (s *localLoginService) EnforceClientTwoFactor(account *db.Account, clientID string) error {
+	if !s.lpConfig.TwoFactor.Enabled || !containsString(s.lpConfig.TwoFactor.Require.Clients, clientID) {
+		return nil
+	}
+
+	// Only accounts with a local login can enroll
+	authLocal, err := s.dbAuth.FindAuthLocal(account)
+	if err != nil || authLocal.HasTOTP() {
+		return nil
+	}
+
+	if err := s.dbStipulations.RequireTwoFactorEnrollment(account, s.lpConfig.TwoFactor.Require.GraceLogins); err != nil {
+		return err
+	}
+	remaining, err := s.dbStipulations.TwoFactorGraceLogins(account)
+	if err != nil {
+		return err
+	}
+	if remaining == 0 {
+		return LocalTwoFactorEnrollRequired.Newf("client %s requires 2FA", clientID)
+	}
+	return nil
+}
+
+func (s *localLoginService) TwoFactorRequired(account *db.Account) (bool, error) {
+	if !s.lpConfig.TwoFactor.Enabled {
+		return false, nil
+	}
+	return s.twoFactorRequired(account)
+}
+
+// twoFactorRequired is true if the policy requires the account to use 2FA, regardless of the client
+func (s *localLoginService) twoFactorRequired(account *db.Account) (bool, error) {
+	policy := &s.lpConfig.TwoFactor.Require
+	if policy.Everyone {
+		return true, nil
+	}
+	if len(policy.Groups) == 0 {
+		return false, nil
+	}
+
+	memberships, err := s.dbMemberships.GetMemberships(account)
+	if err != nil {
+		return false, err
+	}
+	for _, group := range policy.Groups {
+		if containsString(memberships.Groups, group) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (s *localLoginService) assertLoginCredentials(localAuth *db.AuthLocal, password string) error {
 	if !localAuth.Account().Active {
 		return db.InactiveAccount.New()
 	}
 
-	if !localAuth.VerifyPassword(password) {
-		s.dbAudit.CreateAuditRecord(localAuth, db.AuditModuleLocal, db.AuditLevelWarn, "Login failed")
-		return LocalInvalidCredentials.New()
+	if err := s.AssertPassword(localAuth, password); err != nil {
+		return err
 	}
 
-	if s.dbStipulations.AccountHasUnsatisfiedStipulations(localAuth.Account()) {
+	if s.dbApprovals.AccountPendingApproval(localAuth.Account()) {
+		return db.PendingApproval.New()
+	}
+	// Login steps are checked once the login is otherwise complete, see NextLoginStep
+	loginSteps := []db.StipulationType{
+		(&db.TermsStipulation{}).Type(),
+		(&db.PasswordResetStipulation{}).Type(),
+		(&db.TwoFactorEnrollStipulation{}).Type(),
+	}
+	if s.dbStipulations.AccountHasUnsatisfiedStipulations(localAuth.Account(), loginSteps...) {
 		return LocalUnsatisfiedStipulations.New()
 	}
 
 	return nil
 }
 
-func (s *localLoginService) ActivateTOTP(authLocal *db.AuthLocal, otp *totp.Totp, verificationCode string) error {
+func (s *localLoginService) AssertPassword(authLocal *db.AuthLocal, password string) error {
+	// Checked before the password, so a locked login can't be used to guess it
+	if authLocal.IsLocked() {
+		s.dbAudit.CreateAuditRecord(authLocal, db.AuditModuleLocal, db.AuditLevelWarn, "Login rejected, locked")
+		return authLocal.LockedError()
+	}
+
+	if !authLocal.VerifyPassword(password) {
+		s.dbAudit.CreateAuditRecord(authLocal, db.AuditModuleLocal, db.AuditLevelWarn, "Login failed")
+		s.recordLoginFailure(authLocal)
+		return LocalInvalidCredentials.New()
+	}
+
+	return nil
+}
+
+// recordLoginFailure counts a failed login towards the lockout. If it locks the login, the account is
+// emailed a link to unlock it
+func (s *localLoginService) recordLoginFailure(authLocal *db.AuthLocal) {
+	policy, err := s.lockoutPolicy()
+	if err != nil || policy == nil {
+		if err != nil {
+			s.log.Warnf("Invalid lockout config: %v", err)
+		}
+		return
+	}
+
+	locked, err := s.dbAuth.RecordLoginFailure(authLocal, policy)
+	if err != nil {
+		s.log.Warnf("Unable to record failed login for %s: %v", authLocal.Account().UUID, err)
+		return
+	}
+	if !locked {
+		return
+	}
+
+	account := authLocal.Account()
+	ttl, err := parseOptionalDuration(s.lpConfig.Lockout.UnlockExpires)
+	if err != nil {
+		s.log.Warnf("Invalid lockout unlock expiry: %v", err)
+		return
+	}
+	stip := db.NewUnlockStipulation(ttl)
+	if _, err := s.dbStipulations.RemoveStipulations(account, stip.Type()); err != nil {
+		s.log.Warnf("Unable to replace unlock link for %s: %v", account.UUID, err)
+		return
+	}
+	if err := s.dbStipulations.AddStipulation(account, stip); err != nil {
+		s.log.Warnf("Unable to create unlock link for %s: %v", account.UUID, err)
+		return
+	}
+
+	data := &email.LockedData{
+		EmailData: email.EmailData{
+			Company: s.metaConfig.Company,
+			BaseURL: s.baseURL,
+		},
+		FailedLogins:   authLocal.FailedLogins(),
+		UnlockLink:     template.HTML(fmt.Sprintf("%s/#/unlock?account=%s&token=%s", s.baseURL, account.UUID, stip.Code)),
+		UnlockDuration: s.lpConfig.Lockout.UnlockExpires,
+	}
+	if until := authLocal.LockedUntil(); until != nil {
+		data.LockedUntil = until.Format(time.RFC1123)
+	}
+	go s.emailService.SendLockedEmail(account.Email, data)
+}
+
+// lockoutPolicy returns the lockout policy, or nil if disabled
+func (s *localLoginService) lockoutPolicy() (*db.LockoutPolicy, error) {
+	cfg := &s.lpConfig.Lockout
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	duration, err := parseOptionalDuration(cfg.Duration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration: %w", err)
+	}
+	maxDuration, err := parseOptionalDuration(cfg.MaxDuration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid max duration: %w", err)
+	}
+	return &db.LockoutPolicy{
+		Threshold:          cfg.Threshold,
+		Duration:           duration,
+		MaxDuration:        maxDuration,
+		PermanentThreshold: cfg.PermanentThreshold,
+	}, nil
+}
+
+func (s *localLoginService) Unlock(account *db.Account, token string) error {
+	authLocal, err := s.dbAuth.FindAuthLocal(account)
+	if err != nil {
+		return LocalInvalidCredentials.Wrap(err)
+	}
+
+	unlock := &db.UnlockStipulation{}
+	unlock.Code = token
+	if err := s.dbStipulations.SatisfyStipulation(account, unlock); err != nil {
+		return err
+	}
+	return s.dbAuth.UnlockAuthLocal(authLocal)
+}
+
+func (s *localLoginService) ActivateTOTP(authLocal *db.AuthLocal, otp *totp.Totp, verificationCode string) ([]string, error) {
 	if !otp.Validate(verificationCode, s.lpConfig.TwoFactor.Drift) {
-		return LocalTOTPFailed.New()
+		return nil, LocalTOTPFailed.New()
 	}
 
 	tStr := otp.String()
 	if err := s.dbAuth.UpdateAuthLocalTOTP(authLocal, &tStr); err != nil {
-		return err
+		return nil, err
+	}
+	// Record the code as used, so it can't also login. If its time step has passed since, it couldn't anyway
+	if _, err := s.dbAuth.VerifyAuthLocalTOTP(authLocal, verificationCode, s.lpConfig.TwoFactor.Drift); err != nil {
+		return nil, err
 	}
 
-	return nil
+	if s.dbStipulations.AccountHasStipulation(authLocal.Account(), (&db.TwoFactorEnrollStipulation{}).Type()) {
+		if err := s.dbStipulations.SatisfyStipulation(authLocal.Account(), &db.TwoFactorEnrollStipulation{}); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.replaceRecoveryCodes(authLocal)
 }
 
 func (s *localLoginService) DeactivateTOTP(authLocal *db.AuthLocal, verificationCode string) error {
@@ -227,7 +602,16 @@ func (s *localLoginService) DeactivateTOTP(authLocal *db.AuthLocal, verification
 		return errors.New("totp disabled")
 	}
 
-	if !authLocal.VerifyTOTP(verificationCode, s.lpConfig.TwoFactor.Drift) {
+	// Checked first, so a recovery code isn't used up on a deactivation that would be refused
+	if required, err := s.twoFactorRequired(authLocal.Account()); err != nil {
+		return err
+	} else if required {
+		return LocalTwoFactorRequired.Newf("2FA is required for this account")
+	}
+
+	if ok, err := s.verifySecondFactor(authLocal, verificationCode); err != nil {
+		return err
+	} else if !ok {
 		return LocalTOTPFailed.New()
 	}
 
@@ -242,14 +626,190 @@ func (s *localLoginService) AllowTOTP() bool {
 	return s.lpConfig.TwoFactor.Enabled
 }
 
+func (s *localLoginService) RegenerateRecoveryCodes(authLocal *db.AuthLocal, verificationCode string) ([]string, error) {
+	if !authLocal.HasTOTP() {
+		return nil, errors.New("totp disabled")
+	}
+	if s.lpConfig.TwoFactor.RecoveryCodes <= 0 {
+		return nil, errors.New("recovery codes disabled")
+	}
+
+	if ok, err := s.verifySecondFactor(authLocal, verificationCode); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, LocalTOTPFailed.New()
+	}
+
+	return s.replaceRecoveryCodes(authLocal)
+}
+
+func (s *localLoginService) RecoveryCodesRemaining(authLocal *db.AuthLocal) (int, error) {
+	return s.dbAuth.CountRecoveryCodes(authLocal)
+}
+
+// verifySecondFactor checks a TOTP code that hasn't been used before, or failing that, consumes it as a recovery code
+func (s *localLoginService) verifySecondFactor(authLocal *db.AuthLocal, code string) (bool, error) {
+	if ok, err := s.dbAuth.VerifyAuthLocalTOTP(authLocal, code, s.lpConfig.TwoFactor.Drift); err != nil {
+		return false, err
+	} else if ok {
+		return true, nil
+	}
+
+	used, err := s.dbAuth.UseRecoveryCode(authLocal, code)
+	if err != nil || !used {
+		return false, err
+	}
+
+	remaining, err := s.dbAuth.CountRecoveryCodes(authLocal)
+	if err != nil {
+		return false, err
+	}
+	data := &email.RecoveryCodeUsedData{
+		EmailData: email.EmailData{
+			Company: s.metaConfig.Company,
+			BaseURL: s.baseURL,
+		},
+		Remaining: remaining,
+	}
+	go s.emailService.SendRecoveryCodeUsedEmail(authLocal.Account().Email, data)
+
+	return true, nil
+}
+
+// replaceRecoveryCodes generates new recovery codes for the login, or none if disabled
+func (s *localLoginService) replaceRecoveryCodes(authLocal *db.AuthLocal) ([]string, error) {
+	if s.lpConfig.TwoFactor.RecoveryCodes <= 0 {
+		return nil, nil
+	}
+
+	codes := make([]string, s.lpConfig.TwoFactor.RecoveryCodes)
+	for i := range codes {
+		code, err := genRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	if err := s.dbAuth.ReplaceRecoveryCodes(authLocal, codes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// genRecoveryCode returns a random code formatted as xxxxx-xxxxx, from an alphabet without easily confused characters
+func genRecoveryCode() (string, error) {
+	const table = "abcdefghjkmnpqrstuvwxyz23456789"
+	// Bytes at or above the largest multiple of len(table) are discarded, so every symbol is equally likely
+	const limit = 256 - 256%len(table)
+
+	ret := make([]byte, 0, 10)
+	buf := make([]byte, 16)
+	for len(ret) < cap(ret) {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit || len(ret) == cap(ret) {
+				continue
+			}
+			ret = append(ret, table[int(b)%len(table)])
+		}
+	}
+	return string(ret[:5]) + "-" + string(ret[5:]), nil
+}
+
 func (s *localLoginService) UpdatePassword(authLocal *db.AuthLocal, oldPassword string, newPassword string) error {
-	if !authLocal.VerifyPassword(oldPassword) {
-		return LocalInvalidCredentials.New()
+	// A wrong old password counts towards the lockout, like a failed login
+	if err := s.AssertPassword(authLocal, oldPassword); err != nil {
+		return err
 	}
 
 	return s.UpdatePasswordUnsafe(authLocal, newPassword)
 }
 
+// UpdatePasswordUnsafe sets the password without the old one, satisfying a required password change
 func (s *localLoginService) UpdatePasswordUnsafe(authLocal *db.AuthLocal, newPassword string) error {
-	return s.dbAuth.UpdateAuthLocalPassword(authLocal, newPassword)
+	account := authLocal.Account()
+	passwordReset := &db.PasswordResetStipulation{}
+	resetRequired := s.dbStipulations.AccountHasStipulation(account, passwordReset.Type())
+
+	changeRequired := resetRequired || authLocal.PasswordExpired(s.passwordMaxAge())
+
+	if changeRequired && authLocal.VerifyPassword(newPassword) {
+		return LocalPasswordUnchanged.Newf("new password must be different")
+	}
+
+	if err := s.validatePassword(authLocal, authLocal.Username(), account.Email, newPassword); err != nil {
+		return err
+	}
+
+	if err := s.dbAuth.RecordPasswordHistory(authLocal, s.lpConfig.PasswordPolicy.History); err != nil {
+		return err
+	}
+	if err := s.dbAuth.UpdateAuthLocalPassword(authLocal, newPassword); err != nil {
+		return err
+	}
+
+	if resetRequired {
+		return s.dbStipulations.SatisfyStipulation(account, passwordReset)
+	}
+	return nil
+}
+
+func (s *localLoginService) passwordMaxAge() time.Duration {
+	return time.Duration(s.lpConfig.PasswordExpiry.MaxAgeDays) * 24 * time.Hour
+}
+
+func (s *localLoginService) RemindExpiringPasswords() (int, error) {
+	maxAge := s.passwordMaxAge()
+	warn := time.Duration(s.lpConfig.PasswordExpiry.WarnDays) * 24 * time.Hour
+	if maxAge <= 0 || warn <= 0 {
+		return 0, nil
+	}
+
+	due, err := s.dbAuth.FindPasswordsDueReminder(time.Now().Add(warn - maxAge))
+	if err != nil {
+		return 0, err
+	}
+
+	reminded := 0
+	for _, authLocal := range due {
+		expires := authLocal.PasswordExpires(maxAge)
+		data := &email.PasswordExpiringData{
+			EmailData: email.EmailData{
+				Company: s.metaConfig.Company,
+				BaseURL: s.baseURL,
+			},
+			Expires:    expires.Format("Mon, 02 Jan 2006"),
+			Expired:    time.Now().After(*expires),
+			MaxAgeDays: s.lpConfig.PasswordExpiry.MaxAgeDays,
+		}
+		if err := s.emailService.SendPasswordExpiringEmail(authLocal.Account().Email, data); err != nil {
+			s.log.Warnf("Unable to remind %s that their password expires: %v", authLocal.Account().UUID, err)
+			continue
+		}
+		if err := s.dbAuth.MarkPasswordExpiryReminded(authLocal); err != nil {
+			return reminded, err
+		}
+		reminded++
+	}
+	return reminded, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOptionalDuration parses a config duration, where empty is 0
+func parseOptionalDuration(val string) (time.Duration, error) {
+	if val == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(val)
 }
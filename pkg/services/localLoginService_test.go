@@ -1,14 +1,18 @@
 package services
 
 import (
+	"encoding/json"
 	"simple-auth/pkg/appcontext"
 	"simple-auth/pkg/config"
 	"simple-auth/pkg/db"
 	"simple-auth/pkg/email"
 	"simple-auth/pkg/email/engine"
 	"simple-auth/pkg/lib/totp"
+	"simple-auth/pkg/saerrors"
 	"testing"
+	"time"
 
+	"github.com/jinzhu/gorm"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -38,7 +42,7 @@ func init() {
 			KeyLength: 12,
 		},
 		EmailValidationRequired: true,
-	}, "http://example.com").WithContext(ctx)
+	}, &config.ConfigTerms{}, "http://example.com").WithContext(ctx)
 
 	testLocalLoginAccount, _ = sadb.CreateAccount("test", testLocalEmail)
 	testAuthLocalAccount, _ = sadb.CreateAuthLocal(testLocalLoginAccount, testLocalUsername, testLocalPassword)
@@ -58,6 +62,335 @@ func TestAssertLoginFail(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestAssertLoginPendingApproval(t *testing.T) {
+	sadb := getDB()
+	account, _ := sadb.CreateAccount("test", "pending-login@asdf.com")
+	sadb.CreateAuthLocal(account, "pending-login", "pending-pass")
+	sadb.RequireApproval(account)
+
+	_, err := testLocalLogin.AssertLogin("pending-login", "pending-pass", nil)
+	assert.Equal(t, db.PendingApproval, saerrors.UnwrapCode(err))
+
+	sadb.ApproveAccount(account, "")
+	authLocal, err := testLocalLogin.AssertLogin("pending-login", "pending-pass", nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, authLocal)
+}
+
+func TestAssertLoginPasswordChangeRequired(t *testing.T) {
+	sadb := getDB()
+	account, _ := sadb.CreateAccount("test", "reset-login@asdf.com")
+	sadb.CreateAuthLocal(account, "reset-login", "reset-pass")
+	sadb.RequirePasswordChange(account)
+
+	authLocal, err := testLocalLogin.AssertLogin("reset-login", "reset-pass", nil)
+	assert.Equal(t, LocalPasswordChangeRequired, saerrors.UnwrapCode(err))
+	assert.NotNil(t, authLocal)
+
+	err = testLocalLogin.UpdatePasswordUnsafe(authLocal, "reset-pass")
+	assert.Equal(t, LocalPasswordUnchanged, saerrors.UnwrapCode(err))
+
+	assert.NoError(t, testLocalLogin.UpdatePasswordUnsafe(authLocal, "new-reset-pass"))
+	authLocal, err = testLocalLogin.AssertLogin("reset-login", "new-reset-pass", nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, authLocal)
+}
+
+func TestAssertLoginTermsRequired(t *testing.T) {
+	sadb := getDB()
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+	loginService := NewLocalLoginService(email.New(engine.NewMockEngine(nil), "test@example.com"),
+		&config.ConfigMetadata{}, &config.ConfigLocalProvider{}, &config.ConfigTerms{Version: "v1"}, "http://example.com").WithContext(ctx)
+
+	account, _ := sadb.CreateAccount("test", "terms-login@asdf.com")
+	sadb.CreateAuthLocal(account, "terms-login", "terms-pass")
+
+	authLocal, err := loginService.AssertLogin("terms-login", "terms-pass", nil)
+	assert.Equal(t, db.TermsRequired, saerrors.UnwrapCode(err))
+	assert.NotNil(t, authLocal)
+
+	assert.NoError(t, sadb.AcceptTerms(account, "v1"))
+	authLocal, err = loginService.AssertLogin("terms-login", "terms-pass", nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, authLocal)
+}
+
+func TestAssertLoginTwoFactorEnrollment(t *testing.T) {
+	sadb := getDB()
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+	loginService := NewLocalLoginService(email.New(engine.NewMockEngine(nil), "test@example.com"),
+		&config.ConfigMetadata{}, &config.ConfigLocalProvider{
+			TwoFactor: config.ConfigTwoFactor{
+				Enabled:   true,
+				Drift:     3,
+				Issuer:    "test",
+				KeyLength: 12,
+				Require: config.ConfigTwoFactorPolicy{
+					Groups:      []string{"admins"},
+					GraceLogins: 1,
+				},
+			},
+		}, &config.ConfigTerms{}, "http://example.com").WithContext(ctx)
+
+	account, _ := sadb.CreateAccount("test", "2fa-enroll@asdf.com")
+	sadb.CreateAuthLocal(account, "2fa-enroll", "enroll-pass")
+
+	// Not in the group
+	_, err := loginService.AssertLogin("2fa-enroll", "enroll-pass", nil)
+	assert.NoError(t, err)
+	assert.False(t, sadb.AccountHasStipulation(account, (&db.TwoFactorEnrollStipulation{}).Type()))
+
+	// One grace login, then enrollment is required
+	assert.NoError(t, sadb.AddMembership(account, db.MembershipGroup, "admins"))
+	_, err = loginService.AssertLogin("2fa-enroll", "enroll-pass", nil)
+	assert.NoError(t, err)
+	authLocal, err := loginService.AssertLogin("2fa-enroll", "enroll-pass", nil)
+	assert.Equal(t, LocalTwoFactorEnrollRequired, saerrors.UnwrapCode(err))
+	assert.NotNil(t, authLocal)
+
+	otp, _ := totp.NewTOTP(8, "test", "2fa-enroll")
+	_, err = loginService.ActivateTOTP(authLocal, otp, totpAt(otp, -1))
+	assert.NoError(t, err)
+	assert.False(t, sadb.AccountHasStipulation(account, (&db.TwoFactorEnrollStipulation{}).Type()))
+
+	code := totpAt(otp, 0)
+	_, err = loginService.AssertLogin("2fa-enroll", "enroll-pass", &code)
+	assert.NoError(t, err)
+
+	// Can't be deactivated while required
+	authLocal, _ = loginService.FindAuthLocal(account.UUID)
+	assert.Equal(t, LocalTwoFactorRequired, saerrors.UnwrapCode(loginService.DeactivateTOTP(authLocal, totpAt(otp, 1))))
+}
+
+// totpAt is the code offset time steps from now
+func totpAt(otp *totp.Totp, offset int64) string {
+	return otp.GetHOTP(time.Now().Unix()/30 + offset)
+}
+
+func TestAssertLoginTOTPReplay(t *testing.T) {
+	sadb := getDB()
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+	loginService := NewLocalLoginService(email.New(engine.NewMockEngine(nil), "test@example.com"),
+		&config.ConfigMetadata{}, &config.ConfigLocalProvider{
+			TwoFactor: config.ConfigTwoFactor{
+				Enabled: true,
+				Drift:   3,
+			},
+		}, &config.ConfigTerms{}, "http://example.com").WithContext(ctx)
+
+	account, _ := sadb.CreateAccount("test", "totp-replay@asdf.com")
+	authLocal, _ := sadb.CreateAuthLocal(account, "totp-replay", "replay-pass")
+
+	otp, _ := totp.NewTOTP(8, "test", "totp-replay")
+	activation := totpAt(otp, -1)
+	_, err := loginService.ActivateTOTP(authLocal, otp, activation)
+	assert.NoError(t, err)
+
+	// The activation code can't also login
+	_, err = loginService.AssertLogin("totp-replay", "replay-pass", &activation)
+	assert.Equal(t, LocalTOTPFailed, saerrors.UnwrapCode(err))
+
+	code := totpAt(otp, 0)
+	_, err = loginService.AssertLogin("totp-replay", "replay-pass", &code)
+	assert.NoError(t, err)
+	_, err = loginService.AssertLogin("totp-replay", "replay-pass", &code)
+	assert.Equal(t, LocalTOTPFailed, saerrors.UnwrapCode(err))
+
+	// Nor can a code, once used, deactivate
+	authLocal, _ = loginService.FindAuthLocal(account.UUID)
+	assert.Equal(t, LocalTOTPFailed, saerrors.UnwrapCode(loginService.DeactivateTOTP(authLocal, code)))
+	assert.NoError(t, loginService.DeactivateTOTP(authLocal, totpAt(otp, 1)))
+}
+
+func TestAssertLoginRecoveryCode(t *testing.T) {
+	sadb := getDB()
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+
+	loginService := NewLocalLoginService(email.New(engine.NewMockEngine(nil), "test@example.com"),
+		&config.ConfigMetadata{},
+		&config.ConfigLocalProvider{
+			TwoFactor: config.ConfigTwoFactor{
+				Enabled:       true,
+				Drift:         2,
+				RecoveryCodes: 3,
+			},
+		}, &config.ConfigTerms{}, "http://example.com").WithContext(ctx)
+
+	account, _ := sadb.CreateAccount("test", "recovery-login@asdf.com")
+	authLocal, _ := sadb.CreateAuthLocal(account, "recovery-login", "recovery-pass")
+
+	otp, _ := totp.NewTOTP(8, "test", "recovery-login")
+	codes, err := loginService.ActivateTOTP(authLocal, otp, otp.GetTOTP())
+	assert.NoError(t, err)
+	assert.Len(t, codes, 3)
+	assert.Regexp(t, "^[a-z0-9]{5}-[a-z0-9]{5}$", codes[0])
+
+	// Accepted once, in place of the TOTP code
+	_, err = loginService.AssertLogin("recovery-login", "recovery-pass", &codes[0])
+	assert.NoError(t, err)
+	_, err = loginService.AssertLogin("recovery-login", "recovery-pass", &codes[0])
+	assert.Equal(t, LocalTOTPFailed, saerrors.UnwrapCode(err))
+
+	authLocal, _ = loginService.FindAuthLocal(account.UUID)
+	remaining, _ := loginService.RecoveryCodesRemaining(authLocal)
+	assert.Equal(t, 2, remaining)
+
+	// Regenerating needs a valid code, and replaces the rest
+	_, err = loginService.RegenerateRecoveryCodes(authLocal, "wrong")
+	assert.Equal(t, LocalTOTPFailed, saerrors.UnwrapCode(err))
+	newCodes, err := loginService.RegenerateRecoveryCodes(authLocal, totpAt(otp, 1))
+	assert.NoError(t, err)
+	assert.Len(t, newCodes, 3)
+	_, err = loginService.AssertLogin("recovery-login", "recovery-pass", &codes[1])
+	assert.Equal(t, LocalTOTPFailed, saerrors.UnwrapCode(err))
+
+	// A lost device can be deactivated with a recovery code
+	authLocal, _ = loginService.FindAuthLocal(account.UUID)
+	assert.NoError(t, loginService.DeactivateTOTP(authLocal, newCodes[0]))
+	remaining, _ = loginService.RecoveryCodesRemaining(authLocal)
+	assert.Zero(t, remaining)
+}
+
+func TestDeactivateTOTPRequiredKeepsRecoveryCode(t *testing.T) {
+	sadb := getDB()
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+	mockEmail := engine.NewMockEngine(nil)
+	loginService := NewLocalLoginService(email.New(mockEmail, "test@example.com"),
+		&config.ConfigMetadata{},
+		&config.ConfigLocalProvider{
+			TwoFactor: config.ConfigTwoFactor{
+				Enabled:       true,
+				Drift:         2,
+				RecoveryCodes: 3,
+				Require: config.ConfigTwoFactorPolicy{
+					Everyone: true,
+				},
+			},
+		}, &config.ConfigTerms{}, "http://example.com").WithContext(ctx)
+
+	account, _ := sadb.CreateAccount("test", "recovery-required@asdf.com")
+	authLocal, _ := sadb.CreateAuthLocal(account, "recovery-required", "recovery-pass")
+
+	otp, _ := totp.NewTOTP(8, "test", "recovery-required")
+	codes, err := loginService.ActivateTOTP(authLocal, otp, otp.GetTOTP())
+	assert.NoError(t, err)
+	sent := mockEmail.SendCount()
+
+	// Refused by policy, without using up the code
+	authLocal, _ = loginService.FindAuthLocal(account.UUID)
+	err = loginService.DeactivateTOTP(authLocal, codes[0])
+	assert.Equal(t, LocalTwoFactorRequired, saerrors.UnwrapCode(err))
+	remaining, _ := loginService.RecoveryCodesRemaining(authLocal)
+	assert.Equal(t, 3, remaining)
+	assert.Equal(t, sent, mockEmail.SendCount())
+}
+
+func TestGenRecoveryCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := genRecoveryCode()
+		assert.NoError(t, err)
+		assert.Regexp(t, "^[a-hjkmnp-z2-9]{5}-[a-hjkmnp-z2-9]{5}$", code)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	sadb := getDB()
+	account, _ := sadb.CreateAccount("test", "resend@asdf.com")
+
+	assert.Equal(t, db.VerificationMissing, saerrors.UnwrapCode(testLocalLogin.ResendVerification(account)))
+
+	stip := db.NewTokenStipulation()
+	assert.NoError(t, sadb.AddStipulation(account, stip))
+
+	assert.NoError(t, testLocalLogin.ResendVerification(account))
+	assert.Error(t, sadb.SatisfyStipulation(account, stip))
+	assert.True(t, sadb.AccountHasStipulation(account, stip.Type()))
+}
+
+func TestAssertLoginLockout(t *testing.T) {
+	sadb := getDB()
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+	mockEmail := engine.NewMockEngine(nil)
+	loginService := NewLocalLoginService(email.New(mockEmail, "test@example.com"),
+		&config.ConfigMetadata{}, &config.ConfigLocalProvider{
+			Lockout: config.ConfigLockout{
+				Enabled:       true,
+				Threshold:     2,
+				Duration:      "1m",
+				UnlockExpires: "1h",
+			},
+		}, &config.ConfigTerms{}, "http://example.com").WithContext(ctx)
+
+	account, _ := sadb.CreateAccount("test", "lockout-login@asdf.com")
+	sadb.CreateAuthLocal(account, "lockout-login", "lockout-pass")
+
+	// A success resets the count
+	_, err := loginService.AssertLogin("lockout-login", "wrong", nil)
+	assert.Equal(t, LocalInvalidCredentials, saerrors.UnwrapCode(err))
+	_, err = loginService.AssertLogin("lockout-login", "lockout-pass", nil)
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = loginService.AssertLogin("lockout-login", "wrong", nil)
+		assert.Equal(t, LocalInvalidCredentials, saerrors.UnwrapCode(err))
+	}
+
+	// Even the right password is rejected
+	_, err = loginService.AssertLogin("lockout-login", "lockout-pass", nil)
+	assert.Equal(t, db.AuthLocked, saerrors.UnwrapCode(err))
+	assert.True(t, sadb.AccountHasStipulation(account, (&db.UnlockStipulation{}).Type()))
+
+	assert.Error(t, loginService.Unlock(account, "bad-token"))
+	stips, _ := sadb.ListStipulations(account)
+	var unlock db.UnlockStipulation
+	for _, stip := range stips {
+		if stip.Type == unlock.Type() {
+			json.Unmarshal([]byte(stip.Specification), &unlock)
+		}
+	}
+	assert.NoError(t, loginService.Unlock(account, unlock.Code))
+
+	_, err = loginService.AssertLogin("lockout-login", "lockout-pass", nil)
+	assert.NoError(t, err)
+}
+
+func TestUpdatePasswordLockout(t *testing.T) {
+	sadb := getDB()
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+	loginService := NewLocalLoginService(email.New(engine.NewMockEngine(nil), "test@example.com"),
+		&config.ConfigMetadata{}, &config.ConfigLocalProvider{
+			Lockout: config.ConfigLockout{
+				Enabled:       true,
+				Threshold:     2,
+				Duration:      "1m",
+				UnlockExpires: "1h",
+			},
+		}, &config.ConfigTerms{}, "http://example.com").WithContext(ctx)
+
+	account, _ := sadb.CreateAccount("test", "lockout-update@asdf.com")
+	sadb.CreateAuthLocal(account, "lockout-update", "lockout-pass")
+
+	for i := 0; i < 2; i++ {
+		authLocal, _ := loginService.FindAuthLocal(account.UUID)
+		err := loginService.UpdatePassword(authLocal, "wrong", "new-lockout-pass")
+		assert.Equal(t, LocalInvalidCredentials, saerrors.UnwrapCode(err))
+	}
+
+	// Locked, so neither the old password nor a login is accepted
+	authLocal, _ := loginService.FindAuthLocal(account.UUID)
+	err := loginService.UpdatePassword(authLocal, "lockout-pass", "new-lockout-pass")
+	assert.Equal(t, db.AuthLocked, saerrors.UnwrapCode(err))
+	_, err = loginService.AssertLogin("lockout-update", "lockout-pass", nil)
+	assert.Equal(t, db.AuthLocked, saerrors.UnwrapCode(err))
+}
+
 func TestFindLoginByAccount(t *testing.T) {
 	authLocal, err := testLocalLogin.FindAuthLocal(testLocalLoginAccount.UUID)
 	assert.NotNil(t, authLocal)
@@ -129,3 +462,97 @@ func TestAuthLocalModifyPassword(t *testing.T) {
 	assert.Error(t, testLocalLogin.UpdatePassword(authLocal, "passchange-WRONG", "bla"))
 	assert.NoError(t, testLocalLogin.UpdatePassword(authLocal, "passchange-test", "bla"))
 }
+
+func TestUpdatePasswordPolicy(t *testing.T) {
+	sadb := getDB()
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+	loginService := NewLocalLoginService(email.New(engine.NewMockEngine(nil), "test@example.com"),
+		&config.ConfigMetadata{}, &config.ConfigLocalProvider{
+			Requirements: config.ConfigLocalLoginRequirements{
+				PasswordMinLength: 8,
+				PasswordMaxLength: 30,
+			},
+			PasswordPolicy: config.ConfigPasswordPolicy{
+				RequireDigit:   true,
+				RejectUserInfo: true,
+				History:        2,
+			},
+		}, &config.ConfigTerms{}, "http://example.com").WithContext(ctx)
+
+	account, _ := sadb.CreateAccount("test", "policy@asdf.com")
+	authLocal, _ := sadb.CreateAuthLocal(account, "policy-user", "first-pass-1")
+
+	err := loginService.UpdatePasswordUnsafe(authLocal, "short")
+	assert.Equal(t, LocalCredentialRequirements, saerrors.UnwrapCode(err))
+	assert.Equal(t, []string{"too-short", "missing-digit"}, saerrors.UnwrapDetails(err))
+
+	err = loginService.UpdatePassword(authLocal, "first-pass-1", "my-policy-user-1")
+	assert.Equal(t, []string{"contains-user-info"}, saerrors.UnwrapDetails(err))
+
+	assert.NoError(t, loginService.UpdatePassword(authLocal, "first-pass-1", "second-pass-2"))
+	authLocal, _ = sadb.FindAuthLocal(account)
+
+	// Reusing the previous password
+	err = loginService.UpdatePasswordUnsafe(authLocal, "first-pass-1")
+	assert.Equal(t, []string{"reused"}, saerrors.UnwrapDetails(err))
+
+	assert.NoError(t, loginService.UpdatePasswordUnsafe(authLocal, "third-pass-3"))
+	authLocal, _ = sadb.FindAuthLocal(account)
+	assert.NoError(t, loginService.UpdatePasswordUnsafe(authLocal, "first-pass-1"))
+
+	assert.Error(t, loginService.ValidatePassword("new-user", "new@asdf.com", "no-digits-here"))
+	assert.NoError(t, loginService.ValidatePassword("new-user", "new@asdf.com", "has-digit-1"))
+}
+
+func TestAssertLoginPasswordExpired(t *testing.T) {
+	sadb := getDB()
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+	mockEmail := engine.NewMockEngine(nil)
+	loginService := NewLocalLoginService(email.New(mockEmail, "test@example.com"),
+		&config.ConfigMetadata{}, &config.ConfigLocalProvider{
+			PasswordExpiry: config.ConfigPasswordExpiry{
+				MaxAgeDays: 90,
+				WarnDays:   14,
+			},
+		}, &config.ConfigTerms{}, "http://example.com").WithContext(ctx)
+
+	account, _ := sadb.CreateAccount("test", "expired-login@asdf.com")
+	sadb.CreateAuthLocal(account, "expired-login", "expired-pass")
+	_, err := loginService.AssertLogin("expired-login", "expired-pass", nil)
+	assert.NoError(t, err)
+
+	// Age the password into the warning period, then past expiry
+	raw, _ := gorm.Open("sqlite3", "file::memory:?cache=shared")
+	defer raw.Close()
+	age := func(days int) {
+		raw.Exec("UPDATE account_auth_locals SET password_changed = ? WHERE username = ?", time.Now().AddDate(0, 0, -days), "expired-login")
+	}
+
+	age(80)
+	reminded, err := loginService.RemindExpiringPasswords()
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, reminded, 1)
+	assert.Contains(t, mockEmail.LastEmail(), "expires soon")
+	sent := mockEmail.SendCount()
+	loginService.RemindExpiringPasswords()
+	assert.Equal(t, sent, mockEmail.SendCount())
+
+	age(91)
+	authLocal, err := loginService.AssertLogin("expired-login", "expired-pass", nil)
+	assert.Equal(t, LocalPasswordExpired, saerrors.UnwrapCode(err))
+	assert.NotNil(t, authLocal)
+
+	err = loginService.UpdatePasswordUnsafe(authLocal, "expired-pass")
+	assert.Equal(t, LocalPasswordUnchanged, saerrors.UnwrapCode(err))
+	assert.NoError(t, loginService.UpdatePasswordUnsafe(authLocal, "renewed-pass"))
+	assert.NoError(t, loginService.NextLoginStep(authLocal))
+
+	// Exempt
+	age(91)
+	authLocal, _ = loginService.FindAuthLocal(account.UUID)
+	sadb.SetPasswordNeverExpires(authLocal, true)
+	_, err = loginService.AssertLogin("expired-login", "renewed-pass", nil)
+	assert.NoError(t, err)
+}
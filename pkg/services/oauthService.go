@@ -7,6 +7,8 @@ import (
 	"simple-auth/pkg/appcontext"
 	"simple-auth/pkg/config"
 	"simple-auth/pkg/db"
+	"simple-auth/pkg/lib/attributes"
+	"simple-auth/pkg/saerrors"
 	"strings"
 	"time"
 
@@ -17,8 +19,10 @@ import (
 
 // Common scopes
 const (
-	ScopeEmail = "email"
-	ScopeName  = "username"
+	ScopeEmail  = "email"
+	ScopeName   = "username"
+	ScopeRoles  = "roles"
+	ScopeGroups = "groups"
 )
 
 type IssuedToken struct {
@@ -37,7 +41,7 @@ type AuthOAuthService interface {
 	TradeRefreshTokenForAccessToken(secret, refreshToken string) (ret IssuedToken, err error)
 	TradeCredentialsForToken(secret, username, password string, totp *string, scopes db.OAuthScope) (ret IssuedToken, err error)
 
-	FindExistingToken(account *db.Account, tokenType db.OAuthTokenType, scopes db.OAuthScope) (IssuedToken, error)
+	FindExistingToken(account *db.Account, tokenType db.OAuthTokenType, scopes db.OAuthScope) (*db.OAuthToken, error)
 
 	ValidateRedirectURI(uri string) bool
 	ValidateScopes(scopes db.OAuthScope) bool
@@ -45,13 +49,24 @@ type AuthOAuthService interface {
 }
 
 var (
-	ErrInvalidScopes = errors.New("invalid scope")
+	ErrInvalidScopes     = errors.New("invalid scope")
+	ErrClientNotAllowed  = errors.New("client not allowed by the account's organization")
+	ErrTwoFactorRequired = errors.New("client requires the account to enroll in 2FA")
 )
 
 type openIDConnectClaims struct {
 	jwt.StandardClaims
-	Email string `json:"email,omitempty"`
-	Name  string `json:"name,omitempty"`
+	Email        string                 `json:"email,omitempty"`
+	Name         string                 `json:"name,omitempty"`
+	Organization string                 `json:"org,omitempty"` // UUID of the account's organization, if any
+	Roles        []string               `json:"roles,omitempty"`
+	Groups       []string               `json:"groups,omitempty"`
+	Attributes   map[string]interface{} `json:"-"` // Account attribute claims, merged in at the top level
+}
+
+func (s openIDConnectClaims) MarshalJSON() ([]byte, error) {
+	type claims openIDConnectClaims
+	return attributes.MarshalWithClaims(claims(s), s.Attributes)
 }
 
 type authOAuthService struct {
@@ -64,13 +79,21 @@ type authOAuthService struct {
 	jwtSigningKey    interface{}
 
 	// Contextual
-	dbOAuth    db.AccountOAuth
-	localLogin LocalLoginService
-	log        logrus.FieldLogger
+	dbOAuth       db.AccountOAuth
+	localLogin    LocalLoginService
+	log           logrus.FieldLogger
+	dbAttributes  db.AccountAttributes
+	attributes    *attributes.Schema
+	dbMemberships db.AccountMemberships
+	dbOrgs        db.AccountOrganizations
 }
 
 func NewAuthOAuthService(clientID string, config *config.ConfigOAuth2Client, common *config.ConfigOAuth2Settings, localLoginService LocalLoginService) AuthOAuthService {
 	settings := config.Overrides.Coalesce(common)
+	if *settings.ReuseToken {
+		logrus.Warnf("OAuth2 client %s: reusetoken is no longer supported, since tokens are only stored hashed. A new token will always be issued", clientID)
+	}
+
 	ret := &authOAuthService{
 		clientID,
 		config,
@@ -80,6 +103,10 @@ func NewAuthOAuthService(clientID string, config *config.ConfigOAuth2Client, com
 		nil,
 		localLoginService,
 		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 	}
 
 	if config.OIDC != nil {
@@ -102,6 +129,10 @@ func (s *authOAuthService) WithContext(ctx appcontext.Context) AuthOAuthService
 	copy.dbOAuth = appcontext.GetSADB(ctx)
 	copy.localLogin = s.localLogin.WithContext(ctx)
 	copy.log = appcontext.GetLogger(ctx)
+	copy.dbAttributes = appcontext.GetSADB(ctx)
+	copy.attributes = appcontext.GetAttributeSchema(ctx)
+	copy.dbMemberships = appcontext.GetSADB(ctx)
+	copy.dbOrgs = appcontext.GetSADB(ctx)
 	return &copy
 }
 
@@ -109,6 +140,12 @@ func (s *authOAuthService) CreateAccessCode(account *db.Account, scopes db.OAuth
 	if !s.ValidateScopes(scopes) {
 		return "", ErrInvalidScopes
 	}
+	if _, err := s.allowedOrganization(account); err != nil {
+		return "", err
+	}
+	if err := s.enforceTwoFactor(account); err != nil {
+		return "", err
+	}
 
 	code, err := genAccessCode(*s.settings.CodeLength)
 	if err != nil {
@@ -170,6 +207,9 @@ func (s *authOAuthService) TradeCredentialsForToken(secret, username, password s
 	if err != nil {
 		return
 	}
+	if err = s.enforceTwoFactor(authLocal.Account()); err != nil {
+		return
+	}
 
 	ret, err = s.issueToken(authLocal.Account(), scopes)
 	return
@@ -180,6 +220,10 @@ func (s *authOAuthService) issueToken(account *db.Account, scopes db.OAuthScope)
 		err = ErrInvalidScopes
 		return
 	}
+	org, err := s.allowedOrganization(account)
+	if err != nil {
+		return
+	}
 
 	if s.jwtSigningKey != nil && s.jwtSigningMethod != nil {
 		claims := openIDConnectClaims{
@@ -189,6 +233,7 @@ func (s *authOAuthService) issueToken(account *db.Account, scopes db.OAuthScope)
 				Audience:  s.clientID,
 				ExpiresAt: time.Now().Add(time.Duration(*s.settings.TokenExpiresSeconds) * time.Second).Unix(),
 			},
+			Organization: org.OrganizationUUID(),
 		}
 
 		if scopes.Contains(ScopeEmail) {
@@ -197,6 +242,21 @@ func (s *authOAuthService) issueToken(account *db.Account, scopes db.OAuthScope)
 		if scopes.Contains(ScopeName) {
 			claims.Name = account.Name
 		}
+		if scopes.Contains(ScopeRoles) || scopes.Contains(ScopeGroups) {
+			var memberships *db.Memberships
+			if memberships, err = s.dbMemberships.GetMemberships(account); err != nil {
+				return
+			}
+			if scopes.Contains(ScopeRoles) {
+				claims.Roles = memberships.Roles
+			}
+			if scopes.Contains(ScopeGroups) {
+				claims.Groups = memberships.Groups
+			}
+		}
+		if claims.Attributes, err = s.attributeClaims(account, scopes); err != nil {
+			return
+		}
 
 		jwtToken := jwt.NewWithClaims(s.jwtSigningMethod, claims)
 		if idToken, err := jwtToken.SignedString(s.jwtSigningKey); err == nil {
@@ -206,24 +266,6 @@ func (s *authOAuthService) issueToken(account *db.Account, scopes db.OAuthScope)
 		}
 	}
 
-	if *s.settings.ReuseToken {
-		if tokens, vtErr := s.dbOAuth.GetValidOAuthTokens(s.clientID, account); vtErr == nil {
-			for _, t := range tokens {
-				if ret.AccessToken == "" && t.Type == db.OAuthTypeAccessToken && t.Scopes.Matches(scopes) {
-					ret.AccessToken = t.Token
-					ret.Expires = int(time.Until(t.Expires).Seconds())
-					ret.Scope = t.Scopes
-				}
-				if ret.RefreshToken == "" && t.Type == db.OAuthTypeRefreshToken && *s.settings.IssueRefreshToken {
-					ret.RefreshToken = t.Token
-				}
-			}
-			if ret.AccessToken != "" && (ret.RefreshToken != "" || !*s.settings.IssueRefreshToken) {
-				return
-			}
-		}
-	}
-
 	if *s.settings.RevokeOldTokens {
 		if err = s.dbOAuth.InvalidateAllOAuth(s.clientID, account, nil); err != nil {
 			return
@@ -240,8 +282,7 @@ func (s *authOAuthService) issueToken(account *db.Account, scopes db.OAuthScope)
 
 	if *s.settings.IssueRefreshToken {
 		ret.RefreshToken = uuid.New().String()
-		const oneHundredYears = 100 * 365 * 24 * time.Hour
-		err = s.dbOAuth.CreateOAuthToken(account, s.clientID, db.OAuthTypeRefreshToken, ret.RefreshToken, scopes, oneHundredYears)
+		err = s.dbOAuth.CreateOAuthToken(account, s.clientID, db.OAuthTypeRefreshToken, ret.RefreshToken, scopes, s.refreshTokenExpiry())
 		if err != nil {
 			return
 		}
@@ -250,6 +291,40 @@ func (s *authOAuthService) issueToken(account *db.Account, scopes db.OAuthScope)
 	return
 }
 
+// allowedOrganization returns the account's organization, if any, or ErrClientNotAllowed if it doesn't allow the client
+func (s *authOAuthService) allowedOrganization(account *db.Account) (*db.Organization, error) {
+	org, err := s.dbOrgs.GetAccountOrganization(account)
+	if err != nil {
+		return nil, err
+	}
+	if !org.AllowsClient(s.clientID) {
+		return nil, ErrClientNotAllowed
+	}
+	return org, nil
+}
+
+// enforceTwoFactor returns ErrTwoFactorRequired if the client requires the account to have enrolled in 2FA
+func (s *authOAuthService) enforceTwoFactor(account *db.Account) error {
+	err := s.localLogin.EnforceClientTwoFactor(account, s.clientID)
+	if saerrors.UnwrapCode(err) == LocalTwoFactorEnrollRequired {
+		return ErrTwoFactorRequired
+	}
+	return err
+}
+
+// attributeClaims returns the account's attributes that are granted by scopes
+func (s *authOAuthService) attributeClaims(account *db.Account, scopes db.OAuthScope) (map[string]interface{}, error) {
+	if !s.attributes.HasScopeClaims(scopes.Contains) {
+		return nil, nil
+	}
+
+	values, err := s.dbAttributes.GetAccountAttributes(account)
+	if err != nil {
+		return nil, err
+	}
+	return s.attributes.ScopeClaims(values, scopes.Contains), nil
+}
+
 func (s *authOAuthService) TradeRefreshTokenForAccessToken(secret, refreshToken string) (ret IssuedToken, err error) {
 	if s.config.Secret != secret {
 		err = errors.New("invalid secret")
@@ -261,6 +336,9 @@ func (s *authOAuthService) TradeRefreshTokenForAccessToken(secret, refreshToken
 	if err != nil {
 		return
 	}
+	if _, err = s.allowedOrganization(token.Account); err != nil {
+		return
+	}
 
 	if *s.settings.RevokeOldTokens {
 		s.log.Infof("Invalidating all tokens for %s client %s...", token.Account.UUID, s.clientID)
@@ -280,23 +358,21 @@ func (s *authOAuthService) TradeRefreshTokenForAccessToken(secret, refreshToken
 	return
 }
 
-func (s *authOAuthService) FindExistingToken(account *db.Account, tokenType db.OAuthTokenType, scopes db.OAuthScope) (IssuedToken, error) {
+// FindExistingToken finds a valid token matching the scopes.  Since tokens are stored
+// hashed, only its metadata is available, not the token itself
+func (s *authOAuthService) FindExistingToken(account *db.Account, tokenType db.OAuthTokenType, scopes db.OAuthScope) (*db.OAuthToken, error) {
 	tokens, err := s.dbOAuth.GetValidOAuthTokens(s.clientID, account)
 	if err != nil {
-		return IssuedToken{}, err
+		return nil, err
 	}
 
 	for _, v := range tokens {
 		if v.Type == tokenType && v.Scopes.Matches(scopes) {
-			return IssuedToken{
-				AccessToken: v.Token,
-				Expires:     int(time.Until(v.Expires).Seconds()),
-				Scope:       v.Scopes,
-			}, nil
+			return v, nil
 		}
 	}
 
-	return IssuedToken{}, errors.New("no token found")
+	return nil, errors.New("no token found")
 }
 
 func (s *authOAuthService) ValidateRedirectURI(uri string) bool {
@@ -341,3 +417,12 @@ func parseSigningKey(method, key string) (interface{}, error) {
 	}
 	return nil, fmt.Errorf("unable to parse key for %s", method)
 }
+
+// refreshTokenExpiry is the configured refresh-token lifetime, or effectively never if 0
+func (s *authOAuthService) refreshTokenExpiry() time.Duration {
+	if *s.settings.RefreshTokenExpiresSeconds > 0 {
+		return time.Duration(*s.settings.RefreshTokenExpiresSeconds) * time.Second
+	}
+	const oneHundredYears = 100 * 365 * 24 * time.Hour
+	return oneHundredYears
+}
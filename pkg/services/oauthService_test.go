@@ -6,8 +6,10 @@ import (
 	"simple-auth/pkg/db"
 	"simple-auth/pkg/email"
 	"simple-auth/pkg/email/engine"
+	"simple-auth/pkg/lib/attributes"
 	"testing"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,27 +26,29 @@ func init() {
 		email.New(engine.NewMockEngine(nil), "test@example.com"),
 		&config.ConfigMetadata{},
 		&config.ConfigLocalProvider{},
+		&config.ConfigTerms{},
 		"http://example.com",
 	)
 
 	testOAuthService = NewAuthOAuthService("test-client", &config.ConfigOAuth2Client{
 		Secret:      "test-secret",
 		RedirectURI: "http://example.com/redirect",
-		Scopes:      []string{"email", "user"},
+		Scopes:      []string{"email", "user", "roles", "groups"},
 		OIDC: &config.OAuth2OIDCConfig{
 			SigningMethod: "HS256",
 			SigningKey:    "abcdef721yu4uih",
 		},
 	}, &config.ConfigOAuth2Settings{
-		CodeExpiresSeconds:  config.IntPtr(10),
-		TokenExpiresSeconds: config.IntPtr(20),
-		CodeLength:          config.IntPtr(6),
-		AllowCredentials:    config.TruePtr,
-		IssueRefreshToken:   config.TruePtr,
-		AllowAutoGrant:      config.TruePtr,
-		ReuseToken:          config.FalsePtr,
-		RevokeOldTokens:     config.TruePtr,
-		Issuer:              config.StrPtr("simple-auth"),
+		CodeExpiresSeconds:         config.IntPtr(10),
+		TokenExpiresSeconds:        config.IntPtr(20),
+		RefreshTokenExpiresSeconds: config.IntPtr(0),
+		CodeLength:                 config.IntPtr(6),
+		AllowCredentials:           config.TruePtr,
+		IssueRefreshToken:          config.TruePtr,
+		AllowAutoGrant:             config.TruePtr,
+		ReuseToken:                 config.FalsePtr,
+		RevokeOldTokens:            config.TruePtr,
+		Issuer:                     config.StrPtr("simple-auth"),
 	}, localLoginService).WithContext(ctx)
 
 	testOAuthAccount, _ = sadb.CreateAccount("test-oauth", "test-oauth@example.com")
@@ -168,19 +172,20 @@ func TestFindToken(t *testing.T) {
 	{
 		found, err := testOAuthService.FindExistingToken(testOAuthAccount, db.OAuthTypeCode, nil)
 		assert.NoError(t, err)
-		assert.Equal(t, code, found.AccessToken)
+		assert.Equal(t, db.OAuthTypeCode, found.Type)
+		assert.Empty(t, found.Token)
 	}
 
 	token, _ := testOAuthService.TradeCodeForToken("test-secret", code)
 	{
 		found, err := testOAuthService.FindExistingToken(testOAuthAccount, db.OAuthTypeCode, nil)
 		assert.Error(t, err)
-		assert.Empty(t, found.AccessToken)
+		assert.Nil(t, found)
 	}
 	{
 		found, err := testOAuthService.FindExistingToken(testOAuthAccount, db.OAuthTypeAccessToken, nil)
 		assert.NoError(t, err)
-		assert.Equal(t, token.AccessToken, found.AccessToken)
+		assert.Equal(t, token.AccessToken[:5], found.TokenPrefix)
 	}
 }
 
@@ -197,3 +202,105 @@ func TestValidateScopes(t *testing.T) {
 	assert.False(t, testOAuthService.ValidateScopes(db.NewOAuthScope("admin")))
 	assert.False(t, testOAuthService.ValidateScopes(db.NewOAuthScope("admin email")))
 }
+
+func TestIDTokenAttributes(t *testing.T) {
+	sadb := getDB()
+	schema, _ := attributes.NewFromConfig([]*config.ConfigAccountAttribute{
+		{Name: "locale", Scope: "user"},
+		{Name: "department", Claim: "dept", Scope: "email"},
+		{Name: "employeeId", AdminOnly: true, Scope: "user"},
+	})
+
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+	ctx.Use(appcontext.WithAttributeSchema(schema))
+	service := testOAuthService.WithContext(ctx)
+
+	account, _ := sadb.CreateAccount("test-oauth-attrs", "test-oauth-attrs@example.com")
+	locale, dept, employeeID := "en-US", "Sales", "1234"
+	sadb.SetAccountAttributes(account, map[string]*string{
+		"locale":     &locale,
+		"department": &dept,
+		"employeeId": &employeeID,
+	})
+
+	code, _ := service.CreateAccessCode(account, db.NewOAuthScope("user"))
+	token, err := service.TradeCodeForToken("test-secret", code)
+	assert.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(token.IDToken, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte("abcdef721yu4uih"), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, account.UUID, claims["sub"])
+	assert.Equal(t, "en-US", claims["locale"])
+	assert.NotContains(t, claims, "dept")
+	assert.NotContains(t, claims, "employeeId")
+}
+
+func TestIDTokenMemberships(t *testing.T) {
+	sadb := getDB()
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+	service := testOAuthService.WithContext(ctx)
+
+	account, _ := sadb.CreateAccount("test-oauth-memberships", "test-oauth-memberships@example.com")
+	sadb.AddMembership(account, db.MembershipRole, "editor")
+	sadb.AddMembership(account, db.MembershipGroup, "staff")
+
+	parse := func(idToken string) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+		_, err := jwt.ParseWithClaims(idToken, claims, func(*jwt.Token) (interface{}, error) {
+			return []byte("abcdef721yu4uih"), nil
+		})
+		assert.NoError(t, err)
+		return claims
+	}
+
+	code, _ := service.CreateAccessCode(account, db.NewOAuthScope("roles"))
+	token, err := service.TradeCodeForToken("test-secret", code)
+	assert.NoError(t, err)
+	claims := parse(token.IDToken)
+	assert.Equal(t, []interface{}{"editor"}, claims["roles"])
+	assert.NotContains(t, claims, "groups")
+
+	code, _ = service.CreateAccessCode(account, db.NewOAuthScope("groups"))
+	token, err = service.TradeCodeForToken("test-secret", code)
+	assert.NoError(t, err)
+	claims = parse(token.IDToken)
+	assert.Equal(t, []interface{}{"staff"}, claims["groups"])
+	assert.NotContains(t, claims, "roles")
+}
+
+func TestIDTokenOrganization(t *testing.T) {
+	sadb := getDB()
+	ctx := appcontext.NewContainer()
+	ctx.Use(appcontext.WithSADB(sadb))
+	service := testOAuthService.WithContext(ctx)
+
+	org, _ := sadb.CreateOrganization("test-oauth-org", "OAuth Org")
+	account, _ := sadb.CreateAccount("test-oauth-org", "test-oauth-org@example.com")
+	assert.NoError(t, sadb.SetAccountOrganization(account, org, false))
+
+	code, err := service.CreateAccessCode(account, nil)
+	assert.NoError(t, err)
+	token, err := service.TradeCodeForToken("test-secret", code)
+	assert.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(token.IDToken, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte("abcdef721yu4uih"), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, org.UUID, claims["org"])
+
+	// Restrict the organization to another client
+	org.AllowedClients = "other-client"
+	assert.NoError(t, sadb.UpdateOrganization(org))
+
+	_, err = service.CreateAccessCode(account, nil)
+	assert.Equal(t, ErrClientNotAllowed, err)
+	_, err = service.TradeRefreshTokenForAccessToken("test-secret", token.RefreshToken)
+	assert.Equal(t, ErrClientNotAllowed, err)
+}
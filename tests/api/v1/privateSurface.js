@@ -5,6 +5,9 @@ const config = require('../../config');
 
 const tfaSecret = 'ORDRZHDCYXU435ETZCIQ====';
 
+// A code can only be used once, so each route gets its own time step (within the server's drift)
+const tfaCode = (stepOffset) => speakeasy.totp({ secret: tfaSecret, encoding: 'base32', time: Date.now() / 1000 + stepOffset * 30 });
+
 const routes = [
   ['GET', '/api/v1/account', null],
   ['GET', '/api/v1/account/audit', null],
@@ -12,8 +15,9 @@ const routes = [
   ['POST', '/api/v1/local/password', { newpassword: 'bla' }],
   ['GET', '/api/v1/local/2fa', null],
   ['GET', '/api/v1/local/2fa/qrcode', { secret: tfaSecret }],
-  ['POST', '/api/v1/local/2fa', { secret: tfaSecret, code: speakeasy.totp({ secret: tfaSecret, encoding: 'base32' }) }],
-  ['DELETE', '/api/v1/local/2fa', { code: speakeasy.totp({ secret: tfaSecret, encoding: 'base32' }) }],
+  ['POST', '/api/v1/local/2fa', { secret: tfaSecret, code: tfaCode(-1) }],
+  ['POST', '/api/v1/local/2fa/recovery-codes', { code: tfaCode(0) }],
+  ['DELETE', '/api/v1/local/2fa', { code: tfaCode(1) }],
   ['GET', '/api/v1/auth/oauth2', null],
   ['POST', '/api/v1/auth/oauth2/grant', { client_id: 'testid', response_type: 'code', redirect_uri: 'http://example.com/redirect' }],
   ['DELETE', '/api/v1/auth/oauth2/token', { client_id: 'testid' }],
@@ -20,6 +20,9 @@ import PageNotFound from './routes/pageNotFound.vue';
 import ForgotPassword from './routes/forgotPassword.vue';
 import ActivateAccount from './routes/activateAccount.vue';
 import OAuth2 from './routes/oauth2.vue';
+import AcceptTerms from './routes/acceptTerms.vue';
+import ResendVerification from './routes/resendVerification.vue';
+import UnlockAccount from './routes/unlockAccount.vue';
 
 axios.defaults.headers.common['X-CSRF-TOKEN'] = document.head.querySelector('meta[name="csrf"]').content;
 dayjs.extend(localizedPlugin);
@@ -42,7 +45,10 @@ window.bindRouter = function bindRouter(el, data = {}) {
       { path: '/create', component: CreateAccount, props: data },
       { path: '/login-redirect', component: LoginRedirect, props: data },
       { path: '/manage', component: ManageAccount, props: data },
+      { path: '/terms', component: AcceptTerms, props: data },
       { path: '/activate', component: ActivateAccount, props: (route) => ({ token: route.query.token, account: route.query.account }) },
+      { path: '/unlock', component: UnlockAccount, props: (route) => ({ token: route.query.token, account: route.query.account }) },
+      { path: '/resend-verification', component: ResendVerification, props: (route) => ({ appdata: data.appdata, account: route.query.account }) },
       {
         path: '/oauth2',
         component: OAuth2,
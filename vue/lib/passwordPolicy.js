@@ -0,0 +1,18 @@
+// Explains each rule in the details of a 'credentials-failed-requirements' error
+const violations = {
+  'too-short': 'it is too short',
+  'too-long': 'it is too long',
+  'missing-upper': 'it needs an uppercase letter',
+  'missing-lower': 'it needs a lowercase letter',
+  'missing-digit': 'it needs a digit',
+  'missing-symbol': 'it needs a symbol',
+  'contains-user-info': 'it contains your username or email',
+  breached: 'it has appeared in a data breach, and is likely to be guessed',
+  reused: 'it was used recently',
+};
+
+export default function describePasswordRejection(errdata) {
+  const reasons = (errdata.details || []).map((code) => violations[code] || code);
+  if (reasons.length === 0) return errdata.message;
+  return `Password not accepted: ${reasons.join(', ')}`;
+}